@@ -15,7 +15,10 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -29,6 +32,7 @@ import (
 
 var jobNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_-]*$")
 var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+var metricNameRE = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
 
 // Config encapsulates the configuration of a Prometheus instance. It wraps the
 // raw configuration protocol buffer to be able to add custom methods to it.
@@ -42,6 +46,51 @@ func (c Config) String() string {
 	return proto.MarshalTextString(&c.PrometheusConfig)
 }
 
+// secretMask replaces secrets in the masked serialization of a Config.
+const secretMask = "<secret>"
+
+// MaskedString returns an ASCII serialization of the loaded configuration
+// protobuf with all secrets replaced by "<secret>". Secrets are the passwords
+// in proxy and target URLs, basic auth passwords, and bearer tokens. The names
+// of files containing secrets are not masked.
+func (c Config) MaskedString() string {
+	masked := proto.Clone(&c.PrometheusConfig).(*pb.PrometheusConfig)
+	for _, job := range masked.Job {
+		if job.ProxyUrl != nil {
+			job.ProxyUrl = proto.String(MaskURLPassword(job.GetProxyUrl()))
+		}
+		if job.BasicAuth != nil && job.BasicAuth.Password != nil {
+			job.BasicAuth.Password = proto.String(secretMask)
+		}
+		if job.BearerToken != nil {
+			job.BearerToken = proto.String(secretMask)
+		}
+		for _, tg := range job.TargetGroup {
+			for i, t := range tg.Target {
+				tg.Target[i] = MaskURLPassword(t)
+			}
+		}
+	}
+	return proto.MarshalTextString(masked)
+}
+
+// MaskURLPassword replaces the password of a URL by "<secret>". Strings that
+// are not URLs or don't contain a password are returned unchanged.
+func MaskURLPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	// Set the mask after serialization, as it would be escaped otherwise.
+	// The first '@' is the end of the user info since it is escaped within
+	// the user name.
+	u.User = url.User(u.User.Username())
+	return strings.Replace(u.String(), "@", ":"+secretMask+"@", 1)
+}
+
 // validateLabels validates whether label names have the correct format.
 func (c Config) validateLabels(labels *pb.LabelPairs) error {
 	if labels == nil {
@@ -89,16 +138,106 @@ func (c Config) Validate() error {
 		if _, err := utility.StringToDuration(job.GetScrapeTimeout()); err != nil {
 			return fmt.Errorf("invalid scrape timeout for job '%s': %s", job.GetName(), err)
 		}
+		if job.ScrapeInitialDelay != nil {
+			if _, err := utility.StringToDuration(job.GetScrapeInitialDelay()); err != nil {
+				return fmt.Errorf("invalid scrape initial delay for job '%s': %s", job.GetName(), err)
+			}
+		}
+		if job.ScrapeMaxJitter != nil {
+			jitter, err := utility.StringToDuration(job.GetScrapeMaxJitter())
+			if err != nil {
+				return fmt.Errorf("invalid scrape max jitter for job '%s': %s", job.GetName(), err)
+			}
+			if jitter > stringToDuration(job.GetScrapeInterval()) {
+				return fmt.Errorf("scrape max jitter for job '%s' exceeds the scrape interval", job.GetName())
+			}
+		}
+		if scheme := job.GetScheme(); scheme != "http" && scheme != "https" {
+			return fmt.Errorf("invalid scheme for job '%s': '%s'", job.GetName(), scheme)
+		}
+		for _, param := range job.Params {
+			if param.GetName() == "" {
+				return fmt.Errorf("empty param name for job '%s'", job.GetName())
+			}
+		}
 		for _, targetGroup := range job.TargetGroup {
 			if err := c.validateLabels(targetGroup.Labels); err != nil {
 				return fmt.Errorf("invalid labels for job '%s': %s", job.GetName(), err)
 			}
+			for _, endpoint := range targetGroup.Target {
+				if _, err := (JobConfig{*job}).TargetURL(endpoint); err != nil {
+					return fmt.Errorf("invalid target for job '%s': %s", job.GetName(), err)
+				}
+			}
 		}
 		if job.SdName != nil && len(job.TargetGroup) > 0 {
 			return fmt.Errorf("specified both DNS-SD name and target group for job: %s", job.GetName())
 		}
+		if job.ProxyUrl != nil {
+			if err := validateProxyURL(job.GetProxyUrl()); err != nil {
+				return fmt.Errorf("invalid proxy URL for job '%s': %s", job.GetName(), err)
+			}
+		}
+		for _, re := range append(job.GetMetricNameAllow(), job.GetMetricNameDeny()...) {
+			if _, err := regexp.Compile("^(?:" + re + ")$"); err != nil {
+				return fmt.Errorf("invalid metric name regex for job '%s': %s", job.GetName(), err)
+			}
+		}
+		for _, derived := range job.DerivedMetric {
+			if err := validateDerivedMetric(derived); err != nil {
+				return fmt.Errorf("invalid derived metric for job '%s': %s", job.GetName(), err)
+			}
+		}
+		if job.BearerToken != nil && job.BearerTokenFile != nil {
+			return fmt.Errorf("specified both bearer token and bearer token file for job '%s'", job.GetName())
+		}
+		if auth := job.BasicAuth; auth != nil {
+			if job.BearerToken != nil || job.BearerTokenFile != nil {
+				return fmt.Errorf("specified both basic auth and bearer token for job '%s'", job.GetName())
+			}
+			if auth.Password != nil && auth.PasswordFile != nil {
+				return fmt.Errorf("specified both basic auth password and password file for job '%s'", job.GetName())
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateDerivedMetric checks whether the metric names and labels of a
+// derived metric are valid.
+func validateDerivedMetric(derived *pb.DerivedMetric) error {
+	for _, name := range []string{derived.GetName(), derived.GetMetric()} {
+		if !metricNameRE.MatchString(name) {
+			return fmt.Errorf("invalid metric name '%s'", name)
+		}
+	}
+	if derived.DivisorMetric != nil && !metricNameRE.MatchString(derived.GetDivisorMetric()) {
+		return fmt.Errorf("invalid metric name '%s'", derived.GetDivisorMetric())
+	}
+	for _, ln := range derived.By {
+		if !labelNameRE.MatchString(ln) {
+			return fmt.Errorf("invalid label name '%s' in metric '%s'", ln, derived.GetName())
+		}
 	}
+	return nil
+}
 
+// validateProxyURL checks whether the provided string is a proxy URL usable for
+// scraping.
+func validateProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in '%s'", proxyURL)
+	}
 	return nil
 }
 
@@ -145,6 +284,21 @@ func (c Config) ScrapeInterval() time.Duration {
 	return stringToDuration(c.Global.GetScrapeInterval())
 }
 
+// MaxScrapeInterval returns the longest scrape interval of all jobs, or the
+// default scrape interval if there are no jobs.
+func (c Config) MaxScrapeInterval() time.Duration {
+	max := c.ScrapeInterval()
+	if len(c.Job) > 0 {
+		max = 0
+	}
+	for _, job := range c.Jobs() {
+		if i := job.ScrapeInterval(); i > max {
+			max = i
+		}
+	}
+	return max
+}
+
 // EvaluationInterval gets the default evaluation interval for a Config.
 func (c Config) EvaluationInterval() time.Duration {
 	return stringToDuration(c.Global.GetEvaluationInterval())
@@ -161,7 +315,86 @@ func (c JobConfig) ScrapeInterval() time.Duration {
 	return stringToDuration(c.GetScrapeInterval())
 }
 
+// MetricNameFilter returns the compiled, fully anchored regular expressions
+// of the metric names to ingest and not to ingest from the job's targets.
+func (c JobConfig) MetricNameFilter() (allow, deny []*regexp.Regexp) {
+	compile := func(res []string) []*regexp.Regexp {
+		var compiled []*regexp.Regexp
+		for _, re := range res {
+			compiled = append(compiled, regexp.MustCompile("^(?:"+re+")$"))
+		}
+		return compiled
+	}
+	return compile(c.GetMetricNameAllow()), compile(c.GetMetricNameDeny())
+}
+
 // ScrapeTimeout gets the scrape timeout for a job.
 func (c JobConfig) ScrapeTimeout() time.Duration {
 	return stringToDuration(c.GetScrapeInterval())
 }
+
+// InitialDelay returns the delay before the first scrape of each target of a
+// job, or nil if the targets are scraped at an offset into the interval.
+func (c JobConfig) InitialDelay() *time.Duration {
+	if c.ScrapeInitialDelay == nil {
+		return nil
+	}
+	d := stringToDuration(c.GetScrapeInitialDelay())
+	return &d
+}
+
+// MaxJitter returns the maximum offset into the scrape interval at which
+// targets of a job are scraped, or nil if it is the whole interval.
+func (c JobConfig) MaxJitter() *time.Duration {
+	if c.ScrapeMaxJitter == nil {
+		return nil
+	}
+	d := stringToDuration(c.GetScrapeMaxJitter())
+	return &d
+}
+
+// ProxyURL returns the parsed proxy URL for a job or nil if no proxy is
+// configured.
+func (c JobConfig) ProxyURL() *url.URL {
+	if c.ProxyUrl == nil {
+		return nil
+	}
+	u, err := url.Parse(c.GetProxyUrl())
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// TargetURL returns the URL to scrape for the given endpoint of a job. An
+// endpoint given as a full URL is used as is, while a "host:port" endpoint is
+// turned into a URL according to the scheme and metrics path of the job. In
+// both cases, the params of the job are added to the query of the URL.
+func (c JobConfig) TargetURL(endpoint string) (string, error) {
+	var u *url.URL
+	if strings.Contains(endpoint, "://") {
+		var err error
+		if u, err = url.Parse(endpoint); err != nil {
+			return "", err
+		}
+	} else {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return "", fmt.Errorf("'%s' is neither a URL nor a host:port pair", endpoint)
+		}
+		u = &url.URL{
+			Scheme: c.GetScheme(),
+			Host:   endpoint,
+			Path:   c.GetMetricsPath(),
+		}
+	}
+	if len(c.Params) > 0 {
+		query := u.Query()
+		for _, param := range c.Params {
+			for _, value := range param.Value {
+				query.Add(param.GetName(), value)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
@@ -14,19 +14,31 @@
 package config
 
 import (
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 
 	"github.com/golang/protobuf/proto"
 
 	pb "github.com/prometheus/prometheus/config/generated"
 )
 
+var expandEnv = flag.Bool("config.expand-env", false, "Replace ${VAR} references in the configuration and rule files with the values of the environment variables.")
+
+var envRefRE = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
 // LoadFromString returns a config parsed from the provided string.
 func LoadFromString(configStr string) (Config, error) {
 	configProto := pb.PrometheusConfig{}
 	if err := proto.UnmarshalText(configStr, &configProto); err != nil {
 		return Config{}, err
 	}
+	if err := loadJobFiles(&configProto); err != nil {
+		return Config{}, err
+	}
 	if configProto.Global == nil {
 		configProto.Global = &pb.GlobalConfig{}
 	}
@@ -44,10 +56,72 @@ func LoadFromString(configStr string) (Config, error) {
 
 // LoadFromFile returns a config parsed from the file of the provided name.
 func LoadFromFile(fileName string) (Config, error) {
-	configStr, err := ioutil.ReadFile(fileName)
+	configStr, err := ReadFile(fileName)
 	if err != nil {
 		return Config{}, err
 	}
 
 	return LoadFromString(string(configStr))
 }
+
+// loadJobFiles adds the jobs of all files matching the job_file patterns of
+// configProto to its jobs.
+func loadJobFiles(configProto *pb.PrometheusConfig) error {
+	for _, pattern := range configProto.JobFile {
+		fileNames, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid job file pattern '%s': %s", pattern, err)
+		}
+		for _, fileName := range fileNames {
+			jobs, err := loadJobFile(fileName)
+			if err != nil {
+				return fmt.Errorf("%s: %s", fileName, err)
+			}
+			configProto.Job = append(configProto.Job, jobs...)
+		}
+	}
+	return nil
+}
+
+func loadJobFile(fileName string) ([]*pb.JobConfig, error) {
+	configStr, err := ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	fileProto := pb.PrometheusConfig{}
+	if err := proto.UnmarshalText(string(configStr), &fileProto); err != nil {
+		return nil, err
+	}
+	if fileProto.Global != nil || len(fileProto.JobFile) > 0 {
+		return nil, fmt.Errorf("job files may only contain jobs")
+	}
+	return fileProto.Job, nil
+}
+
+// ReadFile returns the contents of the file of the provided name. If
+// -config.expand-env is set, ${VAR} references in it are replaced with the
+// values of the environment variables. Referencing an unset variable is an
+// error.
+func ReadFile(fileName string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil || !*expandEnv {
+		return b, err
+	}
+	s, err := expandEnvRefs(string(b))
+	return []byte(s), err
+}
+
+// expandEnvRefs replaces all ${VAR} references in s with the values of the
+// environment variables.
+func expandEnvRefs(s string) (string, error) {
+	var err error
+	res := envRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRefRE.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return res, err
+}
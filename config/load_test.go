@@ -14,6 +14,7 @@
 package config
 
 import (
+	"os"
 	"testing"
 )
 
@@ -23,3 +24,21 @@ func TestLoadFromFile(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestExpandEnvRefs(t *testing.T) {
+	os.Setenv("PROMETHEUS_TEST_HOST", "example.org")
+	defer os.Unsetenv("PROMETHEUS_TEST_HOST")
+
+	got, err := expandEnvRefs(`target: "${PROMETHEUS_TEST_HOST}:9100" regex: "foo$" other: "$PROMETHEUS_TEST_HOST"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `target: "example.org:9100" regex: "foo$" other: "$PROMETHEUS_TEST_HOST"`
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := expandEnvRefs("${PROMETHEUS_TEST_UNSET}"); err == nil {
+		t.Error("Expected error for unset environment variable")
+	}
+}
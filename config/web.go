@@ -0,0 +1,79 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/prometheus/prometheus/config/generated"
+)
+
+// WebConfig encapsulates the configuration of the web server. It wraps the
+// raw configuration protocol buffer to be able to add custom methods to it.
+type WebConfig struct {
+	// The protobuf containing the actual configuration values.
+	pb.WebConfig
+}
+
+// LoadWebConfigFromString returns a web config parsed from the provided
+// string.
+func LoadWebConfigFromString(configStr string) (WebConfig, error) {
+	configProto := pb.WebConfig{}
+	if err := proto.UnmarshalText(configStr, &configProto); err != nil {
+		return WebConfig{}, err
+	}
+	config := WebConfig{configProto}
+	return config, config.Validate()
+}
+
+// LoadWebConfigFromFile returns a web config parsed from the file of the
+// provided name.
+func LoadWebConfigFromFile(fileName string) (WebConfig, error) {
+	configStr, err := ReadFile(fileName)
+	if err != nil {
+		return WebConfig{}, err
+	}
+	return LoadWebConfigFromString(string(configStr))
+}
+
+// Validate checks a parsed WebConfig for the validity of its fields.
+func (c WebConfig) Validate() error {
+	if tls := c.TlsServerConfig; tls != nil {
+		if tls.GetCertFile() == "" || tls.GetKeyFile() == "" {
+			return fmt.Errorf("TLS server config requires both a certificate and a key file")
+		}
+	}
+	usernames := map[string]bool{}
+	for _, user := range c.BasicAuthUser {
+		name := user.GetUsername()
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("invalid basic auth user name '%s'", name)
+		}
+		if usernames[name] {
+			return fmt.Errorf("found multiple basic auth users with the same name: '%s'", name)
+		}
+		usernames[name] = true
+		if h, err := hex.DecodeString(user.GetPasswordPbkdf2Sha256()); err != nil || len(h) != 32 {
+			return fmt.Errorf("password key of basic auth user '%s' is not a hex-encoded 32-byte PBKDF2 key", name)
+		}
+		if user.GetPbkdf2Iterations() == 0 {
+			return fmt.Errorf("basic auth user '%s' needs at least one PBKDF2 iteration", name)
+		}
+	}
+	return nil
+}
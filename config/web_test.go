@@ -0,0 +1,65 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+var webConfigTests = []struct {
+	inputFile   string
+	shouldFail  bool
+	errContains string
+}{
+	{
+		inputFile: "web.conf.input",
+	},
+	{
+		inputFile: "empty.conf.input",
+	},
+	{
+		inputFile:   "invalid_web_password_hash.conf.input",
+		shouldFail:  true,
+		errContains: "password key of basic auth user 'alice' is not a hex-encoded 32-byte PBKDF2 key",
+	},
+	{
+		inputFile:   "invalid_web_pbkdf2_iterations.conf.input",
+		shouldFail:  true,
+		errContains: "basic auth user 'alice' needs at least one PBKDF2 iteration",
+	},
+	{
+		inputFile:   "repeated_web_user.conf.input",
+		shouldFail:  true,
+		errContains: "found multiple basic auth users with the same name: 'alice'",
+	},
+}
+
+func TestWebConfigs(t *testing.T) {
+	for i, configTest := range webConfigTests {
+		_, err := LoadWebConfigFromFile(path.Join(fixturesPath, configTest.inputFile))
+
+		if err != nil {
+			if !configTest.shouldFail {
+				t.Fatalf("%d. Error parsing web config %v: %v", i, configTest.inputFile, err)
+			}
+			if !strings.Contains(err.Error(), configTest.errContains) {
+				t.Fatalf("%d. Expected error containing '%v', got: %v", i, configTest.errContains, err)
+			}
+		} else if configTest.shouldFail {
+			t.Fatalf("%d. Expected error parsing web config %v", i, configTest.inputFile)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package log provides leveled, structured logging for the components of
+// Prometheus. Every line carries the time, level, component, source location,
+// and message of an event plus optional key/value fields. Lines are written
+// to standard error in the logfmt or the JSON format.
+//
+// The minimum level of logged events can be set per component, both by flag
+// and at runtime.
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logFormat = flag.String("log.format", "logfmt", "The format of log lines, either 'logfmt' or 'json'.")
+	logLevel  = flag.String("log.level", "info", "The minimum level of events to log: debug, info, warn, or error. Levels of single components can be appended as component=level pairs, e.g. 'info,storage=debug'. The components are main, notification, retrieval, rules, storage, tracing, and web.")
+)
+
+// Level is the severity of a logged event.
+type Level int
+
+// The levels in increasing severity.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > FatalLevel {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the given name. Fatal events are always
+// logged, so "fatal" is not a valid level to set.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames[:FatalLevel] {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+var (
+	mtx sync.RWMutex // Protects all of the below.
+
+	output       io.Writer = os.Stderr
+	formatJSON   bool
+	defaultLevel = InfoLevel
+	levels       = map[string]Level{}
+)
+
+// Init applies the -log.format and -log.level flags. It has to be called
+// after the flags have been parsed. Until then, events are logged in the
+// logfmt format at the info level.
+func Init() error {
+	switch *logFormat {
+	case "logfmt", "json":
+	default:
+		return fmt.Errorf("unknown log format %q", *logFormat)
+	}
+
+	def, components, err := parseLevels(*logLevel)
+	if err != nil {
+		return err
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	formatJSON = *logFormat == "json"
+	defaultLevel = def
+	levels = components
+	return nil
+}
+
+// parseLevels parses a level specification like "info,storage=debug".
+func parseLevels(spec string) (Level, map[string]Level, error) {
+	def := InfoLevel
+	components := map[string]Level{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, name := "", part
+		if i := strings.Index(part, "="); i >= 0 {
+			component, name = part[:i], part[i+1:]
+		}
+		l, err := ParseLevel(name)
+		if err != nil {
+			return 0, nil, err
+		}
+		if component == "" {
+			def = l
+		} else {
+			components[component] = l
+		}
+	}
+	return def, components, nil
+}
+
+// SetLevel sets the minimum level of logged events of a component. An empty
+// component sets the level of all components without a level of their own.
+func SetLevel(component string, l Level) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	if component == "" {
+		defaultLevel = l
+		return
+	}
+	levels[component] = l
+}
+
+// Levels returns the current levels by component. The level of components
+// without a level of their own is returned for the empty component.
+func Levels() map[string]string {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	res := map[string]string{"": defaultLevel.String()}
+	for component, l := range levels {
+		res[component] = l.String()
+	}
+	return res
+}
+
+func enabled(component string, l Level) bool {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	min, ok := levels[component]
+	if !ok {
+		min = defaultLevel
+	}
+	return l >= min
+}
+
+// Logger logs the events of a component. Loggers are safe for concurrent use.
+type Logger struct {
+	component string
+	// Key/value pairs added to each event.
+	fields []interface{}
+}
+
+// New returns a Logger for the given component.
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a Logger that adds the given alternating keys and values to
+// each event on top of the fields of l.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{component: l.component, fields: fields}
+}
+
+// Debug logs an event at the debug level, formatting the arguments like
+// fmt.Sprint.
+func (l *Logger) Debug(args ...interface{}) { l.log(DebugLevel, fmt.Sprint(args...)) }
+
+// Debugf logs an event at the debug level, formatting the arguments like
+// fmt.Sprintf.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+// Info logs an event at the info level like Debug.
+func (l *Logger) Info(args ...interface{}) { l.log(InfoLevel, fmt.Sprint(args...)) }
+
+// Infof logs an event at the info level like Debugf.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+// Warn logs an event at the warning level like Debug.
+func (l *Logger) Warn(args ...interface{}) { l.log(WarnLevel, fmt.Sprint(args...)) }
+
+// Warnf logs an event at the warning level like Debugf.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, fmt.Sprintf(format, args...))
+}
+
+// Error logs an event at the error level like Debug.
+func (l *Logger) Error(args ...interface{}) { l.log(ErrorLevel, fmt.Sprint(args...)) }
+
+// Errorf logs an event at the error level like Debugf.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs an event at the fatal level and exits the process with status
+// 1. Fatal events are logged regardless of the level of the component.
+func (l *Logger) Fatal(args ...interface{}) {
+	l.log(FatalLevel, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+// Fatalf works like Fatal but formats the arguments like fmt.Sprintf.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(FatalLevel, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string) {
+	if !enabled(l.component, level) {
+		return
+	}
+	caller := "???"
+	// Skip log and the level method.
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	keyvals := append([]interface{}{
+		"ts", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		"level", level,
+		"component", l.component,
+		"caller", caller,
+		"msg", strings.TrimRight(msg, "\n"),
+	}, l.fields...)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	var b []byte
+	if formatJSON {
+		b = encodeJSON(keyvals)
+	} else {
+		b = encodeLogfmt(keyvals)
+	}
+	output.Write(b)
+}
+
+// encodeLogfmt encodes alternating keys and values as a logfmt line. Keys
+// without a value get an empty one.
+func encodeLogfmt(keyvals []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtValue(fmt.Sprint(keyvals[i])))
+		buf.WriteByte('=')
+		if i+1 < len(keyvals) {
+			buf.WriteString(logfmtValue(fmt.Sprint(keyvals[i+1])))
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// logfmtValue quotes s if it is empty or contains spaces, quotes, equal
+// signs, or control characters.
+func logfmtValue(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// encodeJSON encodes alternating keys and values as a JSON object on a line
+// of its own, with the keys in sorted order.
+func encodeJSON(keyvals []interface{}) []byte {
+	m := make(map[string]string, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		v := ""
+		if i+1 < len(keyvals) {
+			v = fmt.Sprint(keyvals[i+1])
+		}
+		m[fmt.Sprint(keyvals[i])] = v
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		// Marshalling a map of strings cannot fail.
+		panic(err)
+	}
+	return append(b, '\n')
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureOutput redirects log output into the returned buffer until the
+// returned function is called, which also resets the levels.
+func captureOutput(asJSON bool) (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	mtx.Lock()
+	output, formatJSON = &buf, asJSON
+	mtx.Unlock()
+	return &buf, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		output, formatJSON = os.Stderr, false
+		defaultLevel, levels = InfoLevel, map[string]Level{}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	buf, restore := captureOutput(false)
+	defer restore()
+
+	New("storage").With("series", 12, "path", "/tmp/my data").Infof("Done %s.", "checkpointing")
+
+	re := regexp.MustCompile(`^ts=\S+ level=info component=storage caller=log_test\.go:\d+ msg="Done checkpointing\." series=12 path="/tmp/my data"\n$`)
+	if got := buf.String(); !re.MatchString(got) {
+		t.Errorf("unexpected log line %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buf, restore := captureOutput(true)
+	defer restore()
+
+	New("web").Warn("Error ", "writing response\n")
+
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"level":     "warn",
+		"component": "web",
+		"msg":       "Error writing response",
+	} {
+		if got[k] != want {
+			t.Errorf("expected %s %q, got %q", k, want, got[k])
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	buf, restore := captureOutput(false)
+	defer restore()
+
+	def, components, err := parseLevels("warn, storage=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defaultLevel, levels = def, components
+	mtx.Unlock()
+
+	New("storage").Debug("storage debug")
+	New("web").Info("web info")
+	New("web").Error("web error")
+	SetLevel("web", DebugLevel)
+	New("web").Debug("web debug")
+
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		msgs = append(msgs, line[strings.Index(line, "msg="):])
+	}
+	want := []string{`msg="storage debug"`, `msg="web error"`, `msg="web debug"`}
+	if strings.Join(msgs, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, msgs)
+	}
+
+	got := Levels()
+	if got[""] != "warn" || got["storage"] != "debug" || got["web"] != "debug" {
+		t.Errorf("unexpected levels %v", got)
+	}
+
+	for _, spec := range []string{"verbose", "storage=fatal", "storage="} {
+		if _, _, err := parseLevels(spec); err == nil {
+			t.Errorf("expected error for level specification %q", spec)
+		}
+	}
+}
@@ -15,58 +15,123 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	_ "net/http/pprof" // Comment this line to disable pprof endpoint.
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/golang/glog"
-
 	clientmodel "github.com/prometheus/client_golang/model"
 	registry "github.com/prometheus/client_golang/prometheus"
 
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/notification"
 	"github.com/prometheus/prometheus/retrieval"
+	"github.com/prometheus/prometheus/rules/ast"
 	"github.com/prometheus/prometheus/rules/manager"
 	"github.com/prometheus/prometheus/storage/local"
 	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/prometheus/prometheus/storage/remote/opentsdb"
+	"github.com/prometheus/prometheus/tracing"
+	"github.com/prometheus/prometheus/utility"
 	"github.com/prometheus/prometheus/web"
 	"github.com/prometheus/prometheus/web/api"
+	"github.com/prometheus/prometheus/web/api/v1"
 )
 
+var logger = log.New("main")
+
 const deletionBatchSize = 100
 
 // Commandline flags.
 var (
 	configFile = flag.String("config.file", "prometheus.conf", "Prometheus configuration file name.")
 
+	agentMode = flag.Bool("agent", false, "Run as a forwarding agent: targets are scraped and all samples are sent to the remote storage (-storage.remote.url), but there is neither local storage nor querying nor rule evaluation. Samples that cannot be sent are buffered in a write-ahead log in -storage.local.path.")
+
 	alertmanagerURL           = flag.String("alertmanager.url", "", "The URL of the alert manager to send notifications to.")
+	alertmanagerPasswordFile  = flag.String("alertmanager.password-file", "", "File to read the basic auth password for the user of -alertmanager.url from, instead of the URL. It is read for every notification, so that rotated passwords are picked up.")
 	notificationQueueCapacity = flag.Int("alertmanager.notification-queue-capacity", 100, "The capacity of the queue for pending alert manager notifications.")
 
 	persistenceStoragePath = flag.String("storage.local.path", "/tmp/metrics", "Base path for metrics storage.")
 
-	remoteTSDBUrl     = flag.String("storage.remote.url", "", "The URL of the OpenTSDB instance to send samples to.")
-	remoteTSDBTimeout = flag.Duration("storage.remote.timeout", 30*time.Second, "The timeout to use when sending samples to OpenTSDB.")
+	remoteTSDBUrl          = flag.String("storage.remote.url", "", "The URL of the OpenTSDB instance to send samples to.")
+	remoteTSDBPasswordFile = flag.String("storage.remote.password-file", "", "File to read the basic auth password for the user of -storage.remote.url from, instead of the URL. It is read for every batch of samples sent, so that rotated passwords are picked up.")
+	remoteTSDBTimeout      = flag.Duration("storage.remote.timeout", 30*time.Second, "The timeout to use when sending samples to OpenTSDB.")
+	remoteWALSizeLimit     = flag.Int64("storage.remote.wal-size-limit", 512*1024*1024, "The maximum size in bytes of the write-ahead log buffering samples that could not be sent to the remote storage in agent mode. Samples are dropped while the limit is reached.")
 
 	samplesQueueCapacity = flag.Int("storage.incoming-samples-queue-capacity", 64*1024, "The capacity of the queue of samples to be stored. Note that each slot in the queue takes a whole slice of samples whose size depends on details of the scrape process.")
 
 	numMemoryChunks = flag.Int("storage.local.memory-chunks", 1024*1024, "How many chunks to keep in memory. While the size of a chunk is 1kiB, the total memory usage will be significantly higher than this value * 1kiB. Furthermore, for various reasons, more chunks might have to be kept in memory temporarily.")
 
 	persistenceRetentionPeriod = flag.Duration("storage.local.retention", 15*24*time.Hour, "How long to retain samples in the local storage.")
+	retentionTiers             = flag.String("storage.local.retention-tiers", "", "Comma-separated list of named retention tiers in the form name=duration, e.g. 'long-term=1y'. Recording rules can assign their output to a tier with the __retention_tier__ label, whose samples are then retained for the duration of the tier instead of -storage.local.retention.")
 	persistenceQueueCapacity   = flag.Int("storage.local.persistence-queue-capacity", 32*1024, "How many chunks can be waiting for being persisted before sample ingestion will stop.")
 
 	checkpointInterval         = flag.Duration("storage.local.checkpoint-interval", 5*time.Minute, "The period at which the in-memory index of time series is checkpointed.")
+	checkpointMaxIncrements    = flag.Int("storage.local.checkpoint-max-increments", 10, "The maximum number of incremental checkpoints between two full checkpoints. Incremental checkpoints only write the time series changed since the last full checkpoint. 0 makes every checkpoint a full one.")
+	checkpointAutoTune         = flag.Bool("storage.local.checkpoint-auto-tune", false, "If set, the checkpoint interval adapts to the ingestion rate and the checkpoint duration, between a tenth of -storage.local.checkpoint-interval and -storage.local.checkpoint-interval itself.")
+	shutdownCheckpointTimeout  = flag.Duration("storage.local.shutdown-checkpoint-timeout", 5*time.Minute, "The maximum duration of the final checkpoint on shutdown. If it takes longer, the storage is left dirty, and crash recovery is run on the next start. 0 means no limit.")
 	checkpointDirtySeriesLimit = flag.Int("storage.local.checkpoint-dirty-series-limit", 5000, "If approx. that many time series are in a state that would require a recovery operation after a crash, a checkpoint is triggered, even if the checkpoint interval hasn't passed yet. A recovery operation requires a disk seek. The default limit intends to keep the recovery time below 1min even on spinning disks. With SSD, recovery is much faster, so you might want to increase this value in that case to avoid overly frequent checkpoints.")
 
+	maxLabelNameLength  = flag.Int("storage.local.max-label-name-length", 0, "Samples of new series with a label name longer than this many bytes are rejected. 0 means no limit.")
+	maxLabelValueLength = flag.Int("storage.local.max-label-value-length", 0, "Samples of new series with a label value longer than this many bytes are rejected. 0 means no limit.")
+	maxLabelsPerSeries  = flag.Int("storage.local.max-labels-per-series", 0, "Samples of new series with more labels than this, including the metric name, are rejected. 0 means no limit.")
+	maxSeriesPerMetric  = flag.Int("storage.local.max-series-per-metric", 0, "Samples of new series are rejected while this many series with the same metric name are in memory. 0 means no limit.")
+
+	tracingCollectorURL     = flag.String("tracing.zipkin-url", "", "The URL of a Zipkin-compatible collector to send trace spans of queries, rule evaluations, and scrapes to, e.g. 'http://localhost:9411/api/v2/spans'. Tracing is disabled if empty.")
+	tracingSampleRatio      = flag.Float64("tracing.sample-ratio", 1, "The fraction of queries, rule evaluations, and scrapes to trace, between 0 and 1.")
+	tracingQueueCapacity    = flag.Int("tracing.queue-capacity", 10000, "The capacity of the queue for trace spans waiting to be sent to the collector. Spans are dropped while the queue is full.")
+	tracingCollectorTimeout = flag.Duration("tracing.timeout", 10*time.Second, "The timeout to use when sending trace spans to the collector.")
+
+	queryCacheSize    = flag.Int("query.cache-size", 0, "The maximum number of range queries whose results are cached, so that repeated queries over a moving time range only evaluate the points not covered by a previous result. 0 disables the cache.")
+	queryCacheHorizon = flag.Duration("query.cache-horizon", 10*time.Minute, "Points of range queries are only cached once they are older than this, as later samples can still change them. Must be at least -query.staleness-delta plus the longest scrape interval.")
+
+	queryRemoteSources = flag.String("query.remote-sources", "", "Comma-separated list of remote Prometheus servers in the form name=url, e.g. 'eu=http://prometheus.eu.example.org:9090'. Instant vector selectors with a __source__ matcher, e.g. up{__source__=~\"eu|us\"}, also select the series of the matching servers, labeled with __source__ set to the server name.")
+	queryRemoteTimeout = flag.Duration("query.remote-timeout", 30*time.Second, "The timeout to use when querying remote sources.")
+
+	blockProfileRate     = flag.Int("debug.block-profile-rate", 0, "Record one blocking event per this many nanoseconds spent blocked, as served by /debug/pprof/block. 0 disables block profiling. Can be changed at runtime via /api/v1/admin/debug/profiling.")
+	mutexProfileFraction = flag.Int("debug.mutex-profile-fraction", 0, "Record one in this many mutex contention events, as served by /debug/pprof/mutex. 0 disables mutex profiling. Can be changed at runtime via /api/v1/admin/debug/profiling.")
+
 	storageDirty = flag.Bool("storage.local.dirty", false, "If set, the local storage layer will perform crash recovery even if the last shutdown appears to be clean.")
 
 	printVersion = flag.Bool("version", false, "Print version information.")
 )
 
+// secretFlags are the flags whose values are masked wherever the flags are
+// exposed.
+var secretFlags = map[string]bool{
+	"web.admin-api-token": true,
+}
+
+// maskFlagValue masks the secrets in the value of the named flag. Values of
+// secretFlags are masked as a whole. Otherwise, the passwords of URLs are
+// masked, also within comma-separated lists of URLs or of name=URL pairs.
+func maskFlagValue(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretFlags[name] {
+		return "<secret>"
+	}
+	elems := strings.Split(value, ",")
+	for i, e := range elems {
+		if parts := strings.SplitN(e, "=", 2); len(parts) == 2 && !strings.Contains(parts[0], "/") {
+			elems[i] = parts[0] + "=" + config.MaskURLPassword(parts[1])
+		} else {
+			elems[i] = config.MaskURLPassword(e)
+		}
+	}
+	return strings.Join(elems, ",")
+}
+
 // Instrumentation.
 var (
 	samplesQueueCapDesc = registry.NewDesc(
@@ -84,13 +149,21 @@ var (
 type prometheus struct {
 	unwrittenSamples chan clientmodel.Samples
 
+	reloadMtx sync.Mutex    // Serializes configuration reloads.
+	conf      config.Config // The currently applied configuration.
+
 	ruleManager         manager.RuleManager
 	targetManager       retrieval.TargetManager
 	notificationHandler *notification.NotificationHandler
 	storage             local.Storage
 	remoteTSDBQueue     *remote.TSDBQueueManager
+	traceReporter       *tracing.Reporter
 
 	webService *web.WebService
+	// Handlers that display the configuration.
+	statusHandler  *web.PrometheusStatusHandler
+	metricsService *api.MetricsService
+	apiv1          *v1.API
 
 	closeOnce sync.Once
 }
@@ -100,7 +173,13 @@ type prometheus struct {
 func NewPrometheus() *prometheus {
 	conf, err := config.LoadFromFile(*configFile)
 	if err != nil {
-		glog.Fatalf("Error loading configuration from %s: %v", *configFile, err)
+		logger.Fatalf("Error loading configuration from %s: %v", *configFile, err)
+	}
+
+	var traceReporter *tracing.Reporter
+	if *tracingCollectorURL != "" {
+		traceReporter = tracing.NewReporter(*tracingCollectorURL, *tracingSampleRatio, *tracingQueueCapacity, *tracingCollectorTimeout)
+		tracing.SetReporter(traceReporter)
 	}
 
 	unwrittenSamples := make(chan clientmodel.Samples, *samplesQueueCapacity)
@@ -113,88 +192,192 @@ func NewPrometheus() *prometheus {
 	targetManager := retrieval.NewTargetManager(ingester)
 	targetManager.AddTargetsFromConfig(conf)
 
-	notificationHandler := notification.NewNotificationHandler(*alertmanagerURL, *notificationQueueCapacity)
+	var remoteTSDBQueue *remote.TSDBQueueManager
+	if *remoteTSDBUrl == "" {
+		if *agentMode {
+			logger.Fatal("Agent mode requires a remote storage (-storage.remote.url)")
+		}
+		logger.Warnf("No TSDB URL provided; not sending any samples to long-term storage")
+	} else {
+		var wal *remote.WAL
+		if *agentMode {
+			if err := os.MkdirAll(*persistenceStoragePath, 0777); err != nil {
+				logger.Fatal("Error creating storage directory: ", err)
+			}
+			if wal, err = remote.OpenWAL(filepath.Join(*persistenceStoragePath, "remote.wal"), *remoteWALSizeLimit); err != nil {
+				logger.Fatal("Error opening remote storage WAL: ", err)
+			}
+		}
+		openTSDB := opentsdb.NewClient(*remoteTSDBUrl, *remoteTSDBPasswordFile, *remoteTSDBTimeout)
+		remoteTSDBQueue = remote.NewTSDBQueueManager(openTSDB, 512, wal)
+	}
 
+	flags := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = maskFlagValue(f.Name, f.Value.String())
+	})
+	birth := time.Now()
+	prometheusStatus := &web.PrometheusStatusHandler{
+		BuildInfo:     BuildInfo,
+		Config:        conf.MaskedString(),
+		TargetManager: targetManager,
+		Flags:         flags,
+		Birth:         birth,
+	}
+
+	runtime.SetBlockProfileRate(*blockProfileRate)
+	runtime.SetMutexProfileFraction(*mutexProfileFraction)
+
+	apiv1 := &v1.API{
+		TargetManager:      targetManager,
+		EvaluationInterval: conf.EvaluationInterval(),
+		Config:             conf.MaskedString(),
+		ScrapeInterval:     conf.MaxScrapeInterval(),
+		Flags:              flags,
+		BuildInfo:          BuildInfo,
+		Birth:              birth,
+		BlockProfileRate:   *blockProfileRate,
+		PushIngester:       ingester,
+	}
+	if *queryCacheSize > 0 {
+		apiv1.QueryCache = v1.NewQueryCache(*queryCacheSize, *queryCacheHorizon)
+	}
+
+	webService := &web.WebService{
+		StatusHandler: prometheusStatus,
+		APIv1:         apiv1,
+	}
+
+	p := &prometheus{
+		unwrittenSamples: unwrittenSamples,
+
+		conf: conf,
+
+		targetManager:   targetManager,
+		remoteTSDBQueue: remoteTSDBQueue,
+		traceReporter:   traceReporter,
+
+		webService:    webService,
+		statusHandler: prometheusStatus,
+		apiv1:         apiv1,
+	}
+	webService.QuitDelegate = p.Close
+	webService.ReloadDelegate = p.reloadConfig
+
+	// An agent only scrapes and forwards samples. Everything else serves
+	// queries and evaluates rules against the local storage.
+	if *agentMode {
+		logger.Info("Running in agent mode.")
+		return p
+	}
+
+	p.notificationHandler = notification.NewNotificationHandler(*alertmanagerURL, *alertmanagerPasswordFile, *notificationQueueCapacity)
+
+	sources, err := parseRemoteSources(*queryRemoteSources, *queryRemoteTimeout)
+	if err != nil {
+		logger.Fatal("Error parsing remote sources: ", err)
+	}
+
+	tiers, err := parseRetentionTiers(*retentionTiers)
+	if err != nil {
+		logger.Fatal("Error parsing retention tiers: ", err)
+	}
 	o := &local.MemorySeriesStorageOptions{
 		MemoryChunks:               *numMemoryChunks,
 		PersistenceStoragePath:     *persistenceStoragePath,
 		PersistenceRetentionPeriod: *persistenceRetentionPeriod,
+		RetentionTiers:             tiers,
 		PersistenceQueueCapacity:   *persistenceQueueCapacity,
 		CheckpointInterval:         *checkpointInterval,
 		CheckpointDirtySeriesLimit: *checkpointDirtySeriesLimit,
-		Dirty: *storageDirty,
+		CheckpointMaxIncrements:    *checkpointMaxIncrements,
+		CheckpointAutoTune:         *checkpointAutoTune,
+		ShutdownCheckpointTimeout:  *shutdownCheckpointTimeout,
+		Dirty:                      *storageDirty,
+		Limits: local.IngestionLimits{
+			MaxLabelNameLength:  *maxLabelNameLength,
+			MaxLabelValueLength: *maxLabelValueLength,
+			MaxLabelsPerSeries:  *maxLabelsPerSeries,
+			MaxSeriesPerMetric:  *maxSeriesPerMetric,
+		},
+		PersistenceStateChanged: slowDownScrapes,
 	}
-	memStorage, err := local.NewMemorySeriesStorage(o)
-	if err != nil {
-		glog.Fatal("Error opening memory series storage: ", err)
+	if p.storage, err = local.NewMemorySeriesStorage(o); err != nil {
+		logger.Fatal("Error opening memory series storage: ", err)
 	}
+	// Rules and queries can also select the series of the remote sources.
+	queryStorage := ast.WithRemoteSources(p.storage, sources)
 
-	ruleManager := manager.NewRuleManager(&manager.RuleManagerOptions{
+	p.ruleManager = manager.NewRuleManager(&manager.RuleManagerOptions{
 		Results:             unwrittenSamples,
-		NotificationHandler: notificationHandler,
+		NotificationHandler: p.notificationHandler,
 		EvaluationInterval:  conf.EvaluationInterval(),
-		Storage:             memStorage,
+		Storage:             queryStorage,
 		PrometheusURL:       web.MustBuildServerURL(),
+		ExternalLabels:      conf.GlobalLabels(),
 	})
-	if err := ruleManager.AddRulesFromConfig(conf); err != nil {
-		glog.Fatal("Error loading rule files: ", err)
+	if err := p.ruleManager.AddRulesFromConfig(conf); err != nil {
+		logger.Fatal("Error loading rule files: ", err)
 	}
+	prometheusStatus.RuleManager = p.ruleManager
+	apiv1.Storage = queryStorage
+	apiv1.RuleManager = p.ruleManager
 
-	var remoteTSDBQueue *remote.TSDBQueueManager
-	if *remoteTSDBUrl == "" {
-		glog.Warningf("No TSDB URL provided; not sending any samples to long-term storage")
-	} else {
-		openTSDB := opentsdb.NewClient(*remoteTSDBUrl, *remoteTSDBTimeout)
-		remoteTSDBQueue = remote.NewTSDBQueueManager(openTSDB, 512)
-	}
-
-	flags := map[string]string{}
-	flag.VisitAll(func(f *flag.Flag) {
-		flags[f.Name] = f.Value.String()
-	})
-	prometheusStatus := &web.PrometheusStatusHandler{
-		BuildInfo:   BuildInfo,
-		Config:      conf.String(),
-		RuleManager: ruleManager,
-		TargetPools: targetManager.Pools(),
-		Flags:       flags,
-		Birth:       time.Now(),
-	}
-
-	alertsHandler := &web.AlertsHandler{
-		RuleManager: ruleManager,
+	p.metricsService = &api.MetricsService{
+		Config:        &conf,
+		TargetManager: targetManager,
+		Storage:       queryStorage,
 	}
-
-	consolesHandler := &web.ConsolesHandler{
-		Storage: memStorage,
+	webService.MetricsHandler = p.metricsService
+	webService.AlertsHandler = &web.AlertsHandler{
+		RuleManager: p.ruleManager,
 	}
-
-	metricsService := &api.MetricsService{
-		Config:        &conf,
+	webService.ConsolesHandler = &web.ConsolesHandler{
+		Storage:       queryStorage,
 		TargetManager: targetManager,
-		Storage:       memStorage,
 	}
-
-	webService := &web.WebService{
-		StatusHandler:   prometheusStatus,
-		MetricsHandler:  metricsService,
-		ConsolesHandler: consolesHandler,
-		AlertsHandler:   alertsHandler,
+	webService.FederationHandler = &web.FederationHandler{
+		Storage:        p.storage,
+		ExternalLabels: conf.GlobalLabels(),
 	}
+	return p
+}
 
-	p := &prometheus{
-		unwrittenSamples: unwrittenSamples,
+// reloadConfig loads the configuration file again and applies it to the
+// rule manager, the target manager, and the handlers displaying it. If the
+// file is invalid or a rule file cannot be loaded, the current configuration
+// stays in effect. Changes of the global labels and the evaluation interval
+// only take effect after a restart.
+func (p *prometheus) reloadConfig() error {
+	p.reloadMtx.Lock()
+	defer p.reloadMtx.Unlock()
 
-		ruleManager:         ruleManager,
-		targetManager:       targetManager,
-		notificationHandler: notificationHandler,
-		storage:             memStorage,
-		remoteTSDBQueue:     remoteTSDBQueue,
+	logger.Infof("Reloading configuration from %s...", *configFile)
+	conf, err := config.LoadFromFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("error loading configuration from %s: %s", *configFile, err)
+	}
+	if p.ruleManager != nil {
+		if err := p.ruleManager.ApplyConfig(conf); err != nil {
+			return fmt.Errorf("error loading rule files: %s", err)
+		}
+	}
+	p.targetManager.ApplyConfig(conf)
+	p.statusHandler.ApplyConfig(conf)
+	if p.metricsService != nil {
+		p.metricsService.ApplyConfig(conf)
+	}
+	p.apiv1.ApplyConfig(conf)
 
-		webService: webService,
+	if conf.GlobalLabels().String() != p.conf.GlobalLabels().String() {
+		logger.Warn("Changed global labels only take effect after a restart.")
 	}
-	webService.QuitDelegate = p.Close
-	return p
+	if conf.EvaluationInterval() != p.conf.EvaluationInterval() {
+		logger.Warn("A changed evaluation interval only takes effect after a restart.")
+	}
+	p.conf = conf
+	logger.Info("Configuration reloaded.")
+	return nil
 }
 
 // Serve starts the Prometheus server. It returns after the server has been shut
@@ -204,21 +387,30 @@ func (p *prometheus) Serve() {
 	if p.remoteTSDBQueue != nil {
 		go p.remoteTSDBQueue.Run()
 	}
-	go p.ruleManager.Run()
-	go p.notificationHandler.Run()
+	if p.traceReporter != nil {
+		go p.traceReporter.Run()
+	}
+	// The local storage, the rule manager, and the notification handler
+	// don't exist in agent mode.
+	if p.storage != nil {
+		go p.ruleManager.Run()
+		go p.notificationHandler.Run()
+		p.storage.Start()
+	}
 	go p.interruptHandler()
 
-	p.storage.Start()
-
 	go func() {
 		err := p.webService.ServeForever()
 		if err != nil {
-			glog.Fatal(err)
+			logger.Fatal(err)
 		}
 	}()
+	p.webService.SetReady(true)
 
 	for samples := range p.unwrittenSamples {
-		p.storage.AppendSamples(samples)
+		if p.storage != nil {
+			p.storage.AppendSamples(samples)
+		}
 		if p.remoteTSDBQueue != nil {
 			p.remoteTSDBQueue.Queue(samples)
 		}
@@ -226,16 +418,25 @@ func (p *prometheus) Serve() {
 
 	// The following shut-down operations have to happen after
 	// unwrittenSamples is drained. So do not move them into close().
-	if err := p.storage.Stop(); err != nil {
-		glog.Error("Error stopping local storage: ", err)
+	// Pending notifications are sent before the possibly long final
+	// checkpoint of the local storage.
+	if p.notificationHandler != nil {
+		p.notificationHandler.Stop()
+	}
+
+	if p.storage != nil {
+		if err := p.storage.Stop(); err != nil {
+			logger.Error("Error stopping local storage: ", err)
+		}
 	}
 
 	if p.remoteTSDBQueue != nil {
 		p.remoteTSDBQueue.Stop()
 	}
-
-	p.notificationHandler.Stop()
-	glog.Info("See you next time!")
+	if p.traceReporter != nil {
+		p.traceReporter.Stop()
+	}
+	logger.Info("See you next time!")
 }
 
 // Close cleanly shuts down the Prometheus server.
@@ -248,14 +449,17 @@ func (p *prometheus) interruptHandler() {
 	signal.Notify(notifier, os.Interrupt, syscall.SIGTERM)
 	<-notifier
 
-	glog.Warning("Received SIGTERM, exiting gracefully...")
+	logger.Warn("Received SIGTERM, exiting gracefully...")
 	p.Close()
 }
 
 func (p *prometheus) close() {
-	glog.Info("Shutdown has been requested; subsytems are closing:")
+	logger.Info("Shutdown has been requested; subsytems are closing:")
+	p.webService.SetReady(false)
 	p.targetManager.Stop()
-	p.ruleManager.Stop()
+	if p.ruleManager != nil {
+		p.ruleManager.Stop()
+	}
 
 	close(p.unwrittenSamples)
 	// Note: Before closing the remaining subsystems (storage, ...), we have
@@ -267,11 +471,19 @@ func (p *prometheus) close() {
 func (p *prometheus) Describe(ch chan<- *registry.Desc) {
 	ch <- samplesQueueCapDesc
 	ch <- samplesQueueLenDesc
-	p.notificationHandler.Describe(ch)
-	p.storage.Describe(ch)
+	if p.storage != nil {
+		p.notificationHandler.Describe(ch)
+		p.storage.Describe(ch)
+	}
 	if p.remoteTSDBQueue != nil {
 		p.remoteTSDBQueue.Describe(ch)
 	}
+	if p.traceReporter != nil {
+		p.traceReporter.Describe(ch)
+	}
+	if p.apiv1.QueryCache != nil {
+		p.apiv1.QueryCache.Describe(ch)
+	}
 }
 
 // Collect implements registry.Collector.
@@ -286,11 +498,81 @@ func (p *prometheus) Collect(ch chan<- registry.Metric) {
 		registry.GaugeValue,
 		float64(len(p.unwrittenSamples)),
 	)
-	p.notificationHandler.Collect(ch)
-	p.storage.Collect(ch)
+	if p.storage != nil {
+		p.notificationHandler.Collect(ch)
+		p.storage.Collect(ch)
+	}
 	if p.remoteTSDBQueue != nil {
 		p.remoteTSDBQueue.Collect(ch)
 	}
+	if p.traceReporter != nil {
+		p.traceReporter.Collect(ch)
+	}
+	if p.apiv1.QueryCache != nil {
+		p.apiv1.QueryCache.Collect(ch)
+	}
+}
+
+// parseRetentionTiers parses a comma-separated list of retention tiers in the
+// form name=duration.
+func parseRetentionTiers(s string) (map[string]time.Duration, error) {
+	tiers := map[string]time.Duration{}
+	if s == "" {
+		return tiers, nil
+	}
+	for _, tier := range strings.Split(s, ",") {
+		parts := strings.SplitN(tier, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid retention tier %q, expected name=duration", tier)
+		}
+		if _, ok := tiers[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate retention tier %q", parts[0])
+		}
+		retention, err := utility.StringToDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration of retention tier %q: %s", parts[0], err)
+		}
+		tiers[parts[0]] = retention
+	}
+	return tiers, nil
+}
+
+// parseRemoteSources parses a comma-separated list of remote sources in the
+// form name=url.
+func parseRemoteSources(s string, timeout time.Duration) ([]*ast.RemoteSource, error) {
+	var sources []*ast.RemoteSource
+	if s == "" {
+		return sources, nil
+	}
+	names := map[string]struct{}{}
+	for _, source := range strings.Split(s, ",") {
+		parts := strings.SplitN(source, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid remote source %q, expected name=url", source)
+		}
+		if _, ok := names[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate remote source %q", parts[0])
+		}
+		if u, err := url.Parse(parts[1]); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL of remote source %q: %q", parts[0], parts[1])
+		}
+		names[parts[0]] = struct{}{}
+		sources = append(sources, ast.NewRemoteSource(parts[0], parts[1], timeout))
+	}
+	return sources, nil
+}
+
+// slowDownScrapes spaces out scrapes while chunk persistence cannot keep up
+// with ingestion, the more the further it falls behind.
+func slowDownScrapes(state local.PersistenceState) {
+	switch state {
+	case local.PersistenceNormal:
+		retrieval.SlowDownScrapes(1)
+	case local.PersistenceRushed:
+		retrieval.SlowDownScrapes(2)
+	case local.PersistenceThrottled:
+		retrieval.SlowDownScrapes(4)
+	}
 }
 
 func main() {
@@ -300,6 +582,10 @@ func main() {
 	if *printVersion {
 		os.Exit(0)
 	}
+	if err := log.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid logging flags:", err)
+		os.Exit(2)
+	}
 
 	p := NewPrometheus()
 	registry.MustRegister(p)
@@ -16,20 +16,25 @@ package notification
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/utility"
 )
 
+var logger = log.New("notification")
+
 const (
 	alertmanagerAPIEventsPath = "/api/alerts"
 	contentTypeJSON           = "application/json"
@@ -45,6 +50,8 @@ var (
 	deadline = flag.Duration("alertmanager.http-deadline", 10*time.Second, "Alert manager HTTP API timeout.")
 )
 
+var errNoAlertmanager = errors.New("no alert manager configured")
+
 // NotificationReq is a request for sending a notification to the alert manager
 // for a single alert vector element.
 type NotificationReq struct {
@@ -69,6 +76,13 @@ type NotificationReq struct {
 // remove it...
 type NotificationReqs []*NotificationReq
 
+// pendingReqs are notification requests waiting to be sent. If done is not
+// nil, the result of sending them is sent to it.
+type pendingReqs struct {
+	reqs NotificationReqs
+	done chan<- error
+}
+
 type httpPoster interface {
 	Post(url string, bodyType string, body io.Reader) (*http.Response, error)
 }
@@ -78,8 +92,11 @@ type httpPoster interface {
 type NotificationHandler struct {
 	// The URL of the alert manager to send notifications to.
 	alertmanagerURL string
+	// If not empty, the file to read the password of the user of
+	// alertmanagerURL from before each notification.
+	passwordFile string
 	// Buffer of notifications that have not yet been sent.
-	pendingNotifications chan NotificationReqs
+	pendingNotifications chan pendingReqs
 	// HTTP client with custom timeout settings.
 	httpClient httpPoster
 
@@ -88,17 +105,23 @@ type NotificationHandler struct {
 	notificationDropped        prometheus.Counter
 	notificationsQueueLength   prometheus.Gauge
 	notificationsQueueCapacity prometheus.Metric
+	// The number of dropped notifications, for DroppedNotifications.
+	// Accessed atomically.
+	dropped uint64
 
 	stopped chan struct{}
 }
 
-// NewNotificationHandler constructs a new NotificationHandler.
-func NewNotificationHandler(alertmanagerURL string, notificationQueueCapacity int) *NotificationHandler {
+// NewNotificationHandler constructs a new NotificationHandler. If
+// passwordFile is not empty, the password of the user of alertmanagerURL is
+// read from it before each notification.
+func NewNotificationHandler(alertmanagerURL, passwordFile string, notificationQueueCapacity int) *NotificationHandler {
 	return &NotificationHandler{
 		alertmanagerURL:      alertmanagerURL,
-		pendingNotifications: make(chan NotificationReqs, notificationQueueCapacity),
+		passwordFile:         passwordFile,
+		pendingNotifications: make(chan pendingReqs, notificationQueueCapacity),
 
-		httpClient: utility.NewDeadlineClient(*deadline),
+		httpClient: utility.NewDeadlineClient(*deadline, nil),
 
 		notificationLatency: prometheus.NewSummary(prometheus.SummaryOpts{
 			Namespace: namespace,
@@ -157,9 +180,13 @@ func (n *NotificationHandler) sendNotifications(reqs NotificationReqs) error {
 	if err != nil {
 		return err
 	}
-	glog.V(1).Infoln("Sending notifications to alertmanager:", string(buf))
+	u, err := utility.URLWithPasswordFile(n.alertmanagerURL, n.passwordFile)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Sending notifications to alertmanager: %s", buf)
 	resp, err := n.httpClient.Post(
-		n.alertmanagerURL+alertmanagerAPIEventsPath,
+		u+alertmanagerAPIEventsPath,
 		contentTypeJSON,
 		bytes.NewBuffer(buf),
 	)
@@ -172,43 +199,74 @@ func (n *NotificationHandler) sendNotifications(reqs NotificationReqs) error {
 	if err != nil {
 		return err
 	}
-	// BUG: Do we need to check the response code?
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert manager returned HTTP status %s", resp.Status)
+	}
 	return nil
 }
 
 // Run dispatches notifications continuously.
 func (n *NotificationHandler) Run() {
-	for reqs := range n.pendingNotifications {
-		if n.alertmanagerURL == "" {
-			glog.Warning("No alert manager configured, not dispatching notification")
-			n.notificationDropped.Inc()
-			continue
+	for pending := range n.pendingNotifications {
+		err := n.dispatch(pending.reqs)
+		if pending.done != nil {
+			pending.done <- err
 		}
+	}
+	close(n.stopped)
+}
 
-		begin := time.Now()
-		err := n.sendNotifications(reqs)
+func (n *NotificationHandler) dispatch(reqs NotificationReqs) error {
+	if n.alertmanagerURL == "" {
+		logger.Warn("No alert manager configured, not dispatching notification")
+		n.notificationDropped.Inc()
+		atomic.AddUint64(&n.dropped, 1)
+		return errNoAlertmanager
+	}
 
-		if err != nil {
-			glog.Error("Error sending notification: ", err)
-			n.notificationErrors.Inc()
-		}
+	begin := time.Now()
+	err := n.sendNotifications(reqs)
 
-		n.notificationLatency.Observe(float64(time.Since(begin) / time.Millisecond))
+	if err != nil {
+		logger.Error("Error sending notification: ", err)
+		n.notificationErrors.Inc()
 	}
-	close(n.stopped)
+
+	n.notificationLatency.Observe(float64(time.Since(begin) / time.Millisecond))
+	return err
 }
 
 // SubmitReqs queues the given notification requests for processing.
 func (n *NotificationHandler) SubmitReqs(reqs NotificationReqs) {
-	n.pendingNotifications <- reqs
+	n.pendingNotifications <- pendingReqs{reqs: reqs}
+}
+
+// SubmitReqsAndWait queues the given notification requests for processing
+// like SubmitReqs, but only returns once they have been processed. It returns
+// the error sending them, if any.
+func (n *NotificationHandler) SubmitReqsAndWait(reqs NotificationReqs) error {
+	done := make(chan error, 1)
+	n.pendingNotifications <- pendingReqs{reqs: reqs, done: done}
+	return <-done
+}
+
+// QueueLength returns the number of notification requests waiting to be sent.
+func (n *NotificationHandler) QueueLength() int {
+	return len(n.pendingNotifications)
+}
+
+// DroppedNotifications returns the number of notification requests dropped
+// since the notification handler was created.
+func (n *NotificationHandler) DroppedNotifications() uint64 {
+	return atomic.LoadUint64(&n.dropped)
 }
 
 // Stop shuts down the notification handler.
 func (n *NotificationHandler) Stop() {
-	glog.Info("Stopping notification handler...")
+	logger.Info("Stopping notification handler...")
 	close(n.pendingNotifications)
 	<-n.stopped
-	glog.Info("Notification handler stopped.")
+	logger.Info("Notification handler stopped.")
 }
 
 // Describe implements prometheus.Collector.
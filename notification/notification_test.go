@@ -35,7 +35,8 @@ func (p *testHTTPPoster) Post(url string, bodyType string, body io.Reader) (*htt
 	p.message = buf.String()
 	p.receivedPost <- true
 	return &http.Response{
-		Body: ioutil.NopCloser(&bytes.Buffer{}),
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
 	}, nil
 }
 
@@ -46,7 +47,7 @@ type testNotificationScenario struct {
 }
 
 func (s *testNotificationScenario) test(i int, t *testing.T) {
-	h := NewNotificationHandler("alertmanager_url", 0)
+	h := NewNotificationHandler("alertmanager_url", "", 0)
 	defer h.Stop()
 
 	receivedPost := make(chan bool, 1)
@@ -89,3 +90,53 @@ func TestNotificationHandler(t *testing.T) {
 		s.test(i, t)
 	}
 }
+
+func TestDroppedNotifications(t *testing.T) {
+	h := NewNotificationHandler("", "", 2)
+	h.SubmitReqs(NotificationReqs{{Summary: "first"}})
+	h.SubmitReqs(NotificationReqs{{Summary: "second"}})
+	if got := h.QueueLength(); got != 2 {
+		t.Fatalf("Expected queue length 2, got %d", got)
+	}
+
+	go h.Run()
+	h.Stop()
+
+	if got := h.DroppedNotifications(); got != 2 {
+		t.Errorf("Expected 2 dropped notifications, got %d", got)
+	}
+	if got := h.QueueLength(); got != 0 {
+		t.Errorf("Expected queue length 0, got %d", got)
+	}
+}
+
+type statusHTTPPoster int
+
+func (p statusHTTPPoster) Post(url string, bodyType string, body io.Reader) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: int(p),
+		Status:     http.StatusText(int(p)),
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
+	}, nil
+}
+
+func TestSubmitReqsAndWait(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		h := NewNotificationHandler("alertmanager_url", "", 0)
+		h.httpClient = statusHTTPPoster(status)
+		go h.Run()
+
+		err := h.SubmitReqsAndWait(NotificationReqs{{Summary: "test"}})
+		if (err == nil) != (status == http.StatusOK) {
+			t.Errorf("Unexpected error for HTTP status %d: %v", status, err)
+		}
+		h.Stop()
+	}
+
+	h := NewNotificationHandler("", "", 0)
+	go h.Run()
+	defer h.Stop()
+	if err := h.SubmitReqsAndWait(NotificationReqs{{Summary: "test"}}); err != errNoAlertmanager {
+		t.Errorf("Expected error %q without alert manager, got %v", errNoAlertmanager, err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/matttproud/golang_protobuf_extensions/ext"
+	"github.com/prometheus/client_golang/text"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// errLegacyFormat is returned by decodeMetricFamilies for exposition formats
+// that are not based on metric families. Those are left to the extraction
+// package.
+var errLegacyFormat = errors.New("legacy exposition format")
+
+// metricFamily is a decoded metric family, independent of the exposition
+// format it was read from.
+type metricFamily struct {
+	name string
+	// The type of the family as exposed, in lower case, e.g. "counter".
+	typ  string
+	help string
+	// The unit of the family, e.g. "seconds". Only the OpenMetrics format
+	// exposes units.
+	unit string
+	// The samples of the family. Samples without an exposed timestamp have
+	// the timestamp of the scrape.
+	samples clientmodel.Samples
+}
+
+// decodeMetricFamilies decodes a scrape response into metric families. The
+// format of the response is determined from its Content-Type header. Families
+// excluded by the filter are skipped without decoding their samples.
+func decodeMetricFamilies(header http.Header, body io.Reader, timestamp clientmodel.Timestamp, filter *familyFilter) ([]*metricFamily, error) {
+	mediatype, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type header %q: %s", header.Get("Content-Type"), err)
+	}
+	var families []*dto.MetricFamily
+	switch mediatype {
+	case "application/vnd.google.protobuf":
+		if params["proto"] != "io.prometheus.client.MetricFamily" {
+			return nil, fmt.Errorf("unrecognized protocol message %s", params["proto"])
+		}
+		if params["encoding"] != "delimited" {
+			return nil, fmt.Errorf("unsupported encoding %s", params["encoding"])
+		}
+		families, err = decodeProtobuf(body, filter)
+	case "text/plain":
+		switch params["version"] {
+		case "0.0.4", "":
+			if filter != nil {
+				body = newTextFamilyFilter(body, filter)
+			}
+			families, err = decodeText(body)
+		default:
+			return nil, fmt.Errorf("unrecognized API version %s", params["version"])
+		}
+	case "application/openmetrics-text":
+		switch params["version"] {
+		case "1.0.0", "0.0.1", "":
+			return decodeOpenMetrics(body, timestamp, filter)
+		default:
+			return nil, fmt.Errorf("unrecognized OpenMetrics version %s", params["version"])
+		}
+	default:
+		return nil, errLegacyFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*metricFamily, 0, len(families))
+	for _, f := range families {
+		res = append(res, &metricFamily{
+			name:    f.GetName(),
+			typ:     strings.ToLower(f.GetType().String()),
+			help:    f.GetHelp(),
+			samples: familySamples(f, timestamp),
+		})
+	}
+	return res, nil
+}
+
+// DecodeTextSamples decodes samples in the text exposition format, as pushed
+// to the push endpoint of the API. Samples without an explicit timestamp get
+// the provided timestamp.
+func DecodeTextSamples(body io.Reader, timestamp clientmodel.Timestamp) (clientmodel.Samples, error) {
+	families, err := decodeText(body)
+	if err != nil {
+		return nil, err
+	}
+	var samples clientmodel.Samples
+	for _, f := range families {
+		samples = append(samples, familySamples(f, timestamp)...)
+	}
+	return samples, nil
+}
+
+func decodeProtobuf(body io.Reader, filter *familyFilter) ([]*dto.MetricFamily, error) {
+	var families []*dto.MetricFamily
+	if filter == nil {
+		for {
+			family := &dto.MetricFamily{}
+			if _, err := ext.ReadDelimited(body, family); err != nil {
+				if err == io.EOF {
+					return families, nil
+				}
+				return nil, err
+			}
+			families = append(families, family)
+		}
+	}
+
+	// Read the delimited messages by hand to look at the family names before
+	// unmarshalling them.
+	r := bufio.NewReader(body)
+	var buf []byte
+	for {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			if err == io.EOF {
+				return families, nil
+			}
+			return nil, err
+		}
+		if uint64(cap(buf)) < l {
+			buf = make([]byte, l)
+		}
+		buf = buf[:l]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if name, ok := protobufFamilyName(buf); ok && !filter.keep(name) {
+			continue
+		}
+		family := &dto.MetricFamily{}
+		if err := proto.Unmarshal(buf, family); err != nil {
+			return nil, err
+		}
+		if !filter.keep(family.GetName()) {
+			continue
+		}
+		families = append(families, family)
+	}
+}
+
+func decodeText(body io.Reader) ([]*dto.MetricFamily, error) {
+	var parser text.Parser
+	familiesByName, err := parser.TextToMetricFamilies(body)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(familiesByName))
+	for name := range familiesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	families := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		families = append(families, familiesByName[name])
+	}
+	return families, nil
+}
+
+// familySamples turns a metric family into samples. Metrics without an
+// explicit timestamp get the provided timestamp.
+func familySamples(f *dto.MetricFamily, timestamp clientmodel.Timestamp) clientmodel.Samples {
+	samples := make(clientmodel.Samples, 0, len(f.Metric))
+
+	add := func(m *dto.Metric, name string, value float64, extraName clientmodel.LabelName, extraValue string) *clientmodel.Sample {
+		metric := make(clientmodel.Metric, len(m.Label)+2)
+		for _, p := range m.Label {
+			metric[clientmodel.LabelName(p.GetName())] = clientmodel.LabelValue(p.GetValue())
+		}
+		if extraName != "" {
+			metric[extraName] = clientmodel.LabelValue(extraValue)
+		}
+		metric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(name)
+
+		sample := &clientmodel.Sample{
+			Metric:    metric,
+			Value:     clientmodel.SampleValue(value),
+			Timestamp: timestamp,
+		}
+		if m.TimestampMs != nil {
+			sample.Timestamp = clientmodel.TimestampFromUnixNano(*m.TimestampMs * 1000000)
+		}
+		samples = append(samples, sample)
+		return sample
+	}
+
+	name := f.GetName()
+	for _, m := range f.Metric {
+		switch f.GetType() {
+		case dto.MetricType_COUNTER:
+			if m.Counter != nil {
+				add(m, name, m.Counter.GetValue(), "", "")
+			}
+		case dto.MetricType_GAUGE:
+			if m.Gauge != nil {
+				add(m, name, m.Gauge.GetValue(), "", "")
+			}
+		case dto.MetricType_UNTYPED:
+			if m.Untyped != nil {
+				add(m, name, m.Untyped.GetValue(), "", "")
+			}
+		case dto.MetricType_SUMMARY:
+			if m.Summary == nil {
+				continue
+			}
+			for _, q := range m.Summary.Quantile {
+				add(m, name, q.GetValue(), clientmodel.QuantileLabel, fmt.Sprint(q.GetQuantile()))
+			}
+			if m.Summary.SampleSum != nil {
+				add(m, name+"_sum", m.Summary.GetSampleSum(), "", "")
+			}
+			if m.Summary.SampleCount != nil {
+				add(m, name+"_count", float64(m.Summary.GetSampleCount()), "", "")
+			}
+		case dto.MetricType_HISTOGRAM:
+			if m.Histogram == nil {
+				continue
+			}
+			infSeen := false
+			for _, b := range m.Histogram.Bucket {
+				add(m, name+"_bucket", float64(b.GetCumulativeCount()), clientmodel.BucketLabel, fmt.Sprint(b.GetUpperBound()))
+				if math.IsInf(b.GetUpperBound(), +1) {
+					infSeen = true
+				}
+			}
+			if m.Histogram.SampleSum != nil {
+				add(m, name+"_sum", m.Histogram.GetSampleSum(), "", "")
+			}
+			if m.Histogram.SampleCount != nil {
+				count := float64(m.Histogram.GetSampleCount())
+				add(m, name+"_count", count, "", "")
+				if !infSeen {
+					add(m, name+"_bucket", count, clientmodel.BucketLabel, "+Inf")
+				}
+			}
+		}
+	}
+	return samples
+}
@@ -0,0 +1,248 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/matttproud/golang_protobuf_extensions/ext"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDecodeMetricFamiliesText(t *testing.T) {
+	body := `# TYPE rpc_latency summary
+rpc_latency{quantile="0.5"} 4
+rpc_latency_sum 20
+rpc_latency_count 5
+# TYPE req_duration histogram
+req_duration_bucket{le="0.1"} 1
+req_duration_bucket{le="1"} 3
+req_duration_sum 2.5
+req_duration_count 3
+`
+	header := http.Header{"Content-Type": {"text/plain; version=0.0.4"}}
+	ts := clientmodel.Timestamp(42)
+	families, err := decodeMetricFamilies(header, strings.NewReader(body), ts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 2 {
+		t.Fatalf("want 2 families, got %d", len(families))
+	}
+
+	var got []string
+	for _, f := range families {
+		for _, s := range f.samples {
+			if s.Timestamp != ts {
+				t.Errorf("want timestamp %v, got %v", ts, s.Timestamp)
+			}
+			got = append(got, s.Metric.String()+" "+s.Value.String())
+		}
+	}
+	want := []string{
+		`req_duration_bucket{le="0.1"} 1`,
+		`req_duration_bucket{le="1"} 3`,
+		`req_duration_sum 2.5`,
+		`req_duration_count 3`,
+		`req_duration_bucket{le="+Inf"} 3`,
+		`rpc_latency{quantile="0.5"} 4`,
+		`rpc_latency_sum 20`,
+		`rpc_latency_count 5`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d samples, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d. want sample %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestDecodeMetricFamiliesProtobuf(t *testing.T) {
+	var buf bytes.Buffer
+	family := &dto.MetricFamily{
+		Name: proto.String("requests_total"),
+		Help: proto.String("Total number of requests."),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{
+			{
+				Label:       []*dto.LabelPair{{Name: proto.String("code"), Value: proto.String("200")}},
+				Counter:     &dto.Counter{Value: proto.Float64(10)},
+				TimestampMs: proto.Int64(1000),
+			},
+		},
+	}
+	if _, err := ext.WriteDelimited(&buf, family); err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{"Content-Type": {"application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"}}
+	families, err := decodeMetricFamilies(header, &buf, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].help != "Total number of requests." || families[0].typ != "counter" {
+		t.Fatalf("unexpected families: %v", families)
+	}
+
+	samples := families[0].samples
+	want := &clientmodel.Sample{
+		Metric: clientmodel.Metric{
+			clientmodel.MetricNameLabel: "requests_total",
+			"code":                      "200",
+		},
+		Value:     10,
+		Timestamp: clientmodel.TimestampFromUnixNano(1000 * 1000000),
+	}
+	if len(samples) != 1 || !samples[0].Equal(want) {
+		t.Fatalf("want samples %v, got %v", clientmodel.Samples{want}, samples)
+	}
+}
+
+func TestDecodeMetricFamiliesFilter(t *testing.T) {
+	filter := newFamilyFilter(
+		[]*regexp.Regexp{regexp.MustCompile("^(?:rpc_.*|up|go_goroutines)$")},
+		[]*regexp.Regexp{regexp.MustCompile("^(?:rpc_errors)$")},
+	)
+	text := `# HELP rpc_latency RPC latency.
+# TYPE rpc_latency summary
+rpc_latency{quantile="0.5"} 4
+rpc_latency_sum 20
+rpc_latency_count 5
+# TYPE http_duration histogram
+http_duration_bucket{le="1"} 3
+http_duration_sum 2.5
+http_duration_count 3
+rpc_errors 3
+# An unrelated comment.
+up 1
+go_goroutines_total 7
+`
+	openMetrics := `# TYPE rpc_latency summary
+# HELP rpc_latency RPC latency.
+rpc_latency{quantile="0.5"} 4
+rpc_latency_sum 20
+rpc_latency_count 5
+# TYPE http_duration histogram
+http_duration_bucket{le="1"} 3
+http_duration_bucket{le="+Inf"} 3
+http_duration_sum 2.5
+http_duration_count 3
+rpc_errors 3
+up 1
+go_goroutines_total 7
+# EOF
+`
+	var protobuf bytes.Buffer
+	for _, name := range []string{"rpc_latency", "http_duration", "up"} {
+		family := &dto.MetricFamily{
+			Name:   proto.String(name),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		}
+		if _, err := ext.WriteDelimited(&protobuf, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios := []struct {
+		contentType string
+		body        string
+		want        []string
+	}{
+		{
+			contentType: "text/plain; version=0.0.4",
+			body:        text,
+			want:        []string{"rpc_latency", "up"},
+		},
+		{
+			contentType: "application/openmetrics-text; version=1.0.0",
+			body:        openMetrics,
+			want:        []string{"rpc_latency", "up"},
+		},
+		{
+			contentType: "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited",
+			body:        protobuf.String(),
+			want:        []string{"rpc_latency", "up"},
+		},
+	}
+	for i, s := range scenarios {
+		header := http.Header{"Content-Type": {s.contentType}}
+		families, err := decodeMetricFamilies(header, strings.NewReader(s.body), 42, filter)
+		if err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		var got []string
+		for _, f := range families {
+			got = append(got, f.name)
+			if len(f.samples) == 0 {
+				t.Errorf("%d. no samples decoded for %s", i, f.name)
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(s.want, ",") {
+			t.Errorf("%d. want families %v, got %v", i, s.want, got)
+		}
+	}
+}
+
+func TestDecodeMetricFamiliesLegacy(t *testing.T) {
+	header := http.Header{"Content-Type": {`application/json; schema="prometheus/telemetry"; version=0.0.2`}}
+	if _, err := decodeMetricFamilies(header, strings.NewReader("[]"), 0, nil); err != errLegacyFormat {
+		t.Fatalf("want error %q, got %v", errLegacyFormat, err)
+	}
+}
+
+func TestDecodeTextSamples(t *testing.T) {
+	body := `batch_last_success_seconds 1.4e9
+batch_records_processed{stage="load"} 1000 1000
+`
+	samples, err := DecodeTextSamples(strings.NewReader(body), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clientmodel.Samples{
+		{
+			Metric:    clientmodel.Metric{clientmodel.MetricNameLabel: "batch_last_success_seconds"},
+			Value:     1.4e9,
+			Timestamp: 42,
+		},
+		{
+			Metric:    clientmodel.Metric{clientmodel.MetricNameLabel: "batch_records_processed", "stage": "load"},
+			Value:     1000,
+			Timestamp: clientmodel.TimestampFromUnixNano(1000 * 1000000),
+		},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("want samples %v, got %v", want, samples)
+	}
+	for i := range want {
+		if !samples[i].Equal(want[i]) {
+			t.Errorf("%d. want sample %v, got %v", i, want[i], samples[i])
+		}
+	}
+
+	if _, err := DecodeTextSamples(strings.NewReader("invalid metric\n"), 42); err == nil {
+		t.Error("want error for invalid input")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"github.com/prometheus/client_golang/extraction"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// DerivedMetric describes series computed from the samples of each scrape of
+// a target, which are ingested alongside them.
+type DerivedMetric struct {
+	// The metric name of the derived series.
+	Name clientmodel.LabelValue
+	// The metric name of the scraped samples summed up into the value of
+	// the derived series.
+	Metric clientmodel.LabelValue
+	// The labels of the scraped samples kept in the derived series, which
+	// has one series per combination of their values.
+	By clientmodel.LabelNames
+	// If not empty, the metric name of the scraped samples whose sum by the
+	// same labels the sum is divided by.
+	DivisorMetric clientmodel.LabelValue
+}
+
+func derivedMetricsForJob(job config.JobConfig) []DerivedMetric {
+	var res []DerivedMetric
+	for _, d := range job.GetDerivedMetric() {
+		derived := DerivedMetric{
+			Name:          clientmodel.LabelValue(d.GetName()),
+			Metric:        clientmodel.LabelValue(d.GetMetric()),
+			DivisorMetric: clientmodel.LabelValue(d.GetDivisorMetric()),
+		}
+		for _, ln := range d.GetBy() {
+			derived.By = append(derived.By, clientmodel.LabelName(ln))
+		}
+		res = append(res, derived)
+	}
+	return res
+}
+
+// derivedMetricsIngester hands samples over to its Ingester unchanged while
+// summing up those needed for its derived metrics. The derived samples are
+// ingested by flush.
+type derivedMetricsIngester struct {
+	derived   []DerivedMetric
+	timestamp clientmodel.Timestamp
+	// The groups of each derived metric by the fingerprint of their labels.
+	groups []map[clientmodel.Fingerprint]*derivedGroup
+
+	Ingester extraction.Ingester
+}
+
+// derivedGroup holds the sums of the samples of a derived series.
+type derivedGroup struct {
+	labels                  clientmodel.Metric
+	sum, divisor            clientmodel.SampleValue
+	hasSamples, hasDivisors bool
+}
+
+func newDerivedMetricsIngester(derived []DerivedMetric, timestamp clientmodel.Timestamp, ingester extraction.Ingester) *derivedMetricsIngester {
+	i := &derivedMetricsIngester{
+		derived:   derived,
+		timestamp: timestamp,
+		groups:    make([]map[clientmodel.Fingerprint]*derivedGroup, len(derived)),
+		Ingester:  ingester,
+	}
+	for j := range i.groups {
+		i.groups[j] = map[clientmodel.Fingerprint]*derivedGroup{}
+	}
+	return i
+}
+
+// Ingest ingests the provided extraction result by handing it over to
+// i.Ingester after adding its samples to the sums of the derived metrics.
+func (i *derivedMetricsIngester) Ingest(samples clientmodel.Samples) error {
+	for _, s := range samples {
+		name := s.Metric[clientmodel.MetricNameLabel]
+		for j, d := range i.derived {
+			if name != d.Metric && (d.DivisorMetric == "" || name != d.DivisorMetric) {
+				continue
+			}
+			g := i.group(j, s.Metric)
+			if name == d.Metric {
+				g.sum += s.Value
+				g.hasSamples = true
+			}
+			if name == d.DivisorMetric {
+				g.divisor += s.Value
+				g.hasDivisors = true
+			}
+		}
+	}
+	return i.Ingester.Ingest(samples)
+}
+
+// group returns the group of the j-th derived metric the sample of the given
+// metric belongs to.
+func (i *derivedMetricsIngester) group(j int, m clientmodel.Metric) *derivedGroup {
+	d := i.derived[j]
+	labels := make(clientmodel.Metric, len(d.By)+1)
+	for _, ln := range d.By {
+		if lv, ok := m[ln]; ok {
+			labels[ln] = lv
+		}
+	}
+	labels[clientmodel.MetricNameLabel] = d.Name
+	fp := labels.Fingerprint()
+	g, ok := i.groups[j][fp]
+	if !ok {
+		g = &derivedGroup{labels: labels}
+		i.groups[j][fp] = g
+	}
+	return g
+}
+
+// flush hands over the samples of the derived metrics to i.Ingester, one
+// derived metric at a time.
+func (i *derivedMetricsIngester) flush() error {
+	for j, d := range i.derived {
+		samples := make(clientmodel.Samples, 0, len(i.groups[j]))
+		for _, g := range i.groups[j] {
+			if !g.hasSamples || (d.DivisorMetric != "" && !g.hasDivisors) {
+				continue
+			}
+			value := g.sum
+			if d.DivisorMetric != "" {
+				value /= g.divisor
+			}
+			samples = append(samples, &clientmodel.Sample{
+				Metric:    g.labels,
+				Value:     value,
+				Timestamp: i.timestamp,
+			})
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if err := i.Ingester.Ingest(samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"regexp"
+)
+
+// familyFilter selects the metric families to ingest from a scrape by name.
+// A nil familyFilter keeps all families.
+type familyFilter struct {
+	// If not empty, only families matching one of them are kept.
+	allow []*regexp.Regexp
+	// Families matching any of them are dropped.
+	deny []*regexp.Regexp
+}
+
+// newFamilyFilter returns a familyFilter for the given regular expressions,
+// or nil if there are none.
+func newFamilyFilter(allow, deny []*regexp.Regexp) *familyFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &familyFilter{allow: allow, deny: deny}
+}
+
+// keep returns whether the family of the given name is to be ingested.
+func (f *familyFilter) keep(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.allow) > 0 && !matchesAny(f.allow, name) {
+		return false
+	}
+	return !matchesAny(f.deny, name)
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// textFamilySuffixes are the suffixes the names of the samples of summaries
+// and histograms have on top of the family name in the text format.
+var textFamilySuffixes = map[string][]string{
+	"summary":   {"_sum", "_count"},
+	"histogram": {"_sum", "_count", "_bucket"},
+}
+
+// textFamilyFilter is an io.Reader passing on only the lines of a scrape in
+// the text format that belong to metric families kept by the filter, so that
+// the parser never sees the others. The family of a line is the one named by
+// the last HELP or TYPE comment if the sample name is the family name, or the
+// family name with one of the suffixes of summaries and histograms if it is
+// declared to be of one of those types. Other samples are untyped families of
+// their own, as the parser considers them.
+type textFamilyFilter struct {
+	r      *bufio.Reader
+	filter *familyFilter
+
+	family     []byte
+	familyType []byte
+	keep       bool
+
+	// The rest of the current line still to be passed on.
+	pending []byte
+	err     error
+}
+
+func newTextFamilyFilter(r io.Reader, filter *familyFilter) *textFamilyFilter {
+	return &textFamilyFilter{
+		r:      bufio.NewReader(r),
+		filter: filter,
+		keep:   true,
+	}
+}
+
+// Read implements io.Reader.
+func (t *textFamilyFilter) Read(p []byte) (int, error) {
+	for len(t.pending) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		var line []byte
+		line, t.err = t.readLine()
+		if len(line) > 0 && t.keepLine(line) {
+			t.pending = line
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// readLine returns the next line including the newline. It is only valid
+// until the next call.
+func (t *textFamilyFilter) readLine() ([]byte, error) {
+	line, err := t.r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	// Lines longer than the buffer are rare enough to copy.
+	line = append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		var more []byte
+		more, err = t.r.ReadSlice('\n')
+		line = append(line, more...)
+	}
+	return line, err
+}
+
+// keepLine returns whether the given line of the text format belongs to a
+// family kept by the filter.
+func (t *textFamilyFilter) keepLine(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " \t")
+	if len(trimmed) == 0 || trimmed[0] == '\n' {
+		return true
+	}
+	if trimmed[0] == '#' {
+		fields := bytes.Fields(trimmed[1:])
+		if len(fields) < 2 || (!bytes.Equal(fields[0], []byte("HELP")) && !bytes.Equal(fields[0], []byte("TYPE"))) {
+			// Other comments are ignored by the parser anyway.
+			return true
+		}
+		if !bytes.Equal(fields[1], t.family) {
+			t.setFamily(fields[1])
+		}
+		if bytes.Equal(fields[0], []byte("TYPE")) && len(fields) > 2 {
+			t.familyType = append(t.familyType[:0], fields[2]...)
+		}
+		return t.keep
+	}
+
+	name := trimmed
+	if i := bytes.IndexAny(trimmed, "{ \t"); i >= 0 {
+		name = trimmed[:i]
+	}
+	if !t.inFamily(name) {
+		t.setFamily(name)
+	}
+	return t.keep
+}
+
+func (t *textFamilyFilter) setFamily(name []byte) {
+	t.family = append(t.family[:0], name...)
+	t.familyType = t.familyType[:0]
+	t.keep = t.filter.keep(string(name))
+}
+
+// inFamily returns whether a sample of the given name belongs to the current
+// family.
+func (t *textFamilyFilter) inFamily(name []byte) bool {
+	if t.family == nil {
+		return false
+	}
+	if bytes.Equal(name, t.family) {
+		return true
+	}
+	if !bytes.HasPrefix(name, t.family) {
+		return false
+	}
+	suffix := string(name[len(t.family):])
+	for _, s := range textFamilySuffixes[string(t.familyType)] {
+		if suffix == s {
+			return true
+		}
+	}
+	return false
+}
+
+// protobufFamilyName returns the name of the metric family encoded in the
+// given protocol buffer message without decoding the rest of it, provided
+// the name is the first field, as encoded by all client libraries.
+func protobufFamilyName(msg []byte) (string, bool) {
+	// The name is field 1 with wire type 2 (length-delimited).
+	if len(msg) == 0 || msg[0] != 1<<3|2 {
+		return "", false
+	}
+	l, n := binary.Uvarint(msg[1:])
+	if n <= 0 || uint64(len(msg)-1-n) < l {
+		return "", false
+	}
+	return string(msg[1+n : 1+n+int(l)]), true
+}
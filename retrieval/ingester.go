@@ -15,11 +15,14 @@ package retrieval
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/extraction"
 
 	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
 )
 
 const ingestTimeout = 100 * time.Millisecond // TODO(beorn7): Adjust this to a fraction of the actual HTTP timeout.
@@ -46,6 +49,221 @@ func (i *MergeLabelsIngester) Ingest(samples clientmodel.Samples) error {
 	return i.Ingester.Ingest(samples)
 }
 
+// targetLabelsIngester attaches the labels of a target to the scraped samples
+// and passes the result on to another ingester.
+type targetLabelsIngester struct {
+	// The labels of the target, including the job and instance labels.
+	Labels clientmodel.LabelSet
+	// If true, exposed labels win over target labels of the same name. If
+	// false, target labels win and clashing exposed labels are renamed by
+	// prepending ExportedLabelPrefix to them.
+	HonorLabels bool
+
+	Ingester extraction.Ingester
+}
+
+// Ingest ingests the provided extraction result by merging in i.Labels and then
+// handing it over to i.Ingester.
+func (i *targetLabelsIngester) Ingest(samples clientmodel.Samples) error {
+	for _, s := range samples {
+		for ln, lv := range i.Labels {
+			if ev, ok := s.Metric[ln]; ok {
+				if i.HonorLabels {
+					continue
+				}
+				// Find a free name for the exposed label, keeping
+				// prepending the prefix if necessary.
+				exportedName := ExportedLabelPrefix + ln
+				for {
+					if _, ok := s.Metric[exportedName]; !ok {
+						break
+					}
+					exportedName = ExportedLabelPrefix + exportedName
+				}
+				s.Metric[exportedName] = ev
+			}
+			s.Metric[ln] = lv
+		}
+	}
+
+	return i.Ingester.Ingest(samples)
+}
+
+// timestampIngester sets the timestamp of all samples to a given timestamp
+// and passes them on to another ingester.
+type timestampIngester struct {
+	Timestamp clientmodel.Timestamp
+
+	Ingester extraction.Ingester
+}
+
+// Ingest ingests the provided extraction result by setting the timestamps of
+// all samples to i.Timestamp and then handing it over to i.Ingester.
+func (i *timestampIngester) Ingest(samples clientmodel.Samples) error {
+	for _, s := range samples {
+		s.Timestamp = i.Timestamp
+	}
+
+	return i.Ingester.Ingest(samples)
+}
+
+// familyFilterIngester drops the samples whose metric names are excluded by
+// a familyFilter and passes the rest on to another ingester. It is used for
+// the legacy exposition formats, which are not decoded into metric families
+// that could be skipped while parsing.
+type familyFilterIngester struct {
+	Filter *familyFilter
+
+	Ingester extraction.Ingester
+}
+
+// Ingest ingests the samples of the provided extraction result kept by
+// i.Filter by handing them over to i.Ingester.
+func (i *familyFilterIngester) Ingest(samples clientmodel.Samples) error {
+	kept := samples[:0]
+	for _, s := range samples {
+		if i.Filter.keep(string(s.Metric[clientmodel.MetricNameLabel])) {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return i.Ingester.Ingest(kept)
+}
+
+// sampleLimitIngester buffers all samples of a scrape as long as no more than
+// a given number of samples have been ingested. Once the limit is exceeded, the
+// buffer is dropped, but samples are still counted. That way, an oversized
+// scrape can be discarded as a whole.
+type sampleLimitIngester struct {
+	limit   int
+	count   int
+	batches []clientmodel.Samples
+}
+
+// Ingest buffers the provided extraction result if the limit of the ingester
+// is not exceeded.
+func (i *sampleLimitIngester) Ingest(samples clientmodel.Samples) error {
+	i.count += len(samples)
+	if i.exceeded() {
+		i.batches = nil
+		return nil
+	}
+	i.batches = append(i.batches, samples)
+	return nil
+}
+
+// exceeded returns whether more samples than the limit have been ingested.
+func (i *sampleLimitIngester) exceeded() bool {
+	return i.count > i.limit
+}
+
+// flush hands over all buffered samples to the provided ingester, preserving
+// the original batches.
+func (i *sampleLimitIngester) flush(ingester extraction.Ingester) error {
+	for _, samples := range i.batches {
+		if err := ingester.Ingest(samples); err != nil {
+			return err
+		}
+	}
+	i.batches = nil
+	return nil
+}
+
+// rateLimiter is a token bucket limiting the number of samples ingested per
+// second. It is safe for concurrent use.
+type rateLimiter struct {
+	mtx      sync.Mutex
+	rate     float64 // Tokens added per second.
+	capacity float64 // Maximum number of tokens.
+	tokens   float64
+	last     time.Time
+}
+
+// setLimit changes the rate and capacity of the bucket, keeping the current
+// number of tokens as far as it fits.
+func (l *rateLimiter) setLimit(rate, capacity float64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.rate, l.capacity = rate, capacity
+	if l.tokens > capacity {
+		l.tokens = capacity
+	}
+}
+
+// take removes n tokens from the bucket if it holds at least n tokens and
+// returns whether it did.
+func (l *rateLimiter) take(n int, now time.Time) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// rateLimiterForJob returns the rate limiter of the given job with its
+// capacity and rate updated to the job's configuration, or nil if the job has
+// no sample rate limit. If l is nil, a new rate limiter is returned. The
+// bucket holds the samples allowed during one scrape interval, so that the
+// whole output of a scrape can be ingested at once.
+func rateLimiterForJob(l *rateLimiter, job config.JobConfig) *rateLimiter {
+	limit := float64(job.GetSampleRateLimit())
+	if limit == 0 {
+		return nil
+	}
+	capacity := limit * job.ScrapeInterval().Seconds()
+	if capacity < limit {
+		capacity = limit
+	}
+	if l == nil {
+		l = &rateLimiter{tokens: capacity, last: time.Now()}
+	}
+	l.setLimit(limit, capacity)
+	return l
+}
+
+// rateLimitIngester hands over samples to another ingester as long as the rate
+// limiter permits it. Samples are dropped one extraction result at a time.
+type rateLimitIngester struct {
+	limiter *rateLimiter
+	// The job the samples belong to, for instrumentation.
+	job clientmodel.LabelValue
+
+	Ingester extraction.Ingester
+}
+
+// Ingest ingests the provided extraction result by handing it over to
+// i.Ingester if the rate limit allows and dropping it otherwise.
+func (i *rateLimitIngester) Ingest(samples clientmodel.Samples) error {
+	if !i.limiter.take(len(samples), time.Now()) {
+		targetSamplesRateLimited.WithLabelValues(string(i.job)).Add(float64(len(samples)))
+		return nil
+	}
+	return i.Ingester.Ingest(samples)
+}
+
+// countingIngester counts the samples it hands over to another ingester.
+type countingIngester struct {
+	count int
+
+	Ingester extraction.Ingester
+}
+
+// Ingest ingests the provided extraction result by counting its samples and
+// handing it over to i.Ingester.
+func (i *countingIngester) Ingest(samples clientmodel.Samples) error {
+	i.count += len(samples)
+	return i.Ingester.Ingest(samples)
+}
+
 // ChannelIngester feeds results into a channel without modifying them.
 type ChannelIngester chan<- clientmodel.Samples
 
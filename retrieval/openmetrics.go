@@ -0,0 +1,351 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// openMetricsSuffixes maps the OpenMetrics metric types to the suffixes the
+// names of their samples may have on top of the family name.
+var openMetricsSuffixes = map[string][]string{
+	"counter":        {"_total", "_created"},
+	"gauge":          {""},
+	"histogram":      {"_bucket", "_sum", "_count", "_created"},
+	"gaugehistogram": {"_bucket", "_gsum", "_gcount"},
+	"summary":        {"", "_sum", "_count", "_created"},
+	"info":           {"_info"},
+	"stateset":       {""},
+	"unknown":        {""},
+}
+
+// openMetricsParser parses the OpenMetrics text format line by line.
+type openMetricsParser struct {
+	timestamp clientmodel.Timestamp
+	filter    *familyFilter
+
+	lineNum  int
+	families []*metricFamily
+	current  *metricFamily
+	seen     map[string]bool
+	// Whether the current family is excluded by the filter. The samples
+	// of excluded families are not parsed.
+	excluded bool
+}
+
+// decodeOpenMetrics decodes the OpenMetrics text format. Exemplars are
+// validated syntactically but dropped, as are the _created samples of
+// counters, histograms, and summaries. Families excluded by the filter are
+// dropped.
+func decodeOpenMetrics(body io.Reader, timestamp clientmodel.Timestamp, filter *familyFilter) ([]*metricFamily, error) {
+	p := &openMetricsParser{
+		timestamp: timestamp,
+		filter:    filter,
+		seen:      map[string]bool{},
+	}
+	r := bufio.NewReader(body)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				return nil, p.errorf("line not terminated by newline")
+			}
+			return nil, fmt.Errorf("OpenMetrics exposition does not end with # EOF")
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.lineNum++
+		line = line[:len(line)-1]
+		if line == "# EOF" {
+			if _, err := r.ReadByte(); err != io.EOF {
+				return nil, p.errorf("unexpected data after # EOF")
+			}
+			return p.families, nil
+		}
+		if err := p.parseLine(line); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *openMetricsParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("OpenMetrics parse error on line %d: %s", p.lineNum, fmt.Sprintf(format, args...))
+}
+
+func (p *openMetricsParser) parseLine(line string) error {
+	if line == "" {
+		return p.errorf("empty line")
+	}
+	if !strings.HasPrefix(line, "#") {
+		return p.parseSample(line)
+	}
+
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 || fields[0] != "#" {
+		return p.errorf("invalid comment %q", line)
+	}
+	kind, name := fields[1], fields[2]
+	text := ""
+	if len(fields) == 4 {
+		text = fields[3]
+	}
+	if !isValidMetricName(name) {
+		return p.errorf("invalid metric name %q", name)
+	}
+	f, err := p.familyForMetadata(name)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "TYPE":
+		if _, ok := openMetricsSuffixes[text]; !ok {
+			return p.errorf("invalid metric type %q", text)
+		}
+		f.typ = text
+	case "HELP":
+		help, err := unescapeOpenMetrics(text)
+		if err != nil {
+			return p.errorf("%s", err)
+		}
+		f.help = help
+	case "UNIT":
+		if text != "" && !strings.HasSuffix(name, "_"+text) {
+			return p.errorf("unit %q is not a suffix of metric name %q", text, name)
+		}
+		f.unit = text
+	default:
+		return p.errorf("invalid comment %q", line)
+	}
+	return nil
+}
+
+// familyForMetadata returns the family the metadata of the named family is to
+// be recorded in. All metadata of a family must precede its samples.
+func (p *openMetricsParser) familyForMetadata(name string) (*metricFamily, error) {
+	if p.current != nil && p.current.name == name {
+		if len(p.current.samples) > 0 {
+			return nil, p.errorf("metadata for %s after its samples", name)
+		}
+		return p.current, nil
+	}
+	return p.newFamily(name)
+}
+
+func (p *openMetricsParser) newFamily(name string) (*metricFamily, error) {
+	if p.seen[name] {
+		return nil, p.errorf("metric family %s is not contiguous", name)
+	}
+	p.seen[name] = true
+	p.current = &metricFamily{name: name, typ: "unknown"}
+	p.excluded = !p.filter.keep(name)
+	if !p.excluded {
+		p.families = append(p.families, p.current)
+	}
+	return p.current, nil
+}
+
+func (p *openMetricsParser) parseSample(line string) error {
+	i := strings.IndexAny(line, "{ ")
+	if i < 0 {
+		return p.errorf("missing value in %q", line)
+	}
+	name := line[:i]
+	if !isValidMetricName(name) {
+		return p.errorf("invalid metric name %q", name)
+	}
+
+	f := p.current
+	suffix, ok := "", false
+	if f != nil {
+		suffix, ok = familySuffix(f, name)
+	}
+	if !ok {
+		var err error
+		if f, err = p.newFamily(name); err != nil {
+			return err
+		}
+	}
+	if p.excluded || suffix == "_created" {
+		return nil
+	}
+
+	metric := clientmodel.Metric{}
+	rest := line[i:]
+	if rest[0] == '{' {
+		var err error
+		if rest, err = parseOpenMetricsLabels(rest[1:], metric); err != nil {
+			return p.errorf("%s", err)
+		}
+	}
+	metric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(name)
+
+	if rest == "" || rest[0] != ' ' {
+		return p.errorf("missing value in %q", line)
+	}
+	rest = rest[1:]
+	if j := strings.Index(rest, " # "); j >= 0 {
+		if exemplar := rest[j+3:]; exemplar == "" || exemplar[0] != '{' {
+			return p.errorf("invalid exemplar %q", exemplar)
+		}
+		rest = rest[:j]
+	}
+	parts := strings.Split(rest, " ")
+	if len(parts) > 2 {
+		return p.errorf("unexpected data %q after sample", strings.Join(parts[2:], " "))
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return p.errorf("invalid value %q", parts[0])
+	}
+	timestamp := p.timestamp
+	if len(parts) == 2 {
+		ts, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return p.errorf("invalid timestamp %q", parts[1])
+		}
+		timestamp = clientmodel.TimestampFromUnixNano(int64(ts * 1e9))
+	}
+
+	f.samples = append(f.samples, &clientmodel.Sample{
+		Metric:    metric,
+		Value:     clientmodel.SampleValue(value),
+		Timestamp: timestamp,
+	})
+	return nil
+}
+
+// familySuffix returns the suffix of a sample name within the given family
+// and whether the sample belongs to the family at all.
+func familySuffix(f *metricFamily, name string) (string, bool) {
+	for _, suffix := range openMetricsSuffixes[f.typ] {
+		if name == f.name+suffix {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+// parseOpenMetricsLabels parses the label pairs following the opening brace of
+// a sample into metric. It returns the remainder of the line after the closing
+// brace.
+func parseOpenMetricsLabels(s string, metric clientmodel.Metric) (string, error) {
+	for {
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.Index(s, "=\"")
+		if eq < 0 {
+			return "", fmt.Errorf("invalid label pair in %q", s)
+		}
+		name := clientmodel.LabelName(s[:eq])
+		if !isValidLabelName(string(name)) {
+			return "", fmt.Errorf("invalid label name %q", name)
+		}
+		if _, ok := metric[name]; ok {
+			return "", fmt.Errorf("duplicate label name %q", name)
+		}
+		s = s[eq+2:]
+
+		end := -1
+		for k := 0; k < len(s); k++ {
+			if s[k] == '\\' {
+				k++
+			} else if s[k] == '"' {
+				end = k
+				break
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated value for label %q", name)
+		}
+		value, err := unescapeOpenMetrics(s[:end])
+		if err != nil {
+			return "", err
+		}
+		metric[name] = clientmodel.LabelValue(value)
+
+		s = s[end+1:]
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, "}"):
+		default:
+			return "", fmt.Errorf("expected ',' or '}' after value of label %q", name)
+		}
+	}
+}
+
+// unescapeOpenMetrics resolves the escape sequences allowed in HELP texts and
+// label values.
+func unescapeOpenMetrics(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("trailing backslash in %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case '"':
+			b.WriteByte('"')
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isValidMetricName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, b := range []byte(s) {
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_' || b == ':' || i > 0 && b >= '0' && b <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidLabelName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, b := range []byte(s) {
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_' || i > 0 && b >= '0' && b <= '9') {
+			return false
+		}
+	}
+	return true
+}
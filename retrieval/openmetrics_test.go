@@ -0,0 +1,109 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestDecodeOpenMetrics(t *testing.T) {
+	body := `# TYPE requests counter
+# HELP requests Total number of \"requests\".
+requests_total{code="200"} 10 # {trace_id="abc"} 1 1.5
+requests_created{code="200"} 1000
+# TYPE latency_seconds histogram
+# UNIT latency_seconds seconds
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.5
+latency_seconds_count 3
+# TYPE build info
+build_info{version="1.0"} 1
+temperature{room="a\\b"} 21.5 1.5
+# EOF
+`
+	header := http.Header{"Content-Type": {"application/openmetrics-text; version=1.0.0; charset=utf-8"}}
+	families, err := decodeMetricFamilies(header, strings.NewReader(body), 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type family struct {
+		name, typ, help, unit string
+		samples               []string
+	}
+	want := []family{
+		{"requests", "counter", `Total number of "requests".`, "", []string{`requests_total{code="200"} 10`}},
+		{"latency_seconds", "histogram", "", "seconds", []string{
+			`latency_seconds_bucket{le="0.1"} 1`,
+			`latency_seconds_bucket{le="+Inf"} 3`,
+			`latency_seconds_sum 2.5`,
+			`latency_seconds_count 3`,
+		}},
+		{"build", "info", "", "", []string{`build_info{version="1.0"} 1`}},
+		{"temperature", "unknown", "", "", []string{`temperature{room="a\\b"} 21.5`}},
+	}
+	if len(families) != len(want) {
+		t.Fatalf("want %d families, got %d", len(want), len(families))
+	}
+	for i, w := range want {
+		f := families[i]
+		if f.name != w.name || f.typ != w.typ || f.help != w.help || f.unit != w.unit {
+			t.Errorf("%d. want family %s %s %q %q, got %s %s %q %q", i, w.name, w.typ, w.help, w.unit, f.name, f.typ, f.help, f.unit)
+		}
+		if len(f.samples) != len(w.samples) {
+			t.Errorf("%d. want %d samples, got %d", i, len(w.samples), len(f.samples))
+			continue
+		}
+		for j, s := range f.samples {
+			if got := s.Metric.String() + " " + s.Value.String(); got != w.samples[j] {
+				t.Errorf("%d.%d. want sample %q, got %q", i, j, w.samples[j], got)
+			}
+		}
+	}
+
+	if ts := families[3].samples[0].Timestamp; ts != clientmodel.TimestampFromUnixNano(1.5e9) {
+		t.Errorf("want exposed timestamp to be used, got %v", ts)
+	}
+	if ts := families[0].samples[0].Timestamp; ts != 42 {
+		t.Errorf("want scrape timestamp for sample without timestamp, got %v", ts)
+	}
+}
+
+func TestDecodeOpenMetricsErrors(t *testing.T) {
+	scenarios := []struct {
+		name, body string
+	}{
+		{"missing EOF", "foo 1\n"},
+		{"data after EOF", "foo 1\n# EOF\nbar 1\n"},
+		{"empty line", "foo 1\n\n# EOF\n"},
+		{"invalid type", "# TYPE foo bar\n# EOF\n"},
+		{"invalid value", "foo one\n# EOF\n"},
+		{"invalid label", "foo{bar=baz} 1\n# EOF\n"},
+		{"unterminated label value", "foo{bar=\"baz} 1\n# EOF\n"},
+		{"invalid exemplar", "foo_total 1 # 1\n# EOF\n"},
+		{"interleaved families", "foo 1\nbar 1\nfoo 2\n# EOF\n"},
+		{"metadata after samples", "foo 1\n# HELP foo bar\n# EOF\n"},
+		{"unit not a suffix", "# UNIT foo seconds\n# EOF\n"},
+	}
+	for _, s := range scenarios {
+		if _, err := decodeOpenMetrics(strings.NewReader(s.body), 0, nil); err == nil {
+			t.Errorf("%s: expected error", s.name)
+		}
+	}
+}
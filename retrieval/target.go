@@ -14,33 +14,56 @@
 package retrieval
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/rand"
+	"hash/fnv"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/extraction"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
+	"github.com/prometheus/prometheus/tracing"
 	"github.com/prometheus/prometheus/utility"
 )
 
+var logger = log.New("retrieval")
+
 const (
 	// InstanceLabel is the label value used for the instance label.
 	InstanceLabel clientmodel.LabelName = "instance"
+	// ExportedLabelPrefix is the prefix prepended to the names of exposed
+	// labels that clash with target labels if labels are not honored.
+	ExportedLabelPrefix clientmodel.LabelName = "exported_"
+	// TenantLabel is the label the tenant of a job is attached as.
+	TenantLabel clientmodel.LabelName = "tenant"
 	// ScrapeHealthMetricName is the metric name for the synthetic health
 	// variable.
 	scrapeHealthMetricName clientmodel.LabelValue = "up"
 	// ScrapeTimeMetricName is the metric name for the synthetic scrape duration
 	// variable.
 	scrapeDurationMetricName clientmodel.LabelValue = "scrape_duration_seconds"
+	// scrapeSamplesMetricName is the metric name for the synthetic number of
+	// samples exposed by a target.
+	scrapeSamplesMetricName clientmodel.LabelValue = "scrape_samples_scraped"
+	// scrapeSamplesIngestedMetricName is the metric name for the synthetic
+	// number of samples that were actually handed on for ingestion after
+	// all filtering.
+	scrapeSamplesIngestedMetricName clientmodel.LabelValue = "scrape_samples_post_metric_relabeling"
 
 	// Constants for instrumentation.
 	namespace = "prometheus"
@@ -50,6 +73,9 @@ const (
 var (
 	localhostRepresentations = []string{"http://127.0.0.1", "http://localhost"}
 
+	errBodySizeLimitExceeded = errors.New("body size limit exceeded")
+	errSampleLimitExceeded   = errors.New("sample limit exceeded")
+
 	targetIntervalLength = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Namespace:  namespace,
@@ -59,10 +85,35 @@ var (
 		},
 		[]string{interval},
 	)
+	targetBodySizeLimitExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "target_scrapes_exceeded_body_size_limit_total",
+			Help:      "Total number of scrapes aborted because the response body exceeded the size limit.",
+		},
+	)
+	targetSampleLimitExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "target_scrapes_exceeded_sample_limit_total",
+			Help:      "Total number of scrapes discarded because they exceeded the sample limit.",
+		},
+	)
+	targetSamplesRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "target_samples_rate_limited_total",
+			Help:      "Total number of scraped samples dropped because their job exceeded its sample rate limit.",
+		},
+		[]string{string(clientmodel.JobLabel)},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(targetIntervalLength)
+	prometheus.MustRegister(targetBodySizeLimitExceeded)
+	prometheus.MustRegister(targetSampleLimitExceeded)
+	prometheus.MustRegister(targetSamplesRateLimited)
 }
 
 // TargetState describes the state of a Target.
@@ -111,6 +162,8 @@ type Target interface {
 	State() TargetState
 	// Return the last time a scrape was attempted.
 	LastScrape() time.Time
+	// Return how long the last scrape took.
+	LastScrapeDuration() time.Duration
 	// The URL to which the Target corresponds.  Out of all of the available
 	// points in this interface, this one is the best candidate to change given
 	// the ways to express the endpoint.
@@ -122,18 +175,129 @@ type Target interface {
 	GlobalURL() string
 	// Return the target's base labels.
 	BaseLabels() clientmodel.LabelSet
+	// Return the metadata of the metric families exposed by the target in
+	// its last scrape, sorted by metric name.
+	Metadata() []MetricMetadata
 	// SetBaseLabelsFrom queues a replacement of the current base labels by
 	// the labels of the given target. The method returns immediately after
 	// queuing. The actual replacement of the base labels happens
 	// asynchronously (but most likely before the next scrape for the target
 	// begins).
 	SetBaseLabelsFrom(Target)
+	// SetPaused pauses or resumes the regular scrapes of the target.
+	SetPaused(bool)
+	// Paused returns whether the regular scrapes of the target are paused.
+	Paused() bool
+	// ScrapeNow queues an immediate scrape of the target, even if it is
+	// paused. The method returns immediately after queuing. If a scrape is
+	// already queued, no further scrape is queued.
+	ScrapeNow()
 	// Scrape target at the specified interval.
 	RunScraper(extraction.Ingester, time.Duration)
 	// Stop scraping, synchronous.
 	StopScraper()
 }
 
+// MetricMetadata is the metadata a target exposes for a metric family.
+type MetricMetadata struct {
+	Metric string `json:"metric"`
+	Type   string `json:"type"`
+	Help   string `json:"help"`
+	Unit   string `json:"unit"`
+}
+
+type metadataByMetric []MetricMetadata
+
+func (s metadataByMetric) Len() int           { return len(s) }
+func (s metadataByMetric) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s metadataByMetric) Less(i, j int) bool { return s[i].Metric < s[j].Metric }
+
+// TargetOptions are the settings of a job that apply to each of its targets.
+type TargetOptions struct {
+	// What is the deadline for the HTTP or HTTPS against this endpoint.
+	Deadline time.Duration
+	// The proxy to scrape the endpoint through. If nil, the endpoint is
+	// scraped directly.
+	ProxyURL *url.URL
+	// Whether labels exposed by the endpoint take precedence over the
+	// target's labels.
+	HonorLabels bool
+	// Whether timestamps exposed by the endpoint are replaced with the
+	// scrape time.
+	IgnoreTimestamps bool
+	// The maximum number of samples per scrape. 0 means no limit.
+	SampleLimit int
+	// The maximum size of a scrape response body in bytes. 0 means no limit.
+	BodySizeLimit int64
+	// The HTTP basic auth credentials to scrape with, if any.
+	BasicAuth *BasicAuth
+	// The bearer token to scrape with, or the file to read it from for each
+	// scrape. Both are empty if no token is sent.
+	BearerToken     string
+	BearerTokenFile string
+	// The delay before the first scrape. If nil, the target is scraped at a
+	// fixed offset into the scrape interval of at most MaxJitter.
+	InitialDelay *time.Duration
+	// The maximum offset into the scrape interval. If nil, it is the whole
+	// interval.
+	MaxJitter *time.Duration
+	// The metric families to ingest by name. If MetricNameAllow is not
+	// empty, only families matching one of its expressions are ingested.
+	// Families matching any expression of MetricNameDeny are dropped.
+	// Excluded families are skipped while parsing the scrape response.
+	MetricNameAllow, MetricNameDeny []*regexp.Regexp
+	// The tenant the target belongs to, if any. It is added to the base
+	// labels and always overrides exposed labels of the same name.
+	Tenant clientmodel.LabelValue
+	// The series derived from the ingested samples of each scrape.
+	DerivedMetrics []DerivedMetric
+	// The rate limiter shared by all targets of the job, if any. Only set by
+	// the target manager.
+	rateLimiter *rateLimiter
+}
+
+// BasicAuth holds HTTP basic auth credentials. If PasswordFile is set, the
+// password is read from it for each scrape instead of using Password.
+type BasicAuth struct {
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
+// targetOptionsForJob returns the TargetOptions configured for the given job,
+// without the rate limiter shared by the targets of the job.
+func targetOptionsForJob(job config.JobConfig) TargetOptions {
+	opts := TargetOptions{
+		Deadline:         job.ScrapeTimeout(),
+		ProxyURL:         job.ProxyURL(),
+		HonorLabels:      job.GetHonorLabels(),
+		IgnoreTimestamps: !job.GetHonorTimestamps(),
+		SampleLimit:      int(job.GetSampleLimit()),
+		BodySizeLimit:    int64(job.GetBodySizeLimit()),
+		BasicAuth:        basicAuthForJob(job),
+		BearerToken:      job.GetBearerToken(),
+		BearerTokenFile:  job.GetBearerTokenFile(),
+		InitialDelay:     job.InitialDelay(),
+		MaxJitter:        job.MaxJitter(),
+		Tenant:           clientmodel.LabelValue(job.GetTenant()),
+		DerivedMetrics:   derivedMetricsForJob(job),
+	}
+	opts.MetricNameAllow, opts.MetricNameDeny = job.MetricNameFilter()
+	return opts
+}
+
+func basicAuthForJob(job config.JobConfig) *BasicAuth {
+	auth := job.GetBasicAuth()
+	if auth == nil {
+		return nil
+	}
+	return &BasicAuth{
+		Username:     auth.GetUsername(),
+		Password:     auth.GetPassword(),
+		PasswordFile: auth.GetPasswordFile(),
+	}
+}
+
 // target is a Target that refers to a singular HTTP or HTTPS endpoint.
 type target struct {
 	// The current health state of the target.
@@ -142,73 +306,147 @@ type target struct {
 	lastError error
 	// The last time a scrape was attempted.
 	lastScrape time.Time
+	// How long the last scrape took.
+	lastScrapeDuration time.Duration
 	// Closing scraperStopping signals that scraping should stop.
 	scraperStopping chan struct{}
 	// Closing scraperStopped signals that scraping has been stopped.
 	scraperStopped chan struct{}
 	// Channel to queue base labels to be replaced.
 	newBaseLabels chan clientmodel.LabelSet
+	// Channel to queue an immediate scrape.
+	scrapeNow chan struct{}
+	// Whether regular scrapes are paused.
+	paused bool
 
 	url string
 	// What is the deadline for the HTTP or HTTPS against this endpoint.
 	Deadline time.Duration
 	// Any base labels that are added to this target and its metrics.
 	baseLabels clientmodel.LabelSet
+	// Whether exposed labels take precedence over baseLabels.
+	honorLabels bool
+	// The tenant of the target, if any. It is also part of baseLabels and
+	// always takes precedence over exposed labels.
+	tenant clientmodel.LabelValue
+	// Whether exposed timestamps are replaced with the scrape time.
+	ignoreTimestamps bool
+	// The maximum number of samples per scrape. 0 means no limit.
+	sampleLimit int
+	// The maximum size of a scrape response body in bytes. 0 means no limit.
+	bodySizeLimit int64
 	// The HTTP client used to scrape the target's endpoint.
 	httpClient *http.Client
-
-	// Mutex protects lastError, lastScrape, state, and baseLabels.  Writing
-	// the above must only happen in the goroutine running the RunScraper
-	// loop, and it must happen under the lock. In that way, no mutex lock
+	// The credentials to scrape with.
+	basicAuth                    *BasicAuth
+	bearerToken, bearerTokenFile string
+	// The delay before the first scrape and the maximum offset into the
+	// scrape interval, as in TargetOptions.
+	initialDelay, maxJitter *time.Duration
+	// The metric families to ingest. nil means all.
+	familyFilter *familyFilter
+	// The series derived from the ingested samples of each scrape.
+	derivedMetrics []DerivedMetric
+	// The rate limiter shared by all targets of the job, if any.
+	rateLimiter *rateLimiter
+	// The metadata of the metric families exposed in the last scrape.
+	metadata []MetricMetadata
+
+	// Mutex protects lastError, lastScrape, lastScrapeDuration, state,
+	// baseLabels, and metadata.  Writing the above must only happen in the goroutine
+	// running the RunScraper loop, and it must happen under the lock. In that way, no mutex lock
 	// is required for reading the above in the goroutine running the
-	// RunScraper loop, but only for reading in other goroutines.
+	// RunScraper loop, but only for reading in other goroutines. The mutex
+	// also protects paused, which is written by other goroutines and thus
+	// must always be read under the lock.
 	sync.Mutex
 }
 
 // NewTarget creates a reasonably configured target for querying.
-func NewTarget(url string, deadline time.Duration, baseLabels clientmodel.LabelSet) Target {
+func NewTarget(url string, options TargetOptions, baseLabels clientmodel.LabelSet) Target {
+	if options.Tenant != "" {
+		// The base labels may be shared between targets.
+		labels := make(clientmodel.LabelSet, len(baseLabels)+1)
+		for ln, lv := range baseLabels {
+			labels[ln] = lv
+		}
+		labels[TenantLabel] = options.Tenant
+		baseLabels = labels
+	}
 	target := &target{
-		url:             url,
-		Deadline:        deadline,
-		baseLabels:      baseLabels,
-		httpClient:      utility.NewDeadlineClient(deadline),
-		scraperStopping: make(chan struct{}),
-		scraperStopped:  make(chan struct{}),
-		newBaseLabels:   make(chan clientmodel.LabelSet, 1),
+		url:              url,
+		Deadline:         options.Deadline,
+		honorLabels:      options.HonorLabels,
+		tenant:           options.Tenant,
+		ignoreTimestamps: options.IgnoreTimestamps,
+		sampleLimit:      options.SampleLimit,
+		bodySizeLimit:    options.BodySizeLimit,
+		basicAuth:        options.BasicAuth,
+		bearerToken:      options.BearerToken,
+		bearerTokenFile:  options.BearerTokenFile,
+		initialDelay:     options.InitialDelay,
+		maxJitter:        options.MaxJitter,
+		familyFilter:     newFamilyFilter(options.MetricNameAllow, options.MetricNameDeny),
+		derivedMetrics:   options.DerivedMetrics,
+		rateLimiter:      options.rateLimiter,
+		baseLabels:       baseLabels,
+		httpClient:       utility.NewDeadlineClient(options.Deadline, options.ProxyURL),
+		scraperStopping:  make(chan struct{}),
+		scraperStopped:   make(chan struct{}),
+		newBaseLabels:    make(chan clientmodel.LabelSet, 1),
+		scrapeNow:        make(chan struct{}, 1),
 	}
 
 	return target
 }
 
-func (t *target) recordScrapeHealth(ingester extraction.Ingester, timestamp clientmodel.Timestamp, healthy bool, scrapeDuration time.Duration) {
-	healthMetric := clientmodel.Metric{}
-	durationMetric := clientmodel.Metric{}
-	for label, value := range t.baseLabels {
-		healthMetric[label] = value
-		durationMetric[label] = value
-	}
-	healthMetric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(scrapeHealthMetricName)
-	durationMetric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(scrapeDurationMetricName)
-	healthMetric[InstanceLabel] = clientmodel.LabelValue(t.InstanceIdentifier())
-	durationMetric[InstanceLabel] = clientmodel.LabelValue(t.InstanceIdentifier())
-
+func (t *target) recordScrapeHealth(ingester extraction.Ingester, timestamp clientmodel.Timestamp, healthy bool, scrapeDuration time.Duration, samplesScraped, samplesIngested int) {
 	healthValue := clientmodel.SampleValue(0)
 	if healthy {
 		healthValue = clientmodel.SampleValue(1)
 	}
 
-	healthSample := &clientmodel.Sample{
-		Metric:    healthMetric,
-		Timestamp: timestamp,
-		Value:     healthValue,
+	values := []struct {
+		name  clientmodel.LabelValue
+		value clientmodel.SampleValue
+	}{
+		{scrapeHealthMetricName, healthValue},
+		{scrapeDurationMetricName, clientmodel.SampleValue(float64(scrapeDuration) / float64(time.Second))},
+		{scrapeSamplesMetricName, clientmodel.SampleValue(samplesScraped)},
+		{scrapeSamplesIngestedMetricName, clientmodel.SampleValue(samplesIngested)},
 	}
-	durationSample := &clientmodel.Sample{
-		Metric:    durationMetric,
-		Timestamp: timestamp,
-		Value:     clientmodel.SampleValue(float64(scrapeDuration) / float64(time.Second)),
+
+	samples := make(clientmodel.Samples, 0, len(values))
+	for _, v := range values {
+		metric := clientmodel.Metric{}
+		for label, value := range t.baseLabels {
+			metric[label] = value
+		}
+		metric[clientmodel.MetricNameLabel] = v.name
+		metric[InstanceLabel] = clientmodel.LabelValue(t.InstanceIdentifier())
+
+		samples = append(samples, &clientmodel.Sample{
+			Metric:    metric,
+			Timestamp: timestamp,
+			Value:     v.value,
+		})
 	}
 
-	ingester.Ingest(clientmodel.Samples{healthSample, durationSample})
+	ingester.Ingest(samples)
+}
+
+// scrapeSlowdown is the factor by which scrapes of all targets are currently
+// spaced out. Accessed atomically.
+var scrapeSlowdown int32 = 1
+
+// SlowDownScrapes makes the scrapers of all targets skip scrapes so that each
+// target is only scraped every factor intervals. This reduces ingestion while
+// the storage cannot keep up with it. A factor of 1 restores normal scraping.
+func SlowDownScrapes(factor int) {
+	if factor < 1 {
+		factor = 1
+	}
+	atomic.StoreInt32(&scrapeSlowdown, int32(factor))
 }
 
 // RunScraper implements Target.
@@ -226,22 +464,24 @@ func (t *target) RunScraper(ingester extraction.Ingester, interval time.Duration
 		}
 	}()
 
-	jitterTimer := time.NewTimer(time.Duration(float64(interval) * rand.Float64()))
+	offsetTimer := time.NewTimer(t.offset(interval, time.Now()))
 	select {
-	case <-jitterTimer.C:
+	case <-offsetTimer.C:
 	case <-t.scraperStopping:
-		jitterTimer.Stop()
+		offsetTimer.Stop()
 		return
 	}
-	jitterTimer.Stop()
+	offsetTimer.Stop()
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	t.Lock() // Writing t.lastScrape requires the lock.
-	t.lastScrape = time.Now()
-	t.Unlock()
-	t.scrape(ingester)
+	if !t.Paused() {
+		t.Lock() // Writing t.lastScrape requires the lock.
+		t.lastScrape = time.Now()
+		t.Unlock()
+		t.scrape(ingester)
+	}
 
 	// Explanation of the contraption below:
 	//
@@ -251,6 +491,7 @@ func (t *target) RunScraper(ingester extraction.Ingester, interval time.Duration
 	// ticker.C. Should neither t.newBaseLabels nor t.scraperStopping have
 	// anything to receive, we go into the inner select, where ticker.C is
 	// in the mix.
+	ticks := 0
 	for {
 		select {
 		case newBaseLabels := <-t.newBaseLabels:
@@ -267,7 +508,19 @@ func (t *target) RunScraper(ingester extraction.Ingester, interval time.Duration
 				t.Unlock()
 			case <-t.scraperStopping:
 				return
+			case <-t.scrapeNow:
+				t.Lock() // Write t.lastScrape requires locking.
+				t.lastScrape = time.Now()
+				t.Unlock()
+				t.scrape(ingester)
 			case <-ticker.C:
+				if t.Paused() {
+					continue
+				}
+				ticks++
+				if ticks%int(atomic.LoadInt32(&scrapeSlowdown)) != 0 {
+					continue
+				}
 				took := time.Since(t.lastScrape)
 				t.Lock() // Write t.lastScrape requires locking.
 				t.lastScrape = time.Now()
@@ -281,26 +534,71 @@ func (t *target) RunScraper(ingester extraction.Ingester, interval time.Duration
 	}
 }
 
+// offset returns the time to wait from now until the first scrape of the
+// target. Unless an initial delay is configured, scrapes of a target always
+// happen at the same offset into the interval, derived from the target's URL
+// and base labels and bounded by the maximum jitter. Targets scraped at the
+// same interval are thereby spread deterministically across it.
+func (t *target) offset(interval time.Duration, now time.Time) time.Duration {
+	if t.initialDelay != nil {
+		return *t.initialDelay
+	}
+	jitter := interval
+	if t.maxJitter != nil && *t.maxJitter < interval {
+		jitter = *t.maxJitter
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(t.url))
+	fp := make([]byte, 8)
+	binary.BigEndian.PutUint64(fp, uint64(clientmodel.Metric(t.baseLabels).Fingerprint()))
+	h.Write(fp)
+
+	var slot int64
+	if jitter > 0 {
+		slot = int64(h.Sum64() % uint64(jitter))
+	}
+	next := slot - now.UnixNano()%int64(interval)
+	if next < 0 {
+		next += int64(interval)
+	}
+	return time.Duration(next)
+}
+
 // StopScraper implements Target.
 func (t *target) StopScraper() {
 	close(t.scraperStopping)
 	<-t.scraperStopped
 }
 
-const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3,application/json;schema="prometheus/telemetry";version=0.0.2;q=0.2,*/*;q=0.1`
+const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.3,application/json;schema="prometheus/telemetry";version=0.0.2;q=0.2,*/*;q=0.1`
 
 func (t *target) scrape(ingester extraction.Ingester) (err error) {
 	timestamp := clientmodel.Now()
+	// Count the samples exposed by the target and the samples that are
+	// eventually handed on to the ingester.
+	scraped := &countingIngester{}
+	ingested := &countingIngester{Ingester: ingester}
+	span := tracing.StartSpan("scrape")
+	span.SetTag("target", t.URL())
+	span.SetTag("job", string(t.baseLabels[clientmodel.JobLabel]))
 	defer func(start time.Time) {
-		t.Lock() // Writing t.state and t.lastError requires the lock.
+		span.SetTag("samples_scraped", strconv.Itoa(scraped.count))
+		span.SetTag("samples_ingested", strconv.Itoa(ingested.count))
+		span.SetError(err)
+		span.Finish()
+
+		took := time.Since(start)
+		t.Lock() // Writing t.state, t.lastError, and t.lastScrapeDuration requires the lock.
 		if err == nil {
 			t.state = Alive
 		} else {
 			t.state = Unreachable
 		}
 		t.lastError = err
+		t.lastScrapeDuration = took
 		t.Unlock()
-		t.recordScrapeHealth(ingester, timestamp, err == nil, time.Since(start))
+		t.recordScrapeHealth(ingester, timestamp, err == nil, took, scraped.count, ingested.count)
 	}(time.Now())
 
 	req, err := http.NewRequest("GET", t.URL(), nil)
@@ -308,6 +606,9 @@ func (t *target) scrape(ingester extraction.Ingester) (err error) {
 		panic(err)
 	}
 	req.Header.Add("Accept", acceptHeader)
+	if err := t.authorize(req); err != nil {
+		return err
+	}
 
 	resp, err := t.httpClient.Do(req)
 	if err != nil {
@@ -318,9 +619,22 @@ func (t *target) scrape(ingester extraction.Ingester) (err error) {
 		return fmt.Errorf("server returned HTTP status %s", resp.Status)
 	}
 
-	processor, err := extraction.ProcessorForRequestHeader(resp.Header)
-	if err != nil {
-		return err
+	var body io.Reader = resp.Body
+	if t.bodySizeLimit > 0 {
+		if resp.ContentLength > t.bodySizeLimit {
+			targetBodySizeLimitExceeded.Inc()
+			return fmt.Errorf("%s: response announced %d bytes, limit is %d", errBodySizeLimitExceeded, resp.ContentLength, t.bodySizeLimit)
+		}
+		limited := &bodySizeLimitReader{Reader: resp.Body, remaining: t.bodySizeLimit}
+		defer func() {
+			// The processor might have turned the read error into a
+			// parse error, so report the limit violation explicitly.
+			if limited.exceeded {
+				targetBodySizeLimitExceeded.Inc()
+				err = fmt.Errorf("%s: limit is %d bytes", errBodySizeLimitExceeded, t.bodySizeLimit)
+			}
+		}()
+		body = limited
 	}
 
 	baseLabels := clientmodel.LabelSet{InstanceLabel: clientmodel.LabelValue(t.InstanceIdentifier())}
@@ -328,16 +642,174 @@ func (t *target) scrape(ingester extraction.Ingester) (err error) {
 		baseLabels[baseLabel] = baseValue
 	}
 
-	i := &MergeLabelsIngester{
-		Labels:          baseLabels,
-		CollisionPrefix: clientmodel.ExporterLabelPrefix,
+	tli := &targetLabelsIngester{
+		Labels:      baseLabels,
+		HonorLabels: t.honorLabels,
+
+		Ingester: ingested,
+	}
+	if t.rateLimiter != nil {
+		tli.Ingester = &rateLimitIngester{
+			limiter:  t.rateLimiter,
+			job:      t.baseLabels[clientmodel.JobLabel],
+			Ingester: ingested,
+		}
+	}
+	var i extraction.Ingester = tli
+	if t.tenant != "" && t.honorLabels {
+		// The tenant must not be overridden by exposed labels even if
+		// those are honored otherwise.
+		i = &targetLabelsIngester{
+			Labels:   clientmodel.LabelSet{TenantLabel: t.baseLabels[TenantLabel]},
+			Ingester: tli,
+		}
+	}
+	if t.ignoreTimestamps {
+		i = &timestampIngester{Timestamp: timestamp, Ingester: i}
+	}
+	if len(t.derivedMetrics) > 0 {
+		derived := newDerivedMetricsIngester(t.derivedMetrics, timestamp, i)
+		// The derived samples are only complete once all scraped samples
+		// have been ingested.
+		defer func() {
+			if err == nil {
+				err = derived.flush()
+			}
+		}()
+		i = derived
+	}
+	if t.sampleLimit <= 0 {
+		scraped.Ingester = i
+		return t.ingestBody(resp.Header, body, scraped, timestamp)
+	}
+
+	// With a sample limit, samples are only ingested once the whole scrape
+	// is known to be within the limit.
+	limited := &sampleLimitIngester{limit: t.sampleLimit}
+	scraped.Ingester = limited
+	if err := t.ingestBody(resp.Header, body, scraped, timestamp); err != nil {
+		return err
+	}
+	if limited.exceeded() {
+		targetSampleLimitExceeded.Inc()
+		return fmt.Errorf("%s: %d samples in scrape, limit is %d", errSampleLimitExceeded, limited.count, t.sampleLimit)
+	}
+	return limited.flush(i)
+}
 
-		Ingester: ingester,
+// authorize adds the configured credentials to a scrape request. Secrets
+// configured as files are read on each call so that rotated secrets are
+// picked up.
+func (t *target) authorize(req *http.Request) error {
+	if t.basicAuth != nil {
+		password := t.basicAuth.Password
+		if t.basicAuth.PasswordFile != "" {
+			var err error
+			if password, err = utility.ReadSecretFile(t.basicAuth.PasswordFile); err != nil {
+				return fmt.Errorf("error reading basic auth password: %s", err)
+			}
+		}
+		req.SetBasicAuth(t.basicAuth.Username, password)
+	}
+	token := t.bearerToken
+	if t.bearerTokenFile != "" {
+		var err error
+		if token, err = utility.ReadSecretFile(t.bearerTokenFile); err != nil {
+			return fmt.Errorf("error reading bearer token: %s", err)
+		}
 	}
-	processOptions := &extraction.ProcessOptions{
-		Timestamp: timestamp,
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
-	return processor.ProcessSingle(resp.Body, i, processOptions)
+	return nil
+}
+
+// ingestBody decodes the body of a scrape response and hands the resulting
+// samples over to the ingester, one metric family at a time. The metadata of
+// the exposed metric families is recorded along the way. Metric families
+// excluded by the family filter of the target are dropped.
+func (t *target) ingestBody(header http.Header, body io.Reader, ingester extraction.Ingester, timestamp clientmodel.Timestamp) error {
+	families, err := decodeMetricFamilies(header, body, timestamp, t.familyFilter)
+	if err == errLegacyFormat {
+		// Legacy formats carry no metadata we could record.
+		t.Lock() // Writing t.metadata requires the lock.
+		t.metadata = nil
+		t.Unlock()
+
+		processor, err := extraction.ProcessorForRequestHeader(header)
+		if err != nil {
+			return err
+		}
+		if t.familyFilter != nil {
+			ingester = &familyFilterIngester{
+				Filter:   t.familyFilter,
+				Ingester: ingester,
+			}
+		}
+		return processor.ProcessSingle(body, ingester, &extraction.ProcessOptions{
+			Timestamp: timestamp,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	metadata := make(metadataByMetric, 0, len(families))
+	for _, f := range families {
+		metadata = append(metadata, MetricMetadata{
+			Metric: f.name,
+			Type:   f.typ,
+			Help:   f.help,
+			Unit:   f.unit,
+		})
+	}
+	sort.Sort(metadata)
+	t.Lock() // Writing t.metadata requires the lock.
+	t.metadata = metadata
+	t.Unlock()
+
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		if err := ingester.Ingest(f.samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodySizeLimitReader is an io.Reader that fails once more than a given number
+// of bytes have been read from the underlying reader.
+type bodySizeLimitReader struct {
+	io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+// Read implements io.Reader.
+func (r *bodySizeLimitReader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, errBodySizeLimitExceeded
+	}
+	// Allow reading one byte beyond the limit to detect a violation.
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.Reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		r.exceeded = true
+		return 0, errBodySizeLimitExceeded
+	}
+	return n, err
+}
+
+// Metadata implements Target.
+func (t *target) Metadata() []MetricMetadata {
+	t.Lock()
+	defer t.Unlock()
+	return t.metadata
 }
 
 // LastError implements Target.
@@ -361,6 +833,13 @@ func (t *target) LastScrape() time.Time {
 	return t.lastScrape
 }
 
+// LastScrapeDuration implements Target.
+func (t *target) LastScrapeDuration() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	return t.lastScrapeDuration
+}
+
 // URL implements Target.
 func (t *target) URL() string {
 	return t.url
@@ -370,7 +849,7 @@ func (t *target) URL() string {
 func (t *target) InstanceIdentifier() string {
 	u, err := url.Parse(t.url)
 	if err != nil {
-		glog.Warningf("Could not parse instance URL when generating identifier, using raw URL: %s", err)
+		logger.Warnf("Could not parse instance URL when generating identifier, using raw URL: %s", err)
 		return t.url
 	}
 	// If we are given a port in the host port, use that.
@@ -384,7 +863,7 @@ func (t *target) InstanceIdentifier() string {
 		return fmt.Sprintf("%s:443", u.Host)
 	}
 
-	glog.Warningf("Unknown scheme %s when generating identifier, using raw URL.", u.Scheme)
+	logger.Warnf("Unknown scheme %s when generating identifier, using raw URL.", u.Scheme)
 	return t.url
 }
 
@@ -393,7 +872,7 @@ func (t *target) GlobalURL() string {
 	url := t.url
 	hostname, err := os.Hostname()
 	if err != nil {
-		glog.Warningf("Couldn't get hostname: %s, returning target.URL()", err)
+		logger.Warnf("Couldn't get hostname: %s, returning target.URL()", err)
 		return url
 	}
 	for _, localhostRepresentation := range localhostRepresentations {
@@ -416,3 +895,26 @@ func (t *target) SetBaseLabelsFrom(newTarget Target) {
 	}
 	t.newBaseLabels <- newTarget.BaseLabels()
 }
+
+// SetPaused implements Target.
+func (t *target) SetPaused(paused bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.paused = paused
+}
+
+// Paused implements Target.
+func (t *target) Paused() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.paused
+}
+
+// ScrapeNow implements Target.
+func (t *target) ScrapeNow() {
+	select {
+	case t.scrapeNow <- struct{}{}:
+	default:
+		// A scrape is already queued.
+	}
+}
@@ -16,11 +16,9 @@ package retrieval
 import (
 	"fmt"
 	"net"
-	"net/url"
 	"strings"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -59,7 +57,8 @@ type TargetProvider interface {
 }
 
 type sdTargetProvider struct {
-	job config.JobConfig
+	job     config.JobConfig
+	options TargetOptions
 
 	targets []Target
 
@@ -67,14 +66,16 @@ type sdTargetProvider struct {
 	refreshInterval time.Duration
 }
 
-// NewSdTargetProvider constructs a new sdTargetProvider for a job.
-func NewSdTargetProvider(job config.JobConfig) *sdTargetProvider {
+// NewSdTargetProvider constructs a new sdTargetProvider for a job whose
+// targets it creates with the given options.
+func NewSdTargetProvider(job config.JobConfig, options TargetOptions) *sdTargetProvider {
 	i, err := utility.StringToDuration(job.GetSdRefreshInterval())
 	if err != nil {
 		panic(fmt.Sprintf("illegal refresh duration string %s: %s", job.GetSdRefreshInterval(), err))
 	}
 	return &sdTargetProvider{
 		job:             job,
+		options:         options,
 		refreshInterval: i,
 	}
 }
@@ -103,22 +104,22 @@ func (p *sdTargetProvider) Targets() ([]Target, error) {
 	}
 
 	targets := make([]Target, 0, len(response.Answer))
-	endpoint := &url.URL{
-		Scheme: "http",
-		Path:   p.job.GetMetricsPath(),
-	}
 	for _, record := range response.Answer {
 		addr, ok := record.(*dns.SRV)
 		if !ok {
-			glog.Warningf("%s is not a valid SRV record", addr)
+			logger.Warnf("%s is not a valid SRV record", addr)
 			continue
 		}
 		// Remove the final dot from rooted DNS names to make them look more usual.
 		if addr.Target[len(addr.Target)-1] == '.' {
 			addr.Target = addr.Target[:len(addr.Target)-1]
 		}
-		endpoint.Host = fmt.Sprintf("%s:%d", addr.Target, addr.Port)
-		t := NewTarget(endpoint.String(), p.job.ScrapeTimeout(), baseLabels)
+		endpoint, err := p.job.TargetURL(net.JoinHostPort(addr.Target, fmt.Sprint(addr.Port)))
+		if err != nil {
+			logger.Warnf("Invalid endpoint for SRV record %s: %s", addr, err)
+			continue
+		}
+		t := NewTarget(endpoint, p.options, baseLabels)
 		targets = append(targets, t)
 	}
 
@@ -144,7 +145,7 @@ func lookupSRV(name string) (*dns.Msg, error) {
 					return response, nil
 				}
 			} else {
-				glog.Warningf("resolving %s.%s failed: %s", name, suffix, err)
+				logger.Warnf("resolving %s.%s failed: %s", name, suffix, err)
 			}
 		}
 		response, err = lookup(name, dns.TypeSRV, client, servAddr, "", false)
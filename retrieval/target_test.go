@@ -15,8 +15,14 @@ package retrieval
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,11 +32,13 @@ import (
 )
 
 type collectResultIngester struct {
-	result clientmodel.Samples
+	result     clientmodel.Samples
+	allResults clientmodel.Samples
 }
 
 func (i *collectResultIngester) Ingest(s clientmodel.Samples) error {
 	i.result = s
+	i.allResults = append(i.allResults, s...)
 	return nil
 }
 
@@ -45,7 +53,7 @@ func TestTargetHidesURLAuth(t *testing.T) {
 		testTarget := target{
 			state:      Unknown,
 			url:        testVectors[i],
-			httpClient: utility.NewDeadlineClient(0),
+			httpClient: utility.NewDeadlineClient(0, nil),
 		}
 		u := testTarget.InstanceIdentifier()
 		if u != testResults[i] {
@@ -58,12 +66,18 @@ func TestTargetScrapeUpdatesState(t *testing.T) {
 	testTarget := target{
 		state:      Unknown,
 		url:        "bad schema",
-		httpClient: utility.NewDeadlineClient(0),
+		httpClient: utility.NewDeadlineClient(0, nil),
 	}
 	testTarget.scrape(nopIngester{})
 	if testTarget.state != Unreachable {
 		t.Errorf("Expected target state %v, actual: %v", Unreachable, testTarget.state)
 	}
+	if testTarget.lastError == nil {
+		t.Errorf("Expected target error to be recorded")
+	}
+	if testTarget.lastScrapeDuration <= 0 {
+		t.Errorf("Expected positive scrape duration, actual: %v", testTarget.lastScrapeDuration)
+	}
 }
 
 func TestTargetScrapeWithFullChannel(t *testing.T) {
@@ -79,7 +93,7 @@ func TestTargetScrapeWithFullChannel(t *testing.T) {
 
 	testTarget := NewTarget(
 		server.URL,
-		100*time.Millisecond,
+		TargetOptions{Deadline: 100 * time.Millisecond},
 		clientmodel.LabelSet{"dings": "bums"},
 	).(*target)
 
@@ -92,51 +106,398 @@ func TestTargetScrapeWithFullChannel(t *testing.T) {
 	}
 }
 
+func TestTargetScrapeHonorLabels(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{job=\"exposed\",exported_job=\"older\"} 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		honorLabels bool
+		want        clientmodel.Metric
+	}{
+		{
+			honorLabels: false,
+			want: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "test_metric",
+				clientmodel.JobLabel:        "testjob",
+				"exported_job":              "older",
+				"exported_exported_job":     "exposed",
+				InstanceLabel:               clientmodel.LabelValue(server.URL[len("http://"):]),
+			},
+		},
+		{
+			honorLabels: true,
+			want: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "test_metric",
+				clientmodel.JobLabel:        "exposed",
+				"exported_job":              "older",
+				InstanceLabel:               clientmodel.LabelValue(server.URL[len("http://"):]),
+			},
+		},
+	}
+
+	for i, s := range scenarios {
+		testTarget := NewTarget(
+			server.URL,
+			TargetOptions{Deadline: 100 * time.Millisecond, HonorLabels: s.honorLabels},
+			clientmodel.LabelSet{clientmodel.JobLabel: "testjob"},
+		)
+		ingester := &collectResultIngester{}
+		if err := testTarget.(*target).scrape(ingester); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		// The last ingested batch holds the synthetic health samples,
+		// so look for the scraped sample by metric name.
+		found := false
+		for _, sample := range ingester.allResults {
+			if sample.Metric[clientmodel.MetricNameLabel] != "test_metric" {
+				continue
+			}
+			found = true
+			if !sample.Metric.Equal(s.want) {
+				t.Errorf("%d. want metric %v, got %v", i, s.want, sample.Metric)
+			}
+		}
+		if !found {
+			t.Errorf("%d. scraped sample not ingested", i)
+		}
+	}
+}
+
+func TestTargetScrapeTenant(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{tenant=\"exposed\"} 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	for _, honorLabels := range []bool{false, true} {
+		testTarget := NewTarget(
+			server.URL,
+			TargetOptions{Deadline: 100 * time.Millisecond, HonorLabels: honorLabels, Tenant: "team-a"},
+			clientmodel.LabelSet{clientmodel.JobLabel: "testjob"},
+		)
+		ingester := &collectResultIngester{}
+		if err := testTarget.(*target).scrape(ingester); err != nil {
+			t.Fatalf("honor labels %t: unexpected error: %s", honorLabels, err)
+		}
+		// Both the scraped and the synthetic health samples belong to the
+		// tenant.
+		for _, sample := range ingester.allResults {
+			if sample.Metric[TenantLabel] != "team-a" {
+				t.Errorf("honor labels %t: want tenant team-a, got %v", honorLabels, sample.Metric)
+			}
+			if sample.Metric[clientmodel.MetricNameLabel] == "test_metric" && sample.Metric["exported_tenant"] != "exposed" {
+				t.Errorf("honor labels %t: want exposed tenant label to be renamed, got %v", honorLabels, sample.Metric)
+			}
+		}
+	}
+}
+
+func TestTargetScrapeDerivedMetrics(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`errors_total{code="500",path="/a"} 1
+errors_total{code="503",path="/a"} 2
+errors_total{code="500",path="/b"} 4
+requests_total{path="/a"} 30
+requests_total{path="/c"} 10
+`))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := NewTarget(
+		server.URL,
+		TargetOptions{
+			Deadline: 100 * time.Millisecond,
+			DerivedMetrics: []DerivedMetric{
+				{Name: "errors:sum", Metric: "errors_total"},
+				{Name: "path:errors:ratio", Metric: "errors_total", DivisorMetric: "requests_total", By: clientmodel.LabelNames{"path"}},
+			},
+		},
+		clientmodel.LabelSet{clientmodel.JobLabel: "testjob"},
+	)
+	ingester := &collectResultIngester{}
+	if err := testTarget.(*target).scrape(ingester); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := map[string]clientmodel.SampleValue{
+		`errors:sum{instance="` + testTarget.InstanceIdentifier() + `", job="testjob"}`:                   7,
+		`path:errors:ratio{instance="` + testTarget.InstanceIdentifier() + `", job="testjob", path="/a"}`: 0.1,
+	}
+	actual := map[string]clientmodel.SampleValue{}
+	for _, s := range ingester.allResults {
+		if name := s.Metric[clientmodel.MetricNameLabel]; name == "errors:sum" || name == "path:errors:ratio" {
+			actual[s.Metric.String()] = s.Value
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected derived samples %v, got %v", expected, actual)
+	}
+}
+
+func TestTargetScrapeIgnoreTimestamps(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1 1000\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	for _, ignoreTimestamps := range []bool{false, true} {
+		testTarget := NewTarget(
+			server.URL,
+			TargetOptions{Deadline: 100 * time.Millisecond, IgnoreTimestamps: ignoreTimestamps},
+			clientmodel.LabelSet{},
+		)
+		ingester := &collectResultIngester{}
+		before := clientmodel.Now()
+		if err := testTarget.(*target).scrape(ingester); err != nil {
+			t.Fatalf("ignoreTimestamps=%v: unexpected error: %s", ignoreTimestamps, err)
+		}
+		for _, sample := range ingester.allResults {
+			if sample.Metric[clientmodel.MetricNameLabel] != "test_metric" {
+				continue
+			}
+			exposed := sample.Timestamp == clientmodel.TimestampFromUnixNano(1000*int64(time.Millisecond))
+			if exposed == ignoreTimestamps {
+				t.Errorf("ignoreTimestamps=%v: unexpected timestamp %v", ignoreTimestamps, sample.Timestamp)
+			}
+			if ignoreTimestamps && sample.Timestamp.Before(before) {
+				t.Errorf("want scrape time as timestamp, got %v", sample.Timestamp)
+			}
+		}
+	}
+}
+
+func TestTargetScrapeSampleLimit(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 1\ntest_metric{foo=\"baz\"} 2\nother_metric 3\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		limit       int
+		wantErr     bool
+		wantSamples int
+	}{
+		{limit: 0, wantSamples: 3},
+		{limit: 3, wantSamples: 3},
+		{limit: 2, wantErr: true},
+	}
+
+	for i, s := range scenarios {
+		testTarget := NewTarget(
+			server.URL,
+			TargetOptions{Deadline: 100 * time.Millisecond, SampleLimit: s.limit},
+			clientmodel.LabelSet{},
+		).(*target)
+		ingester := &collectResultIngester{}
+		err := testTarget.scrape(ingester)
+		if s.wantErr != (err != nil) {
+			t.Fatalf("%d. want error %v, got %v", i, s.wantErr, err)
+		}
+		if s.wantErr && testTarget.state != Unreachable {
+			t.Errorf("%d. want target state %v, got %v", i, Unreachable, testTarget.state)
+		}
+		// The synthetic health samples are always ingested last.
+		health := ingester.result
+		if got := len(ingester.allResults) - len(health); got != s.wantSamples {
+			t.Errorf("%d. want %d ingested samples, got %d", i, s.wantSamples, got)
+		}
+		if got := health[2].Value; got != 3 {
+			t.Errorf("%d. want %d samples scraped, got %v", i, 3, got)
+		}
+		if got := health[3].Value; got != clientmodel.SampleValue(s.wantSamples) {
+			t.Errorf("%d. want %d samples post metric relabeling, got %v", i, s.wantSamples, got)
+		}
+	}
+}
+
+func TestTargetScrapeSampleRateLimit(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 1\ntest_metric{foo=\"baz\"} 2\nother_metric 3\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	// Both targets share the rate limit of their job, which allows for
+	// the samples of a single scrape.
+	limiter := &rateLimiter{rate: 0.001, capacity: 3, tokens: 3, last: time.Now()}
+	options := TargetOptions{Deadline: 100 * time.Millisecond, rateLimiter: limiter}
+	for i, wantSamples := range []int{3, 0} {
+		testTarget := NewTarget(server.URL, options, clientmodel.LabelSet{clientmodel.JobLabel: "limited"}).(*target)
+		ingester := &collectResultIngester{}
+		if err := testTarget.scrape(ingester); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		// The synthetic health samples are always ingested last.
+		health := ingester.result
+		if got := len(ingester.allResults) - len(health); got != wantSamples {
+			t.Errorf("%d. want %d ingested samples, got %d", i, wantSamples, got)
+		}
+		if got := health[3].Value; got != clientmodel.SampleValue(wantSamples) {
+			t.Errorf("%d. want %d samples post metric relabeling, got %v", i, wantSamples, got)
+		}
+	}
+
+	// Tokens are refilled at the configured rate.
+	limiter.setLimit(1000, 3)
+	if !limiter.take(3, limiter.last.Add(10*time.Millisecond)) {
+		t.Errorf("want tokens to be refilled")
+	}
+}
+
+func TestTargetScrapeBodySizeLimit(t *testing.T) {
+	body := []byte("test_metric{foo=\"bar\"} 1\ntest_metric{foo=\"baz\"} 2\n")
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write(body[:10])
+				if r.URL.Query().Get("chunked") != "" {
+					// Flushing forces a chunked response without
+					// Content-Length.
+					w.(http.Flusher).Flush()
+				}
+				w.Write(body[10:])
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		url     string
+		limit   int64
+		wantErr bool
+	}{
+		{url: server.URL, limit: 0},
+		{url: server.URL, limit: int64(len(body))},
+		{url: server.URL, limit: int64(len(body)) - 1, wantErr: true},
+		{url: server.URL + "?chunked=1", limit: int64(len(body))},
+		{url: server.URL + "?chunked=1", limit: int64(len(body)) - 1, wantErr: true},
+	}
+
+	for i, s := range scenarios {
+		testTarget := NewTarget(
+			s.url,
+			TargetOptions{Deadline: 100 * time.Millisecond, BodySizeLimit: s.limit},
+			clientmodel.LabelSet{},
+		).(*target)
+		err := testTarget.scrape(nopIngester{})
+		if s.wantErr != (err != nil) {
+			t.Fatalf("%d. want error %v, got %v", i, s.wantErr, err)
+		}
+		if s.wantErr && !strings.Contains(err.Error(), errBodySizeLimitExceeded.Error()) {
+			t.Errorf("%d. want error containing %q, got %q", i, errBodySizeLimitExceeded, err)
+		}
+	}
+}
+
+func TestTargetScrapeMetadata(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`# HELP requests_total Total number of requests.
+# TYPE requests_total counter
+requests_total 10
+# TYPE temperature gauge
+temperature 21.5
+untyped_metric 1
+`))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := NewTarget(server.URL, TargetOptions{Deadline: 100 * time.Millisecond}, clientmodel.LabelSet{}).(*target)
+	if err := testTarget.scrape(nopIngester{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []MetricMetadata{
+		{Metric: "requests_total", Type: "counter", Help: "Total number of requests."},
+		{Metric: "temperature", Type: "gauge"},
+		{Metric: "untyped_metric", Type: "untyped"},
+	}
+	got := testTarget.Metadata()
+	if len(got) != len(want) {
+		t.Fatalf("want %d metadata entries, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d. want metadata %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
 func TestTargetRecordScrapeHealth(t *testing.T) {
 	testTarget := target{
 		url:        "http://example.url",
 		baseLabels: clientmodel.LabelSet{clientmodel.JobLabel: "testjob"},
-		httpClient: utility.NewDeadlineClient(0),
+		httpClient: utility.NewDeadlineClient(0, nil),
 	}
 
 	now := clientmodel.Now()
 	ingester := &collectResultIngester{}
-	testTarget.recordScrapeHealth(ingester, now, true, 2*time.Second)
+	testTarget.recordScrapeHealth(ingester, now, true, 2*time.Second, 5, 3)
 
 	result := ingester.result
 
-	if len(result) != 2 {
-		t.Fatalf("Expected two samples, got %d", len(result))
+	expected := []struct {
+		name  clientmodel.LabelValue
+		value clientmodel.SampleValue
+	}{
+		{scrapeHealthMetricName, 1},
+		{scrapeDurationMetricName, 2.0},
+		{scrapeSamplesMetricName, 5},
+		{scrapeSamplesIngestedMetricName, 3},
 	}
-
-	actual := result[0]
-	expected := &clientmodel.Sample{
-		Metric: clientmodel.Metric{
-			clientmodel.MetricNameLabel: scrapeHealthMetricName,
-			InstanceLabel:               "example.url:80",
-			clientmodel.JobLabel:        "testjob",
-		},
-		Timestamp: now,
-		Value:     1,
-	}
-
-	if !actual.Equal(expected) {
-		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d samples, got %d", len(expected), len(result))
 	}
 
-	actual = result[1]
-	expected = &clientmodel.Sample{
-		Metric: clientmodel.Metric{
-			clientmodel.MetricNameLabel: scrapeDurationMetricName,
-			InstanceLabel:               "example.url:80",
-			clientmodel.JobLabel:        "testjob",
-		},
-		Timestamp: now,
-		Value:     2.0,
-	}
-
-	if !actual.Equal(expected) {
-		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	for i, e := range expected {
+		expected := &clientmodel.Sample{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: e.name,
+				InstanceLabel:               "example.url:80",
+				clientmodel.JobLabel:        "testjob",
+			},
+			Timestamp: now,
+			Value:     e.value,
+		}
+		if !result[i].Equal(expected) {
+			t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, result[i])
+		}
 	}
 }
 
@@ -153,7 +514,7 @@ func TestTargetScrapeTimeout(t *testing.T) {
 	)
 	defer server.Close()
 
-	testTarget := NewTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget := NewTarget(server.URL, TargetOptions{Deadline: 10 * time.Millisecond}, clientmodel.LabelSet{})
 	ingester := nopIngester{}
 
 	// scrape once without timeout
@@ -195,7 +556,7 @@ func TestTargetScrape404(t *testing.T) {
 	)
 	defer server.Close()
 
-	testTarget := NewTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget := NewTarget(server.URL, TargetOptions{Deadline: 10 * time.Millisecond}, clientmodel.LabelSet{})
 	ingester := nopIngester{}
 
 	want := errors.New("server returned HTTP status 404 Not Found")
@@ -205,11 +566,170 @@ func TestTargetScrape404(t *testing.T) {
 	}
 }
 
+func TestTargetScrapeThroughProxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				user, password, _ := parseProxyAuth(r.Header.Get("Proxy-Authorization"))
+				proxied <- r.URL.String() + " " + user + ":" + password
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	testTarget := NewTarget(
+		"http://unreachable.example.org:9100/metrics",
+		TargetOptions{Deadline: 100 * time.Millisecond, ProxyURL: proxyURL},
+		clientmodel.LabelSet{},
+	)
+	if err := testTarget.(*target).scrape(nopIngester{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "http://unreachable.example.org:9100/metrics user:secret"
+	if got := <-proxied; got != want {
+		t.Fatalf("want proxied request %q, got %q", want, got)
+	}
+}
+
+func TestTargetScrapeCredentialsFromFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus_credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	tokenFile := filepath.Join(dir, "token")
+
+	authorization := make(chan string, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				authorization <- r.Header.Get("Authorization")
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	basicAuthTarget := NewTarget(
+		server.URL,
+		TargetOptions{
+			Deadline:  100 * time.Millisecond,
+			BasicAuth: &BasicAuth{Username: "user", PasswordFile: passwordFile},
+		},
+		clientmodel.LabelSet{},
+	).(*target)
+	bearerTokenTarget := NewTarget(
+		server.URL,
+		TargetOptions{Deadline: 100 * time.Millisecond, BearerTokenFile: tokenFile},
+		clientmodel.LabelSet{},
+	).(*target)
+
+	if err := basicAuthTarget.scrape(nopIngester{}); err == nil {
+		t.Fatal("expected scrape to fail with missing password file")
+	}
+
+	// The files are read for each scrape, so rotated secrets are picked up.
+	for _, secret := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(passwordFile, []byte(secret+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(tokenFile, []byte(secret), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := basicAuthTarget.scrape(nopIngester{}); err != nil {
+			t.Fatal(err)
+		}
+		user, password, _ := parseProxyAuth(<-authorization)
+		if user != "user" || password != secret {
+			t.Errorf("want basic auth user:%s, got %s:%s", secret, user, password)
+		}
+
+		if err := bearerTokenTarget.scrape(nopIngester{}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := <-authorization, "Bearer "+secret; got != want {
+			t.Errorf("want Authorization header %q, got %q", want, got)
+		}
+	}
+}
+
+// parseProxyAuth parses a basic authentication Proxy-Authorization header.
+func parseProxyAuth(header string) (user, password string, ok bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return r.BasicAuth()
+}
+
+func TestTargetOffset(t *testing.T) {
+	interval := 10 * time.Second
+	now := time.Unix(1234567890, 123)
+
+	target1 := NewTarget("http://example.org:80/metrics", TargetOptions{}, clientmodel.LabelSet{"job": "a"}).(*target)
+	target2 := NewTarget("http://example.org:80/metrics", TargetOptions{}, clientmodel.LabelSet{"job": "b"}).(*target)
+	target3 := NewTarget("http://example.com:80/metrics", TargetOptions{}, clientmodel.LabelSet{"job": "a"}).(*target)
+
+	offset := target1.offset(interval, now)
+	if offset < 0 || offset >= interval {
+		t.Fatalf("Expected offset within [0, %v), got %v", interval, offset)
+	}
+	// The scrapes must happen at the same point in the interval, independent
+	// of when the target was started.
+	slot := (now.UnixNano() + int64(offset)) % int64(interval)
+	for _, later := range []time.Duration{time.Second, 7 * time.Second, interval, 25 * time.Hour} {
+		o := target1.offset(interval, now.Add(later))
+		if got := (now.Add(later).UnixNano() + int64(o)) % int64(interval); got != slot {
+			t.Errorf("Expected slot %v when starting %v later, got %v", slot, later, got)
+		}
+	}
+
+	if target2.offset(interval, now) == offset {
+		t.Errorf("Expected targets with different labels to have different offsets")
+	}
+	if target3.offset(interval, now) == offset {
+		t.Errorf("Expected targets with different URLs to have different offsets")
+	}
+}
+
+func TestTargetOffsetOptions(t *testing.T) {
+	interval := 10 * time.Second
+	now := time.Unix(1234567890, 123)
+	durationPtr := func(d time.Duration) *time.Duration { return &d }
+
+	delayed := NewTarget("http://example.org:80/metrics", TargetOptions{InitialDelay: durationPtr(3 * time.Second)}, clientmodel.LabelSet{"job": "a"}).(*target)
+	if got := delayed.offset(interval, now); got != 3*time.Second {
+		t.Errorf("Expected initial delay as offset, got %v", got)
+	}
+
+	aligned := NewTarget("http://example.org:80/metrics", TargetOptions{MaxJitter: durationPtr(0)}, clientmodel.LabelSet{"job": "a"}).(*target)
+	if got := (now.UnixNano() + int64(aligned.offset(interval, now))) % int64(interval); got != 0 {
+		t.Errorf("Expected scrapes aligned to the interval without jitter, got slot %v", got)
+	}
+
+	for _, job := range []clientmodel.LabelValue{"a", "b", "c", "d", "e"} {
+		jittered := NewTarget("http://example.org:80/metrics", TargetOptions{MaxJitter: durationPtr(time.Second)}, clientmodel.LabelSet{"job": job}).(*target)
+		if got := (now.UnixNano() + int64(jittered.offset(interval, now))) % int64(interval); got >= int64(time.Second) {
+			t.Errorf("Expected slot within the max jitter of job %s, got %v", job, time.Duration(got))
+		}
+	}
+}
+
 func TestTargetRunScraperScrapes(t *testing.T) {
 	testTarget := target{
 		state:           Unknown,
 		url:             "bad schema",
-		httpClient:      utility.NewDeadlineClient(0),
+		httpClient:      utility.NewDeadlineClient(0, nil),
 		scraperStopping: make(chan struct{}),
 		scraperStopped:  make(chan struct{}),
 	}
@@ -232,6 +752,25 @@ func TestTargetRunScraperScrapes(t *testing.T) {
 	}
 }
 
+func TestTargetRunScraperPaused(t *testing.T) {
+	testTarget := NewTarget("bad schema", TargetOptions{}, clientmodel.LabelSet{}).(*target)
+	testTarget.SetPaused(true)
+	go testTarget.RunScraper(nopIngester{}, time.Duration(time.Millisecond))
+	defer testTarget.StopScraper()
+
+	// Enough time for several scrapes to happen.
+	time.Sleep(5 * time.Millisecond)
+	if !testTarget.LastScrape().IsZero() {
+		t.Fatalf("Scrape occured while paused.")
+	}
+
+	testTarget.ScrapeNow()
+	time.Sleep(5 * time.Millisecond)
+	if testTarget.LastScrape().IsZero() {
+		t.Errorf("Forced scrape hasn't occured.")
+	}
+}
+
 func BenchmarkScrape(b *testing.B) {
 	server := httptest.NewServer(
 		http.HandlerFunc(
@@ -245,7 +784,7 @@ func BenchmarkScrape(b *testing.B) {
 
 	testTarget := NewTarget(
 		server.URL,
-		100*time.Millisecond,
+		TargetOptions{Deadline: 100 * time.Millisecond},
 		clientmodel.LabelSet{"dings": "bums"},
 	)
 	ingester := nopIngester{}
@@ -16,7 +16,7 @@ package retrieval
 import (
 	"sync"
 
-	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/prometheus/client_golang/extraction"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -30,38 +30,73 @@ type TargetManager interface {
 	ReplaceTargets(job config.JobConfig, newTargets []Target)
 	Remove(t Target)
 	AddTargetsFromConfig(config config.Config)
+	// ApplyConfig replaces the configured jobs by the jobs of the given
+	// configuration. Pools of jobs whose configuration did not change keep
+	// running undisturbed.
+	ApplyConfig(config config.Config)
+	// TargetOptions returns the options targets of the given job have to be
+	// created with. They share the sample rate limit of the job.
+	TargetOptions(job config.JobConfig) TargetOptions
 	Stop()
 	Pools() map[string]*TargetPool // Returns a copy of the name -> TargetPool mapping.
 }
 
 type targetManager struct {
-	sync.Mutex // Protects poolByJob.
+	sync.Mutex // Protects poolByJob, jobsByName, and rateLimiters.
 	poolsByJob map[string]*TargetPool
-	ingester   extraction.Ingester
+	// The configuration each pool was created with.
+	jobsByName map[string]config.JobConfig
+	// The rate limiters shared by the targets of each job with a sample
+	// rate limit, by job name.
+	rateLimiters map[string]*rateLimiter
+	ingester     extraction.Ingester
 }
 
 // NewTargetManager returns a newly initialized TargetManager ready to use.
 func NewTargetManager(ingester extraction.Ingester) TargetManager {
 	return &targetManager{
-		ingester:   ingester,
-		poolsByJob: make(map[string]*TargetPool),
+		ingester:     ingester,
+		poolsByJob:   make(map[string]*TargetPool),
+		jobsByName:   make(map[string]config.JobConfig),
+		rateLimiters: make(map[string]*rateLimiter),
 	}
 }
 
+// TargetOptions implements TargetManager.
+func (m *targetManager) TargetOptions(job config.JobConfig) TargetOptions {
+	m.Lock()
+	defer m.Unlock()
+	return m.targetOptions(job)
+}
+
+// targetOptions returns the options of targets of the given job. The caller
+// must hold the lock.
+func (m *targetManager) targetOptions(job config.JobConfig) TargetOptions {
+	options := targetOptionsForJob(job)
+	options.rateLimiter = rateLimiterForJob(m.rateLimiters[job.GetName()], job)
+	if options.rateLimiter == nil {
+		delete(m.rateLimiters, job.GetName())
+	} else {
+		m.rateLimiters[job.GetName()] = options.rateLimiter
+	}
+	return options
+}
+
 func (m *targetManager) targetPoolForJob(job config.JobConfig) *TargetPool {
 	targetPool, ok := m.poolsByJob[job.GetName()]
 
 	if !ok {
 		var provider TargetProvider
 		if job.SdName != nil {
-			provider = NewSdTargetProvider(job)
+			provider = NewSdTargetProvider(job, m.targetOptions(job))
 		}
 
 		interval := job.ScrapeInterval()
 		targetPool = NewTargetPool(m, provider, m.ingester, interval)
-		glog.Infof("Pool for job %s does not exist; creating and starting...", job.GetName())
+		logger.Infof("Pool for job %s does not exist; creating and starting...", job.GetName())
 
 		m.poolsByJob[job.GetName()] = targetPool
+		m.jobsByName[job.GetName()] = job
 		go targetPool.Run()
 	}
 
@@ -91,27 +126,71 @@ func (m *targetManager) Remove(t Target) {
 
 func (m *targetManager) AddTargetsFromConfig(config config.Config) {
 	for _, job := range config.Jobs() {
-		if job.SdName != nil {
-			m.Lock()
-			m.targetPoolForJob(job)
-			m.Unlock()
-			continue
-		}
+		m.addTargetsFromJob(job)
+	}
+}
 
-		for _, targetGroup := range job.TargetGroup {
-			baseLabels := clientmodel.LabelSet{
-				clientmodel.JobLabel: clientmodel.LabelValue(job.GetName()),
-			}
-			if targetGroup.Labels != nil {
-				for _, label := range targetGroup.Labels.Label {
-					baseLabels[clientmodel.LabelName(label.GetName())] = clientmodel.LabelValue(label.GetValue())
-				}
+func (m *targetManager) addTargetsFromJob(job config.JobConfig) {
+	options := m.TargetOptions(job)
+	if job.SdName != nil {
+		m.Lock()
+		m.targetPoolForJob(job)
+		m.Unlock()
+		return
+	}
+
+	for _, targetGroup := range job.TargetGroup {
+		baseLabels := clientmodel.LabelSet{
+			clientmodel.JobLabel: clientmodel.LabelValue(job.GetName()),
+		}
+		if targetGroup.Labels != nil {
+			for _, label := range targetGroup.Labels.Label {
+				baseLabels[clientmodel.LabelName(label.GetName())] = clientmodel.LabelValue(label.GetValue())
 			}
+		}
 
-			for _, endpoint := range targetGroup.Target {
-				target := NewTarget(endpoint, job.ScrapeTimeout(), baseLabels)
-				m.AddTarget(job, target)
+		for _, endpoint := range targetGroup.Target {
+			u, err := job.TargetURL(endpoint)
+			if err != nil {
+				logger.Errorf("Invalid target %q for job %s: %s", endpoint, job.GetName(), err)
+				continue
 			}
+			target := NewTarget(u, options, baseLabels)
+			m.AddTarget(job, target)
+		}
+	}
+}
+
+func (m *targetManager) ApplyConfig(conf config.Config) {
+	jobs := map[string]config.JobConfig{}
+	for _, job := range conf.Jobs() {
+		jobs[job.GetName()] = job
+	}
+
+	m.Lock()
+	stale := map[string]*TargetPool{}
+	for name, pool := range m.poolsByJob {
+		oldJob := m.jobsByName[name]
+		if job, ok := jobs[name]; ok && proto.Equal(&job.JobConfig, &oldJob.JobConfig) {
+			delete(jobs, name)
+			continue
+		}
+		stale[name] = pool
+		delete(m.poolsByJob, name)
+		delete(m.jobsByName, name)
+		if _, ok := jobs[name]; !ok {
+			// The job is gone. Changed jobs keep their rate limiter.
+			delete(m.rateLimiters, name)
+		}
+	}
+	m.Unlock()
+
+	// The pools of changed jobs have to be stopped before their new pools
+	// start scraping the same targets.
+	stopPools(stale)
+	for _, job := range conf.Jobs() {
+		if _, ok := jobs[job.GetName()]; ok {
+			m.addTargetsFromJob(job)
 		}
 	}
 }
@@ -120,19 +199,25 @@ func (m *targetManager) Stop() {
 	m.Lock()
 	defer m.Unlock()
 
-	glog.Info("Stopping target manager...")
+	logger.Info("Stopping target manager...")
+	stopPools(m.poolsByJob)
+	logger.Info("Target manager stopped.")
+}
+
+// stopPools stops the given pools concurrently and returns once all of them
+// are stopped.
+func stopPools(pools map[string]*TargetPool) {
 	var wg sync.WaitGroup
-	for j, p := range m.poolsByJob {
+	for j, p := range pools {
 		wg.Add(1)
 		go func(j string, p *TargetPool) {
 			defer wg.Done()
-			glog.Infof("Stopping target pool %q...", j)
+			logger.Infof("Stopping target pool %q...", j)
 			p.Stop()
-			glog.Infof("Target pool %q stopped.", j)
+			logger.Infof("Target pool %q stopped.", j)
 		}(j, p)
 	}
 	wg.Wait()
-	glog.Info("Target manager stopped.")
 }
 
 func (m *targetManager) Pools() map[string]*TargetPool {
@@ -62,6 +62,10 @@ func (t fakeTarget) LastScrape() time.Time {
 	return t.lastScrape
 }
 
+func (t fakeTarget) LastScrapeDuration() time.Duration {
+	return 0
+}
+
 func (t fakeTarget) scrape(i extraction.Ingester) error {
 	t.scrapeCount++
 
@@ -82,6 +86,18 @@ func (t fakeTarget) State() TargetState {
 
 func (t *fakeTarget) SetBaseLabelsFrom(newTarget Target) {}
 
+func (t *fakeTarget) SetPaused(bool) {}
+
+func (t fakeTarget) Paused() bool {
+	return false
+}
+
+func (t *fakeTarget) ScrapeNow() {}
+
+func (t fakeTarget) Metadata() []MetricMetadata {
+	return nil
+}
+
 func testTargetManager(t testing.TB) {
 	targetManager := NewTargetManager(nopIngester{})
 	testJob1 := config.JobConfig{
@@ -118,6 +134,82 @@ func TestTargetManager(t *testing.T) {
 	testTargetManager(t)
 }
 
+func TestTargetManagerApplyConfig(t *testing.T) {
+	job := func(name, interval string) *pb.JobConfig {
+		return &pb.JobConfig{
+			Name:           proto.String(name),
+			ScrapeInterval: proto.String(interval),
+			TargetGroup: []*pb.TargetGroup{
+				{Target: []string{"http://localhost:1/" + name}},
+			},
+		}
+	}
+	conf := func(jobs ...*pb.JobConfig) config.Config {
+		return config.Config{PrometheusConfig: pb.PrometheusConfig{Job: jobs}}
+	}
+
+	targetManager := NewTargetManager(nopIngester{})
+	defer targetManager.Stop()
+	targetManager.AddTargetsFromConfig(conf(job("a", "1m"), job("b", "1m")))
+	poolA := targetManager.Pools()["a"]
+
+	targetManager.ApplyConfig(conf(job("a", "1m"), job("c", "1m")))
+	pools := targetManager.Pools()
+	if len(pools) != 2 || pools["c"] == nil {
+		t.Fatalf("want pools for jobs a and c, got %v", pools)
+	}
+	if pools["a"] != poolA {
+		t.Errorf("pool of unchanged job a was replaced")
+	}
+
+	targetManager.ApplyConfig(conf(job("a", "2m"), job("c", "1m")))
+	pools = targetManager.Pools()
+	if pools["a"] == poolA {
+		t.Errorf("pool of changed job a was not replaced")
+	}
+	if pools["a"].interval != 2*time.Minute {
+		t.Errorf("want interval 2m for the new pool of job a, got %v", pools["a"].interval)
+	}
+}
+
+func TestTargetManagerRateLimiters(t *testing.T) {
+	job := func(name string, limit uint32) *pb.JobConfig {
+		return &pb.JobConfig{
+			Name:            proto.String(name),
+			ScrapeInterval:  proto.String("1m"),
+			SampleRateLimit: proto.Uint32(limit),
+			TargetGroup: []*pb.TargetGroup{
+				{Target: []string{"http://localhost:1/" + name}},
+			},
+		}
+	}
+	conf := func(jobs ...*pb.JobConfig) config.Config {
+		return config.Config{PrometheusConfig: pb.PrometheusConfig{Job: jobs}}
+	}
+
+	targetManager := NewTargetManager(nopIngester{}).(*targetManager)
+	defer targetManager.Stop()
+	targetManager.AddTargetsFromConfig(conf(job("a", 10), job("b", 10), job("c", 0)))
+	limiterA := targetManager.rateLimiters["a"]
+	if len(targetManager.rateLimiters) != 2 || limiterA == nil || targetManager.rateLimiters["b"] == nil {
+		t.Fatalf("want rate limiters of jobs a and b, got %v", targetManager.rateLimiters)
+	}
+
+	// Changed jobs keep their rate limiter with the new limit, removed jobs
+	// and jobs without a limit have none.
+	targetManager.ApplyConfig(conf(job("a", 20), job("c", 0)))
+	if len(targetManager.rateLimiters) != 1 || targetManager.rateLimiters["a"] != limiterA {
+		t.Fatalf("want only the rate limiter of job a, got %v", targetManager.rateLimiters)
+	}
+	if limiterA.rate != 20 {
+		t.Errorf("want rate 20 of changed job a, got %v", limiterA.rate)
+	}
+	targetManager.ApplyConfig(conf(job("a", 0), job("c", 0)))
+	if len(targetManager.rateLimiters) != 0 {
+		t.Errorf("want no rate limiters, got %v", targetManager.rateLimiters)
+	}
+}
+
 func BenchmarkTargetManager(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		testTargetManager(b)
@@ -18,8 +18,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/extraction"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/utility"
 )
 
@@ -28,6 +28,18 @@ const (
 	targetReplaceQueueSize = 1
 )
 
+var targetDuplicatesDropped = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "target_duplicates_dropped_total",
+		Help:      "Total number of targets dropped because a target with the same URL already existed in their pool.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(targetDuplicatesDropped)
+}
+
 // TargetPool is a pool of targets for the same job.
 type TargetPool struct {
 	sync.RWMutex
@@ -39,6 +51,8 @@ type TargetPool struct {
 	addTargetQueue chan Target
 
 	targetProvider TargetProvider
+	// Whether regular scrapes of the pool's targets are paused.
+	paused bool
 
 	stopping, stopped chan struct{}
 }
@@ -69,7 +83,7 @@ func (p *TargetPool) Run() {
 			if p.targetProvider != nil {
 				targets, err := p.targetProvider.Targets()
 				if err != nil {
-					glog.Warningf("Error looking up targets, keeping old list: %s", err)
+					logger.Warnf("Error looking up targets, keeping old list: %s", err)
 				} else {
 					p.ReplaceTargets(targets)
 				}
@@ -99,24 +113,39 @@ func (p *TargetPool) addTarget(target Target) {
 	p.Lock()
 	defer p.Unlock()
 
+	// A target is identified by its URL. Scraping the same URL twice would
+	// ingest every sample twice.
+	if _, ok := p.targetsByURL[target.URL()]; ok {
+		logger.Debugf("Dropping duplicate target %s", target.URL())
+		targetDuplicatesDropped.Inc()
+		return
+	}
+	target.SetPaused(p.paused)
 	p.targetsByURL[target.URL()] = target
 	go target.RunScraper(p.ingester, p.interval)
 }
 
 // ReplaceTargets replaces the old targets by the provided new ones but reuses
 // old targets that are also present in newTargets to preserve scheduling and
-// health state. Targets no longer present are stopped.
+// health state. Targets no longer present are stopped. Of several new targets
+// with the same URL, only the first one is used.
 func (p *TargetPool) ReplaceTargets(newTargets []Target) {
 	p.Lock()
 	defer p.Unlock()
 
 	newTargetURLs := make(utility.Set)
 	for _, newTarget := range newTargets {
+		if newTargetURLs.Has(newTarget.URL()) {
+			logger.Debugf("Dropping duplicate target %s", newTarget.URL())
+			targetDuplicatesDropped.Inc()
+			continue
+		}
 		newTargetURLs.Add(newTarget.URL())
 		oldTarget, ok := p.targetsByURL[newTarget.URL()]
 		if ok {
 			oldTarget.SetBaseLabelsFrom(newTarget)
 		} else {
+			newTarget.SetPaused(p.paused)
 			p.targetsByURL[newTarget.URL()] = newTarget
 			go newTarget.RunScraper(p.ingester, p.interval)
 		}
@@ -128,9 +157,9 @@ func (p *TargetPool) ReplaceTargets(newTargets []Target) {
 			wg.Add(1)
 			go func(k string, oldTarget Target) {
 				defer wg.Done()
-				glog.V(1).Infof("Stopping scraper for target %s...", k)
+				logger.Debugf("Stopping scraper for target %s...", k)
 				oldTarget.StopScraper()
-				glog.V(1).Infof("Scraper for target %s stopped.", k)
+				logger.Debugf("Scraper for target %s stopped.", k)
 			}(k, oldTarget)
 			delete(p.targetsByURL, k)
 		}
@@ -138,6 +167,33 @@ func (p *TargetPool) ReplaceTargets(newTargets []Target) {
 	wg.Wait()
 }
 
+// SetPaused pauses or resumes the regular scrapes of all targets in the pool,
+// including targets added later on.
+func (p *TargetPool) SetPaused(paused bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.paused = paused
+	for _, t := range p.targetsByURL {
+		t.SetPaused(paused)
+	}
+}
+
+// Paused returns whether the regular scrapes of the pool's targets are paused.
+func (p *TargetPool) Paused() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.paused
+}
+
+// Target returns the target with the given URL and whether it exists.
+func (p *TargetPool) Target(url string) (Target, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	t, ok := p.targetsByURL[url]
+	return t, ok
+}
+
 type targetsByURL []Target
 
 func (s targetsByURL) Len() int {
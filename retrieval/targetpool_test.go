@@ -77,6 +77,28 @@ func testTargetPool(t testing.TB) {
 				},
 			},
 		},
+		{
+			name: "duplicates dropped",
+			inputs: []input{
+				{
+					url: "duplicate1",
+				},
+				{
+					url: "duplicate2",
+				},
+				{
+					url: "duplicate1",
+				},
+			},
+			outputs: []output{
+				{
+					url: "duplicate1",
+				},
+				{
+					url: "duplicate2",
+				},
+			},
+		},
 	}
 
 	for i, scenario := range scenarios {
@@ -155,17 +177,65 @@ func TestTargetPoolReplaceTargets(t *testing.T) {
 		t.Errorf("Expected 2 elements in pool, had %d", len(pool.targetsByURL))
 	}
 
-	if pool.targetsByURL["example1"].State() != oldTarget1.State() {
-		t.Errorf("target1 channel has changed")
+	// The scrapers started for the targets change their state, so that the
+	// targets are compared by identity.
+	if pool.targetsByURL["example1"] != oldTarget1 {
+		t.Errorf("oldTarget1 has been replaced")
 	}
-	if pool.targetsByURL["example3"].State() == oldTarget2.State() {
-		t.Errorf("newTarget2 channel same as oldTarget2's")
+	if pool.targetsByURL["example3"] != newTarget2 {
+		t.Errorf("newTarget2 has not been added")
 	}
 
 }
 
+func TestTargetPoolReplaceTargetsDropsDuplicates(t *testing.T) {
+	pool := NewTargetPool(nil, nil, nopIngester{}, time.Minute)
+	newTarget := func(url string) *target {
+		return &target{
+			url:             url,
+			scraperStopping: make(chan struct{}),
+			scraperStopped:  make(chan struct{}),
+			newBaseLabels:   make(chan clientmodel.LabelSet, 1),
+			httpClient:      &http.Client{},
+		}
+	}
+	first := newTarget("example1")
+
+	pool.ReplaceTargets([]Target{first, newTarget("example1"), newTarget("example2")})
+
+	if len(pool.targetsByURL) != 2 {
+		t.Fatalf("Expected 2 elements in pool, had %d", len(pool.targetsByURL))
+	}
+	if pool.targetsByURL["example1"] != first {
+		t.Errorf("Expected first of the duplicate targets to be kept")
+	}
+	pool.ReplaceTargets([]Target{})
+}
+
 func BenchmarkTargetPool(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		testTargetPool(b)
 	}
 }
+
+func TestTargetPoolSetPaused(t *testing.T) {
+	pool := NewTargetPool(nil, nil, nopIngester{}, time.Minute)
+	oldTarget := NewTarget("example1", TargetOptions{}, clientmodel.LabelSet{})
+	pool.addTarget(oldTarget)
+
+	pool.SetPaused(true)
+	if !pool.Paused() || !oldTarget.Paused() {
+		t.Errorf("Expected pool and its targets to be paused")
+	}
+	newTarget := NewTarget("example2", TargetOptions{}, clientmodel.LabelSet{})
+	pool.ReplaceTargets([]Target{oldTarget, newTarget})
+	if !newTarget.Paused() {
+		t.Errorf("Expected target added to paused pool to be paused")
+	}
+
+	pool.SetPaused(false)
+	if pool.Paused() || oldTarget.Paused() || newTarget.Paused() {
+		t.Errorf("Expected pool and its targets to be resumed")
+	}
+	pool.ReplaceTargets([]Target{})
+}
@@ -128,15 +128,20 @@ func (rule *AlertingRule) Name() string {
 	return rule.name
 }
 
+// Expr returns the vector expression of the rule.
+func (rule *AlertingRule) Expr() ast.VectorNode {
+	return rule.Vector
+}
+
 // EvalRaw returns the raw value of the rule expression, without creating alerts.
-func (rule *AlertingRule) EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error) {
-	return ast.EvalVectorInstant(rule.Vector, timestamp, storage, stats.NewTimerGroup())
+func (rule *AlertingRule) EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	return ast.EvalVectorInstant(rule.Vector, timestamp, storage, queryStats)
 }
 
 // Eval evaluates the rule expression and then creates pending alerts and fires
 // or removes previously pending alerts accordingly.
-func (rule *AlertingRule) Eval(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error) {
-	exprResult, err := rule.EvalRaw(timestamp, storage)
+func (rule *AlertingRule) Eval(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	exprResult, err := rule.EvalRaw(timestamp, storage, queryStats)
 	if err != nil {
 		return nil, err
 	}
@@ -191,6 +196,45 @@ func (rule *AlertingRule) Eval(timestamp clientmodel.Timestamp, storage local.St
 	return vector, nil
 }
 
+// Preview returns an ALERTS sample for each alert the rule expression makes
+// active without creating the alerts. The samples have the value of the
+// expression and no alertstate label, as whether an alert would be pending or
+// firing depends on its history.
+func (rule *AlertingRule) Preview(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	exprResult, err := rule.EvalRaw(timestamp, storage, queryStats)
+	if err != nil {
+		return nil, err
+	}
+
+	vector := make(ast.Vector, 0, len(exprResult))
+	for _, sample := range exprResult {
+		labels := clientmodel.LabelSet{}
+		labels.MergeFromMetric(sample.Metric.Metric)
+		labels = labels.Merge(rule.Labels)
+		delete(labels, clientmodel.MetricNameLabel)
+
+		s := Alert{Name: rule.name, Labels: labels}.sample(timestamp, sample.Value)
+		delete(s.Metric.Metric, AlertStateLabel)
+		vector = append(vector, s)
+	}
+	return vector, nil
+}
+
+// TestAlert returns a firing alert of the rule for a sample of the rule
+// expression with the given labels and value, becoming active at the given
+// time. The rule's state is not changed.
+func (rule *AlertingRule) TestAlert(labels clientmodel.LabelSet, value clientmodel.SampleValue, timestamp clientmodel.Timestamp) Alert {
+	alertLabels := labels.Merge(rule.Labels)
+	delete(alertLabels, clientmodel.MetricNameLabel)
+	return Alert{
+		Name:        rule.name,
+		Labels:      alertLabels,
+		State:       Firing,
+		ActiveSince: timestamp,
+		Value:       value,
+	}
+}
+
 // ToDotGraph returns the text representation of a dot graph.
 func (rule *AlertingRule) ToDotGraph() string {
 	graph := fmt.Sprintf(
@@ -210,16 +254,16 @@ func (rule *AlertingRule) String() string {
 }
 
 // HTMLSnippet returns an HTML snippet representing this alerting rule.
-func (rule *AlertingRule) HTMLSnippet() template.HTML {
+func (rule *AlertingRule) HTMLSnippet(pathPrefix string) template.HTML {
 	alertMetric := clientmodel.Metric{
 		clientmodel.MetricNameLabel: AlertMetricName,
 		AlertNameLabel:              clientmodel.LabelValue(rule.name),
 	}
 	return template.HTML(fmt.Sprintf(
 		`ALERT <a href="%s">%s</a> IF <a href="%s">%s</a> FOR %s WITH %s`,
-		GraphLinkForExpression(alertMetric.String()),
+		pathPrefix+GraphLinkForExpression(alertMetric.String()),
 		rule.name,
-		GraphLinkForExpression(rule.Vector.String()),
+		pathPrefix+GraphLinkForExpression(rule.Vector.String()),
 		rule.Vector,
 		utility.DurationToString(rule.holdDuration),
 		rule.Labels))
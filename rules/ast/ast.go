@@ -41,6 +41,23 @@ func (e queryTimeoutError) Error() string {
 	return fmt.Sprintf("query timeout after %v", e.timeoutAfter)
 }
 
+// IsQueryTimeout returns whether the given error was caused by a query
+// exceeding its timeout.
+func IsQueryTimeout(err error) bool {
+	_, ok := err.(queryTimeoutError)
+	return ok
+}
+
+// effectiveTimeout returns the timeout to use for a query requesting the given
+// timeout. Queries may shorten the timeout set by the -query.timeout flag but
+// not extend it. A requested timeout of 0 selects the flag value.
+func effectiveTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 || timeout > *queryTimeout {
+		return *queryTimeout
+	}
+	return timeout
+}
+
 // ----------------------------------------------------------------------------
 // Raw data value types.
 
@@ -129,6 +146,7 @@ const (
 	Min
 	Max
 	Count
+	Group
 )
 
 // ----------------------------------------------------------------------------
@@ -220,6 +238,9 @@ type (
 		metrics   map[clientmodel.Fingerprint]clientmodel.COWMetric
 		// Fingerprints are populated from label matchers at query analysis time.
 		fingerprints clientmodel.Fingerprints
+		// The samples of remote sources selected by the source label are
+		// fetched at query preparation time.
+		remoteSamples Vector
 	}
 
 	// VectorFunctionCall represents a function with vector return
@@ -386,7 +407,7 @@ func EvalVectorInstant(node VectorNode, timestamp clientmodel.Timestamp, storage
 	totalEvalTimer := queryStats.GetTimer(stats.TotalEvalTime).Start()
 	defer totalEvalTimer.Stop()
 
-	closer, err := prepareInstantQuery(node, timestamp, storage, queryStats)
+	closer, err := prepareInstantQuery(node, timestamp, *queryTimeout, storage, queryStats)
 	if err != nil {
 		return nil, err
 	}
@@ -397,30 +418,55 @@ func EvalVectorInstant(node VectorNode, timestamp clientmodel.Timestamp, storage
 	return node.Eval(timestamp), nil
 }
 
-// EvalVectorRange evaluates a VectorNode with a range query.
-func EvalVectorRange(node VectorNode, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (Matrix, error) {
+// EvalInstant evaluates a node of any type with an instant query. Depending on
+// the type of the node, the result is a clientmodel.SampleValue, a Vector, a
+// Matrix, or a string. The query is aborted after the given timeout (see
+// -query.timeout for the limits).
+func EvalInstant(node Node, timestamp clientmodel.Timestamp, timeout time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (interface{}, error) {
 	totalEvalTimer := queryStats.GetTimer(stats.TotalEvalTime).Start()
 	defer totalEvalTimer.Stop()
-	// Explicitly initialize to an empty matrix since a nil Matrix encodes to
-	// null in JSON.
-	matrix := Matrix{}
+	timeout = effectiveTimeout(timeout)
 
 	prepareTimer := queryStats.GetTimer(stats.TotalQueryPreparationTime).Start()
-	closer, err := prepareRangeQuery(node, start, end, interval, storage, queryStats)
+	closer, err := prepareInstantQuery(node, timestamp, timeout, storage, queryStats)
 	prepareTimer.Stop()
 	if err != nil {
 		return nil, err
 	}
 	defer closer.Close()
+	if et := totalEvalTimer.ElapsedTime(); et > timeout {
+		return nil, queryTimeoutError{et}
+	}
 
 	evalTimer := queryStats.GetTimer(stats.InnerEvalTime).Start()
+	defer evalTimer.Stop()
+	switch n := node.(type) {
+	case ScalarNode:
+		return n.Eval(timestamp), nil
+	case VectorNode:
+		return n.Eval(timestamp), nil
+	case MatrixNode:
+		return n.Eval(timestamp), nil
+	case StringNode:
+		return n.Eval(timestamp), nil
+	}
+	panic("Switch didn't cover all node types")
+}
+
+// EvalVectorRange evaluates a VectorNode with a range query.
+func EvalVectorRange(node VectorNode, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (Matrix, error) {
+	return EvalVectorRangeWithTimeout(node, start, end, interval, *queryTimeout, storage, queryStats)
+}
+
+// EvalVectorRangeWithTimeout evaluates a VectorNode with a range query that is
+// aborted after the given timeout (see -query.timeout for the limits).
+func EvalVectorRangeWithTimeout(node VectorNode, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, timeout time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (Matrix, error) {
+	// Explicitly initialize to an empty matrix since a nil Matrix encodes to
+	// null in JSON.
+	matrix := Matrix{}
+
 	sampleStreams := map[clientmodel.Fingerprint]*SampleStream{}
-	for t := start; !t.After(end); t = t.Add(interval) {
-		if et := totalEvalTimer.ElapsedTime(); et > *queryTimeout {
-			evalTimer.Stop()
-			return nil, queryTimeoutError{et}
-		}
-		vector := node.Eval(t)
+	err := EvalVectorRangeFunc(node, start, end, interval, timeout, storage, queryStats, func(t clientmodel.Timestamp, vector Vector) error {
 		for _, sample := range vector {
 			samplePair := metric.SamplePair{
 				Value:     sample.Value,
@@ -436,8 +482,11 @@ func EvalVectorRange(node VectorNode, start clientmodel.Timestamp, end clientmod
 				sampleStreams[fp].Values = append(sampleStreams[fp].Values, samplePair)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	evalTimer.Stop()
 
 	appendTimer := queryStats.GetTimer(stats.ResultAppendTime).Start()
 	for _, sampleStream := range sampleStreams {
@@ -448,6 +497,37 @@ func EvalVectorRange(node VectorNode, start clientmodel.Timestamp, end clientmod
 	return matrix, nil
 }
 
+// EvalVectorRangeFunc evaluates a VectorNode with a range query like
+// EvalVectorRangeWithTimeout but, instead of accumulating a Matrix, calls f
+// with the vector of each step in chronological order. The time spent in f
+// counts towards the timeout. Evaluation stops at the first error returned by
+// f, which is then returned.
+func EvalVectorRangeFunc(node VectorNode, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, timeout time.Duration, storage local.Storage, queryStats *stats.TimerGroup, f func(clientmodel.Timestamp, Vector) error) error {
+	timeout = effectiveTimeout(timeout)
+	totalEvalTimer := queryStats.GetTimer(stats.TotalEvalTime).Start()
+	defer totalEvalTimer.Stop()
+
+	prepareTimer := queryStats.GetTimer(stats.TotalQueryPreparationTime).Start()
+	closer, err := prepareRangeQuery(node, start, end, interval, timeout, storage, queryStats)
+	prepareTimer.Stop()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	evalTimer := queryStats.GetTimer(stats.InnerEvalTime).Start()
+	defer evalTimer.Stop()
+	for t := start; !t.After(end); t = t.Add(interval) {
+		if et := totalEvalTimer.ElapsedTime(); et > timeout {
+			return queryTimeoutError{et}
+		}
+		if err := f(t, node.Eval(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func labelIntersection(metric1, metric2 clientmodel.COWMetric) clientmodel.COWMetric {
 	for label, value := range metric1.Metric {
 		if metric2.Metric[label] != value {
@@ -465,6 +545,8 @@ func (node *VectorAggregation) groupedAggregationsToVector(aggregations map[uint
 			aggregation.value = aggregation.value / clientmodel.SampleValue(aggregation.groupCount)
 		case Count:
 			aggregation.value = clientmodel.SampleValue(aggregation.groupCount)
+		case Group:
+			aggregation.value = 1
 		default:
 			// For other aggregations, we already have the right value.
 		}
@@ -506,6 +588,8 @@ func (node *VectorAggregation) Eval(timestamp clientmodel.Timestamp) Vector {
 				}
 			case Count:
 				groupedResult.groupCount++
+			case Group:
+				// The value is always 1.
 			default:
 				panic("Unknown aggregation type")
 			}
@@ -552,6 +636,13 @@ func (node *VectorSelector) Eval(timestamp clientmodel.Timestamp) Vector {
 			})
 		}
 	}
+	for _, s := range node.remoteSamples {
+		samples = append(samples, &Sample{
+			Metric:    s.Metric,
+			Value:     s.Value,
+			Timestamp: timestamp,
+		})
+	}
 	//// timer.Stop()
 	return samples
 }
@@ -906,6 +997,11 @@ func NewVectorSelector(m metric.LabelMatchers, offset time.Duration) *VectorSele
 	}
 }
 
+// LabelMatchers returns the label matchers of the selector.
+func (node *VectorSelector) LabelMatchers() metric.LabelMatchers {
+	return node.labelMatchers
+}
+
 // NewVectorAggregation returns a (not yet evaluated)
 // VectorAggregation, aggregating the given VectorNode using the given
 // AggrType, grouping by the given LabelNames.
@@ -0,0 +1,72 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage/local"
+)
+
+// Copy returns a deep copy of the expression without any of its evaluation
+// state. The copy can be evaluated and modified, e.g. by RestrictSelectors,
+// independently of the original.
+func Copy(node Node) Node {
+	switch n := node.(type) {
+	case *ScalarLiteral:
+		return &ScalarLiteral{value: n.value}
+	case *ScalarFunctionCall:
+		return &ScalarFunctionCall{function: n.function, args: copyNodes(n.args)}
+	case *ScalarArithExpr:
+		return &ScalarArithExpr{
+			opType: n.opType,
+			lhs:    Copy(n.lhs).(ScalarNode),
+			rhs:    Copy(n.rhs).(ScalarNode),
+		}
+	case *VectorSelector:
+		return NewVectorSelector(n.labelMatchers, n.offset)
+	case *VectorFunctionCall:
+		return &VectorFunctionCall{function: n.function, args: copyNodes(n.args)}
+	case *VectorAggregation:
+		return &VectorAggregation{
+			aggrType:        n.aggrType,
+			groupBy:         n.groupBy,
+			keepExtraLabels: n.keepExtraLabels,
+			vector:          Copy(n.vector).(VectorNode),
+		}
+	case *VectorArithExpr:
+		return &VectorArithExpr{opType: n.opType, lhs: Copy(n.lhs), rhs: Copy(n.rhs)}
+	case *MatrixSelector:
+		return &MatrixSelector{
+			labelMatchers: n.labelMatchers,
+			interval:      n.interval,
+			offset:        n.offset,
+			iterators:     map[clientmodel.Fingerprint]local.SeriesIterator{},
+			metrics:       map[clientmodel.Fingerprint]clientmodel.COWMetric{},
+		}
+	case *StringLiteral:
+		return &StringLiteral{str: n.str}
+	case *StringFunctionCall:
+		return &StringFunctionCall{function: n.function, args: copyNodes(n.args)}
+	}
+	panic("Switch didn't cover all node types")
+}
+
+func copyNodes(nodes Nodes) Nodes {
+	res := make(Nodes, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, Copy(n))
+	}
+	return res
+}
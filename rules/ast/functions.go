@@ -19,6 +19,7 @@ import (
 	"math"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -34,10 +35,14 @@ type Function struct {
 	optionalArgs int
 	returnType   ExprType
 	callFn       func(timestamp clientmodel.Timestamp, args []Node) interface{}
+	// checkArgs, if not nil, validates the values of arguments that can be
+	// checked before evaluation, e.g. string literals.
+	checkArgs func(args []Node) error
 }
 
 // CheckArgTypes returns a non-nil error if the number or types of
-// passed in arg nodes do not match the function's expectations.
+// passed in arg nodes do not match the function's expectations, or if
+// the function rejects the values of arg nodes known before evaluation.
 func (function *Function) CheckArgTypes(args []Node) error {
 	if len(function.argTypes) < len(args) {
 		return fmt.Errorf(
@@ -78,6 +83,11 @@ func (function *Function) CheckArgTypes(args []Node) error {
 			)
 		}
 	}
+	if function.checkArgs != nil {
+		if err := function.checkArgs(args); err != nil {
+			return fmt.Errorf("invalid argument in function %v(): %v", function.name, err)
+		}
+	}
 	return nil
 }
 
@@ -196,6 +206,18 @@ func (s reverseHeap) Less(i, j int) bool {
 	return s.Interface.Less(j, i)
 }
 
+type sampleWithFingerprint struct {
+	sample *Sample
+	fp     clientmodel.Fingerprint
+}
+
+// vectorByFingerprint implements sort.Interface.
+type vectorByFingerprint []sampleWithFingerprint
+
+func (s vectorByFingerprint) Len() int           { return len(s) }
+func (s vectorByFingerprint) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s vectorByFingerprint) Less(i, j int) bool { return s[i].fp < s[j].fp }
+
 // === sort(node VectorNode) Vector ===
 func sortImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	byValueSorter := vectorByValueHeap(args[0].(VectorNode).Eval(timestamp))
@@ -255,6 +277,51 @@ func bottomkImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	return Vector(bottomk)
 }
 
+// === limitk(k ScalarNode, node VectorNode) Vector ===
+func limitkImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	k := int(args[0].(ScalarNode).Eval(timestamp))
+	if k < 1 {
+		return Vector{}
+	}
+	vector := args[1].(VectorNode).Eval(timestamp)
+	if len(vector) <= k {
+		return vector
+	}
+
+	// Select the series with the lowest fingerprints so that the same
+	// series are returned in each evaluation.
+	byFP := make(vectorByFingerprint, 0, len(vector))
+	for _, el := range vector {
+		byFP = append(byFP, sampleWithFingerprint{el, el.Metric.Metric.Fingerprint()})
+	}
+	sort.Sort(byFP)
+	limited := make(Vector, 0, k)
+	for _, el := range byFP[:k] {
+		limited = append(limited, el.sample)
+	}
+	return limited
+}
+
+// === limit_ratio(ratio ScalarNode, node VectorNode) Vector ===
+func limitRatioImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	ratio := float64(args[0].(ScalarNode).Eval(timestamp))
+	vector := args[1].(VectorNode).Eval(timestamp)
+	if ratio >= 1 {
+		return vector
+	}
+
+	// A series is selected if its fingerprint falls into the lowest ratio
+	// of the fingerprint space, so that the same series are returned in
+	// each evaluation and a higher ratio returns a superset.
+	limited := Vector{}
+	for _, el := range vector {
+		if float64(el.Metric.Metric.Fingerprint()) < ratio*math.MaxUint64 {
+			limited = append(limited, el)
+		}
+	}
+	return limited
+}
+
 // === drop_common_labels(node VectorNode) Vector ===
 func dropCommonLabelsImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	vector := args[0].(VectorNode).Eval(timestamp)
@@ -292,6 +359,93 @@ func dropCommonLabelsImpl(timestamp clientmodel.Timestamp, args []Node) interfac
 	return vector
 }
 
+// === label_compare(vector VectorNode, label StringNode, op StringNode, value StringNode) Vector ===
+func labelCompareImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	vector := args[0].(VectorNode).Eval(timestamp)
+	label := clientmodel.LabelName(args[1].(StringNode).Eval(timestamp))
+	// String literals have been validated by checkLabelCompareArgs.
+	cmp := labelComparisons[args[2].(StringNode).Eval(timestamp)]
+	value := args[3].(StringNode).Eval(timestamp)
+
+	resultVector := Vector{}
+	for _, el := range vector {
+		if cmp(compareLabelValues(string(el.Metric.Metric[label]), value)) {
+			resultVector = append(resultVector, el)
+		}
+	}
+	return resultVector
+}
+
+// labelComparisons maps the comparison operators of label_compare to
+// functions taking the result of compareLabelValues.
+var labelComparisons = map[string]func(int) bool{
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+}
+
+func checkLabelCompareArgs(args []Node) error {
+	if lit, ok := args[2].(*StringLiteral); ok {
+		if _, ok := labelComparisons[lit.Eval(0)]; !ok {
+			return fmt.Errorf("unknown comparison operator %q", lit.Eval(0))
+		}
+	}
+	return nil
+}
+
+// compareLabelValues compares two label values lexically, except that runs of
+// digits are compared by their numeric value, so that version strings sort
+// as expected (e.g. "1.9.2" < "1.10.0"). It returns -1, 0, or 1 if a is less
+// than, equal to, or greater than b.
+func compareLabelValues(a, b string) int {
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	for len(a) > 0 && len(b) > 0 {
+		if !isDigit(a[0]) || !isDigit(b[0]) {
+			if a[0] != b[0] {
+				if a[0] < b[0] {
+					return -1
+				}
+				return 1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// Compare the runs of digits at the start of a and b numerically,
+		// i.e. by length after stripping leading zeros, then lexically.
+		i, j := 0, 0
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		na, nb := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+		switch {
+		case len(na) != len(nb):
+			if len(na) < len(nb) {
+				return -1
+			}
+			return 1
+		case na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[i:], b[j:]
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
 // === round(vector VectorNode, toNearest=1 Scalar) Vector ===
 func roundImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	// round returns a number rounded to toNearest.
@@ -364,6 +518,20 @@ func countOverTimeImpl(timestamp clientmodel.Timestamp, args []Node) interface{}
 	})
 }
 
+// === last_over_time(matrix MatrixNode) Vector ===
+func lastOverTimeImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	return aggrOverTime(timestamp, args, func(values metric.Values) clientmodel.SampleValue {
+		return values[len(values)-1].Value
+	})
+}
+
+// === present_over_time(matrix MatrixNode) Vector ===
+func presentOverTimeImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	return aggrOverTime(timestamp, args, func(values metric.Values) clientmodel.SampleValue {
+		return 1
+	})
+}
+
 // === floor(vector VectorNode) Vector ===
 func floorImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	n := args[0].(VectorNode)
@@ -419,18 +587,58 @@ func absImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	return vector
 }
 
+// === sgn(vector VectorNode) Vector ===
+func sgnImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	n := args[0].(VectorNode)
+	vector := n.Eval(timestamp)
+	for _, el := range vector {
+		el.Metric.Delete(clientmodel.MetricNameLabel)
+		switch {
+		case el.Value < 0:
+			el.Value = -1
+		case el.Value > 0:
+			el.Value = 1
+		}
+		// 0 and NaN keep their value.
+	}
+	return vector
+}
+
 // === absent(vector VectorNode) Vector ===
 func absentImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	n := args[0].(VectorNode)
 	if len(n.Eval(timestamp)) > 0 {
 		return Vector{}
 	}
-	m := clientmodel.Metric{}
+	var matchers metric.LabelMatchers
 	if vs, ok := n.(*VectorSelector); ok {
-		for _, matcher := range vs.labelMatchers {
-			if matcher.Type == metric.Equal && matcher.Name != clientmodel.MetricNameLabel {
-				m[matcher.Name] = matcher.Value
-			}
+		matchers = vs.labelMatchers
+	}
+	return absentVector(matchers, timestamp)
+}
+
+// === absent_over_time(matrix MatrixNode) Vector ===
+func absentOverTimeImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	n := args[0].(MatrixNode)
+	for _, el := range n.Eval(timestamp) {
+		if len(el.Values) > 0 {
+			return Vector{}
+		}
+	}
+	var matchers metric.LabelMatchers
+	if ms, ok := n.(*MatrixSelector); ok {
+		matchers = ms.labelMatchers
+	}
+	return absentVector(matchers, timestamp)
+}
+
+// absentVector returns the result of absent functions: a single sample with
+// value 1, labeled with the equality matchers among the given label matchers.
+func absentVector(matchers metric.LabelMatchers, timestamp clientmodel.Timestamp) Vector {
+	m := clientmodel.Metric{}
+	for _, matcher := range matchers {
+		if matcher.Type == metric.Equal && matcher.Name != clientmodel.MetricNameLabel {
+			m[matcher.Name] = matcher.Value
 		}
 	}
 	return Vector{
@@ -499,10 +707,15 @@ func derivImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	return resultVector
 }
 
-// === histogram_quantile(k ScalarNode, vector VectorNode) Vector ===
+// === histogram_quantile(k ScalarNode, vector VectorNode, interpolation="linear" StringNode) Vector ===
 func histogramQuantileImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
 	q := args[0].(ScalarNode).Eval(timestamp)
 	inVec := args[1].(VectorNode).Eval(timestamp)
+	ip := linearInterpolation
+	if len(args) >= 3 {
+		// String literals have been validated by checkHistogramQuantileArgs.
+		ip, _ = parseInterpolation(args[2].(StringNode).Eval(timestamp))
+	}
 	outVec := Vector{}
 	fpToMetricWithBuckets := map[clientmodel.Fingerprint]*metricWithBuckets{}
 	for _, el := range inVec {
@@ -528,7 +741,7 @@ func histogramQuantileImpl(timestamp clientmodel.Timestamp, args []Node) interfa
 	for _, mb := range fpToMetricWithBuckets {
 		outVec = append(outVec, &Sample{
 			Metric:    mb.metric,
-			Value:     clientmodel.SampleValue(quantile(q, mb.buckets)),
+			Value:     clientmodel.SampleValue(quantile(q, mb.buckets, ip)),
 			Timestamp: timestamp,
 		})
 	}
@@ -536,6 +749,58 @@ func histogramQuantileImpl(timestamp clientmodel.Timestamp, args []Node) interfa
 	return outVec
 }
 
+// === histogram_buckets(vector VectorNode) Vector ===
+func histogramBucketsImpl(timestamp clientmodel.Timestamp, args []Node) interface{} {
+	inVec := args[0].(VectorNode).Eval(timestamp)
+	histograms := map[clientmodel.Fingerprint][]bucketSample{}
+	for _, el := range inVec {
+		upperBound, err := strconv.ParseFloat(
+			string(el.Metric.Metric[clientmodel.BucketLabel]), 64,
+		)
+		if err != nil {
+			// No bucket label or malformed label value. Skip.
+			continue
+		}
+		fp := bucketFingerprint(el.Metric.Metric)
+		histograms[fp] = append(histograms[fp], bucketSample{upperBound, el})
+	}
+
+	outVec := Vector{}
+	for _, bs := range histograms {
+		sort.Sort(bucketSamples(bs))
+		prev := clientmodel.SampleValue(0)
+		for _, b := range bs {
+			count := b.sample.Value - prev
+			if count < 0 {
+				// Buckets are not monotonic if they were not scraped
+				// atomically.
+				count = 0
+			}
+			prev = b.sample.Value
+
+			b.sample.Metric.Delete(clientmodel.MetricNameLabel)
+			b.sample.Metric.Set(clientmodel.BucketLabel, formatBucketBound(b.upperBound))
+			outVec = append(outVec, &Sample{
+				Metric:    b.sample.Metric,
+				Value:     count,
+				Timestamp: timestamp,
+			})
+		}
+	}
+	return outVec
+}
+
+func checkHistogramQuantileArgs(args []Node) error {
+	if len(args) < 3 {
+		return nil
+	}
+	if lit, ok := args[2].(*StringLiteral); ok {
+		_, err := parseInterpolation(lit.Eval(0))
+		return err
+	}
+	return nil
+}
+
 var functions = map[string]*Function{
 	"abs": {
 		name:       "abs",
@@ -549,6 +814,12 @@ var functions = map[string]*Function{
 		returnType: VectorType,
 		callFn:     absentImpl,
 	},
+	"absent_over_time": {
+		name:       "absent_over_time",
+		argTypes:   []ExprType{MatrixType},
+		returnType: VectorType,
+		callFn:     absentOverTimeImpl,
+	},
 	"avg_over_time": {
 		name:       "avg_over_time",
 		argTypes:   []ExprType{MatrixType},
@@ -604,11 +875,44 @@ var functions = map[string]*Function{
 		returnType: VectorType,
 		callFn:     floorImpl,
 	},
+	"histogram_buckets": {
+		name:       "histogram_buckets",
+		argTypes:   []ExprType{VectorType},
+		returnType: VectorType,
+		callFn:     histogramBucketsImpl,
+	},
 	"histogram_quantile": {
-		name:       "histogram_quantile",
+		name:         "histogram_quantile",
+		argTypes:     []ExprType{ScalarType, VectorType, StringType},
+		optionalArgs: 1,
+		returnType:   VectorType,
+		callFn:       histogramQuantileImpl,
+		checkArgs:    checkHistogramQuantileArgs,
+	},
+	"label_compare": {
+		name:       "label_compare",
+		argTypes:   []ExprType{VectorType, StringType, StringType, StringType},
+		returnType: VectorType,
+		callFn:     labelCompareImpl,
+		checkArgs:  checkLabelCompareArgs,
+	},
+	"last_over_time": {
+		name:       "last_over_time",
+		argTypes:   []ExprType{MatrixType},
+		returnType: VectorType,
+		callFn:     lastOverTimeImpl,
+	},
+	"limit_ratio": {
+		name:       "limit_ratio",
+		argTypes:   []ExprType{ScalarType, VectorType},
+		returnType: VectorType,
+		callFn:     limitRatioImpl,
+	},
+	"limitk": {
+		name:       "limitk",
 		argTypes:   []ExprType{ScalarType, VectorType},
 		returnType: VectorType,
-		callFn:     histogramQuantileImpl,
+		callFn:     limitkImpl,
 	},
 	"max_over_time": {
 		name:       "max_over_time",
@@ -622,6 +926,12 @@ var functions = map[string]*Function{
 		returnType: VectorType,
 		callFn:     minOverTimeImpl,
 	},
+	"present_over_time": {
+		name:       "present_over_time",
+		argTypes:   []ExprType{MatrixType},
+		returnType: VectorType,
+		callFn:     presentOverTimeImpl,
+	},
 	"rate": {
 		name:       "rate",
 		argTypes:   []ExprType{MatrixType},
@@ -641,6 +951,12 @@ var functions = map[string]*Function{
 		returnType: ScalarType,
 		callFn:     scalarImpl,
 	},
+	"sgn": {
+		name:       "sgn",
+		argTypes:   []ExprType{VectorType},
+		returnType: VectorType,
+		callFn:     sgnImpl,
+	},
 	"sort": {
 		name:       "sort",
 		argTypes:   []ExprType{VectorType},
@@ -61,3 +61,31 @@ func TestDeltaWithEmptyElementDoesNotCrash(t *testing.T) {
 		t.Fatalf("Expected empty result vector, got: %v", vector)
 	}
 }
+
+func TestCompareLabelValues(t *testing.T) {
+	scenarios := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "a", -1},
+		{"abc", "abd", -1},
+		{"abc", "ab", 1},
+		{"1.9.2", "1.10.0", -1},
+		{"1.10.0", "1.10.0", 0},
+		{"1.10.1", "1.10.0", 1},
+		{"v2", "v10", -1},
+		{"1.02", "1.2", 0},
+		{"1.2-rc1", "1.2", 1},
+		{"1.2-rc1", "1.2-rc2", -1},
+		{"10", "9a", 1},
+	}
+	for i, s := range scenarios {
+		if got := compareLabelValues(s.a, s.b); got != s.want {
+			t.Errorf("%d. compareLabelValues(%q, %q) = %d, want %d", i, s.a, s.b, got, s.want)
+		}
+		if got := compareLabelValues(s.b, s.a); got != -s.want {
+			t.Errorf("%d. compareLabelValues(%q, %q) = %d, want %d", i, s.b, s.a, got, -s.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage/metric"
+	"github.com/prometheus/prometheus/utility"
+)
+
+// LintOptions configure the checks of Lint.
+type LintOptions struct {
+	// MetricType returns the type of the metric with the given name as
+	// exposed by the targets, e.g. "counter" or "gauge", or "" if unknown.
+	// If nil, metrics are only recognized as counters by the suffix of their
+	// name.
+	MetricType func(clientmodel.LabelValue) string
+	// The longest scrape interval of the series queried. Range selectors
+	// shorter than two scrape intervals are reported. 0 disables the check.
+	ScrapeInterval time.Duration
+}
+
+// A LintWarning reports an anti-pattern found in an expression.
+type LintWarning struct {
+	// The subexpression the warning is about.
+	Expr    string `json:"expr"`
+	Message string `json:"message"`
+}
+
+// Lint checks the expression for common anti-patterns, namely:
+//
+//   - rate() of a gauge,
+//   - a sum or average over counters without rate(),
+//   - a binary operation between vectors that can never match as an aggregation
+//     dropped labels the other operand has,
+//   - rate(), delta(), or deriv() over a range shorter than two scrape
+//     intervals.
+func Lint(node Node, o LintOptions) []LintWarning {
+	l := &linter{opts: o}
+	Walk(l, node)
+	return l.warnings
+}
+
+type linter struct {
+	opts     LintOptions
+	warnings []LintWarning
+}
+
+func (l *linter) warnf(node Node, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, LintWarning{
+		Expr:    node.String(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) visit(node Node) {
+	switch n := node.(type) {
+	case *VectorFunctionCall:
+		l.checkRangeFunction(n, n.function, n.args)
+	case *ScalarFunctionCall:
+		l.checkRangeFunction(n, n.function, n.args)
+	case *VectorAggregation:
+		if n.aggrType != Sum && n.aggrType != Avg {
+			return
+		}
+		for _, s := range directSelectors(n.vector) {
+			if name := selectedMetricName(s.labelMatchers); l.isCounter(name) {
+				l.warnf(n, "%s of counter %s without rate(), which is dominated by the counter values since the last restart", n.aggrType, name)
+			}
+		}
+	case *VectorArithExpr:
+		if n.opType == Or || n.lhs.Type() != VectorType || n.rhs.Type() != VectorType {
+			return
+		}
+		lhs, lok := outputLabels(n.lhs)
+		rhs, rok := outputLabels(n.rhs)
+		switch {
+		case lok && rok:
+			if !lhs.Equal(rhs) {
+				l.warnf(n, "operands are aggregated by different labels (%s and %s), so no elements can match", lhs, rhs)
+			}
+		case lok && isRawSelector(n.rhs):
+			l.warnf(n, "left operand is aggregated by (%s), so it can only match elements of %s without any other labels", lhs, n.rhs)
+		case rok && isRawSelector(n.lhs):
+			l.warnf(n, "right operand is aggregated by (%s), so it can only match elements of %s without any other labels", rhs, n.lhs)
+		}
+	}
+}
+
+// checkRangeFunction checks the calls of functions taking a range of
+// samples of counters or gauges.
+func (l *linter) checkRangeFunction(node Node, f *Function, args Nodes) {
+	switch f.name {
+	case "rate", "delta", "deriv":
+	default:
+		return
+	}
+	ms, ok := args[0].(*MatrixSelector)
+	if !ok {
+		return
+	}
+	name := selectedMetricName(ms.labelMatchers)
+	if f.name == "rate" && l.metricType(name) == "gauge" {
+		l.warnf(node, "rate() of gauge %s, use deriv() or delta() for gauges", name)
+	}
+	if l.opts.ScrapeInterval > 0 && ms.interval < 2*l.opts.ScrapeInterval {
+		l.warnf(node, "range of %s is shorter than two scrape intervals of %s, so it often contains fewer than two samples", utility.DurationToString(ms.interval), utility.DurationToString(l.opts.ScrapeInterval))
+	}
+}
+
+func (l *linter) metricType(name clientmodel.LabelValue) string {
+	if name == "" || l.opts.MetricType == nil {
+		return ""
+	}
+	return l.opts.MetricType(name)
+}
+
+func (l *linter) isCounter(name clientmodel.LabelValue) bool {
+	if name == "" {
+		return false
+	}
+	if t := l.metricType(name); t != "" {
+		return t == "counter"
+	}
+	return strings.HasSuffix(string(name), "_total")
+}
+
+// directSelectors returns the vector selectors whose values flow into the
+// given node unchanged by any function or further aggregation.
+func directSelectors(node Node) []*VectorSelector {
+	switch n := node.(type) {
+	case *VectorSelector:
+		return []*VectorSelector{n}
+	case *VectorArithExpr:
+		return append(directSelectors(n.lhs), directSelectors(n.rhs)...)
+	}
+	return nil
+}
+
+// outputLabels returns the label names of the elements of the given node if
+// they are known from an aggregation.
+func outputLabels(node Node) (labelNameSet, bool) {
+	n, ok := node.(*VectorAggregation)
+	if !ok || n.keepExtraLabels {
+		return nil, false
+	}
+	return newLabelNameSet(n.groupBy), true
+}
+
+// isRawSelector returns whether the node selects series that presumably carry
+// more labels than an aggregation keeps. Metric names containing a colon are
+// taken to be the output of recording rules, which are often aggregated.
+func isRawSelector(node Node) bool {
+	s, ok := node.(*VectorSelector)
+	if !ok {
+		return false
+	}
+	name := selectedMetricName(s.labelMatchers)
+	return name != "" && !strings.Contains(string(name), ":")
+}
+
+// selectedMetricName returns the metric name matched for equality by the
+// given matchers, or "" if there is none.
+func selectedMetricName(matchers metric.LabelMatchers) clientmodel.LabelValue {
+	for _, m := range matchers {
+		if m.Name == clientmodel.MetricNameLabel && m.Type == metric.Equal {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// labelNameSet is a set of label names, printed sorted and comma-separated.
+type labelNameSet map[clientmodel.LabelName]struct{}
+
+func newLabelNameSet(names clientmodel.LabelNames) labelNameSet {
+	s := labelNameSet{}
+	for _, name := range names {
+		s[name] = struct{}{}
+	}
+	return s
+}
+
+func (s labelNameSet) Equal(o labelNameSet) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for name := range s {
+		if _, ok := o[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s labelNameSet) String() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
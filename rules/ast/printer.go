@@ -65,6 +65,7 @@ func (aggrType AggrType) String() string {
 		Min:   "MIN",
 		Max:   "MAX",
 		Count: "COUNT",
+		Group: "GROUP",
 	}
 	return aggrTypeMap[aggrType]
 }
@@ -164,7 +165,7 @@ func EvalToString(node Node, timestamp clientmodel.Timestamp, format OutputForma
 	defer totalEvalTimer.Stop()
 
 	prepareTimer := queryStats.GetTimer(stats.TotalQueryPreparationTime).Start()
-	closer, err := prepareInstantQuery(node, timestamp, storage, queryStats)
+	closer, err := prepareInstantQuery(node, timestamp, *queryTimeout, storage, queryStats)
 	prepareTimer.Stop()
 	if err != nil {
 		panic(err)
@@ -219,7 +220,7 @@ func EvalToVector(node Node, timestamp clientmodel.Timestamp, storage local.Stor
 	defer totalEvalTimer.Stop()
 
 	prepareTimer := queryStats.GetTimer(stats.TotalQueryPreparationTime).Start()
-	closer, err := prepareInstantQuery(node, timestamp, storage, queryStats)
+	closer, err := prepareInstantQuery(node, timestamp, *queryTimeout, storage, queryStats)
 	prepareTimer.Stop()
 	if err != nil {
 		panic(err)
@@ -15,9 +15,11 @@ package ast
 
 import (
 	"encoding/binary"
+	"fmt"
 	"hash/fnv"
 	"math"
 	"sort"
+	"strconv"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 )
@@ -36,21 +38,73 @@ func (b buckets) Len() int           { return len(b) }
 func (b buckets) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
 func (b buckets) Less(i, j int) bool { return b[i].upperBound < b[j].upperBound }
 
+// bucketSample is a sample of a bucket with its parsed upper bound.
+type bucketSample struct {
+	upperBound float64
+	sample     *Sample
+}
+
+// bucketSamples implements sort.Interface.
+type bucketSamples []bucketSample
+
+func (b bucketSamples) Len() int           { return len(b) }
+func (b bucketSamples) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bucketSamples) Less(i, j int) bool { return b[i].upperBound < b[j].upperBound }
+
+// formatBucketBound formats the upper bound of a bucket in its canonical
+// form, so that the same bound is always represented by the same label
+// value, e.g. "0.2" for ".2" and "1" for "1e0".
+func formatBucketBound(bound float64) clientmodel.LabelValue {
+	if math.IsInf(bound, +1) {
+		return "+Inf"
+	}
+	return clientmodel.LabelValue(strconv.FormatFloat(bound, 'g', -1, 64))
+}
+
 type metricWithBuckets struct {
 	metric  clientmodel.COWMetric
 	buckets buckets
 }
 
+// interpolation is the scheme by which quantile values are interpolated
+// within a bucket.
+type interpolation int
+
+const (
+	// linearInterpolation assumes a uniform distribution within a bucket.
+	linearInterpolation interpolation = iota
+	// exponentialInterpolation assumes observations within a bucket to be
+	// distributed uniformly on a logarithmic scale, which suits
+	// exponentially spaced buckets better.
+	exponentialInterpolation
+)
+
+var interpolations = map[string]interpolation{
+	"linear":      linearInterpolation,
+	"exponential": exponentialInterpolation,
+}
+
+// parseInterpolation returns the interpolation scheme with the given name.
+func parseInterpolation(name string) (interpolation, error) {
+	ip, ok := interpolations[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown interpolation %q, must be \"linear\" or \"exponential\"", name)
+	}
+	return ip, nil
+}
+
 // quantile calculates the quantile 'q' based on the given buckets. The buckets
 // will be sorted by upperBound by this function (i.e. no sorting needed before
-// calling this function). The quantile value is interpolated assuming a linear
-// distribution within a bucket. However, if the quantile falls into the highest
-// bucket, the upper bound of the 2nd highest bucket is returned. A natural
-// lower bound of 0 is assumed if the upper bound of the lowest bucket is
-// greater 0. In that case, interpolation in the lowest bucket happens linearly
-// between 0 and the upper bound of the lowest bucket. However, if the lowest
-// bucket has an upper bound less or equal 0, this upper bound is returned if
-// the quantile falls into the lowest bucket.
+// calling this function). The quantile value is interpolated within a bucket
+// according to the given interpolation scheme. Exponential interpolation is
+// only possible between positive bounds, so linear interpolation is used in
+// buckets with a lower bound less or equal 0. However, if the quantile falls
+// into the highest bucket, the upper bound of the 2nd highest bucket is
+// returned. A natural lower bound of 0 is assumed if the upper bound of the
+// lowest bucket is greater 0. In that case, interpolation in the lowest bucket
+// happens linearly between 0 and the upper bound of the lowest bucket.
+// However, if the lowest bucket has an upper bound less or equal 0, this upper
+// bound is returned if the quantile falls into the lowest bucket.
 //
 // There are a number of special cases (once we have a way to report errors
 // happening during evaluations of AST functions, we should report those
@@ -63,7 +117,7 @@ type metricWithBuckets struct {
 // If q<0, -Inf is returned.
 //
 // If q>1, +Inf is returned.
-func quantile(q clientmodel.SampleValue, buckets buckets) float64 {
+func quantile(q clientmodel.SampleValue, buckets buckets, ip interpolation) float64 {
 	if q < 0 {
 		return math.Inf(-1)
 	}
@@ -97,6 +151,9 @@ func quantile(q clientmodel.SampleValue, buckets buckets) float64 {
 		count -= buckets[b-1].count
 		rank -= buckets[b-1].count
 	}
+	if ip == exponentialInterpolation && bucketStart > 0 {
+		return bucketStart * math.Pow(bucketEnd/bucketStart, float64(rank/count))
+	}
 	return bucketStart + (bucketEnd-bucketStart)*float64(rank/count)
 }
 
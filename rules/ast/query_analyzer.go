@@ -73,7 +73,8 @@ func (analyzer *queryAnalyzer) visit(node Node) {
 	switch n := node.(type) {
 	case *VectorSelector:
 		pt := analyzer.getPreloadTimes(n.offset)
-		fingerprints := analyzer.storage.GetFingerprintsForLabelMatchers(n.labelMatchers)
+		_, localMatchers := splitSourceMatchers(n.labelMatchers)
+		fingerprints := analyzer.storage.GetFingerprintsForLabelMatchers(localMatchers)
 		n.fingerprints = fingerprints
 		for _, fp := range fingerprints {
 			// Only add the fingerprint to the instants if not yet present in the
@@ -87,7 +88,8 @@ func (analyzer *queryAnalyzer) visit(node Node) {
 		}
 	case *MatrixSelector:
 		pt := analyzer.getPreloadTimes(n.offset)
-		fingerprints := analyzer.storage.GetFingerprintsForLabelMatchers(n.labelMatchers)
+		_, localMatchers := splitSourceMatchers(n.labelMatchers)
+		fingerprints := analyzer.storage.GetFingerprintsForLabelMatchers(localMatchers)
 		n.fingerprints = fingerprints
 		for _, fp := range fingerprints {
 			if pt.ranges[fp] < n.interval {
@@ -120,10 +122,16 @@ func (i *iteratorInitializer) visit(node Node) {
 	}
 }
 
-func prepareInstantQuery(node Node, timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (local.Preloader, error) {
+func prepareInstantQuery(node Node, timestamp clientmodel.Timestamp, timeout time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (local.Preloader, error) {
 	totalTimer := queryStats.GetTimer(stats.TotalEvalTime)
 
 	analyzeTimer := queryStats.GetTimer(stats.QueryAnalysisTime).Start()
+	fetcher := &remoteFetcher{sources: remoteSources(storage), timestamp: timestamp}
+	Walk(fetcher, node)
+	if fetcher.err != nil {
+		analyzeTimer.Stop()
+		return nil, fetcher.err
+	}
 	analyzer := newQueryAnalyzer(storage)
 	Walk(analyzer, node)
 	analyzeTimer.Stop()
@@ -133,7 +141,7 @@ func prepareInstantQuery(node Node, timestamp clientmodel.Timestamp, storage loc
 	for offset, pt := range analyzer.offsetPreloadTimes {
 		ts := timestamp.Add(-offset)
 		for fp, rangeDuration := range pt.ranges {
-			if et := totalTimer.ElapsedTime(); et > *queryTimeout {
+			if et := totalTimer.ElapsedTime(); et > timeout {
 				preloadTimer.Stop()
 				p.Close()
 				return nil, queryTimeoutError{et}
@@ -145,7 +153,7 @@ func prepareInstantQuery(node Node, timestamp clientmodel.Timestamp, storage loc
 			}
 		}
 		for fp := range pt.instants {
-			if et := totalTimer.ElapsedTime(); et > *queryTimeout {
+			if et := totalTimer.ElapsedTime(); et > timeout {
 				preloadTimer.Stop()
 				p.Close()
 				return nil, queryTimeoutError{et}
@@ -167,10 +175,16 @@ func prepareInstantQuery(node Node, timestamp clientmodel.Timestamp, storage loc
 	return p, nil
 }
 
-func prepareRangeQuery(node Node, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (local.Preloader, error) {
+func prepareRangeQuery(node Node, start clientmodel.Timestamp, end clientmodel.Timestamp, interval time.Duration, timeout time.Duration, storage local.Storage, queryStats *stats.TimerGroup) (local.Preloader, error) {
 	totalTimer := queryStats.GetTimer(stats.TotalEvalTime)
 
 	analyzeTimer := queryStats.GetTimer(stats.QueryAnalysisTime).Start()
+	fetcher := &remoteFetcher{rangeQuery: true}
+	Walk(fetcher, node)
+	if fetcher.err != nil {
+		analyzeTimer.Stop()
+		return nil, fetcher.err
+	}
 	analyzer := newQueryAnalyzer(storage)
 	Walk(analyzer, node)
 	analyzeTimer.Stop()
@@ -181,7 +195,7 @@ func prepareRangeQuery(node Node, start clientmodel.Timestamp, end clientmodel.T
 		offsetStart := start.Add(-offset)
 		offsetEnd := end.Add(-offset)
 		for fp, rangeDuration := range pt.ranges {
-			if et := totalTimer.ElapsedTime(); et > *queryTimeout {
+			if et := totalTimer.ElapsedTime(); et > timeout {
 				preloadTimer.Stop()
 				p.Close()
 				return nil, queryTimeoutError{et}
@@ -206,7 +220,7 @@ func prepareRangeQuery(node Node, start clientmodel.Timestamp, end clientmodel.T
 			*/
 		}
 		for fp := range pt.instants {
-			if et := totalTimer.ElapsedTime(); et > *queryTimeout {
+			if et := totalTimer.ElapsedTime(); et > timeout {
 				preloadTimer.Stop()
 				p.Close()
 				return nil, queryTimeoutError{et}
@@ -0,0 +1,257 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/storage/metric"
+	"github.com/prometheus/prometheus/utility"
+)
+
+// SourceLabel is the pseudo label by which a vector selector requests series
+// from remote sources, e.g. up{__source__=~"eu-.*"}. Such a selector is
+// evaluated against the local storage as if the source matcher was absent,
+// and against each remote source whose name matches via the query API of the
+// source. The series of remote sources are merged into the local result with
+// the source label set to the name of their source.
+const SourceLabel clientmodel.LabelName = "__source__"
+
+var (
+	errRemoteSourceNotInstant = errors.New("remote sources can only be selected by instant vector selectors in instant queries")
+	errRemoteSourceOnly       = errors.New("vector selectors of remote sources need at least one matcher besides the source label")
+)
+
+// maxRemoteResponseSize limits the size of the response to a query of a
+// remote source in bytes.
+const maxRemoteResponseSize = 32 << 20
+
+// remoteSourceStorage is a storage whose queries can also select the series
+// of remote sources.
+type remoteSourceStorage struct {
+	local.Storage
+	sources []*RemoteSource
+}
+
+// WithRemoteSources returns a storage evaluating queries against the given
+// storage, whose vector selectors can also select the series of the given
+// remote sources by the source label. Against other storages, selectors of
+// remote sources only select local series.
+func WithRemoteSources(storage local.Storage, sources []*RemoteSource) local.Storage {
+	if len(sources) == 0 {
+		return storage
+	}
+	return &remoteSourceStorage{Storage: storage, sources: sources}
+}
+
+// remoteSources returns the remote sources selectable in queries against the
+// given storage.
+func remoteSources(storage local.Storage) []*RemoteSource {
+	if s, ok := storage.(*remoteSourceStorage); ok {
+		return s.sources
+	}
+	return nil
+}
+
+// A RemoteSource is another Prometheus server vector selectors can be
+// evaluated against.
+type RemoteSource struct {
+	name   string
+	url    string
+	client *http.Client
+	// The maximum size of a query response in bytes.
+	maxResponseSize int64
+}
+
+// NewRemoteSource returns a RemoteSource of the given name for the Prometheus
+// server at the given URL, e.g. "http://prometheus.eu.example.org:9090".
+// Queries against it time out after the given timeout.
+func NewRemoteSource(name, url string, timeout time.Duration) *RemoteSource {
+	return &RemoteSource{
+		name:            name,
+		url:             strings.TrimRight(url, "/"),
+		client:          utility.NewDeadlineClient(timeout, nil),
+		maxResponseSize: maxRemoteResponseSize,
+	}
+}
+
+// Name returns the name of the remote source.
+func (s *RemoteSource) Name() string {
+	return s.name
+}
+
+// query evaluates the expression at the given time via the v1 query API of
+// the remote source.
+func (s *RemoteSource) query(expr string, timestamp clientmodel.Timestamp) (Vector, error) {
+	u := fmt.Sprintf("%s/api/v1/query?%s", s.url, url.Values{
+		"query": {expr},
+		"time":  {timestamp.String()},
+	}.Encode())
+	resp, err := s.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric clientmodel.Metric `json:"metric"`
+				Value  [2]interface{}     `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	body := &io.LimitedReader{R: resp.Body, N: s.maxResponseSize}
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		if body.N <= 0 {
+			return nil, fmt.Errorf("response exceeds %d bytes", s.maxResponseSize)
+		}
+		return nil, fmt.Errorf("error decoding response with HTTP status %s: %s", resp.Status, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("query failed with HTTP status %s: %s", resp.Status, result.Error)
+	}
+	if result.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected result type %q", result.Data.ResultType)
+	}
+
+	vector := make(Vector, 0, len(result.Data.Result))
+	for _, r := range result.Data.Result {
+		str, ok := r.Value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid sample value %v", r.Value[1])
+		}
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample value %q", str)
+		}
+		m := r.Metric
+		if m == nil {
+			m = clientmodel.Metric{}
+		}
+		m[SourceLabel] = clientmodel.LabelValue(s.name)
+		vector = append(vector, &Sample{
+			Metric:    clientmodel.COWMetric{Metric: m},
+			Value:     clientmodel.SampleValue(value),
+			Timestamp: timestamp,
+		})
+	}
+	return vector, nil
+}
+
+// splitSourceMatchers returns the matchers on the source label and the other
+// matchers of the given label matchers.
+func splitSourceMatchers(matchers metric.LabelMatchers) (source, local metric.LabelMatchers) {
+	for _, m := range matchers {
+		if m.Name == SourceLabel {
+			source = append(source, m)
+		} else {
+			local = append(local, m)
+		}
+	}
+	return source, local
+}
+
+// matchingRemoteSources returns the sources whose names match all of the
+// given matchers on the source label.
+func matchingRemoteSources(sources []*RemoteSource, matchers metric.LabelMatchers) []*RemoteSource {
+	var matching []*RemoteSource
+	for _, s := range sources {
+		if matchers.Match(clientmodel.LabelSet{SourceLabel: clientmodel.LabelValue(s.name)}) {
+			matching = append(matching, s)
+		}
+	}
+	return matching
+}
+
+// remoteFetcher evaluates the vector selectors of a query that select remote
+// sources against those sources. The first error encountered is kept in err.
+type remoteFetcher struct {
+	sources   []*RemoteSource
+	timestamp clientmodel.Timestamp
+	// Whether the query is a range query, for which remote sources are not
+	// supported.
+	rangeQuery bool
+	err        error
+}
+
+func (f *remoteFetcher) visit(node Node) {
+	if f.err != nil {
+		return
+	}
+	switch n := node.(type) {
+	case *VectorSelector:
+		n.remoteSamples = nil
+		sourceMatchers, localMatchers := splitSourceMatchers(n.labelMatchers)
+		if len(sourceMatchers) == 0 {
+			return
+		}
+		if f.rangeQuery {
+			f.err = errRemoteSourceNotInstant
+			return
+		}
+		if len(localMatchers) == 0 {
+			f.err = errRemoteSourceOnly
+			return
+		}
+		expr := (&VectorSelector{labelMatchers: localMatchers}).String()
+		n.remoteSamples, f.err = fetchRemote(matchingRemoteSources(f.sources, sourceMatchers), expr, f.timestamp.Add(-n.offset))
+	case *MatrixSelector:
+		if sourceMatchers, _ := splitSourceMatchers(n.labelMatchers); len(sourceMatchers) > 0 {
+			f.err = errRemoteSourceNotInstant
+		}
+	}
+}
+
+// fetchRemote evaluates expr against all given sources in parallel and
+// returns the concatenated results.
+func fetchRemote(sources []*RemoteSource, expr string, timestamp clientmodel.Timestamp) (Vector, error) {
+	var (
+		wg      sync.WaitGroup
+		vectors = make([]Vector, len(sources))
+		errs    = make([]error, len(sources))
+	)
+	for i, s := range sources {
+		wg.Add(1)
+		go func(i int, s *RemoteSource) {
+			defer wg.Done()
+			vectors[i], errs[i] = s.query(expr, timestamp)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var result Vector
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error querying remote source %s: %s", sources[i].name, err)
+		}
+		result = append(result, vectors[i]...)
+	}
+	return result, nil
+}
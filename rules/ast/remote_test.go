@@ -0,0 +1,46 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestRemoteSourceResponseSize(t *testing.T) {
+	response := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up"},"value":[0,"1"]}]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	s := NewRemoteSource("eu", server.URL, time.Second)
+	s.maxResponseSize = int64(len(response))
+	if v, err := s.query("up", clientmodel.Now()); err != nil || len(v) != 1 {
+		t.Fatalf("Expected 1 sample of a response within the limit, got %v and error %v", v, err)
+	}
+
+	s.maxResponseSize = int64(len(response)) - 1
+	_, err := s.query("up", clientmodel.Now())
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected error of a response exceeding the limit, got %v", err)
+	}
+}
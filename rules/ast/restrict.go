@@ -0,0 +1,44 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ast
+
+import (
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// RestrictSelectors adds the given matchers to all vector and matrix
+// selectors of the expression, so that it only selects series matching them.
+// It has to be called before the expression is evaluated.
+func RestrictSelectors(node Node, matchers ...*metric.LabelMatcher) {
+	Walk(&selectorRestricter{matchers: matchers}, node)
+}
+
+type selectorRestricter struct {
+	matchers metric.LabelMatchers
+}
+
+func (r *selectorRestricter) visit(node Node) {
+	switch n := node.(type) {
+	case *VectorSelector:
+		n.labelMatchers = r.restrict(n.labelMatchers)
+	case *MatrixSelector:
+		n.labelMatchers = r.restrict(n.labelMatchers)
+	}
+}
+
+func (r *selectorRestricter) restrict(matchers metric.LabelMatchers) metric.LabelMatchers {
+	res := make(metric.LabelMatchers, 0, len(matchers)+len(r.matchers))
+	res = append(res, matchers...)
+	return append(res, r.matchers...)
+}
@@ -74,6 +74,7 @@ func NewVectorAggregation(aggrTypeStr string, vector ast.Node, groupBy clientmod
 		"MIN":   ast.Min,
 		"AVG":   ast.Avg,
 		"COUNT": ast.Count,
+		"GROUP": ast.Group,
 	}
 	aggrType, ok := aggrTypes[aggrTypeStr]
 	if !ok {
@@ -2220,8 +2220,11 @@ yyrule21: // {D}+{U}
 yyrule22: // {L}({L}|{D})*
 	{
 		lval.str = lexer.token()
+		if lexer.isGroupAggregation(lval.str) {
+			lval.str = "GROUP"
+			return AGGR_OP
+		}
 		return IDENTIFIER
-		goto yystate0
 	}
 yyrule23: // {M}({M}|{D})*
 	{
@@ -15,17 +15,21 @@ package rules
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
-	"github.com/golang/glog"
-
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/storage/metric"
 )
 
+var logger = log.New("rules")
+
 // RulesLexer is the lexer for rule expressions.
 type RulesLexer struct {
 	// Errors encountered during parsing.
@@ -71,7 +75,7 @@ func (lexer *RulesLexer) getChar() byte {
 		}
 		lexer.current = b
 	} else if err != io.EOF {
-		glog.Fatal(err)
+		logger.Fatal(err)
 	}
 	return lexer.current
 }
@@ -80,6 +84,43 @@ func (lexer *RulesLexer) token() string {
 	return string(lexer.buf)
 }
 
+// peek returns the input character n positions after the current one without
+// consuming it, or 0 at the end of the input.
+func (lexer *RulesLexer) peek(n int) byte {
+	if n == 0 {
+		return lexer.current
+	}
+	b, err := lexer.src.Peek(n)
+	if err != nil {
+		return 0
+	}
+	return b[n-1]
+}
+
+// isGroupAggregation reports whether the identifier just lexed is the GROUP
+// aggregation operator. Unlike the other aggregation operators, GROUP is not
+// a reserved word, so that it remains usable as a label or metric name. It is
+// only taken as an operator if it is directly followed by its parenthesized
+// argument or by its BY clause.
+func (lexer *RulesLexer) isGroupAggregation(ident string) bool {
+	if ident != "group" && ident != "GROUP" {
+		return false
+	}
+	i := 0
+	for c := lexer.peek(i); c == ' ' || c == '\t' || c == '\r' || c == '\n'; c = lexer.peek(i) {
+		i++
+	}
+	if lexer.peek(i) == '(' {
+		return true
+	}
+	by := string([]byte{lexer.peek(i), lexer.peek(i + 1)})
+	if by != "by" && by != "BY" {
+		return false
+	}
+	c := lexer.peek(i + 2)
+	return !(c == '_' || c == ':' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z')
+}
+
 func newRulesLexer(src io.Reader, singleExpr bool) *RulesLexer {
 	lexer := &RulesLexer{
 		startToken: START_RULES,
@@ -127,12 +168,11 @@ func LoadRulesFromString(rulesString string) ([]Rule, error) {
 // LoadRulesFromFile parses rules from the file of the provided name and returns
 // them.
 func LoadRulesFromFile(fileName string) ([]Rule, error) {
-	rulesReader, err := os.Open(fileName)
+	b, err := config.ReadFile(fileName)
 	if err != nil {
 		return []Rule{}, err
 	}
-	defer rulesReader.Close()
-	return LoadRulesFromReader(rulesReader)
+	return LoadRulesFromReader(bytes.NewReader(b))
 }
 
 // LoadExprFromReader parses a single expression from the provided reader and
@@ -152,6 +192,20 @@ func LoadExprFromString(exprString string) (ast.Node, error) {
 	return LoadExprFromReader(exprReader)
 }
 
+// LoadSelectorFromString parses a series selector like `foo{bar="baz"}` or
+// `{job="foo"}` from the provided string and returns its label matchers.
+func LoadSelectorFromString(selector string) (metric.LabelMatchers, error) {
+	expr, err := LoadExprFromString(selector)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := expr.(*ast.VectorSelector)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a series selector", selector)
+	}
+	return vs.LabelMatchers(), nil
+}
+
 // LoadExprFromFile parses a single expression from the file of the provided
 // name and returns it as an AST node.
 func LoadExprFromFile(fileName string) (ast.Node, error) {
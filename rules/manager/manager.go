@@ -18,18 +18,22 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/notification"
 	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/stats"
 	"github.com/prometheus/prometheus/storage/local"
 	"github.com/prometheus/prometheus/templates"
+	"github.com/prometheus/prometheus/tracing"
 )
 
+var logger = log.New("rules")
+
 // Constants for instrumentation.
 const (
 	namespace = "prometheus"
@@ -39,6 +43,17 @@ const (
 	recordingRuleType = "recording"
 )
 
+// Metric names of the synthetic series recording the health of rule
+// evaluation and alert notification after each evaluation iteration. They
+// carry the external labels so that a meta-monitoring server federating them
+// can tell Prometheus servers apart.
+const (
+	evalDurationMetricName            clientmodel.LabelValue = "rule_evaluation_last_duration_seconds"
+	evalTimestampMetricName           clientmodel.LabelValue = "rule_evaluation_last_timestamp_seconds"
+	notificationQueueLengthMetricName clientmodel.LabelValue = "notification_queue_length"
+	notificationsDroppedMetricName    clientmodel.LabelValue = "notifications_dropped_total"
+)
+
 var (
 	evalDuration = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -74,6 +89,10 @@ func init() {
 type RuleManager interface {
 	// Load and add rules from rule files specified in the configuration.
 	AddRulesFromConfig(config config.Config) error
+	// Replace all rules by the rules from the rule files specified in the
+	// configuration. If loading any of the files fails, the current rules
+	// are kept.
+	ApplyConfig(config config.Config) error
 	// Start the rule manager's periodic rule evaluation.
 	Run()
 	// Stop the rule manager's rule evaluation cycles.
@@ -82,6 +101,11 @@ type RuleManager interface {
 	Rules() []rules.Rule
 	// Return all alerting rules.
 	AlertingRules() []*rules.AlertingRule
+	// Send the notification of a test alert of the given alerting rule
+	// with the given labels and value, and wait for it to be delivered to
+	// the alert manager. The notification is returned even if delivering it
+	// failed.
+	TestFire(rule *rules.AlertingRule, labels clientmodel.LabelSet, value clientmodel.SampleValue) (*notification.NotificationReq, error)
 }
 
 type ruleManager struct {
@@ -97,7 +121,8 @@ type ruleManager struct {
 	results             chan<- clientmodel.Samples
 	notificationHandler *notification.NotificationHandler
 
-	prometheusURL string
+	prometheusURL  string
+	externalLabels clientmodel.LabelSet
 }
 
 // RuleManagerOptions bundles options for the RuleManager.
@@ -109,6 +134,9 @@ type RuleManagerOptions struct {
 	Results             chan<- clientmodel.Samples
 
 	PrometheusURL string
+	// Labels attached to the synthetic series recording the health of rule
+	// evaluation and alert notification.
+	ExternalLabels clientmodel.LabelSet
 }
 
 // NewRuleManager returns an implementation of RuleManager, ready to be started
@@ -123,12 +151,13 @@ func NewRuleManager(o *RuleManagerOptions) RuleManager {
 		results:             o.Results,
 		notificationHandler: o.NotificationHandler,
 		prometheusURL:       o.PrometheusURL,
+		externalLabels:      o.ExternalLabels,
 	}
 	return manager
 }
 
 func (m *ruleManager) Run() {
-	defer glog.Info("Rule manager stopped.")
+	defer logger.Info("Rule manager stopped.")
 
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
@@ -146,7 +175,9 @@ func (m *ruleManager) Run() {
 			case <-ticker.C:
 				start := time.Now()
 				m.runIteration(m.results)
-				iterationDuration.Observe(float64(time.Since(start) / time.Millisecond))
+				duration := time.Since(start)
+				iterationDuration.Observe(float64(duration / time.Millisecond))
+				m.recordHealth(start, duration)
 			case <-m.done:
 				return
 			}
@@ -155,7 +186,7 @@ func (m *ruleManager) Run() {
 }
 
 func (m *ruleManager) Stop() {
-	glog.Info("Stopping rule manager...")
+	logger.Info("Stopping rule manager...")
 	m.done <- true
 }
 
@@ -171,46 +202,59 @@ func (m *ruleManager) queueAlertNotifications(rule *rules.AlertingRule, timestam
 			// BUG: In the future, make AlertManager support pending alerts?
 			continue
 		}
+		notifications = append(notifications, m.notificationReq(rule, aa, timestamp))
+	}
+	m.notificationHandler.SubmitReqs(notifications)
+}
 
-		// Provide the alert information to the template.
-		l := map[string]string{}
-		for k, v := range aa.Labels {
-			l[string(k)] = string(v)
-		}
-		tmplData := struct {
-			Labels map[string]string
-			Value  clientmodel.SampleValue
-		}{
-			Labels: l,
-			Value:  aa.Value,
-		}
-		// Inject some convenience variables that are easier to remember for users
-		// who are not used to Go's templating system.
-		defs := "{{$labels := .Labels}}{{$value := .Value}}"
+// notificationReq returns the notification of an alert of the given rule with
+// its summary and description expanded at the given time.
+func (m *ruleManager) notificationReq(rule *rules.AlertingRule, alert rules.Alert, timestamp clientmodel.Timestamp) *notification.NotificationReq {
+	// Provide the alert information to the template.
+	l := map[string]string{}
+	for k, v := range alert.Labels {
+		l[string(k)] = string(v)
+	}
+	tmplData := struct {
+		Labels map[string]string
+		Value  clientmodel.SampleValue
+	}{
+		Labels: l,
+		Value:  alert.Value,
+	}
+	// Inject some convenience variables that are easier to remember for users
+	// who are not used to Go's templating system.
+	defs := "{{$labels := .Labels}}{{$value := .Value}}"
 
-		expand := func(text string) string {
-			template := templates.NewTemplateExpander(defs+text, "__alert_"+rule.Name(), tmplData, timestamp, m.storage)
-			result, err := template.Expand()
-			if err != nil {
-				result = err.Error()
-				glog.Warningf("Error expanding alert template %v with data '%v': %v", rule.Name(), tmplData, err)
-			}
-			return result
+	expand := func(text string) string {
+		template := templates.NewTemplateExpander(defs+text, "__alert_"+rule.Name(), tmplData, timestamp, m.storage)
+		result, err := template.Expand()
+		if err != nil {
+			result = err.Error()
+			logger.Warnf("Error expanding alert template %v with data '%v': %v", rule.Name(), tmplData, err)
 		}
+		return result
+	}
 
-		notifications = append(notifications, &notification.NotificationReq{
-			Summary:     expand(rule.Summary),
-			Description: expand(rule.Description),
-			Labels: aa.Labels.Merge(clientmodel.LabelSet{
-				rules.AlertNameLabel: clientmodel.LabelValue(rule.Name()),
-			}),
-			Value:        aa.Value,
-			ActiveSince:  aa.ActiveSince.Time(),
-			RuleString:   rule.String(),
-			GeneratorURL: m.prometheusURL + rules.GraphLinkForExpression(rule.Vector.String()),
-		})
+	return &notification.NotificationReq{
+		Summary:     expand(rule.Summary),
+		Description: expand(rule.Description),
+		Labels: alert.Labels.Merge(clientmodel.LabelSet{
+			rules.AlertNameLabel: clientmodel.LabelValue(rule.Name()),
+		}),
+		Value:        alert.Value,
+		ActiveSince:  alert.ActiveSince.Time(),
+		RuleString:   rule.String(),
+		GeneratorURL: m.prometheusURL + rules.GraphLinkForExpression(rule.Vector.String()),
 	}
-	m.notificationHandler.SubmitReqs(notifications)
+}
+
+// TestFire implements RuleManager.
+func (m *ruleManager) TestFire(rule *rules.AlertingRule, labels clientmodel.LabelSet, value clientmodel.SampleValue) (*notification.NotificationReq, error) {
+	now := clientmodel.Now()
+	req := m.notificationReq(rule, rule.TestAlert(labels, value, now), now)
+	logger.Infof("Test-firing alert %s with labels %s.", rule.Name(), req.Labels)
+	return req, m.notificationHandler.SubmitReqsAndWait(notification.NotificationReqs{req})
 }
 
 func (m *ruleManager) runIteration(results chan<- clientmodel.Samples) {
@@ -228,9 +272,16 @@ func (m *ruleManager) runIteration(results chan<- clientmodel.Samples) {
 		go func(rule rules.Rule) {
 			defer wg.Done()
 
+			span := tracing.StartSpan("rule evaluation")
+			span.SetTag("rule", rule.Name())
+			queryStats := stats.NewTimerGroup()
+			queryStats.SetSpan(span)
+
 			start := time.Now()
-			vector, err := rule.Eval(now, m.storage)
+			vector, err := rule.Eval(now, m.storage, queryStats)
 			duration := time.Since(start)
+			span.SetError(err)
+			span.Finish()
 
 			samples := make(clientmodel.Samples, len(vector))
 			for i, s := range vector {
@@ -243,7 +294,7 @@ func (m *ruleManager) runIteration(results chan<- clientmodel.Samples) {
 
 			if err != nil {
 				evalFailures.Inc()
-				glog.Warningf("Error while evaluating rule %q: %s", rule, err)
+				logger.Warnf("Error while evaluating rule %q: %s", rule, err)
 			} else {
 				m.results <- samples
 			}
@@ -267,6 +318,37 @@ func (m *ruleManager) runIteration(results chan<- clientmodel.Samples) {
 	wg.Wait()
 }
 
+// recordHealth sends the synthetic series recording the health of rule
+// evaluation and alert notification to the results.
+func (m *ruleManager) recordHealth(start time.Time, duration time.Duration) {
+	values := []struct {
+		name  clientmodel.LabelValue
+		value clientmodel.SampleValue
+	}{
+		{evalDurationMetricName, clientmodel.SampleValue(duration.Seconds())},
+		{evalTimestampMetricName, clientmodel.SampleValue(float64(start.UnixNano()) / float64(time.Second))},
+		{notificationQueueLengthMetricName, clientmodel.SampleValue(m.notificationHandler.QueueLength())},
+		{notificationsDroppedMetricName, clientmodel.SampleValue(m.notificationHandler.DroppedNotifications())},
+	}
+
+	now := clientmodel.Now()
+	samples := make(clientmodel.Samples, 0, len(values))
+	for _, v := range values {
+		metric := clientmodel.Metric{}
+		for label, value := range m.externalLabels {
+			metric[label] = value
+		}
+		metric[clientmodel.MetricNameLabel] = v.name
+
+		samples = append(samples, &clientmodel.Sample{
+			Metric:    metric,
+			Timestamp: now,
+			Value:     v.value,
+		})
+	}
+	m.results <- samples
+}
+
 func (m *ruleManager) AddRulesFromConfig(config config.Config) error {
 	for _, ruleFile := range config.Global.RuleFile {
 		newRules, err := rules.LoadRulesFromFile(ruleFile)
@@ -277,9 +359,52 @@ func (m *ruleManager) AddRulesFromConfig(config config.Config) error {
 		m.rules = append(m.rules, newRules...)
 		m.Unlock()
 	}
+	return m.setRetentionTiers(m.Rules())
+}
+
+// ApplyConfig implements RuleManager. The state of alerting rules, i.e. their
+// pending and firing alerts, is not carried over to the new rules.
+func (m *ruleManager) ApplyConfig(config config.Config) error {
+	newRules := []rules.Rule{}
+	for _, ruleFile := range config.Global.RuleFile {
+		fileRules, err := rules.LoadRulesFromFile(ruleFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ruleFile, err)
+		}
+		newRules = append(newRules, fileRules...)
+	}
+	if err := m.setRetentionTiers(newRules); err != nil {
+		return err
+	}
+	m.Lock()
+	m.rules = newRules
+	m.Unlock()
 	return nil
 }
 
+// setRetentionTiers assigns the output metrics of recording rules declaring a
+// retention tier to that tier in the storage.
+func (m *ruleManager) setRetentionTiers(rs []rules.Rule) error {
+	tiers := map[clientmodel.LabelValue]string{}
+	for _, rule := range rs {
+		rr, ok := rule.(*rules.RecordingRule)
+		if !ok {
+			continue
+		}
+		name := clientmodel.LabelValue(rr.Name())
+		if tier, ok := tiers[name]; ok && tier != rr.RetentionTier() {
+			return fmt.Errorf("conflicting retention tiers %q and %q for metric %s", tier, rr.RetentionTier(), name)
+		}
+		tiers[name] = rr.RetentionTier()
+	}
+	for name, tier := range tiers {
+		if tier == "" {
+			delete(tiers, name)
+		}
+	}
+	return m.storage.SetMetricRetentionTiers(tiers)
+}
+
 func (m *ruleManager) Rules() []rules.Rule {
 	m.Lock()
 	defer m.Unlock()
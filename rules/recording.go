@@ -25,6 +25,14 @@ import (
 	"github.com/prometheus/prometheus/storage/local"
 )
 
+// RetentionTierLabel is the label by which a recording rule assigns its output
+// series to a retention tier of the storage, e.g.
+//
+//	job:requests:rate5m{__retention_tier__="long-term"} = ...
+//
+// The label is not attached to the output series.
+const RetentionTierLabel clientmodel.LabelName = "__retention_tier__"
+
 // A RecordingRule records its vector expression into new timeseries.
 type RecordingRule struct {
 	name      string
@@ -36,14 +44,23 @@ type RecordingRule struct {
 // Name returns the rule name.
 func (rule RecordingRule) Name() string { return rule.name }
 
+// Expr returns the vector expression of the rule.
+func (rule RecordingRule) Expr() ast.VectorNode { return rule.vector }
+
+// RetentionTier returns the retention tier of the output series of the rule,
+// or "" if they are retained for the default retention period.
+func (rule RecordingRule) RetentionTier() string {
+	return string(rule.labels[RetentionTierLabel])
+}
+
 // EvalRaw returns the raw value of the rule expression.
-func (rule RecordingRule) EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error) {
-	return ast.EvalVectorInstant(rule.vector, timestamp, storage, stats.NewTimerGroup())
+func (rule RecordingRule) EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	return ast.EvalVectorInstant(rule.vector, timestamp, storage, queryStats)
 }
 
 // Eval evaluates the rule and then overrides the metric names and labels accordingly.
-func (rule RecordingRule) Eval(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error) {
-	vector, err := rule.EvalRaw(timestamp, storage)
+func (rule RecordingRule) Eval(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	vector, err := rule.EvalRaw(timestamp, storage, queryStats)
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +69,9 @@ func (rule RecordingRule) Eval(timestamp clientmodel.Timestamp, storage local.St
 	for _, sample := range vector {
 		sample.Metric.Set(clientmodel.MetricNameLabel, clientmodel.LabelValue(rule.name))
 		for label, value := range rule.labels {
+			if label == RetentionTierLabel {
+				continue
+			}
 			if value == "" {
 				sample.Metric.Delete(label)
 			} else {
@@ -63,6 +83,12 @@ func (rule RecordingRule) Eval(timestamp clientmodel.Timestamp, storage local.St
 	return vector, nil
 }
 
+// Preview evaluates the rule like Eval.
+func (rule RecordingRule) Preview(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error) {
+	// Recording rules have no state.
+	return rule.Eval(timestamp, storage, queryStats)
+}
+
 // ToDotGraph returns the text representation of a dot graph.
 func (rule RecordingRule) ToDotGraph() string {
 	graph := fmt.Sprintf(
@@ -83,13 +109,13 @@ func (rule RecordingRule) String() string {
 }
 
 // HTMLSnippet returns an HTML snippet representing this rule.
-func (rule RecordingRule) HTMLSnippet() template.HTML {
+func (rule RecordingRule) HTMLSnippet(pathPrefix string) template.HTML {
 	ruleExpr := rule.vector.String()
 	return template.HTML(fmt.Sprintf(
 		`<a href="%s">%s</a>%s = <a href="%s">%s</a>`,
-		GraphLinkForExpression(rule.name),
+		pathPrefix+GraphLinkForExpression(rule.name),
 		rule.name,
 		rule.labels,
-		GraphLinkForExpression(ruleExpr),
+		pathPrefix+GraphLinkForExpression(ruleExpr),
 		ruleExpr))
 }
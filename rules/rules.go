@@ -19,7 +19,9 @@ import (
 	clientmodel "github.com/prometheus/client_golang/model"
 
 	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
 	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/storage/metric"
 )
 
 // A Rule encapsulates a vector expression which is evaluated at a specified
@@ -27,16 +29,44 @@ import (
 type Rule interface {
 	// Name returns the name of the rule.
 	Name() string
+	// Expr returns the vector expression of the rule.
+	Expr() ast.VectorNode
 	// EvalRaw evaluates the rule's vector expression without triggering any
-	// other actions, like recording or alerting.
-	EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error)
+	// other actions, like recording or alerting. The time spent is recorded
+	// in queryStats.
+	EvalRaw(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error)
 	// Eval evaluates the rule, including any associated recording or alerting actions.
-	Eval(timestamp clientmodel.Timestamp, storage local.Storage) (ast.Vector, error)
+	Eval(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error)
+	// Preview evaluates the rule like Eval, but without changing any state
+	// of the rule, so that its output can be inspected before the rule is
+	// loaded.
+	Preview(timestamp clientmodel.Timestamp, storage local.Storage, queryStats *stats.TimerGroup) (ast.Vector, error)
 	// ToDotGraph returns a Graphviz dot graph of the rule.
 	ToDotGraph() string
 	// String returns a human-readable string representation of the rule.
 	String() string
 	// HTMLSnippet returns a human-readable string representation of the rule,
-	// decorated with HTML elements for use the web frontend.
-	HTMLSnippet() template.HTML
+	// decorated with HTML elements for use the web frontend. Links are
+	// prefixed with the given path prefix of the web frontend.
+	HTMLSnippet(pathPrefix string) template.HTML
+}
+
+// RestrictedCopy returns a copy of the rule whose selectors only select series
+// matching the given matchers. The copy has none of the state of the rule, so
+// that it can be evaluated independently of it.
+func RestrictedCopy(rule Rule, matchers ...*metric.LabelMatcher) Rule {
+	vector := ast.Copy(rule.Expr()).(ast.VectorNode)
+	ast.RestrictSelectors(vector, matchers...)
+	switch r := rule.(type) {
+	case *AlertingRule:
+		return NewAlertingRule(r.name, vector, r.holdDuration, r.Labels, r.Summary, r.Description)
+	case *RecordingRule:
+		return &RecordingRule{
+			name:      r.name,
+			vector:    vector,
+			labels:    r.labels,
+			permanent: r.permanent,
+		}
+	}
+	panic("Switch didn't cover all rule types")
 }
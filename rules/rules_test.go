@@ -16,8 +16,12 @@ package rules
 import (
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"path"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -191,6 +195,18 @@ func TestExpressions(t *testing.T) {
 				`{job="api-server"} => 400 @[%v]`,
 				`{job="app-server"} => 800 @[%v]`,
 			},
+		}, {
+			expr: `group(http_requests) BY (job)`,
+			output: []string{
+				`{job="api-server"} => 1 @[%v]`,
+				`{job="app-server"} => 1 @[%v]`,
+			},
+		}, {
+			// GROUP remains usable as a label name.
+			expr: `GROUP BY (group) (http_requests{group="canary"})`,
+			output: []string{
+				`{group="canary"} => 1 @[%v]`,
+			},
 		}, {
 			expr: `SUM(http_requests) BY (job) - COUNT(http_requests) BY (job)`,
 			output: []string{
@@ -335,6 +351,30 @@ func TestExpressions(t *testing.T) {
 				`http_requests{group="canary", instance="0", job="app-server"} => 700 @[%v]`,
 			},
 			checkOrder: true,
+		}, {
+			expr: `count_scalar(limitk(3, http_requests))`,
+			output: []string{`scalar: 3 @[%v]`},
+		}, {
+			expr: `count_scalar(limitk(3, http_requests) and limitk(3, http_requests{job=~".+"}))`,
+			output: []string{`scalar: 3 @[%v]`},
+		}, {
+			expr: `limitk(10, http_requests{group="canary",job="app-server"})`,
+			output: []string{
+				`http_requests{group="canary", instance="0", job="app-server"} => 700 @[%v]`,
+				`http_requests{group="canary", instance="1", job="app-server"} => 800 @[%v]`,
+			},
+		}, {
+			expr:   `count_scalar(limitk(0, http_requests))`,
+			output: []string{`scalar: 0 @[%v]`},
+		}, {
+			expr:   `count_scalar(limit_ratio(1, http_requests))`,
+			output: []string{`scalar: 8 @[%v]`},
+		}, {
+			expr:   `count_scalar(limit_ratio(0, http_requests))`,
+			output: []string{`scalar: 0 @[%v]`},
+		}, {
+			expr:   `count_scalar(limit_ratio(0.5, http_requests) and limit_ratio(0.7, http_requests)) == count_scalar(limit_ratio(0.5, http_requests))`,
+			output: []string{`scalar: 1 @[%v]`},
 		}, {
 			expr: `bottomk(3, http_requests)`,
 			output: []string{
@@ -583,6 +623,71 @@ func TestExpressions(t *testing.T) {
 				`{group="production", instance="1", job="api-server"} => 1100 @[%v]`,
 			},
 		},
+		{
+			expr: `last_over_time(http_requests{group="production",job="api-server"}[1h])`,
+			output: []string{
+				`{group="production", instance="0", job="api-server"} => 100 @[%v]`,
+				`{group="production", instance="1", job="api-server"} => 200 @[%v]`,
+			},
+		},
+		{
+			expr: `last_over_time(http_requests{group="production",job="api-server"}[1h] offset 12m)`,
+			output: []string{
+				`{group="production", instance="0", job="api-server"} => 70 @[%v]`,
+				`{group="production", instance="1", job="api-server"} => 140 @[%v]`,
+			},
+		},
+		{
+			expr: `present_over_time(http_requests{group="production",job="api-server"}[1h])`,
+			output: []string{
+				`{group="production", instance="0", job="api-server"} => 1 @[%v]`,
+				`{group="production", instance="1", job="api-server"} => 1 @[%v]`,
+			},
+		},
+		{
+			expr: `count_scalar(present_over_time(http_requests[1m] offset 2m))`,
+			output: []string{
+				`scalar: 0 @[%v]`,
+			},
+		},
+		{
+			expr: `label_compare(http_requests{group="production"}, "job", "<", "app")`,
+			output: []string{
+				`http_requests{group="production", instance="0", job="api-server"} => 100 @[%v]`,
+				`http_requests{group="production", instance="1", job="api-server"} => 200 @[%v]`,
+			},
+		},
+		{
+			expr: `label_compare(http_requests{group="production", job="app-server"}, "instance", ">=", "1")`,
+			output: []string{
+				`http_requests{group="production", instance="1", job="app-server"} => 600 @[%v]`,
+			},
+		},
+		{
+			// Series without the label compare as if it was empty.
+			expr: `count_scalar(label_compare(http_requests, "version", "==", ""))`,
+			output: []string{
+				`scalar: 8 @[%v]`,
+			},
+		},
+		{
+			expr:       `label_compare(http_requests, "job", "=~", "api.*")`,
+			shouldFail: true,
+		},
+		{
+			expr: `sgn(http_requests{group="production",job="api-server"} - 150)`,
+			output: []string{
+				`{group="production", instance="0", job="api-server"} => -1 @[%v]`,
+				`{group="production", instance="1", job="api-server"} => 1 @[%v]`,
+			},
+		},
+		{
+			expr: `sgn(http_requests{group="production",job="api-server"} - 100)`,
+			output: []string{
+				`{group="production", instance="0", job="api-server"} => 0 @[%v]`,
+				`{group="production", instance="1", job="api-server"} => 1 @[%v]`,
+			},
+		},
 		{
 			expr:   `time()`,
 			output: []string{`scalar: 3000 @[%v]`},
@@ -696,6 +801,31 @@ func TestExpressions(t *testing.T) {
 				`{} => 1 @[%v]`,
 			},
 		},
+		{
+			expr: `absent_over_time(nonexistent{job="testjob", instance="testinstance", method=~".*"}[5m])`,
+			output: []string{
+				`{instance="testinstance", job="testjob"} => 1 @[%v]`,
+			},
+		},
+		{
+			expr: `count_scalar(absent_over_time(http_requests[5m]))`,
+			output: []string{
+				`scalar: 0 @[%v]`,
+			},
+		},
+		{
+			// Unlike absent(), no sample in the window counts as absent.
+			expr: `absent_over_time(http_requests{job="api-server"}[1m] offset 2m)`,
+			output: []string{
+				`{job="api-server"} => 1 @[%v]`,
+			},
+		},
+		{
+			expr: `count_scalar(absent(http_requests{job="api-server"} offset 2m))`,
+			output: []string{
+				`scalar: 0 @[%v]`,
+			},
+		},
 		{
 			expr: `http_requests{group="production",job="api-server"} offset 5m`,
 			output: []string{
@@ -726,6 +856,29 @@ func TestExpressions(t *testing.T) {
 				`{a="aa", b="bb"} => 100 @[%v]`,
 			},
 		},
+		// De-accumulated buckets with normalized bounds.
+		{
+			expr: `histogram_buckets(testhistogram_bucket)`,
+			output: []string{
+				`{le="0.1", start="positive"} => 50 @[%v]`,
+				`{le="0.2", start="positive"} => 20 @[%v]`,
+				`{le="1", start="positive"} => 40 @[%v]`,
+				`{le="+Inf", start="positive"} => 10 @[%v]`,
+				`{le="-0.2", start="negative"} => 10 @[%v]`,
+				`{le="-0.1", start="negative"} => 10 @[%v]`,
+				`{le="0.3", start="negative"} => 0 @[%v]`,
+				`{le="+Inf", start="negative"} => 10 @[%v]`,
+			},
+		},
+		{
+			expr: `histogram_buckets(rate(testhistogram_bucket{start="positive"}[5m]))`,
+			output: []string{
+				`{le="0.1", start="positive"} => 0.016666666666666666 @[%v]`,
+				`{le="0.2", start="positive"} => 0.006666666666666668 @[%v]`,
+				`{le="1", start="positive"} => 0.013333333333333332 @[%v]`,
+				`{le="+Inf", start="positive"} => 0.003333333333333334 @[%v]`,
+			},
+		},
 		// Quantile too low.
 		{
 			expr: `histogram_quantile(-0.1, testhistogram_bucket)`,
@@ -808,6 +961,40 @@ func TestExpressions(t *testing.T) {
 				`{start="negative"} => 0.3 @[%v]`,
 			},
 		},
+		// Exponential interpolation, which falls back to linear
+		// interpolation for lower bounds less or equal 0.
+		{
+			expr: `histogram_quantile(0.2, testhistogram_bucket, "exponential")`,
+			output: []string{
+				`{start="positive"} => 0.048 @[%v]`,
+				`{start="negative"} => -0.2 @[%v]`,
+			},
+		},
+		{
+			expr: `histogram_quantile(0.5, testhistogram_bucket, "exponential")`,
+			output: []string{
+				`{start="positive"} => 0.14142135623730953 @[%v]`,
+				`{start="negative"} => -0.15 @[%v]`,
+			},
+		},
+		{
+			expr: `histogram_quantile(0.8, rate(testhistogram_bucket[5m]), "exponential")`,
+			output: []string{
+				`{start="positive"} => 0.569325319425153 @[%v]`,
+				`{start="negative"} => 0.3 @[%v]`,
+			},
+		},
+		{
+			expr: `histogram_quantile(0.8, testhistogram_bucket, "linear")`,
+			output: []string{
+				`{start="positive"} => 0.72 @[%v]`,
+				`{start="negative"} => 0.3 @[%v]`,
+			},
+		},
+		{
+			expr:       `histogram_quantile(0.8, testhistogram_bucket, "cubic")`,
+			shouldFail: true,
+		},
 		// Aggregated histogram: Everything in one.
 		{
 			expr: `histogram_quantile(0.3, sum(rate(request_duration_seconds_bucket[5m])) by (le))`,
@@ -1206,6 +1393,89 @@ func TestRangedEvaluationRegressions(t *testing.T) {
 	}
 }
 
+func TestEvalInstant(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	scenarios := []struct {
+		expr string
+		typ  interface{}
+	}{
+		{expr: "1 + 2", typ: clientmodel.SampleValue(0)},
+		{expr: "http_requests", typ: ast.Vector{}},
+		{expr: "http_requests[5m]", typ: ast.Matrix{}},
+	}
+	for i, s := range scenarios {
+		expr, err := LoadExprFromString(s.expr)
+		if err != nil {
+			t.Fatalf("%d. Error parsing expression: %v", i, err)
+		}
+		got, err := ast.EvalInstant(expr, testEvalTime, 0, storage, stats.NewTimerGroup())
+		if err != nil {
+			t.Fatalf("%d. Error evaluating expression: %v", i, err)
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(s.typ) {
+			t.Errorf("%d. Expression %s: expected result of type %T, got %T", i, s.expr, s.typ, got)
+		}
+	}
+
+	expr, _ := LoadExprFromString("http_requests")
+	_, err := ast.EvalInstant(expr, testEvalTime, time.Nanosecond, storage, stats.NewTimerGroup())
+	if !ast.IsQueryTimeout(err) {
+		t.Errorf("Expected query timeout error, got %v", err)
+	}
+}
+
+func TestEvalVectorRangeFunc(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	expr, err := LoadExprFromString("http_requests")
+	if err != nil {
+		t.Fatalf("Error parsing expression: %v", err)
+	}
+	start, end := testEvalTime.Add(-2*time.Minute), testEvalTime
+	want, err := ast.EvalVectorRange(expr.(ast.VectorNode), start, end, time.Minute, storage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatalf("Error evaluating expression: %v", err)
+	}
+
+	var steps []clientmodel.Timestamp
+	numSamples := 0
+	err = ast.EvalVectorRangeFunc(expr.(ast.VectorNode), start, end, time.Minute, 0, storage, stats.NewTimerGroup(), func(ts clientmodel.Timestamp, vector ast.Vector) error {
+		steps = append(steps, ts)
+		numSamples += len(vector)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error evaluating expression: %v", err)
+	}
+	wantSteps := []clientmodel.Timestamp{start, start.Add(time.Minute), end}
+	if !reflect.DeepEqual(steps, wantSteps) {
+		t.Errorf("Expected steps %v, got %v", wantSteps, steps)
+	}
+	wantSamples := 0
+	for _, ss := range want {
+		wantSamples += len(ss.Values)
+	}
+	if numSamples != wantSamples {
+		t.Errorf("Expected %d samples, got %d", wantSamples, numSamples)
+	}
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = ast.EvalVectorRangeFunc(expr.(ast.VectorNode), start, end, time.Minute, 0, storage, stats.NewTimerGroup(), func(clientmodel.Timestamp, ast.Vector) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("Expected error %v, got %v", stop, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected evaluation to stop after 1 step, got %d", calls)
+	}
+}
+
 var ruleTests = []struct {
 	inputFile         string
 	shouldFail        bool
@@ -1234,6 +1504,124 @@ var ruleTests = []struct {
 	},
 }
 
+func TestRemoteSources(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"http_requests","job":"api-server","instance":"0","group":"production"},"value":[0,"42"]}]}}`)
+	}))
+	defer server.Close()
+
+	remoteStorage := ast.WithRemoteSources(storage, []*ast.RemoteSource{
+		ast.NewRemoteSource("eu", server.URL, time.Second),
+		ast.NewRemoteSource("us", server.URL, time.Second),
+	})
+
+	local, err := LoadExprFromString(`http_requests{job="api-server"}`)
+	if err != nil {
+		t.Fatalf("Error parsing expression: %v", err)
+	}
+	want, err := ast.EvalVectorInstant(local.(ast.VectorNode), testEvalTime, storage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatalf("Error evaluating expression: %v", err)
+	}
+
+	expr, err := LoadExprFromString(`http_requests{job="api-server",__source__="eu"}`)
+	if err != nil {
+		t.Fatalf("Error parsing expression: %v", err)
+	}
+	got, err := ast.EvalVectorInstant(expr.(ast.VectorNode), testEvalTime, remoteStorage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatalf("Error evaluating expression: %v", err)
+	}
+	if len(got) != len(want)+1 {
+		t.Fatalf("Expected %d samples, got %d: %v", len(want)+1, len(got), got)
+	}
+	remote := 0
+	for _, s := range got {
+		if s.Metric.Metric[ast.SourceLabel] == "" {
+			continue
+		}
+		remote++
+		if s.Metric.Metric[ast.SourceLabel] != "eu" || s.Value != 42 || s.Timestamp != testEvalTime {
+			t.Errorf("Unexpected remote sample %v", s)
+		}
+	}
+	if remote != 1 {
+		t.Errorf("Expected 1 remote sample, got %d", remote)
+	}
+	if len(queries) != 1 || queries[0] != `http_requests{job="api-server"}` {
+		t.Errorf("Unexpected remote queries %q", queries)
+	}
+
+	// Without remote sources, the selector only selects local series.
+	got, err = ast.EvalVectorInstant(expr.(ast.VectorNode), testEvalTime, storage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatalf("Error evaluating expression: %v", err)
+	}
+	if len(got) != len(want) || len(queries) != 1 {
+		t.Errorf("Expected %d local samples and no further remote queries, got %v and %q", len(want), got, queries)
+	}
+
+	if _, err := ast.EvalVectorRange(expr.(ast.VectorNode), testEvalTime.Add(-time.Minute), testEvalTime, time.Minute, remoteStorage, stats.NewTimerGroup()); err == nil {
+		t.Error("Expected error selecting remote sources in a range query")
+	}
+
+	sourceOnly, err := LoadExprFromString(`{__source__="eu"}`)
+	if err != nil {
+		t.Fatalf("Error parsing expression: %v", err)
+	}
+	if _, err := ast.EvalVectorInstant(sourceOnly.(ast.VectorNode), testEvalTime, remoteStorage, stats.NewTimerGroup()); err == nil {
+		t.Error("Expected error selecting remote sources without other matchers")
+	}
+}
+
+func TestLint(t *testing.T) {
+	types := map[clientmodel.LabelValue]string{
+		"memory_bytes":  "gauge",
+		"requests":      "counter",
+		"errors_total":  "gauge",
+		"handled_total": "",
+	}
+	opts := ast.LintOptions{
+		MetricType:     func(name clientmodel.LabelValue) string { return types[name] },
+		ScrapeInterval: time.Minute,
+	}
+
+	scenarios := []struct {
+		expr     string
+		warnings int
+	}{
+		{expr: `rate(requests[5m])`},
+		{expr: `rate(memory_bytes[5m])`, warnings: 1},
+		{expr: `deriv(memory_bytes[5m])`},
+		{expr: `rate(requests[1m])`, warnings: 1},
+		{expr: `sum(rate(requests[5m])) by (job)`},
+		{expr: `sum(requests) by (job)`, warnings: 1},
+		{expr: `avg(handled_total)`, warnings: 1},
+		{expr: `sum(errors_total)`},
+		{expr: `max(requests)`},
+		{expr: `sum(rate(requests[5m])) by (job) / sum(rate(handled_total[5m])) by (job)`},
+		{expr: `sum(rate(requests[5m])) by (job) / sum(rate(handled_total[5m])) by (instance)`, warnings: 1},
+		{expr: `sum(rate(requests[5m])) by (job) / memory_bytes`, warnings: 1},
+		{expr: `sum(rate(requests[5m])) by (job) / job:memory_bytes:sum`},
+		{expr: `sum(rate(requests[5m])) by (job) / 2`},
+	}
+	for i, s := range scenarios {
+		expr, err := LoadExprFromString(s.expr)
+		if err != nil {
+			t.Fatalf("%d. Error parsing expression %s: %v", i, s.expr, err)
+		}
+		if warnings := ast.Lint(expr, opts); len(warnings) != s.warnings {
+			t.Errorf("%d. Expected %d warnings for %s, got %v", i, s.warnings, s.expr, warnings)
+		}
+	}
+}
+
 func TestRules(t *testing.T) {
 	for i, ruleTest := range ruleTests {
 		testRules, err := LoadRulesFromFile(path.Join(fixturesPath, ruleTest.inputFile))
@@ -1313,7 +1701,7 @@ func TestAlertingRule(t *testing.T) {
 
 	for i, expected := range evalOutputs {
 		evalTime := testStartTime.Add(testSampleInterval * time.Duration(i))
-		actual, err := rule.Eval(evalTime, storage)
+		actual, err := rule.Eval(evalTime, storage, stats.NewTimerGroup())
 		if err != nil {
 			t.Fatalf("Error during alerting rule evaluation: %s", err)
 		}
@@ -1347,3 +1735,138 @@ func TestAlertingRule(t *testing.T) {
 		}
 	}
 }
+
+func TestAlertingRulePreview(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	alertExpr, err := LoadExprFromString(`http_requests{group="canary", job="app-server"} < 100`)
+	if err != nil {
+		t.Fatalf("Unable to parse alert expression: %s", err)
+	}
+	rule := NewAlertingRule("HttpRequestRateLow", alertExpr.(ast.VectorNode), time.Minute, clientmodel.LabelSet{"severity": "critical"}, "summary", "description")
+
+	expected := []string{
+		`ALERTS{alertname="HttpRequestRateLow", group="canary", instance="0", job="app-server", severity="critical"} => 70 @[%v]`,
+		`ALERTS{alertname="HttpRequestRateLow", group="canary", instance="1", job="app-server", severity="critical"} => 80 @[%v]`,
+	}
+	evalTime := testStartTime.Add(testSampleInterval)
+	actual, err := rule.Preview(evalTime, storage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatalf("Error during alerting rule preview: %s", err)
+	}
+	actualLines := strings.Split(actual.String(), "\n")
+	sort.Strings(actualLines)
+	expectedLines := annotateWithTime(expected, evalTime)
+	if !reflect.DeepEqual(actualLines, expectedLines) {
+		t.Fatalf("Expected and actual outputs don't match:\n%v", vectorComparisonString(expectedLines, actualLines))
+	}
+
+	if active := rule.ActiveAlerts(); len(active) != 0 {
+		t.Errorf("Preview created active alerts: %v", active)
+	}
+}
+
+func TestAlertingRuleTestAlert(t *testing.T) {
+	alertExpr, err := LoadExprFromString(`http_requests < 100`)
+	if err != nil {
+		t.Fatalf("Unable to parse alert expression: %s", err)
+	}
+	rule := NewAlertingRule("HttpRequestRateLow", alertExpr.(ast.VectorNode), time.Minute, clientmodel.LabelSet{"severity": "critical"}, "summary", "description")
+
+	alert := rule.TestAlert(clientmodel.LabelSet{
+		clientmodel.MetricNameLabel: "http_requests",
+		"instance":                  "0",
+		"severity":                  "low",
+	}, 42, testStartTime)
+	expected := Alert{
+		Name:        "HttpRequestRateLow",
+		Labels:      clientmodel.LabelSet{"instance": "0", "severity": "critical"},
+		State:       Firing,
+		ActiveSince: testStartTime,
+		Value:       42,
+	}
+	if !reflect.DeepEqual(alert, expected) {
+		t.Errorf("Expected test alert %v, got %v", expected, alert)
+	}
+	if active := rule.ActiveAlerts(); len(active) != 0 {
+		t.Errorf("Test alert created active alerts: %v", active)
+	}
+}
+
+func TestRecordingRuleRetentionTier(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	rs, err := LoadRulesFromString(`job:http_requests:sum{__retention_tier__="long-term", tier="x"} = sum by (job)(http_requests)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := rs[0].(*RecordingRule)
+	if got := rule.RetentionTier(); got != "long-term" {
+		t.Errorf("want retention tier %q, got %q", "long-term", got)
+	}
+	vector, err := rule.Eval(testStartTime, storage, stats.NewTimerGroup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vector) == 0 {
+		t.Fatal("expected samples from recording rule")
+	}
+	for _, s := range vector {
+		if _, ok := s.Metric.Metric[RetentionTierLabel]; ok {
+			t.Errorf("retention tier label attached to output series %v", s.Metric)
+		}
+		if s.Metric.Metric["tier"] != "x" {
+			t.Errorf("rule label missing from output series %v", s.Metric)
+		}
+	}
+}
+
+func TestRestrictSelectors(t *testing.T) {
+	storage, closer := newTestStorage(t)
+	defer closer.Close()
+
+	m, err := metric.NewLabelMatcher(metric.Equal, "group", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scenarios := []struct {
+		expr, restricted string
+	}{
+		{
+			expr:       `sum(http_requests) by (job)`,
+			restricted: `sum(http_requests{group="canary"}) by (job)`,
+		},
+		{
+			expr:       `sum(delta(http_requests[50m])) by (job) / count(http_requests) by (job)`,
+			restricted: `sum(delta(http_requests{group="canary"}[50m])) by (job) / count(http_requests{group="canary"}) by (job)`,
+		},
+	}
+	for i, s := range scenarios {
+		expr, err := LoadExprFromString(s.expr)
+		if err != nil {
+			t.Fatalf("%d. Error parsing expression: %v", i, err)
+		}
+		ast.RestrictSelectors(expr, m)
+		got, err := ast.EvalVectorInstant(expr.(ast.VectorNode), testEvalTime, storage, stats.NewTimerGroup())
+		if err != nil {
+			t.Fatalf("%d. Error evaluating expression: %v", i, err)
+		}
+
+		restricted, err := LoadExprFromString(s.restricted)
+		if err != nil {
+			t.Fatalf("%d. Error parsing expression: %v", i, err)
+		}
+		want, err := ast.EvalVectorInstant(restricted.(ast.VectorNode), testEvalTime, storage, stats.NewTimerGroup())
+		if err != nil {
+			t.Fatalf("%d. Error evaluating expression: %v", i, err)
+		}
+		gotLines, wantLines := strings.Split(got.String(), "\n"), strings.Split(want.String(), "\n")
+		sort.Strings(gotLines)
+		sort.Strings(wantLines)
+		if !reflect.DeepEqual(gotLines, wantLines) {
+			t.Errorf("%d. Expected %v, got %v", i, want, got)
+		}
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"sort"
 	"time"
+
+	"github.com/prometheus/prometheus/tracing"
 )
 
 // A Timer that can be started and stopped and accumulates the total time it
@@ -27,17 +29,38 @@ type Timer struct {
 	created  time.Time
 	start    time.Time
 	duration time.Duration
+
+	group *TimerGroup
+	// The span of the current run if the group is traced.
+	span *tracing.Span
 }
 
-// Start the timer.
+// Start the timer. If the TimerGroup of the timer is traced, the run is also
+// recorded as a span named after the timer.
 func (t *Timer) Start() *Timer {
 	t.start = time.Now()
+	if parent := t.group.runningSpan(); parent != nil {
+		t.span = parent.StartChild(t.name.String())
+		t.group.running = append(t.group.running, t)
+	}
 	return t
 }
 
 // Stop the timer.
 func (t *Timer) Stop() {
 	t.duration += time.Since(t.start)
+	if t.span == nil {
+		return
+	}
+	t.span.Finish()
+	t.span = nil
+	running := t.group.running
+	for i := len(running) - 1; i >= 0; i-- {
+		if running[i] == t {
+			t.group.running = append(running[:i], running[i+1:]...)
+			break
+		}
+	}
 }
 
 // ElapsedTime returns the time that passed since starting the timer.
@@ -54,6 +77,10 @@ func (t *Timer) String() string {
 type TimerGroup struct {
 	timers map[fmt.Stringer]*Timer
 	child  *TimerGroup
+
+	span *tracing.Span
+	// Timers with a span in the order they were started.
+	running []*Timer
 }
 
 // NewTimerGroup constructs a new TimerGroup.
@@ -69,11 +96,29 @@ func (t *TimerGroup) GetTimer(name fmt.Stringer) *Timer {
 	timer := &Timer{
 		name:    name,
 		created: time.Now(),
+		group:   t,
 	}
 	t.timers[name] = timer
 	return timer
 }
 
+// SetSpan traces the timers of the group. Timers started afterwards record
+// their runs as child spans of the span of the innermost running timer or, if
+// there is none, of the given span.
+func (t *TimerGroup) SetSpan(span *tracing.Span) {
+	t.span = span
+}
+
+func (t *TimerGroup) runningSpan() *tracing.Span {
+	if t == nil {
+		return nil
+	}
+	if n := len(t.running); n > 0 {
+		return t.running[n-1].span
+	}
+	return t.span
+}
+
 // Timers is a slice of Timer pointers that implements Len and Swap from
 // sort.Interface.
 type Timers []*Timer
@@ -35,6 +35,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -209,20 +210,18 @@ func (fp *Fingerprint) UnmarshalBinary(buf []byte) error {
 
 // FingerprintSet is a map[clientmodel.Fingerprint]struct{} that
 // implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler. Its
-// binary form is identical to that of Fingerprints.
+// binary form is identical to that of Fingerprints, with the fingerprints in
+// ascending order.
 type FingerprintSet map[clientmodel.Fingerprint]struct{}
 
 // MarshalBinary implements encoding.BinaryMarshaler.
 func (fps FingerprintSet) MarshalBinary() ([]byte, error) {
-	b := make([]byte, binary.MaxVarintLen64+len(fps)*8)
-	lenBytes := binary.PutVarint(b, int64(len(fps)))
-	offset := lenBytes
-
+	sorted := make(clientmodel.Fingerprints, 0, len(fps))
 	for fp := range fps {
-		binary.BigEndian.PutUint64(b[offset:], uint64(fp))
-		offset += 8
+		sorted = append(sorted, fp)
 	}
-	return b[:len(fps)*8+lenBytes], nil
+	sort.Sort(sorted)
+	return Fingerprints(sorted).MarshalBinary()
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
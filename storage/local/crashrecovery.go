@@ -20,8 +20,6 @@ import (
 	"path"
 	"strings"
 
-	"github.com/golang/glog"
-
 	clientmodel "github.com/prometheus/client_golang/model"
 
 	"github.com/prometheus/prometheus/storage/local/codable"
@@ -35,13 +33,13 @@ import (
 // queue as started by newPersistence).
 func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Fingerprint]*memorySeries) error {
 	// TODO(beorn): We need proper tests for the crash recovery.
-	glog.Warning("Starting crash recovery. Prometheus is inoperational until complete.")
+	logger.Warn("Starting crash recovery. Prometheus is inoperational until complete.")
 
 	fpsSeen := map[clientmodel.Fingerprint]struct{}{}
 	count := 0
 	seriesDirNameFmt := fmt.Sprintf("%%0%dx", seriesDirNameLen)
 
-	glog.Info("Scanning files.")
+	logger.Info("Scanning files.")
 	for i := 0; i < 1<<(seriesDirNameLen*4); i++ {
 		dirname := path.Join(p.basePath, fmt.Sprintf(seriesDirNameFmt, i))
 		dir, err := os.Open(dirname)
@@ -63,14 +61,14 @@ func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Finge
 				}
 				count++
 				if count%10000 == 0 {
-					glog.Infof("%d files scanned.", count)
+					logger.Infof("%d files scanned.", count)
 				}
 			}
 		}
 	}
-	glog.Infof("File scan complete. %d series found.", len(fpsSeen))
+	logger.Infof("File scan complete. %d series found.", len(fpsSeen))
 
-	glog.Info("Checking for series without series file.")
+	logger.Info("Checking for series without series file.")
 	for fp, s := range fingerprintToSeries {
 		if _, seen := fpsSeen[fp]; !seen {
 			// fp exists in fingerprintToSeries, but has no representation on disk.
@@ -83,7 +81,7 @@ func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Finge
 					// to unindex it, just in case it's in the indexes.
 					p.unindexMetric(fp, s.metric)
 				}
-				glog.Warningf("Lost series detected: fingerprint %v, metric %v.", fp, s.metric)
+				logger.Warnf("Lost series detected: fingerprint %v, metric %v.", fp, s.metric)
 				continue
 			}
 			// If we are here, the only chunk we have is the head chunk.
@@ -91,12 +89,12 @@ func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Finge
 			if len(s.chunkDescs) > 1 || s.chunkDescsOffset != 0 {
 				minLostChunks := len(s.chunkDescs) + s.chunkDescsOffset - 1
 				if minLostChunks <= 0 {
-					glog.Warningf(
+					logger.Warnf(
 						"Possible loss of chunks for fingerprint %v, metric %v.",
 						fp, s.metric,
 					)
 				} else {
-					glog.Warningf(
+					logger.Warnf(
 						"Lost at least %d chunks for fingerprint %v, metric %v.",
 						minLostChunks, fp, s.metric,
 					)
@@ -107,7 +105,7 @@ func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Finge
 			fpsSeen[fp] = struct{}{} // Add so that fpsSeen is complete.
 		}
 	}
-	glog.Info("Check for series without series file complete.")
+	logger.Info("Check for series without series file complete.")
 
 	if err := p.cleanUpArchiveIndexes(fingerprintToSeries, fpsSeen); err != nil {
 		return err
@@ -117,7 +115,7 @@ func (p *persistence) recoverFromCrash(fingerprintToSeries map[clientmodel.Finge
 	}
 
 	p.setDirty(false)
-	glog.Warning("Crash recovery complete.")
+	logger.Warn("Crash recovery complete.")
 	return nil
 }
 
@@ -153,9 +151,9 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 		var err error
 		defer func() {
 			if err != nil {
-				glog.Errorf("Failed to move lost series file %s to orphaned directory, deleting it instead. Error was: %s", filename, err)
+				logger.Errorf("Failed to move lost series file %s to orphaned directory, deleting it instead. Error was: %s", filename, err)
 				if err = os.Remove(filename); err != nil {
-					glog.Errorf("Even deleting file %s did not work: %s", filename, err)
+					logger.Errorf("Even deleting file %s did not work: %s", filename, err)
 				}
 			}
 		}()
@@ -171,12 +169,12 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 	var fp clientmodel.Fingerprint
 	if len(fi.Name()) != fpLen-seriesDirNameLen+len(seriesFileSuffix) ||
 		!strings.HasSuffix(fi.Name(), seriesFileSuffix) {
-		glog.Warningf("Unexpected series file name %s.", filename)
+		logger.Warnf("Unexpected series file name %s.", filename)
 		purge()
 		return fp, false
 	}
 	if err := fp.LoadFromString(path.Base(dirname) + fi.Name()[:fpLen-seriesDirNameLen]); err != nil {
-		glog.Warningf("Error parsing file name %s: %s", filename, err)
+		logger.Warnf("Error parsing file name %s: %s", filename, err)
 		purge()
 		return fp, false
 	}
@@ -184,24 +182,24 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 	bytesToTrim := fi.Size() % int64(p.chunkLen+chunkHeaderLen)
 	chunksInFile := int(fi.Size()) / (p.chunkLen + chunkHeaderLen)
 	if bytesToTrim != 0 {
-		glog.Warningf(
+		logger.Warnf(
 			"Truncating file %s to exactly %d chunks, trimming %d extraneous bytes.",
 			filename, chunksInFile, bytesToTrim,
 		)
 		f, err := os.OpenFile(filename, os.O_WRONLY, 0640)
 		if err != nil {
-			glog.Errorf("Could not open file %s: %s", filename, err)
+			logger.Errorf("Could not open file %s: %s", filename, err)
 			purge()
 			return fp, false
 		}
 		if err := f.Truncate(fi.Size() - bytesToTrim); err != nil {
-			glog.Errorf("Failed to truncate file %s: %s", filename, err)
+			logger.Errorf("Failed to truncate file %s: %s", filename, err)
 			purge()
 			return fp, false
 		}
 	}
 	if chunksInFile == 0 {
-		glog.Warningf("No chunks left in file %s.", filename)
+		logger.Warnf("No chunks left in file %s.", filename)
 		purge()
 		return fp, false
 	}
@@ -223,7 +221,7 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 			// in heads.db. Treat this series as a freshly
 			// unarchived one. No chunks or chunkDescs in memory, no
 			// current head chunk.
-			glog.Warningf(
+			logger.Warnf(
 				"Treating recovered metric %v, fingerprint %v, as freshly unarchived, with %d chunks in series file.",
 				s.metric, fp, chunksInFile,
 			)
@@ -243,7 +241,7 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 		// Load all the chunk descs (which assumes we have none from the future).
 		cds, err := p.loadChunkDescs(fp, clientmodel.Now())
 		if err != nil {
-			glog.Errorf(
+			logger.Errorf(
 				"Failed to load chunk descriptors for metric %v, fingerprint %v: %s",
 				s.metric, fp, err,
 			)
@@ -252,12 +250,12 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 		}
 		if cds[len(cds)-1].firstTime().Before(s.head().firstTime()) {
 			s.chunkDescs = append(cds, s.chunkDescs...)
-			glog.Warningf(
+			logger.Warnf(
 				"Recovered metric %v, fingerprint %v: recovered %d chunks from series file, recovered head chunk from checkpoint.",
 				s.metric, fp, chunksInFile,
 			)
 		} else {
-			glog.Warningf(
+			logger.Warnf(
 				"Recovered metric %v, fingerprint %v: head chunk found among the %d recovered chunks in series file.",
 				s.metric, fp, chunksInFile,
 			)
@@ -270,7 +268,7 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 	// This series is supposed to be archived.
 	metric, err := p.getArchivedMetric(fp)
 	if err != nil {
-		glog.Errorf(
+		logger.Errorf(
 			"Fingerprint %v assumed archived but couldn't be looked up in archived index: %s",
 			fp, err,
 		)
@@ -278,7 +276,7 @@ func (p *persistence) sanitizeSeries(dirname string, fi os.FileInfo, fingerprint
 		return fp, false
 	}
 	if metric == nil {
-		glog.Warningf(
+		logger.Warnf(
 			"Fingerprint %v assumed archived but couldn't be found in archived index.",
 			fp,
 		)
@@ -293,14 +291,14 @@ func (p *persistence) cleanUpArchiveIndexes(
 	fpToSeries map[clientmodel.Fingerprint]*memorySeries,
 	fpsSeen map[clientmodel.Fingerprint]struct{},
 ) error {
-	glog.Info("Cleaning up archive indexes.")
+	logger.Info("Cleaning up archive indexes.")
 	var fp codable.Fingerprint
 	var m codable.Metric
 	count := 0
 	if err := p.archivedFingerprintToMetrics.ForEach(func(kv index.KeyValueAccessor) error {
 		count++
 		if count%10000 == 0 {
-			glog.Infof("%d archived metrics checked.", count)
+			logger.Infof("%d archived metrics checked.", count)
 		}
 		if err := kv.Key(&fp); err != nil {
 			return err
@@ -312,10 +310,10 @@ func (p *persistence) cleanUpArchiveIndexes(
 		}
 		if !fpSeen || inMemory {
 			if inMemory {
-				glog.Warningf("Archive clean-up: Fingerprint %v is not archived. Purging from archive indexes.", clientmodel.Fingerprint(fp))
+				logger.Warnf("Archive clean-up: Fingerprint %v is not archived. Purging from archive indexes.", clientmodel.Fingerprint(fp))
 			}
 			if !fpSeen {
-				glog.Warningf("Archive clean-up: Fingerprint %v is unknown. Purging from archive indexes.", clientmodel.Fingerprint(fp))
+				logger.Warnf("Archive clean-up: Fingerprint %v is unknown. Purging from archive indexes.", clientmodel.Fingerprint(fp))
 			}
 			// It's fine if the fp is not in the archive indexes.
 			if _, err := p.archivedFingerprintToMetrics.Delete(fp); err != nil {
@@ -333,7 +331,7 @@ func (p *persistence) cleanUpArchiveIndexes(
 		if has {
 			return nil // All good.
 		}
-		glog.Warningf("Archive clean-up: Fingerprint %v is not in time-range index. Unarchiving it for recovery.")
+		logger.Warnf("Archive clean-up: Fingerprint %v is not in time-range index. Unarchiving it for recovery.", fp)
 		// Again, it's fine if fp is not in the archive index.
 		if _, err := p.archivedFingerprintToMetrics.Delete(fp); err != nil {
 			return err
@@ -357,7 +355,7 @@ func (p *persistence) cleanUpArchiveIndexes(
 	if err := p.archivedFingerprintToTimeRange.ForEach(func(kv index.KeyValueAccessor) error {
 		count++
 		if count%10000 == 0 {
-			glog.Infof("%d archived time ranges checked.", count)
+			logger.Infof("%d archived time ranges checked.", count)
 		}
 		if err := kv.Key(&fp); err != nil {
 			return err
@@ -369,19 +367,19 @@ func (p *persistence) cleanUpArchiveIndexes(
 		if has {
 			return nil // All good.
 		}
-		glog.Warningf("Archive clean-up: Purging unknown fingerprint %v in time-range index.", fp)
+		logger.Warnf("Archive clean-up: Purging unknown fingerprint %v in time-range index.", fp)
 		deleted, err := p.archivedFingerprintToTimeRange.Delete(fp)
 		if err != nil {
 			return err
 		}
 		if !deleted {
-			glog.Errorf("Fingerprint %s to be deleted from archivedFingerprintToTimeRange not found. This should never happen.", fp)
+			logger.Errorf("Fingerprint %v to be deleted from archivedFingerprintToTimeRange not found. This should never happen.", fp)
 		}
 		return nil
 	}); err != nil {
 		return err
 	}
-	glog.Info("Clean-up of archive indexes complete.")
+	logger.Info("Clean-up of archive indexes complete.")
 	return nil
 }
 
@@ -389,16 +387,16 @@ func (p *persistence) rebuildLabelIndexes(
 	fpToSeries map[clientmodel.Fingerprint]*memorySeries,
 ) error {
 	count := 0
-	glog.Info("Rebuilding label indexes.")
-	glog.Info("Indexing metrics in memory.")
+	logger.Info("Rebuilding label indexes.")
+	logger.Info("Indexing metrics in memory.")
 	for fp, s := range fpToSeries {
 		p.indexMetric(fp, s.metric)
 		count++
 		if count%10000 == 0 {
-			glog.Infof("%d metrics queued for indexing.", count)
+			logger.Infof("%d metrics queued for indexing.", count)
 		}
 	}
-	glog.Info("Indexing archived metrics.")
+	logger.Info("Indexing archived metrics.")
 	var fp codable.Fingerprint
 	var m codable.Metric
 	if err := p.archivedFingerprintToMetrics.ForEach(func(kv index.KeyValueAccessor) error {
@@ -411,12 +409,12 @@ func (p *persistence) rebuildLabelIndexes(
 		p.indexMetric(clientmodel.Fingerprint(fp), clientmodel.Metric(m))
 		count++
 		if count%10000 == 0 {
-			glog.Infof("%d metrics queued for indexing.", count)
+			logger.Infof("%d metrics queued for indexing.", count)
 		}
 		return nil
 	}); err != nil {
 		return err
 	}
-	glog.Info("All requests for rebuilding the label indexes queued. (Actual processing may lag behind.)")
+	logger.Info("All requests for rebuilding the label indexes queued. (Actual processing may lag behind.)")
 	return nil
 }
@@ -20,6 +20,7 @@ import (
 	"flag"
 	"os"
 	"path"
+	"sort"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
@@ -160,6 +161,22 @@ func (i *LabelNameLabelValuesIndex) LookupSet(l clientmodel.LabelName) (values m
 	return
 }
 
+// LabelNames returns all label names in the index, in no particular order.
+//
+// This method is goroutine-safe.
+func (i *LabelNameLabelValuesIndex) LabelNames() (clientmodel.LabelNames, error) {
+	var names clientmodel.LabelNames
+	var name codable.LabelName
+	err := i.ForEach(func(kv KeyValueAccessor) error {
+		if err := kv.Key(&name); err != nil {
+			return err
+		}
+		names = append(names, clientmodel.LabelName(name))
+		return nil
+	})
+	return names, err
+}
+
 // NewLabelNameLabelValuesIndex returns a LevelDB-backed
 // LabelNameLabelValuesIndex ready to use.
 func NewLabelNameLabelValuesIndex(basePath string) (*LabelNameLabelValuesIndex, error) {
@@ -213,11 +230,17 @@ func (i *LabelPairFingerprintIndex) IndexBatch(m LabelPairFingerprintsMapping) e
 
 // Lookup looks up all fingerprints for a given label pair.  Looking up a
 // non-existing label pair is not an error. In that case, (nil, false, nil) is
-// returned.
+// returned. The fingerprints are returned as a sorted postings list, see
+// IntersectPostings.
 //
 // This method is goroutine-safe.
 func (i *LabelPairFingerprintIndex) Lookup(p metric.LabelPair) (fps clientmodel.Fingerprints, ok bool, err error) {
 	ok, err = i.Get((codable.LabelPair)(p), (*codable.Fingerprints)(&fps))
+	// Fingerprints are stored sorted, but indexes written by earlier
+	// versions might contain them in any order.
+	if !sort.IsSorted(fps) {
+		sort.Sort(fps)
+	}
 	return
 }
 
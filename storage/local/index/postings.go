@@ -0,0 +1,123 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"sort"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// The length ratio from which on intersecting two postings lists searches the
+// longer list for each fingerprint of the shorter one instead of merging
+// them.
+const searchIntersectionRatio = 16
+
+// IntersectPostings returns the fingerprints contained in all of the given
+// postings lists. Postings lists are sorted slices of fingerprints without
+// duplicates, as returned by LabelPairFingerprintIndex.Lookup. The lists are
+// intersected shortest first, so that the cost of intersecting is bounded by
+// the length of the shortest list rather than of the longest. The result is
+// a postings list itself. No argument is modified.
+func IntersectPostings(lists ...clientmodel.Fingerprints) clientmodel.Fingerprints {
+	if len(lists) == 0 {
+		return nil
+	}
+	sorted := make([]clientmodel.Fingerprints, len(lists))
+	copy(sorted, lists)
+	sort.Sort(postingsByLength(sorted))
+
+	result := sorted[0]
+	for _, l := range sorted[1:] {
+		if len(result) == 0 {
+			break
+		}
+		result = intersectTwo(result, l)
+	}
+	return result
+}
+
+// intersectTwo intersects the postings lists a and b, where a is not longer
+// than b.
+func intersectTwo(a, b clientmodel.Fingerprints) clientmodel.Fingerprints {
+	result := make(clientmodel.Fingerprints, 0, len(a))
+	if len(b) >= searchIntersectionRatio*len(a) {
+		for _, fp := range a {
+			i := sort.Search(len(b), func(i int) bool { return b[i] >= fp })
+			if i == len(b) {
+				break
+			}
+			if b[i] == fp {
+				result = append(result, fp)
+			}
+			b = b[i:]
+		}
+		return result
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// MergePostings returns the fingerprints contained in any of the given
+// postings lists, as a postings list. No argument is modified.
+func MergePostings(lists ...clientmodel.Fingerprints) clientmodel.Fingerprints {
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		return lists[0]
+	}
+	// Merge pairwise so that each fingerprint takes part in a logarithmic
+	// number of merges.
+	half := len(lists) / 2
+	return mergeTwo(MergePostings(lists[:half]...), MergePostings(lists[half:]...))
+}
+
+func mergeTwo(a, b clientmodel.Fingerprints) clientmodel.Fingerprints {
+	result := make(clientmodel.Fingerprints, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+type postingsByLength []clientmodel.Fingerprints
+
+func (p postingsByLength) Len() int           { return len(p) }
+func (p postingsByLength) Less(i, j int) bool { return len(p[i]) < len(p[j]) }
+func (p postingsByLength) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
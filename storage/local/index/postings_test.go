@@ -0,0 +1,102 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func postingsRange(from, to, step int) clientmodel.Fingerprints {
+	var fps clientmodel.Fingerprints
+	for i := from; i < to; i += step {
+		fps = append(fps, clientmodel.Fingerprint(i))
+	}
+	return fps
+}
+
+func TestIntersectPostings(t *testing.T) {
+	var scenarios = []struct {
+		in   []clientmodel.Fingerprints
+		want clientmodel.Fingerprints
+	}{
+		{
+			in:   nil,
+			want: nil,
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 3, 5}},
+			want: clientmodel.Fingerprints{1, 3, 5},
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 2, 3, 4}, {2, 4, 6}},
+			want: clientmodel.Fingerprints{2, 4},
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 2, 3, 4}, {2, 4, 6}, {}},
+			want: clientmodel.Fingerprints{},
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 2}, {3, 4}},
+			want: clientmodel.Fingerprints{},
+		},
+		{
+			in:   []clientmodel.Fingerprints{postingsRange(0, 1000, 2), postingsRange(0, 1000, 3), postingsRange(0, 1000, 5)},
+			want: postingsRange(0, 1000, 30),
+		},
+		{
+			// Searching the longer list.
+			in:   []clientmodel.Fingerprints{postingsRange(0, 10000, 1), {5, 17, 9999, 10001}},
+			want: clientmodel.Fingerprints{5, 17, 9999},
+		},
+	}
+
+	for i, s := range scenarios {
+		if got := IntersectPostings(s.in...); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%d. expected %v, got %v", i, s.want, got)
+		}
+	}
+}
+
+func TestMergePostings(t *testing.T) {
+	var scenarios = []struct {
+		in   []clientmodel.Fingerprints
+		want clientmodel.Fingerprints
+	}{
+		{
+			in:   nil,
+			want: nil,
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 3, 5}},
+			want: clientmodel.Fingerprints{1, 3, 5},
+		},
+		{
+			in:   []clientmodel.Fingerprints{{1, 3, 5}, {}, {2, 3, 6}, {0, 7}},
+			want: clientmodel.Fingerprints{0, 1, 2, 3, 5, 6, 7},
+		},
+		{
+			in:   []clientmodel.Fingerprints{postingsRange(0, 100, 2), postingsRange(1, 100, 2)},
+			want: postingsRange(0, 100, 1),
+		},
+	}
+
+	for i, s := range scenarios {
+		if got := MergePostings(s.in...); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%d. expected %v, got %v", i, s.want, got)
+		}
+	}
+}
@@ -50,6 +50,8 @@ const (
 	subsystem = "local_storage"
 
 	opTypeLabel = "type"
+	stateLabel  = "state"
+	limitLabel  = "limit"
 
 	// Op-types for seriesOps.
 	create             = "create"
@@ -72,6 +74,10 @@ const (
 	// Op-types for chunkOps and chunkDescOps.
 	evict = "evict"
 	load  = "load"
+
+	// Checkpoint types.
+	fullCheckpoint        = "full"
+	incrementalCheckpoint = "incremental"
 )
 
 func init() {
@@ -42,8 +42,18 @@ type Storage interface {
 	GetFingerprintsForLabelMatchers(metric.LabelMatchers) clientmodel.Fingerprints
 	// Get all of the label values that are associated with a given label name.
 	GetLabelValuesForLabelName(clientmodel.LabelName) clientmodel.LabelValues
+	// Get all label names of all metrics.
+	GetLabelNames() clientmodel.LabelNames
 	// Get the metric associated with the provided fingerprint.
 	GetMetricForFingerprint(clientmodel.Fingerprint) clientmodel.COWMetric
+	// Get the interval between the first and the last sample of the series
+	// with the provided fingerprint, without loading any sample data. The
+	// boolean is false if there is no such series.
+	GetTimeRangeForFingerprint(clientmodel.Fingerprint) (metric.Interval, bool)
+	// Get the chunks and the last sample of the series with the provided
+	// fingerprint, without loading any sample data from disk. The boolean
+	// is false if there is no such series.
+	GetSeriesInfo(clientmodel.Fingerprint) (SeriesInfo, bool)
 	// Construct an iterator for a given fingerprint.
 	NewIterator(clientmodel.Fingerprint) SeriesIterator
 	// Run the various maintenance loops in goroutines. Returns when the
@@ -57,6 +67,75 @@ type Storage interface {
 	// indexed. Indexing is needed for GetFingerprintsForLabelMatchers and
 	// GetLabelValuesForLabelName and may lag behind.
 	WaitForIndexing()
+	// Stats returns a snapshot of the current state of the storage.
+	Stats() Stats
+	// SetMetricRetentionTiers assigns metrics by metric name to the named
+	// retention tiers the storage is configured with, replacing previous
+	// assignments. Samples of assigned metrics are retained for the
+	// retention period of their tier instead of the default one. An error is
+	// returned for unknown tiers, in which case nothing is changed.
+	SetMetricRetentionTiers(map[clientmodel.LabelValue]string) error
+}
+
+// Stats describes the state of a Storage at a point in time.
+type Stats struct {
+	NumSeries       int
+	NumMemChunks    int64
+	MaxMemChunks    int
+	RetentionPeriod time.Duration
+	// Dirty is true if the storage was started in or has become dirty,
+	// i.e. a crash recovery will be performed on the next start.
+	Dirty bool
+	// The time and duration of the last successful checkpoint of the
+	// series map and head chunks. LastCheckpoint is the zero time if no
+	// checkpoint was written since the start.
+	CheckpointInterval     time.Duration
+	LastCheckpoint         time.Time
+	LastCheckpointDuration time.Duration
+	// How well chunk persistence keeps up with ingestion.
+	PersistenceState PersistenceState
+}
+
+// SeriesInfo describes how a series is stored.
+type SeriesInfo struct {
+	// Whether the series is archived, i.e. has no chunks in memory. Only
+	// NumChunks is set for archived series.
+	Archived bool
+	// The number of chunks of the series in memory and on disk, and in
+	// memory only.
+	NumChunks, NumMemChunks int
+	// The time range of the head chunk, the one samples are appended to.
+	HeadChunk metric.Interval
+	// The last sample of the series. It is nil if the head chunk has been
+	// evicted from memory.
+	LastSample *metric.SamplePair
+}
+
+// PersistenceState describes how well chunk persistence keeps up with
+// ingestion.
+type PersistenceState int32
+
+// Possible PersistenceStates.
+const (
+	// Chunks are persisted as they come in.
+	PersistenceNormal PersistenceState = iota
+	// The persist queue is filling up. Ingestion should be reduced.
+	PersistenceRushed
+	// The persist queue is full, and ingestion is blocked until chunks
+	// have been persisted.
+	PersistenceThrottled
+)
+
+func (s PersistenceState) String() string {
+	switch s {
+	case PersistenceNormal:
+		return "normal"
+	case PersistenceRushed:
+		return "rushed"
+	case PersistenceThrottled:
+		return "throttled"
+	}
+	panic("unknown persistence state")
 }
 
 // SeriesIterator enables efficient access of sample values in a series. All
@@ -0,0 +1,111 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"sync"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// Names of the limits for the limitLabel of rejected samples.
+const (
+	labelNameLengthLimit  = "label_name_length"
+	labelValueLengthLimit = "label_value_length"
+	labelsPerSeriesLimit  = "labels_per_series"
+	seriesPerMetricLimit  = "series_per_metric"
+)
+
+// IngestionLimits protect the storage from pathological exporters. Samples of
+// series violating a limit are dropped. A limit of 0 means no limit.
+type IngestionLimits struct {
+	MaxLabelNameLength  int // In bytes.
+	MaxLabelValueLength int // In bytes.
+	MaxLabelsPerSeries  int // Including the metric name.
+	// The maximum number of series in memory with the same metric name.
+	// Concurrently created series may exceed it by a few.
+	MaxSeriesPerMetric int
+}
+
+// seriesLimiter enforces IngestionLimits before a series is created in
+// memory. It keeps track of the number of series in memory per metric name
+// if MaxSeriesPerMetric is set.
+type seriesLimiter struct {
+	limits IngestionLimits
+
+	mtx             sync.Mutex
+	seriesPerMetric map[clientmodel.LabelValue]int
+}
+
+func newSeriesLimiter(limits IngestionLimits, fpToSeries *seriesMap) *seriesLimiter {
+	l := &seriesLimiter{
+		limits:          limits,
+		seriesPerMetric: map[clientmodel.LabelValue]int{},
+	}
+	if limits.MaxSeriesPerMetric > 0 {
+		for pair := range fpToSeries.iter() {
+			l.seriesPerMetric[pair.series.metric[clientmodel.MetricNameLabel]]++
+		}
+	}
+	return l
+}
+
+// check returns the name of the first limit that creating a series for m
+// would violate, or "" if it violates none.
+func (l *seriesLimiter) check(m clientmodel.Metric) string {
+	if l.limits.MaxLabelsPerSeries > 0 && len(m) > l.limits.MaxLabelsPerSeries {
+		return labelsPerSeriesLimit
+	}
+	for name, value := range m {
+		if l.limits.MaxLabelNameLength > 0 && len(name) > l.limits.MaxLabelNameLength {
+			return labelNameLengthLimit
+		}
+		if l.limits.MaxLabelValueLength > 0 && len(value) > l.limits.MaxLabelValueLength {
+			return labelValueLengthLimit
+		}
+	}
+	if l.limits.MaxSeriesPerMetric > 0 {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if l.seriesPerMetric[m[clientmodel.MetricNameLabel]] >= l.limits.MaxSeriesPerMetric {
+			return seriesPerMetricLimit
+		}
+	}
+	return ""
+}
+
+// add records that a series for m has been created in memory.
+func (l *seriesLimiter) add(m clientmodel.Metric) {
+	if l.limits.MaxSeriesPerMetric == 0 {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.seriesPerMetric[m[clientmodel.MetricNameLabel]]++
+}
+
+// remove records that a series for m has been removed from memory.
+func (l *seriesLimiter) remove(m clientmodel.Metric) {
+	if l.limits.MaxSeriesPerMetric == 0 {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	name := m[clientmodel.MetricNameLabel]
+	if l.seriesPerMetric[name] <= 1 {
+		delete(l.seriesPerMetric, name)
+		return
+	}
+	l.seriesPerMetric[name]--
+}
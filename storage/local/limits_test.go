@@ -0,0 +1,76 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	fpToSeries := newSeriesMap()
+	existing := clientmodel.Metric{clientmodel.MetricNameLabel: "up", "instance": "a"}
+	fpToSeries.put(existing.Fingerprint(), newMemorySeries(existing, true, clientmodel.Earliest))
+
+	l := newSeriesLimiter(IngestionLimits{
+		MaxLabelNameLength:  8,
+		MaxLabelValueLength: 4,
+		MaxLabelsPerSeries:  3,
+		MaxSeriesPerMetric:  2,
+	}, fpToSeries)
+
+	var scenarios = []struct {
+		metric clientmodel.Metric
+		add    bool
+		want   string
+	}{
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "up", "instance": "b"},
+			add:    true,
+		},
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "up", "instance": "c"},
+			want:   seriesPerMetricLimit,
+		},
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "down", "instance": "c"},
+		},
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "down", "instancex": "c"},
+			want:   labelNameLengthLimit,
+		},
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "down", "instance": "abcde"},
+			want:   labelValueLengthLimit,
+		},
+		{
+			metric: clientmodel.Metric{clientmodel.MetricNameLabel: "down", "a": "1", "b": "2", "c": "3"},
+			want:   labelsPerSeriesLimit,
+		},
+	}
+	for i, s := range scenarios {
+		if got := l.check(s.metric); got != s.want {
+			t.Errorf("%d. expected limit %q, got %q", i, s.want, got)
+		}
+		if s.add {
+			l.add(s.metric)
+		}
+	}
+
+	l.remove(existing)
+	if got := l.check(clientmodel.Metric{clientmodel.MetricNameLabel: "up", "instance": "c"}); got != "" {
+		t.Errorf("expected no limit after removing a series, got %q", got)
+	}
+}
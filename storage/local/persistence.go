@@ -16,6 +16,7 @@ package local
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,7 +26,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -41,10 +41,11 @@ const (
 	seriesTempFileSuffix = ".db.tmp"
 	seriesDirNameLen     = 2 // How many bytes of the fingerprint in dir name.
 
-	headsFileName      = "heads.db"
-	headsTempFileName  = "heads.db.tmp"
-	headsFormatVersion = 1
-	headsMagicString   = "PrometheusHeads"
+	headsFileName          = "heads.db"
+	headsIncrementFileName = "heads.db.increment"
+	headsTempFileName      = "heads.db.tmp"
+	headsFormatVersion     = 1
+	headsMagicString       = "PrometheusHeads"
 
 	dirtyFileName = "DIRTY"
 
@@ -58,8 +59,15 @@ const (
 	indexingMaxBatchSize  = 1024 * 1024
 	indexingBatchTimeout  = 500 * time.Millisecond // Commit batch when idle for that long.
 	indexingQueueCapacity = 1024 * 16
+
+	// How often a long-running checkpoint logs its progress.
+	checkpointProgressInterval = 10 * time.Second
 )
 
+// errCheckpointTimeout is returned by checkpoints exceeding their maximum
+// duration.
+var errCheckpointTimeout = errors.New("checkpoint timed out")
+
 var fpLen = len(clientmodel.Fingerprint(0).String()) // Length of a fingerprint as string.
 
 const (
@@ -105,6 +113,18 @@ type persistence struct {
 	indexingBatchSizes    prometheus.Summary
 	indexingBatchLatency  prometheus.Summary
 	checkpointDuration    prometheus.Gauge
+	checkpointProgress    prometheus.Gauge
+	checkpointSize        *prometheus.GaugeVec
+	checkpointSeries      *prometheus.GaugeVec
+
+	// Only accessed by checkpointSeriesMapAndHeads.
+	maxCheckpointIncrements int  // Incremental checkpoints between full ones.
+	checkpointIncrements    int  // Incremental checkpoints since the last full one.
+	fullCheckpointNeeded    bool // Whether the next checkpoint has to be full.
+
+	checkpointMtx          sync.Mutex    // Protects the two fields below.
+	lastCheckpoint         time.Time     // End of the last successful checkpoint.
+	lastCheckpointDuration time.Duration // Duration of the last successful checkpoint.
 
 	dirtyMtx      sync.Mutex     // Protects dirty and becameDirty.
 	dirty         bool           // true if persistence was started in dirty state.
@@ -114,7 +134,9 @@ type persistence struct {
 }
 
 // newPersistence returns a newly allocated persistence backed by local disk storage, ready to use.
-func newPersistence(basePath string, chunkLen int, dirty bool) (*persistence, error) {
+// Up to maxCheckpointIncrements incremental checkpoints are written between
+// two full ones.
+func newPersistence(basePath string, chunkLen int, maxCheckpointIncrements int, dirty bool) (*persistence, error) {
 	if err := os.MkdirAll(basePath, 0700); err != nil {
 		return nil, err
 	}
@@ -122,7 +144,7 @@ func newPersistence(basePath string, chunkLen int, dirty bool) (*persistence, er
 
 	fLock, dirtyfileExisted, err := flock.New(dirtyPath)
 	if err != nil {
-		glog.Errorf("Could not lock %s, Prometheus already running?", dirtyPath)
+		logger.Errorf("Could not lock %s, Prometheus already running?", dirtyPath)
 		return nil, err
 	}
 	if dirtyfileExisted {
@@ -186,10 +208,40 @@ func newPersistence(basePath string, chunkLen int, dirty bool) (*persistence, er
 			Name:      "checkpoint_duration_milliseconds",
 			Help:      "The duration (in milliseconds) it took to checkpoint in-memory metrics and head chunks.",
 		}),
+		checkpointProgress: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "checkpoint_progress_ratio",
+			Help:      "The fraction of series written by the running checkpoint of in-memory metrics and head chunks. 1 if no checkpoint is running.",
+		}),
+		checkpointSize: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "checkpoint_last_size_bytes",
+				Help:      "The size of the last successful checkpoint of in-memory metrics and head chunks, by checkpoint type.",
+			},
+			[]string{opTypeLabel},
+		),
+		checkpointSeries: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "checkpoint_last_series",
+				Help:      "The number of series written by the last successful checkpoint of in-memory metrics and head chunks, by checkpoint type.",
+			},
+			[]string{opTypeLabel},
+		),
+		maxCheckpointIncrements: maxCheckpointIncrements,
+		// The changed flags of loaded series are unknown, so they
+		// are checkpointed in full once.
+		fullCheckpointNeeded: true,
+
 		dirty:         dirty,
 		dirtyFileName: dirtyPath,
 		fLock:         fLock,
 	}
+	p.checkpointProgress.Set(1)
 
 	if p.dirty {
 		// Blow away the label indexes. We'll rebuild them later.
@@ -222,6 +274,9 @@ func (p *persistence) Describe(ch chan<- *prometheus.Desc) {
 	p.indexingBatchSizes.Describe(ch)
 	p.indexingBatchLatency.Describe(ch)
 	ch <- p.checkpointDuration.Desc()
+	ch <- p.checkpointProgress.Desc()
+	p.checkpointSize.Describe(ch)
+	p.checkpointSeries.Describe(ch)
 }
 
 // Collect implements prometheus.Collector.
@@ -233,6 +288,9 @@ func (p *persistence) Collect(ch chan<- prometheus.Metric) {
 	p.indexingBatchSizes.Collect(ch)
 	p.indexingBatchLatency.Collect(ch)
 	ch <- p.checkpointDuration
+	ch <- p.checkpointProgress
+	p.checkpointSize.Collect(ch)
+	p.checkpointSeries.Collect(ch)
 }
 
 // isDirty returns the dirty flag in a goroutine-safe way.
@@ -242,6 +300,14 @@ func (p *persistence) isDirty() bool {
 	return p.dirty
 }
 
+// lastCheckpointStats returns the end time and the duration of the last
+// successful checkpoint in a goroutine-safe way.
+func (p *persistence) lastCheckpointStats() (time.Time, time.Duration) {
+	p.checkpointMtx.Lock()
+	defer p.checkpointMtx.Unlock()
+	return p.lastCheckpoint, p.lastCheckpointDuration
+}
+
 // setDirty sets the dirty flag in a goroutine-safe way. Once the dirty flag was
 // set to true with this method, it cannot be set to false again. (If we became
 // dirty during our runtime, there is no way back. If we were dirty from the
@@ -255,7 +321,7 @@ func (p *persistence) setDirty(dirty bool) {
 	p.dirty = dirty
 	if dirty {
 		p.becameDirty = true
-		glog.Error("The storage is now inconsistent. Restart Prometheus ASAP to initiate recovery.")
+		logger.Error("The storage is now inconsistent. Restart Prometheus ASAP to initiate recovery.")
 	}
 }
 
@@ -283,6 +349,12 @@ func (p *persistence) getLabelValuesForLabelName(ln clientmodel.LabelName) (clie
 	return lvs, nil
 }
 
+// getLabelNames returns all indexed label names. This method is goroutine-safe
+// with the same caveat as getLabelValuesForLabelName.
+func (p *persistence) getLabelNames() (clientmodel.LabelNames, error) {
+	return p.labelNameToLabelValues.LabelNames()
+}
+
 // persistChunks persists a number of consecutive chunks of a series. It is the
 // caller's responsibility to not modify the chunks concurrently and to not
 // persist or drop anything for the same fingerprint concurrently. It returns
@@ -364,6 +436,20 @@ func (p *persistence) loadChunks(fp clientmodel.Fingerprint, indexes []int, inde
 	return chunks, nil
 }
 
+// numPersistedChunks returns the number of chunks in the series file of the
+// given fingerprint. It is the caller's responsibility to not persist or drop
+// anything for the same fingerprint concurrently.
+func (p *persistence) numPersistedChunks(fp clientmodel.Fingerprint) (int, error) {
+	fi, err := os.Stat(p.fileNameForFingerprint(fp))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return p.chunkIndexForOffset(fi.Size())
+}
+
 // loadChunkDescs loads chunkDescs for a series up until a given time.  It is
 // the caller's responsibility to not persist or drop anything for the same
 // fingerprint concurrently.
@@ -422,6 +508,15 @@ func (p *persistence) loadChunkDescs(fp clientmodel.Fingerprint, beforeTime clie
 // and all open (non-full) head chunks. Do not call concurrently with
 // loadSeriesMapAndHeads.
 //
+// A full checkpoint writes all series to the heads file. An incremental
+// checkpoint only writes the series changed since the last full checkpoint,
+// and the fingerprints of the series removed since then, to the incremental
+// heads file. Loading applies the incremental heads file on top of the heads
+// file. A checkpoint is full if it is the first one of the persistence, if the
+// previous checkpoint failed, if maxCheckpointIncrements incremental
+// checkpoints were written since the last full one, or if the last
+// incremental checkpoint already contained most of the series.
+//
 // Description of the file format:
 //
 // (1) Magic string (const headsMagicString).
@@ -457,14 +552,43 @@ func (p *persistence) loadChunkDescs(fp clientmodel.Fingerprint, beforeTime clie
 //
 // (4.8.2) The head chunk itself, marshaled with the marshal() method.
 //
-func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap, fpLocker *fingerprintLocker) (err error) {
-	glog.Info("Checkpointing in-memory metrics and head chunks...")
+// (5) Only in incremental heads files: The varint-encoded number of series
+// removed since the last full checkpoint, followed by their fingerprints as
+// big-endian uint64.
+//
+// A timeout greater than 0 limits the duration of the checkpoint. Once it is
+// exceeded, the checkpoint is aborted with errCheckpointTimeout, leaving the
+// previous checkpoint in place.
+func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap, fpLocker *fingerprintLocker, timeout time.Duration) (err error) {
+	full := p.fullCheckpointNeeded || p.checkpointIncrements >= p.maxCheckpointIncrements
+	checkpointType, fileName := incrementalCheckpoint, p.headsIncrementFileName()
+	var removed clientmodel.Fingerprints
+	if full {
+		checkpointType, fileName = fullCheckpoint, p.headsFileName()
+		fingerprintToSeries.resetRemoved()
+		// The changed flags of the series are reset while writing, so
+		// only a successful full checkpoint is followed by an
+		// incremental one.
+		p.fullCheckpointNeeded = true
+	} else {
+		removed = fingerprintToSeries.removed()
+	}
+
+	logger.Infof("Checkpointing in-memory metrics and head chunks (%s)...", checkpointType)
 	begin := time.Now()
+	p.checkpointProgress.Set(0)
+	defer p.checkpointProgress.Set(1)
 	f, err := os.OpenFile(p.headsTempFileName(), os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0640)
 	if err != nil {
 		return
 	}
 
+	var (
+		numberOfSeriesTotal = uint64(fingerprintToSeries.length())
+		realNumberOfSeries  uint64
+		numberOfSeriesDone  uint64
+		lastProgressLog     = begin
+	)
 	defer func() {
 		closeErr := f.Close()
 		if err != nil {
@@ -474,10 +598,43 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 		if err != nil {
 			return
 		}
-		err = os.Rename(p.headsTempFileName(), p.headsFileName())
+		if full {
+			// Remove the incremental heads file first. Should we crash
+			// before the rename below, the previous full checkpoint
+			// is loaded without it, which is outdated but consistent.
+			if err = os.Remove(p.headsIncrementFileName()); os.IsNotExist(err) {
+				err = nil
+			}
+			if err != nil {
+				return
+			}
+		}
+		var size int64
+		if fi, statErr := os.Stat(p.headsTempFileName()); statErr == nil {
+			size = fi.Size()
+		}
+		err = os.Rename(p.headsTempFileName(), fileName)
 		duration := time.Since(begin)
 		p.checkpointDuration.Set(float64(duration) / float64(time.Millisecond))
-		glog.Infof("Done checkpointing in-memory metrics and head chunks in %v.", duration)
+		if err == nil {
+			if full {
+				p.fullCheckpointNeeded = false
+				p.checkpointIncrements = 0
+			} else {
+				p.checkpointIncrements++
+				// Once most series have changed since the last
+				// full checkpoint, incremental checkpoints don't
+				// save much anymore.
+				p.fullCheckpointNeeded = 2*realNumberOfSeries > numberOfSeriesTotal
+			}
+			p.checkpointSize.WithLabelValues(checkpointType).Set(float64(size))
+			p.checkpointSeries.WithLabelValues(checkpointType).Set(float64(realNumberOfSeries))
+			p.checkpointMtx.Lock()
+			p.lastCheckpoint = time.Now()
+			p.lastCheckpointDuration = duration
+			p.checkpointMtx.Unlock()
+		}
+		logger.Infof("Done checkpointing %d in-memory metrics and head chunks (%s) in %v.", realNumberOfSeries, checkpointType, duration)
 	}()
 
 	w := bufio.NewWriterSize(f, fileBufSize)
@@ -490,7 +647,10 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 		return
 	}
 	numberOfSeriesOffset += len(headsMagicString)
-	numberOfSeriesInHeader := uint64(fingerprintToSeries.length())
+	numberOfSeriesInHeader := numberOfSeriesTotal
+	if !full {
+		numberOfSeriesInHeader = 0
+	}
 	// We have to write the number of series as uint64 because we might need
 	// to overwrite it later, and a varint might change byte width then.
 	if err = codable.EncodeUint64(w, numberOfSeriesInHeader); err != nil {
@@ -504,8 +664,18 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 		}
 	}()
 
-	var realNumberOfSeries uint64
 	for m := range iter {
+		if timeout > 0 && time.Since(begin) > timeout {
+			logger.Warnf("Aborting checkpoint after %v with %d of about %d series written.", timeout, numberOfSeriesDone, numberOfSeriesTotal)
+			return errCheckpointTimeout
+		}
+		if numberOfSeriesDone++; numberOfSeriesTotal > 0 {
+			p.checkpointProgress.Set(float64(numberOfSeriesDone) / float64(numberOfSeriesTotal))
+		}
+		if time.Since(lastProgressLog) > checkpointProgressInterval {
+			logger.Infof("Checkpointed %d of about %d series...", numberOfSeriesDone, numberOfSeriesTotal)
+			lastProgressLog = time.Now()
+		}
 		func() { // Wrapped in function to use defer for unlocking the fp.
 			fpLocker.Lock(m.fp)
 			defer fpLocker.Unlock(m.fp)
@@ -514,6 +684,9 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 				// This series was completely purged or archived in the meantime. Ignore.
 				return
 			}
+			if !full && !m.series.changed {
+				return
+			}
 			realNumberOfSeries++
 			var seriesFlags byte
 			if m.series.headChunkPersisted {
@@ -558,11 +731,24 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 					}
 				}
 			}
+			if full {
+				m.series.changed = false
+			}
 		}()
 		if err != nil {
 			return
 		}
 	}
+	if !full {
+		if _, err = codable.EncodeVarint(w, int64(len(removed))); err != nil {
+			return
+		}
+		for _, fp := range removed {
+			if err = codable.EncodeUint64(w, uint64(fp)); err != nil {
+				return
+			}
+		}
+	}
 	if err = w.Flush(); err != nil {
 		return
 	}
@@ -580,19 +766,26 @@ func (p *persistence) checkpointSeriesMapAndHeads(fingerprintToSeries *seriesMap
 }
 
 // loadSeriesMapAndHeads loads the fingerprint to memory-series mapping and all
-// open (non-full) head chunks. If recoverable corruption is detected, or if the
-// dirty flag was set from the beginning, crash recovery is run, which might
-// take a while. If an unrecoverable error is encountered, it is returned. Call
-// this method during start-up while nothing else is running in storage
-// land. This method is utterly goroutine-unsafe.
+// open (non-full) head chunks from the last full checkpoint and the
+// incremental checkpoint written since, if any. If recoverable corruption is
+// detected, or if the dirty flag was set from the beginning, crash recovery is
+// run, which might take a while. If an unrecoverable error is encountered, it
+// is returned. Call this method during start-up while nothing else is running
+// in storage land. This method is utterly goroutine-unsafe.
 func (p *persistence) loadSeriesMapAndHeads() (sm *seriesMap, err error) {
 	var chunksTotal, chunkDescsTotal int64
 	fingerprintToSeries := make(map[clientmodel.Fingerprint]*memorySeries)
 	sm = &seriesMap{m: fingerprintToSeries}
 
 	defer func() {
+		for _, s := range fingerprintToSeries {
+			chunkDescsTotal += int64(len(s.chunkDescs))
+			if len(s.chunkDescs) > 0 && !s.headChunkPersisted {
+				chunksTotal++
+			}
+		}
 		if sm != nil && p.dirty {
-			glog.Warning("Persistence layer appears dirty.")
+			logger.Warn("Persistence layer appears dirty.")
 			err = p.recoverFromCrash(fingerprintToSeries)
 			if err != nil {
 				sm = nil
@@ -609,94 +802,153 @@ func (p *persistence) loadSeriesMapAndHeads() (sm *seriesMap, err error) {
 		return sm, nil
 	}
 	if err != nil {
-		glog.Warning("Could not open heads file:", err)
+		logger.Warn("Could not open heads file:", err)
 		p.dirty = true
 		return
 	}
 	defer f.Close()
+	if !p.loadHeads(bufio.NewReaderSize(f, fileBufSize), fingerprintToSeries) {
+		return sm, nil
+	}
+
+	f, err = os.Open(p.headsIncrementFileName())
+	if os.IsNotExist(err) {
+		return sm, nil
+	}
+	if err != nil {
+		logger.Warn("Could not open incremental heads file:", err)
+		p.dirty = true
+		return sm, nil
+	}
+	defer f.Close()
 	r := bufio.NewReaderSize(f, fileBufSize)
+	increment := make(map[clientmodel.Fingerprint]*memorySeries)
+	if !p.loadHeads(r, increment) {
+		return sm, nil
+	}
+	numRemoved, err := binary.ReadVarint(r)
+	if err != nil {
+		logger.Warn("Could not decode number of removed series:", err)
+		p.dirty = true
+		return sm, nil
+	}
+	// Series removed since the last full checkpoint are removed first, as
+	// they might have been added again afterwards.
+	for ; numRemoved > 0; numRemoved-- {
+		fp, err := codable.DecodeUint64(r)
+		if err != nil {
+			logger.Warn("Could not decode fingerprint of removed series:", err)
+			p.dirty = true
+			return sm, nil
+		}
+		discardLoadedSeries(fingerprintToSeries, clientmodel.Fingerprint(fp))
+	}
+	for fp, s := range increment {
+		discardLoadedSeries(fingerprintToSeries, fp)
+		fingerprintToSeries[fp] = s
+	}
+	return sm, nil
+}
+
+// discardLoadedSeries removes a series loaded by loadHeads from
+// fingerprintToSeries, accounting for its head chunk no longer being in
+// memory.
+func discardLoadedSeries(fingerprintToSeries map[clientmodel.Fingerprint]*memorySeries, fp clientmodel.Fingerprint) {
+	s, ok := fingerprintToSeries[fp]
+	if !ok {
+		return
+	}
+	if len(s.chunkDescs) > 0 && !s.headChunkPersisted {
+		atomic.AddInt64(&numMemChunks, -1)
+		numMemChunkDescs.Dec()
+	}
+	delete(fingerprintToSeries, fp)
+}
 
+// loadHeads reads the series of a heads file written by
+// checkpointSeriesMapAndHeads from r into fingerprintToSeries. If the file is
+// corrupted, the persistence is marked dirty, and false is returned.
+func (p *persistence) loadHeads(r *bufio.Reader, fingerprintToSeries map[clientmodel.Fingerprint]*memorySeries) bool {
 	buf := make([]byte, len(headsMagicString))
 	if _, err := io.ReadFull(r, buf); err != nil {
-		glog.Warning("Could not read from heads file:", err)
+		logger.Warn("Could not read from heads file:", err)
 		p.dirty = true
-		return sm, nil
+		return false
 	}
 	magic := string(buf)
 	if magic != headsMagicString {
-		glog.Warningf(
+		logger.Warnf(
 			"unexpected magic string, want %q, got %q",
 			headsMagicString, magic,
 		)
 		p.dirty = true
-		return
+		return false
 	}
 	if version, err := binary.ReadVarint(r); version != headsFormatVersion || err != nil {
-		glog.Warningf("unknown heads format version, want %d", headsFormatVersion)
+		logger.Warnf("unknown heads format version, want %d", headsFormatVersion)
 		p.dirty = true
-		return sm, nil
+		return false
 	}
 	numSeries, err := codable.DecodeUint64(r)
 	if err != nil {
-		glog.Warning("Could not decode number of series:", err)
+		logger.Warn("Could not decode number of series:", err)
 		p.dirty = true
-		return sm, nil
+		return false
 	}
 
 	for ; numSeries > 0; numSeries-- {
 		seriesFlags, err := r.ReadByte()
 		if err != nil {
-			glog.Warning("Could not read series flags:", err)
+			logger.Warn("Could not read series flags:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		headChunkPersisted := seriesFlags&flagHeadChunkPersisted != 0
 		fp, err := codable.DecodeUint64(r)
 		if err != nil {
-			glog.Warning("Could not decode fingerprint:", err)
+			logger.Warn("Could not decode fingerprint:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		var metric codable.Metric
 		if err := metric.UnmarshalFromReader(r); err != nil {
-			glog.Warning("Could not decode metric:", err)
+			logger.Warn("Could not decode metric:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		chunkDescsOffset, err := binary.ReadVarint(r)
 		if err != nil {
-			glog.Warning("Could not decode chunk descriptor offset:", err)
+			logger.Warn("Could not decode chunk descriptor offset:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		savedFirstTime, err := binary.ReadVarint(r)
 		if err != nil {
-			glog.Warning("Could not decode saved first time:", err)
+			logger.Warn("Could not decode saved first time:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		numChunkDescs, err := binary.ReadVarint(r)
 		if err != nil {
-			glog.Warning("Could not decode number of chunk descriptors:", err)
+			logger.Warn("Could not decode number of chunk descriptors:", err)
 			p.dirty = true
-			return sm, nil
+			return false
 		}
 		chunkDescs := make([]*chunkDesc, numChunkDescs)
-		chunkDescsTotal += numChunkDescs
 
 		for i := int64(0); i < numChunkDescs; i++ {
 			if headChunkPersisted || i < numChunkDescs-1 {
 				firstTime, err := binary.ReadVarint(r)
 				if err != nil {
-					glog.Warning("Could not decode first time:", err)
+					logger.Warn("Could not decode first time:", err)
 					p.dirty = true
-					return sm, nil
+					return false
 				}
 				lastTime, err := binary.ReadVarint(r)
 				if err != nil {
-					glog.Warning("Could not decode last time:", err)
+					logger.Warn("Could not decode last time:", err)
 					p.dirty = true
-					return sm, nil
+					return false
 				}
 				chunkDescs[i] = &chunkDesc{
 					chunkFirstTime: clientmodel.Timestamp(firstTime),
@@ -704,18 +956,17 @@ func (p *persistence) loadSeriesMapAndHeads() (sm *seriesMap, err error) {
 				}
 			} else {
 				// Non-persisted head chunk.
-				chunksTotal++
 				chunkType, err := r.ReadByte()
 				if err != nil {
-					glog.Warning("Could not decode chunk type:", err)
+					logger.Warn("Could not decode chunk type:", err)
 					p.dirty = true
-					return sm, nil
+					return false
 				}
 				chunk := chunkForType(chunkType)
 				if err := chunk.unmarshal(r); err != nil {
-					glog.Warning("Could not decode chunk type:", err)
+					logger.Warn("Could not decode chunk type:", err)
 					p.dirty = true
-					return sm, nil
+					return false
 				}
 				chunkDescs[i] = newChunkDesc(chunk)
 			}
@@ -729,7 +980,7 @@ func (p *persistence) loadSeriesMapAndHeads() (sm *seriesMap, err error) {
 			headChunkPersisted: headChunkPersisted,
 		}
 	}
-	return sm, nil
+	return true
 }
 
 // dropChunks deletes all chunks from a series whose last sample time is before
@@ -930,14 +1181,14 @@ func (p *persistence) purgeArchivedMetric(fp clientmodel.Fingerprint) (err error
 		return err
 	}
 	if !deleted {
-		glog.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToMetrics index. This should never happen.", fp)
+		logger.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToMetrics index. This should never happen.", fp)
 	}
 	deleted, err = p.archivedFingerprintToTimeRange.Delete(codable.Fingerprint(fp))
 	if err != nil {
 		return err
 	}
 	if !deleted {
-		glog.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToTimeRange index. This should never happen.", fp)
+		logger.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToTimeRange index. This should never happen.", fp)
 	}
 	p.unindexMetric(fp, metric)
 	return nil
@@ -967,14 +1218,14 @@ func (p *persistence) unarchiveMetric(fp clientmodel.Fingerprint) (
 		return false, firstTime, err
 	}
 	if !deleted {
-		glog.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToMetrics index. This should never happen.", fp)
+		logger.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToMetrics index. This should never happen.", fp)
 	}
 	deleted, err = p.archivedFingerprintToTimeRange.Delete(codable.Fingerprint(fp))
 	if err != nil {
 		return false, firstTime, err
 	}
 	if !deleted {
-		glog.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToTimeRange index. This should never happen.", fp)
+		logger.Errorf("Tried to delete non-archived fingerprint %s from archivedFingerprintToTimeRange index. This should never happen.", fp)
 	}
 	return true, firstTime, nil
 }
@@ -989,26 +1240,26 @@ func (p *persistence) close() error {
 	var lastError, dirtyFileRemoveError error
 	if err := p.archivedFingerprintToMetrics.Close(); err != nil {
 		lastError = err
-		glog.Error("Error closing archivedFingerprintToMetric index DB: ", err)
+		logger.Error("Error closing archivedFingerprintToMetric index DB: ", err)
 	}
 	if err := p.archivedFingerprintToTimeRange.Close(); err != nil {
 		lastError = err
-		glog.Error("Error closing archivedFingerprintToTimeRange index DB: ", err)
+		logger.Error("Error closing archivedFingerprintToTimeRange index DB: ", err)
 	}
 	if err := p.labelPairToFingerprints.Close(); err != nil {
 		lastError = err
-		glog.Error("Error closing labelPairToFingerprints index DB: ", err)
+		logger.Error("Error closing labelPairToFingerprints index DB: ", err)
 	}
 	if err := p.labelNameToLabelValues.Close(); err != nil {
 		lastError = err
-		glog.Error("Error closing labelNameToLabelValues index DB: ", err)
+		logger.Error("Error closing labelNameToLabelValues index DB: ", err)
 	}
 	if lastError == nil && !p.isDirty() {
 		dirtyFileRemoveError = os.Remove(p.dirtyFileName)
 	}
 	if err := p.fLock.Release(); err != nil {
 		lastError = err
-		glog.Error("Error releasing file lock: ", err)
+		logger.Error("Error releasing file lock: ", err)
 	}
 	if dirtyFileRemoveError != nil {
 		// On Windows, removing the dirty file before unlocking is not
@@ -1076,6 +1327,10 @@ func (p *persistence) headsFileName() string {
 	return path.Join(p.basePath, headsFileName)
 }
 
+func (p *persistence) headsIncrementFileName() string {
+	return path.Join(p.basePath, headsIncrementFileName)
+}
+
 func (p *persistence) headsTempFileName() string {
 	return path.Join(p.basePath, headsTempFileName)
 }
@@ -1094,10 +1349,10 @@ func (p *persistence) processIndexingQueue() {
 		}(time.Now())
 
 		if err := p.labelPairToFingerprints.IndexBatch(pairToFPs); err != nil {
-			glog.Error("Error indexing label pair to fingerprints batch: ", err)
+			logger.Error("Error indexing label pair to fingerprints batch: ", err)
 		}
 		if err := p.labelNameToLabelValues.IndexBatch(nameToValues); err != nil {
-			glog.Error("Error indexing label name to label values batch: ", err)
+			logger.Error("Error indexing label name to label values batch: ", err)
 		}
 		batchSize = 0
 		nameToValues = index.LabelNameLabelValuesMapping{}
@@ -1146,7 +1401,7 @@ loop:
 					var err error
 					baseFPs, _, err = p.labelPairToFingerprints.LookupSet(lp)
 					if err != nil {
-						glog.Errorf("Error looking up label pair %v: %s", lp, err)
+						logger.Errorf("Error looking up label pair %v: %s", lp, err)
 						continue
 					}
 					pairToFPs[lp] = baseFPs
@@ -1156,7 +1411,7 @@ loop:
 					var err error
 					baseValues, _, err = p.labelNameToLabelValues.LookupSet(ln)
 					if err != nil {
-						glog.Errorf("Error looking up label name %v: %s", ln, err)
+						logger.Errorf("Error looking up label name %v: %s", ln, err)
 						continue
 					}
 					nameToValues[ln] = baseValues
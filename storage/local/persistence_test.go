@@ -14,8 +14,10 @@
 package local
 
 import (
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
@@ -33,7 +35,7 @@ var (
 
 func newTestPersistence(t *testing.T) (*persistence, test.Closer) {
 	dir := test.NewTemporaryDirectory("test_persistence", t)
-	p, err := newPersistence(dir.Path(), 1024, false)
+	p, err := newPersistence(dir.Path(), 1024, 0, false)
 	if err != nil {
 		dir.Close()
 		t.Fatal(err)
@@ -199,9 +201,15 @@ func TestCheckpointAndLoadSeriesMapAndHeads(t *testing.T) {
 	sm.put(m2.Fingerprint(), s2)
 	sm.put(m3.Fingerprint(), s3)
 
-	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker); err != nil {
+	if last, _ := p.lastCheckpointStats(); !last.IsZero() {
+		t.Errorf("want no last checkpoint before checkpointing, got %v", last)
+	}
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
 		t.Fatal(err)
 	}
+	if last, _ := p.lastCheckpointStats(); last.IsZero() {
+		t.Error("want last checkpoint to be recorded")
+	}
 
 	loadedSM, err := p.loadSeriesMapAndHeads()
 	if err != nil {
@@ -244,6 +252,119 @@ func TestCheckpointAndLoadSeriesMapAndHeads(t *testing.T) {
 	}
 }
 
+func TestCheckpointTimeout(t *testing.T) {
+	p, closer := newTestPersistence(t)
+	defer closer.Close()
+
+	fpLocker := newFingerprintLocker(10)
+	sm := newSeriesMap()
+	s1 := newMemorySeries(m1, true, 0)
+	s1.add(m1.Fingerprint(), &metric.SamplePair{Timestamp: 1, Value: 3.14})
+	sm.put(m1.Fingerprint(), s1)
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
+		t.Fatal(err)
+	}
+	last, _ := p.lastCheckpointStats()
+
+	s2 := newMemorySeries(m2, true, 0)
+	s2.add(m2.Fingerprint(), &metric.SamplePair{Timestamp: 1, Value: 2.7})
+	sm.put(m2.Fingerprint(), s2)
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, time.Nanosecond); err != errCheckpointTimeout {
+		t.Fatalf("want %q, got %v", errCheckpointTimeout, err)
+	}
+	if l, _ := p.lastCheckpointStats(); l != last {
+		t.Errorf("want last checkpoint %v to be kept, got %v", last, l)
+	}
+
+	// The previous checkpoint is still in place.
+	loadedSM, err := p.loadSeriesMapAndHeads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedSM.length() != 1 {
+		t.Errorf("want 1 series in map, got %d", loadedSM.length())
+	}
+	if _, ok := loadedSM.get(m1.Fingerprint()); !ok {
+		t.Errorf("couldn't find %v in loaded map", m1)
+	}
+}
+
+func TestIncrementalCheckpoint(t *testing.T) {
+	dir := test.NewTemporaryDirectory("test_persistence", t)
+	defer dir.Close()
+	p, err := newPersistence(dir.Path(), 1024, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	fpLocker := newFingerprintLocker(10)
+	sm := newSeriesMap()
+	for _, m := range []clientmodel.Metric{m1, m2, m3} {
+		s := newMemorySeries(m, true, 0)
+		s.add(m.Fingerprint(), &metric.SamplePair{Timestamp: 1, Value: 1})
+		sm.put(m.Fingerprint(), s)
+	}
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.headsIncrementFileName()); !os.IsNotExist(err) {
+		t.Fatalf("want no incremental heads file after full checkpoint, got %v", err)
+	}
+
+	// Change m1, remove m2, and leave m3 alone.
+	s1, _ := sm.get(m1.Fingerprint())
+	s1.add(m1.Fingerprint(), &metric.SamplePair{Timestamp: 2, Value: 2})
+	sm.del(m2.Fingerprint())
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.headsIncrementFileName()); err != nil {
+		t.Fatalf("want incremental heads file, got %v", err)
+	}
+	if p.fullCheckpointNeeded {
+		t.Error("want next checkpoint to be incremental, too")
+	}
+
+	loadedSM, err := p.loadSeriesMapAndHeads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedSM.length() != 2 {
+		t.Errorf("want 2 series in map, got %d", loadedSM.length())
+	}
+	if _, ok := loadedSM.get(m2.Fingerprint()); ok {
+		t.Errorf("want removed series %v to stay removed", m2)
+	}
+	if _, ok := loadedSM.get(m3.Fingerprint()); !ok {
+		t.Errorf("couldn't find %v in loaded map", m3)
+	}
+	if loadedS1, ok := loadedSM.get(m1.Fingerprint()); ok {
+		if !reflect.DeepEqual(loadedS1.head().chunk, s1.head().chunk) {
+			t.Error("head chunks differ")
+		}
+	} else {
+		t.Errorf("couldn't find %v in loaded map", m1)
+	}
+
+	// After the maximum number of increments, the next checkpoint is full.
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.checkpointSeriesMapAndHeads(sm, fpLocker, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.headsIncrementFileName()); !os.IsNotExist(err) {
+		t.Fatalf("want no incremental heads file after full checkpoint, got %v", err)
+	}
+	if loadedSM, err = p.loadSeriesMapAndHeads(); err != nil {
+		t.Fatal(err)
+	}
+	if loadedSM.length() != 2 {
+		t.Errorf("want 2 series in map, got %d", loadedSM.length())
+	}
+}
+
 func TestGetFingerprintsModifiedBefore(t *testing.T) {
 	p, closer := newTestPersistence(t)
 	defer closer.Close()
@@ -604,6 +725,25 @@ func verifyIndexedState(i int, t *testing.T, b incrementalBatch, indexedFpsToMet
 		}
 	}
 
+	// Compare label names.
+	outLns, err := p.getLabelNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outLnSet := map[clientmodel.LabelName]struct{}{}
+	for _, ln := range outLns {
+		outLnSet[ln] = struct{}{}
+	}
+	lnSet := map[clientmodel.LabelName]struct{}{}
+	for ln, lvs := range b.expectedLnToLvs {
+		if len(lvs) > 0 {
+			lnSet[ln] = struct{}{}
+		}
+	}
+	if !reflect.DeepEqual(lnSet, outLnSet) {
+		t.Errorf("%d. label names don't match. Got: %v; want %v", i, outLnSet, lnSet)
+	}
+
 	// Compare label pair -> fingerprints mappings.
 	for lp, fps := range b.expectedLpToFps {
 		outFPs, err := p.getFingerprintsForLabelPair(lp)
@@ -42,6 +42,9 @@ type fingerprintSeriesPair struct {
 type seriesMap struct {
 	mtx sync.RWMutex
 	m   map[clientmodel.Fingerprint]*memorySeries
+	// The fingerprints removed since the last call of resetRemoved, as
+	// needed for incremental checkpoints.
+	removedFPs map[clientmodel.Fingerprint]struct{}
 }
 
 // newSeriesMap returns a newly allocated empty seriesMap. To create a seriesMap
@@ -85,6 +88,31 @@ func (sm *seriesMap) del(fp clientmodel.Fingerprint) {
 	defer sm.mtx.Unlock()
 
 	delete(sm.m, fp)
+	if sm.removedFPs == nil {
+		sm.removedFPs = map[clientmodel.Fingerprint]struct{}{}
+	}
+	sm.removedFPs[fp] = struct{}{}
+}
+
+// removed returns the fingerprints removed from the seriesMap since the last
+// call of resetRemoved. Some of them might have been added again since.
+func (sm *seriesMap) removed() clientmodel.Fingerprints {
+	sm.mtx.RLock()
+	defer sm.mtx.RUnlock()
+
+	fps := make(clientmodel.Fingerprints, 0, len(sm.removedFPs))
+	for fp := range sm.removedFPs {
+		fps = append(fps, fp)
+	}
+	return fps
+}
+
+// resetRemoved forgets the fingerprints removed so far.
+func (sm *seriesMap) resetRemoved() {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+
+	sm.removedFPs = nil
 }
 
 // iter returns a channel that produces all mappings in the seriesMap. The
@@ -158,6 +186,9 @@ type memorySeries struct {
 	// a non-persisted head chunk has to be cloned before more samples are
 	// appended.
 	headChunkUsedByIterator bool
+	// Whether the series changed since the last full checkpoint, so that it
+	// has to be written by incremental checkpoints.
+	changed bool
 }
 
 // newMemorySeries returns a pointer to a newly allocated memorySeries for the
@@ -174,6 +205,7 @@ func newMemorySeries(m clientmodel.Metric, reallyNew bool, firstTime clientmodel
 		metric:             m,
 		headChunkPersisted: !reallyNew,
 		savedFirstTime:     firstTime,
+		changed:            true,
 	}
 	if !reallyNew {
 		s.chunkDescsOffset = -1
@@ -185,6 +217,7 @@ func newMemorySeries(m clientmodel.Metric, reallyNew bool, firstTime clientmodel
 // It returns chunkDescs that must be queued to be persisted.
 // The caller must have locked the fingerprint of the series.
 func (s *memorySeries) add(fp clientmodel.Fingerprint, v *metric.SamplePair) []*chunkDesc {
+	s.changed = true
 	if len(s.chunkDescs) == 0 || s.headChunkPersisted {
 		newHead := newChunkDesc(newDeltaEncodedChunk(d1, d0, true))
 		s.chunkDescs = append(s.chunkDescs, newHead)
@@ -230,6 +263,7 @@ func (s *memorySeries) add(fp clientmodel.Fingerprint, v *metric.SamplePair) []*
 func (s *memorySeries) evictChunkDescs(iOldestNotEvicted int) {
 	lenToKeep := chunkDescEvictionFactor * (len(s.chunkDescs) - iOldestNotEvicted)
 	if lenToKeep < len(s.chunkDescs) {
+		s.changed = true
 		s.savedFirstTime = s.firstTime()
 		lenEvicted := len(s.chunkDescs) - lenToKeep
 		s.chunkDescsOffset += lenEvicted
@@ -255,6 +289,7 @@ func (s *memorySeries) dropChunks(t clientmodel.Timestamp) (int, bool) {
 		}
 	}
 	if keepIdx > 0 {
+		s.changed = true
 		s.chunkDescs = append(make([]*chunkDesc, 0, len(s.chunkDescs)-keepIdx), s.chunkDescs[keepIdx:]...)
 		numMemChunkDescs.Sub(float64(keepIdx))
 	}
@@ -347,6 +382,7 @@ func (s *memorySeries) preloadChunksForRange(
 		}
 		s.chunkDescs = append(cds, s.chunkDescs...)
 		s.chunkDescsOffset = 0
+		s.changed = true
 	}
 
 	if len(s.chunkDescs) == 0 {
@@ -16,18 +16,22 @@ package local
 
 import (
 	"container/list"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/log"
+	"github.com/prometheus/prometheus/storage/local/index"
 	"github.com/prometheus/prometheus/storage/metric"
 )
 
+var logger = log.New("storage")
+
 const (
 	evictRequestsCap = 1024
 	chunkLen         = 1024
@@ -40,6 +44,15 @@ const (
 	maxEvictInterval = time.Minute
 	headChunkTimeout = time.Hour // Close head chunk if not touched for that long.
 
+	// With auto-tuning, checkpoints are at least that many times their
+	// duration apart.
+	checkpointDurationFactor = 10
+
+	// The fill ratios of the persist queue at which rushed mode is entered
+	// and left again.
+	rushedModeEnterRatio = 0.8
+	rushedModeLeaveRatio = 0.7
+
 	appendWorkers  = 8 // Should be enough to not make appending a bottleneck.
 	appendQueueCap = 2 * appendWorkers
 )
@@ -69,8 +82,12 @@ type memorySeriesStorage struct {
 	loopStopping, loopStopped  chan struct{}
 	maxMemoryChunks            int
 	dropAfter                  time.Duration
+	retentionTiers             map[string]time.Duration
+	minRetention               time.Duration // Of dropAfter and all retention tiers.
 	checkpointInterval         time.Duration
 	checkpointDirtySeriesLimit int
+	checkpointAutoTune         bool
+	shutdownCheckpointTimeout  time.Duration
 
 	appendQueue         chan *clientmodel.Sample
 	appendLastTimestamp clientmodel.Timestamp // The timestamp of the last sample sent to the append queue.
@@ -80,7 +97,15 @@ type memorySeriesStorage struct {
 	persistQueueCap int // Not actually the cap of above channel. See handlePersistQueue.
 	persistStopped  chan struct{}
 
+	persistenceState        int32 // A PersistenceState. Accessed atomically.
+	persistenceStateChanged func(PersistenceState)
+
 	persistence *persistence
+	limiter     *seriesLimiter
+
+	metricRetentionMtx sync.RWMutex
+	// The retention of metrics assigned to a retention tier by metric name.
+	metricRetention map[clientmodel.LabelValue]time.Duration
 
 	countPersistedHeadChunks chan struct{}
 
@@ -94,7 +119,10 @@ type memorySeriesStorage struct {
 	persistQueueLength          prometheus.Gauge
 	numSeries                   prometheus.Gauge
 	seriesOps                   *prometheus.CounterVec
+	checkpointIntervalGauge     prometheus.Gauge
+	persistenceStateGauge       *prometheus.GaugeVec
 	ingestedSamplesCount        prometheus.Counter
+	rejectedSamplesCount        *prometheus.CounterVec
 	invalidPreloadRequestsCount prometheus.Counter
 }
 
@@ -102,28 +130,36 @@ type memorySeriesStorage struct {
 // NewMemorySeriesStorage. It is not safe to leave any of those at their zero
 // values.
 type MemorySeriesStorageOptions struct {
-	MemoryChunks               int           // How many chunks to keep in memory.
-	PersistenceStoragePath     string        // Location of persistence files.
-	PersistenceRetentionPeriod time.Duration // Chunks at least that old are dropped.
-	PersistenceQueueCapacity   int           // Capacity of queue for chunks to be persisted.
-	CheckpointInterval         time.Duration // How often to checkpoint the series map and head chunks.
-	CheckpointDirtySeriesLimit int           // How many dirty series will trigger an early checkpoint.
-	Dirty                      bool          // Force the storage to consider itself dirty on startup.
+	MemoryChunks               int                      // How many chunks to keep in memory.
+	PersistenceStoragePath     string                   // Location of persistence files.
+	PersistenceRetentionPeriod time.Duration            // Chunks at least that old are dropped.
+	RetentionTiers             map[string]time.Duration // Retention periods by tier name for metrics assigned to a tier.
+	PersistenceQueueCapacity   int                      // Capacity of queue for chunks to be persisted.
+	CheckpointInterval         time.Duration            // How often to checkpoint the series map and head chunks.
+	CheckpointDirtySeriesLimit int                      // How many dirty series will trigger an early checkpoint.
+	CheckpointMaxIncrements    int                      // How many incremental checkpoints to write between full ones.
+	CheckpointAutoTune         bool                     // Adapt the checkpoint interval to ingestion rate and checkpoint duration.
+	ShutdownCheckpointTimeout  time.Duration            // Maximum duration of the final checkpoint on shutdown, 0 for no limit.
+	Dirty                      bool                     // Force the storage to consider itself dirty on startup.
+	Limits                     IngestionLimits
+	// If not nil, called with the new state whenever the persistence
+	// state changes. It must not block.
+	PersistenceStateChanged func(PersistenceState)
 }
 
 // NewMemorySeriesStorage returns a newly allocated Storage. Storage.Serve still
 // has to be called to start the storage.
 func NewMemorySeriesStorage(o *MemorySeriesStorageOptions) (Storage, error) {
-	p, err := newPersistence(o.PersistenceStoragePath, chunkLen, o.Dirty)
+	p, err := newPersistence(o.PersistenceStoragePath, chunkLen, o.CheckpointMaxIncrements, o.Dirty)
 	if err != nil {
 		return nil, err
 	}
-	glog.Info("Loading series map and head chunks...")
+	logger.Info("Loading series map and head chunks...")
 	fpToSeries, err := p.loadSeriesMapAndHeads()
 	if err != nil {
 		return nil, err
 	}
-	glog.Infof("%d series loaded.", fpToSeries.length())
+	logger.Infof("%d series loaded.", fpToSeries.length())
 	numSeries := prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Subsystem: subsystem,
@@ -140,8 +176,12 @@ func NewMemorySeriesStorage(o *MemorySeriesStorageOptions) (Storage, error) {
 		loopStopped:                make(chan struct{}),
 		maxMemoryChunks:            o.MemoryChunks,
 		dropAfter:                  o.PersistenceRetentionPeriod,
+		retentionTiers:             o.RetentionTiers,
+		minRetention:               o.PersistenceRetentionPeriod,
 		checkpointInterval:         o.CheckpointInterval,
 		checkpointDirtySeriesLimit: o.CheckpointDirtySeriesLimit,
+		checkpointAutoTune:         o.CheckpointAutoTune,
+		shutdownCheckpointTimeout:  o.ShutdownCheckpointTimeout,
 
 		appendLastTimestamp: clientmodel.Earliest,
 		appendQueue:         make(chan *clientmodel.Sample, appendQueueCap),
@@ -153,6 +193,9 @@ func NewMemorySeriesStorage(o *MemorySeriesStorageOptions) (Storage, error) {
 		persistQueueCap: o.PersistenceQueueCapacity,
 		persistStopped:  make(chan struct{}),
 		persistence:     p,
+		limiter:         newSeriesLimiter(o.Limits, fpToSeries),
+
+		persistenceStateChanged: o.PersistenceStateChanged,
 
 		countPersistedHeadChunks: make(chan struct{}, 1024),
 
@@ -203,13 +246,47 @@ func NewMemorySeriesStorage(o *MemorySeriesStorageOptions) (Storage, error) {
 			Name:      "ingested_samples_total",
 			Help:      "The total number of samples ingested.",
 		}),
+		rejectedSamplesCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "rejected_samples_total",
+				Help:      "The total number of samples rejected because their series violated an ingestion limit, by limit.",
+			},
+			[]string{limitLabel},
+		),
 		invalidPreloadRequestsCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "invalid_preload_requests_total",
 			Help:      "The total number of preload requests referring to a non-existent series. This is an indication of outdated label indexes.",
 		}),
+		checkpointIntervalGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "checkpoint_interval_seconds",
+			Help:      "The current interval between checkpoints of in-memory metrics and head chunks.",
+		}),
+		persistenceStateGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "persistence_state",
+				Help:      "1 for the current state of chunk persistence (normal, rushed if the persist queue is filling up, or throttled if ingestion is blocked), 0 for the others.",
+			},
+			[]string{stateLabel},
+		),
+	}
+	for _, retention := range o.RetentionTiers {
+		if retention < s.minRetention {
+			s.minRetention = retention
+		}
+	}
+	for _, state := range []PersistenceState{PersistenceNormal, PersistenceRushed, PersistenceThrottled} {
+		s.persistenceStateGauge.WithLabelValues(state.String()).Set(0)
 	}
+	s.persistenceStateGauge.WithLabelValues(PersistenceNormal.String()).Set(1)
+	s.checkpointIntervalGauge.Set(o.CheckpointInterval.Seconds())
 
 	for i := 0; i < appendWorkers; i++ {
 		go func() {
@@ -232,34 +309,41 @@ func (s *memorySeriesStorage) Start() {
 
 // Stop implements Storage.
 func (s *memorySeriesStorage) Stop() error {
-	glog.Info("Stopping local storage...")
+	logger.Info("Stopping local storage...")
 
-	glog.Info("Draining append queue...")
+	logger.Info("Draining append queue...")
 	close(s.appendQueue)
 	s.appendWaitGroup.Wait()
-	glog.Info("Append queue drained.")
+	logger.Info("Append queue drained.")
 
-	glog.Info("Stopping maintenance loop...")
+	logger.Info("Stopping maintenance loop...")
 	close(s.loopStopping)
 	<-s.loopStopped
 
-	glog.Info("Stopping persist queue...")
+	logger.Info("Stopping persist queue...")
 	close(s.persistQueue)
 	<-s.persistStopped
 
-	glog.Info("Stopping chunk eviction...")
+	logger.Info("Stopping chunk eviction...")
 	close(s.evictStopping)
 	<-s.evictStopped
 
-	// One final checkpoint of the series map and the head chunks.
-	if err := s.persistence.checkpointSeriesMapAndHeads(s.fpToSeries, s.fpLocker); err != nil {
+	// One final checkpoint of the series map and the head chunks. If it
+	// takes too long, the storage is left dirty instead, so that crash
+	// recovery runs on the next start.
+	switch err := s.persistence.checkpointSeriesMapAndHeads(s.fpToSeries, s.fpLocker, s.shutdownCheckpointTimeout); err {
+	case nil:
+	case errCheckpointTimeout:
+		logger.Warn("Final checkpoint timed out, marking the storage dirty.")
+		s.persistence.setDirty(true)
+	default:
 		return err
 	}
 
 	if err := s.persistence.close(); err != nil {
 		return err
 	}
-	glog.Info("Local storage stopped.")
+	logger.Info("Local storage stopped.")
 	return nil
 }
 
@@ -300,76 +384,133 @@ func (s *memorySeriesStorage) NewPreloader() Preloader {
 
 // GetFingerprintsForLabelMatchers implements Storage.
 func (s *memorySeriesStorage) GetFingerprintsForLabelMatchers(labelMatchers metric.LabelMatchers) clientmodel.Fingerprints {
-	var result map[clientmodel.Fingerprint]struct{}
+	// Equality matchers need a single index lookup, so they are looked up
+	// first. If any of them matches nothing, the other matchers don't have
+	// to be looked up at all.
+	postings := make([]clientmodel.Fingerprints, 0, len(labelMatchers))
+	for _, matcher := range labelMatchers {
+		if matcher.Type != metric.Equal {
+			continue
+		}
+		fps, err := s.persistence.getFingerprintsForLabelPair(
+			metric.LabelPair{
+				Name:  matcher.Name,
+				Value: matcher.Value,
+			},
+		)
+		if err != nil {
+			logger.Error("Error getting fingerprints for label pair: ", err)
+		}
+		if len(fps) == 0 {
+			return nil
+		}
+		postings = append(postings, fps)
+	}
 	for _, matcher := range labelMatchers {
-		intersection := map[clientmodel.Fingerprint]struct{}{}
-		switch matcher.Type {
-		case metric.Equal:
+		if matcher.Type == metric.Equal {
+			continue
+		}
+		matches, ok := matcher.Literals()
+		if !ok {
+			values, err := s.persistence.getLabelValuesForLabelName(matcher.Name)
+			if err != nil {
+				logger.Errorf("Error getting label values for label name %q: %v", matcher.Name, err)
+			}
+			matches = matcher.Filter(values)
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		valuePostings := make([]clientmodel.Fingerprints, 0, len(matches))
+		for _, v := range matches {
 			fps, err := s.persistence.getFingerprintsForLabelPair(
 				metric.LabelPair{
 					Name:  matcher.Name,
-					Value: matcher.Value,
+					Value: v,
 				},
 			)
 			if err != nil {
-				glog.Error("Error getting fingerprints for label pair: ", err)
-			}
-			if len(fps) == 0 {
-				return nil
-			}
-			for _, fp := range fps {
-				if _, ok := result[fp]; ok || result == nil {
-					intersection[fp] = struct{}{}
-				}
-			}
-		default:
-			values, err := s.persistence.getLabelValuesForLabelName(matcher.Name)
-			if err != nil {
-				glog.Errorf("Error getting label values for label name %q: %v", matcher.Name, err)
-			}
-			matches := matcher.Filter(values)
-			if len(matches) == 0 {
-				return nil
-			}
-			for _, v := range matches {
-				fps, err := s.persistence.getFingerprintsForLabelPair(
-					metric.LabelPair{
-						Name:  matcher.Name,
-						Value: v,
-					},
-				)
-				if err != nil {
-					glog.Error("Error getting fingerprints for label pair: ", err)
-				}
-				for _, fp := range fps {
-					if _, ok := result[fp]; ok || result == nil {
-						intersection[fp] = struct{}{}
-					}
-				}
+				logger.Error("Error getting fingerprints for label pair: ", err)
 			}
+			valuePostings = append(valuePostings, fps)
 		}
-		if len(intersection) == 0 {
-			return nil
-		}
-		result = intersection
+		postings = append(postings, index.MergePostings(valuePostings...))
 	}
 
-	fps := make(clientmodel.Fingerprints, 0, len(result))
-	for fp := range result {
-		fps = append(fps, fp)
-	}
-	return fps
+	return index.IntersectPostings(postings...)
 }
 
 // GetLabelValuesForLabelName implements Storage.
 func (s *memorySeriesStorage) GetLabelValuesForLabelName(labelName clientmodel.LabelName) clientmodel.LabelValues {
 	lvs, err := s.persistence.getLabelValuesForLabelName(labelName)
 	if err != nil {
-		glog.Errorf("Error getting label values for label name %q: %v", labelName, err)
+		logger.Errorf("Error getting label values for label name %q: %v", labelName, err)
 	}
 	return lvs
 }
 
+// GetLabelNames implements Storage.
+func (s *memorySeriesStorage) GetLabelNames() clientmodel.LabelNames {
+	lns, err := s.persistence.getLabelNames()
+	if err != nil {
+		logger.Errorf("Error getting label names: %v", err)
+	}
+	return lns
+}
+
+// SetMetricRetentionTiers implements Storage.
+func (s *memorySeriesStorage) SetMetricRetentionTiers(tiers map[clientmodel.LabelValue]string) error {
+	metricRetention := make(map[clientmodel.LabelValue]time.Duration, len(tiers))
+	for name, tier := range tiers {
+		retention, ok := s.retentionTiers[tier]
+		if !ok {
+			return fmt.Errorf("unknown retention tier %q for metric %s", tier, name)
+		}
+		metricRetention[name] = retention
+	}
+	s.metricRetentionMtx.Lock()
+	defer s.metricRetentionMtx.Unlock()
+	s.metricRetention = metricRetention
+	return nil
+}
+
+// retentionBeforeTime returns the time before which samples of the metric m
+// are dropped, given the time beforeTime before which samples are dropped
+// under the default retention period.
+func (s *memorySeriesStorage) retentionBeforeTime(m clientmodel.Metric, beforeTime clientmodel.Timestamp) clientmodel.Timestamp {
+	s.metricRetentionMtx.RLock()
+	defer s.metricRetentionMtx.RUnlock()
+	retention, ok := s.metricRetention[m[clientmodel.MetricNameLabel]]
+	if !ok {
+		return beforeTime
+	}
+	return beforeTime.Add(s.dropAfter - retention)
+}
+
+// hasMetricRetention returns whether any metric is assigned to a retention
+// tier.
+func (s *memorySeriesStorage) hasMetricRetention() bool {
+	s.metricRetentionMtx.RLock()
+	defer s.metricRetentionMtx.RUnlock()
+	return len(s.metricRetention) > 0
+}
+
+// Stats implements Storage.
+func (s *memorySeriesStorage) Stats() Stats {
+	lastCheckpoint, lastCheckpointDuration := s.persistence.lastCheckpointStats()
+	return Stats{
+		NumSeries:              s.fpToSeries.length(),
+		NumMemChunks:           atomic.LoadInt64(&numMemChunks),
+		MaxMemChunks:           s.maxMemoryChunks,
+		RetentionPeriod:        s.dropAfter,
+		Dirty:                  s.persistence.isDirty(),
+		CheckpointInterval:     s.checkpointInterval,
+		LastCheckpoint:         lastCheckpoint,
+		LastCheckpointDuration: lastCheckpointDuration,
+		PersistenceState:       PersistenceState(atomic.LoadInt32(&s.persistenceState)),
+	}
+}
+
 // GetMetricForFingerprint implements Storage.
 func (s *memorySeriesStorage) GetMetricForFingerprint(fp clientmodel.Fingerprint) clientmodel.COWMetric {
 	s.fpLocker.Lock(fp)
@@ -385,13 +526,81 @@ func (s *memorySeriesStorage) GetMetricForFingerprint(fp clientmodel.Fingerprint
 	}
 	metric, err := s.persistence.getArchivedMetric(fp)
 	if err != nil {
-		glog.Errorf("Error retrieving archived metric for fingerprint %v: %v", fp, err)
+		logger.Errorf("Error retrieving archived metric for fingerprint %v: %v", fp, err)
 	}
 	return clientmodel.COWMetric{
 		Metric: metric,
 	}
 }
 
+// GetTimeRangeForFingerprint implements Storage.
+func (s *memorySeriesStorage) GetTimeRangeForFingerprint(fp clientmodel.Fingerprint) (metric.Interval, bool) {
+	s.fpLocker.Lock(fp)
+	defer s.fpLocker.Unlock(fp)
+
+	series, ok := s.fpToSeries.get(fp)
+	if ok && len(series.chunkDescs) > 0 {
+		return metric.Interval{
+			OldestInclusive: series.firstTime(),
+			NewestInclusive: series.head().lastTime(),
+		}, true
+	}
+	archived, first, last, err := s.persistence.hasArchivedMetric(fp)
+	if err != nil {
+		logger.Errorf("Error looking up archived time range for fingerprint %v: %v", fp, err)
+		return metric.Interval{}, false
+	}
+	if !archived {
+		return metric.Interval{}, false
+	}
+	return metric.Interval{
+		OldestInclusive: first,
+		NewestInclusive: last,
+	}, true
+}
+
+// GetSeriesInfo implements Storage.
+func (s *memorySeriesStorage) GetSeriesInfo(fp clientmodel.Fingerprint) (SeriesInfo, bool) {
+	s.fpLocker.Lock(fp)
+	defer s.fpLocker.Unlock(fp)
+
+	numPersisted, err := s.persistence.numPersistedChunks(fp)
+	if err != nil {
+		logger.Errorf("Error counting persisted chunks for fingerprint %v: %v", fp, err)
+	}
+	series, ok := s.fpToSeries.get(fp)
+	if !ok || len(series.chunkDescs) == 0 {
+		archived, _, _, err := s.persistence.hasArchivedMetric(fp)
+		if err != nil {
+			logger.Errorf("Error looking up archived metric for fingerprint %v: %v", fp, err)
+			return SeriesInfo{}, false
+		}
+		return SeriesInfo{Archived: true, NumChunks: numPersisted}, archived
+	}
+
+	info := SeriesInfo{NumMemChunks: len(series.chunkDescs)}
+	if series.chunkDescsOffset < 0 {
+		// The chunks in memory are not on disk yet.
+		info.NumChunks = numPersisted + len(series.chunkDescs)
+	} else {
+		info.NumChunks = series.chunkDescsOffset + len(series.chunkDescs)
+		if numPersisted > info.NumChunks {
+			info.NumChunks = numPersisted
+		}
+	}
+	head := series.head()
+	info.HeadChunk = metric.Interval{
+		OldestInclusive: head.firstTime(),
+		NewestInclusive: head.lastTime(),
+	}
+	if c := head.getChunk(); c != nil {
+		if values := c.newIterator().getValueAtTime(c.lastTime()); len(values) > 0 {
+			info.LastSample = &values[len(values)-1]
+		}
+	}
+	return info, true
+}
+
 // AppendSamples implements Storage.
 func (s *memorySeriesStorage) AppendSamples(samples clientmodel.Samples) {
 	for _, sample := range samples {
@@ -413,6 +622,13 @@ func (s *memorySeriesStorage) AppendSamples(samples clientmodel.Samples) {
 func (s *memorySeriesStorage) appendSample(sample *clientmodel.Sample) {
 	fp := sample.Metric.Fingerprint()
 	s.fpLocker.Lock(fp)
+	if _, ok := s.fpToSeries.get(fp); !ok {
+		if limit := s.limiter.check(sample.Metric); limit != "" {
+			s.fpLocker.Unlock(fp)
+			s.rejectedSamplesCount.WithLabelValues(limit).Inc()
+			return
+		}
+	}
 	series := s.getOrCreateSeries(fp, sample.Metric)
 	chunkDescsToPersist := series.add(fp, &metric.SamplePair{
 		Value:     sample.Value,
@@ -442,7 +658,7 @@ func (s *memorySeriesStorage) getOrCreateSeries(fp clientmodel.Fingerprint, m cl
 	if !ok {
 		unarchived, firstTime, err := s.persistence.unarchiveMetric(fp)
 		if err != nil {
-			glog.Errorf("Error unarchiving fingerprint %v: %v", fp, err)
+			logger.Errorf("Error unarchiving fingerprint %v: %v", fp, err)
 		}
 		if unarchived {
 			s.seriesOps.WithLabelValues(unarchive).Inc()
@@ -453,6 +669,7 @@ func (s *memorySeriesStorage) getOrCreateSeries(fp clientmodel.Fingerprint, m cl
 		}
 		series = newMemorySeries(m, !unarchived, firstTime)
 		s.fpToSeries.put(fp, series)
+		s.limiter.add(m)
 		s.numSeries.Inc()
 	}
 	return series
@@ -526,7 +743,7 @@ func (s *memorySeriesStorage) handleEvictList() {
 				}
 			}()
 			ticker.Stop()
-			glog.Info("Chunk eviction stopped.")
+			logger.Info("Chunk eviction stopped.")
 			close(s.evictStopped)
 			return
 		}
@@ -590,8 +807,9 @@ func (s *memorySeriesStorage) handlePersistQueue() {
 
 loop:
 	for {
+		s.updatePersistenceState(chunkCount)
 		if chunkCount >= s.persistQueueCap && chunkCount > 0 {
-			glog.Warningf("%d chunks queued for persistence. Ingestion pipeline will backlog.", chunkCount)
+			logger.Warnf("%d chunks queued for persistence. Ingestion pipeline will backlog.", chunkCount)
 			persistMostConsecutiveChunks()
 		}
 		select {
@@ -624,7 +842,7 @@ loop:
 			if s.persistChunks(fp, cds) == nil {
 				chunkCount -= len(cds)
 				if (chunkCount+len(cds))/1000 > chunkCount/1000 {
-					glog.Infof(
+					logger.Infof(
 						"Still draining persist queue, %d chunks left to persist...",
 						chunkCount,
 					)
@@ -634,10 +852,43 @@ loop:
 		}
 	}
 
-	glog.Info("Persist queue drained and stopped.")
+	logger.Info("Persist queue drained and stopped.")
 	close(s.persistStopped)
 }
 
+// updatePersistenceState derives the persistence state from the number of
+// chunks waiting to be persisted. Rushed mode is left at a lower fill ratio of
+// the persist queue than it is entered at to not flap between states.
+func (s *memorySeriesStorage) updatePersistenceState(chunkCount int) {
+	old := PersistenceState(atomic.LoadInt32(&s.persistenceState))
+	state := old
+	ratio := float64(chunkCount) / float64(s.persistQueueCap)
+	switch {
+	case chunkCount >= s.persistQueueCap && chunkCount > 0:
+		state = PersistenceThrottled
+	case ratio >= rushedModeEnterRatio:
+		state = PersistenceRushed
+	case ratio < rushedModeLeaveRatio:
+		state = PersistenceNormal
+	case old == PersistenceThrottled:
+		state = PersistenceRushed
+	}
+	if state == old {
+		return
+	}
+	atomic.StoreInt32(&s.persistenceState, int32(state))
+	s.persistenceStateGauge.WithLabelValues(old.String()).Set(0)
+	s.persistenceStateGauge.WithLabelValues(state.String()).Set(1)
+	if state > old {
+		logger.Warnf("Chunk persistence cannot keep up, entering %s mode with %d chunks waiting to be persisted.", state, chunkCount)
+	} else {
+		logger.Infof("Chunk persistence is catching up, entering %s mode with %d chunks waiting to be persisted.", state, chunkCount)
+	}
+	if s.persistenceStateChanged != nil {
+		s.persistenceStateChanged(state)
+	}
+}
+
 func (s *memorySeriesStorage) persistChunks(fp clientmodel.Fingerprint, cds []*chunkDesc) error {
 	start := time.Now()
 	chunks := make([]chunk, len(cds))
@@ -651,12 +902,13 @@ func (s *memorySeriesStorage) persistChunks(fp clientmodel.Fingerprint, cds []*c
 		// series that had prior chunks on disk. Finally, we can
 		// set the chunkDescsOffset.
 		series.chunkDescsOffset = offset
+		series.changed = true
 	}
 	s.fpLocker.Unlock(fp)
 	s.persistLatency.Observe(float64(time.Since(start)) / float64(time.Microsecond))
 	if err != nil {
 		s.persistErrors.Inc()
-		glog.Error("Error persisting chunks: ", err)
+		logger.Error("Error persisting chunks: ", err)
 		s.persistence.setDirty(true)
 		return err
 	}
@@ -735,7 +987,7 @@ func (s *memorySeriesStorage) cycleThroughMemoryFingerprints() chan clientmodel.
 				count++
 			}
 			if count > 0 {
-				glog.Infof(
+				logger.Infof(
 					"Completed maintenance sweep through %d in-memory fingerprints in %v.",
 					count, time.Since(begin),
 				)
@@ -756,10 +1008,10 @@ func (s *memorySeriesStorage) cycleThroughArchivedFingerprints() chan clientmode
 
 		for {
 			archivedFPs, err := s.persistence.getFingerprintsModifiedBefore(
-				clientmodel.TimestampFromTime(time.Now()).Add(-s.dropAfter),
+				clientmodel.TimestampFromTime(time.Now()).Add(-s.minRetention),
 			)
 			if err != nil {
-				glog.Error("Failed to lookup archived fingerprint ranges: ", err)
+				logger.Error("Failed to lookup archived fingerprint ranges: ", err)
 				s.waitForNextFP(0)
 				continue
 			}
@@ -777,7 +1029,7 @@ func (s *memorySeriesStorage) cycleThroughArchivedFingerprints() chan clientmode
 				s.waitForNextFP(len(archivedFPs))
 			}
 			if len(archivedFPs) > 0 {
-				glog.Infof(
+				logger.Infof(
 					"Completed maintenance sweep through %d archived fingerprints in %v.",
 					len(archivedFPs), time.Since(begin),
 				)
@@ -789,6 +1041,7 @@ func (s *memorySeriesStorage) cycleThroughArchivedFingerprints() chan clientmode
 
 func (s *memorySeriesStorage) loop() {
 	checkpointTimer := time.NewTimer(s.checkpointInterval)
+	lastCheckpoint := time.Now()
 
 	// We take the number of head chunks persisted since the last checkpoint
 	// as an approximation for the number of series that are "dirty",
@@ -801,7 +1054,7 @@ func (s *memorySeriesStorage) loop() {
 
 	defer func() {
 		checkpointTimer.Stop()
-		glog.Info("Maintenance loop stopped.")
+		logger.Info("Maintenance loop stopped.")
 		close(s.loopStopped)
 	}()
 
@@ -814,9 +1067,12 @@ loop:
 		case <-s.loopStopping:
 			break loop
 		case <-checkpointTimer.C:
-			s.persistence.checkpointSeriesMapAndHeads(s.fpToSeries, s.fpLocker)
+			s.persistence.checkpointSeriesMapAndHeads(s.fpToSeries, s.fpLocker, 0)
+			interval := s.nextCheckpointInterval(headChunksPersistedSinceLastCheckpoint, time.Since(lastCheckpoint))
+			s.checkpointIntervalGauge.Set(interval.Seconds())
+			lastCheckpoint = time.Now()
 			headChunksPersistedSinceLastCheckpoint = 0
-			checkpointTimer.Reset(s.checkpointInterval)
+			checkpointTimer.Reset(interval)
 		case fp := <-memoryFingerprints:
 			s.maintainMemorySeries(fp, clientmodel.TimestampFromTime(time.Now()).Add(-s.dropAfter))
 		case fp := <-archivedFingerprints:
@@ -844,6 +1100,38 @@ loop:
 	}
 }
 
+// nextCheckpointInterval returns the interval until the next checkpoint, given
+// the number of dirty series, as estimated in loop, accumulated during the
+// elapsed time since the previous checkpoint. Without auto-tuning, it is the
+// configured checkpoint interval. With auto-tuning, it is the time until the
+// dirty series limit is reached again at the current ingestion rate, but at
+// least checkpointDurationFactor times the duration of the last checkpoint,
+// so that checkpointing doesn't keep the disk busy, and a tenth of the
+// configured interval. The configured interval remains the maximum.
+func (s *memorySeriesStorage) nextCheckpointInterval(dirtySeries int, elapsed time.Duration) time.Duration {
+	if !s.checkpointAutoTune {
+		return s.checkpointInterval
+	}
+	interval := s.checkpointInterval
+	if dirtySeries > 0 {
+		untilLimit := time.Duration(float64(elapsed) * float64(s.checkpointDirtySeriesLimit) / float64(dirtySeries))
+		if untilLimit < interval {
+			interval = untilLimit
+		}
+	}
+	_, lastDuration := s.persistence.lastCheckpointStats()
+	if min := checkpointDurationFactor * lastDuration; interval < min {
+		interval = min
+	}
+	if min := s.checkpointInterval / 10; interval < min {
+		interval = min
+	}
+	if interval > s.checkpointInterval {
+		interval = s.checkpointInterval
+	}
+	return interval
+}
+
 // maintainMemorySeries first purges the series from old chunks. If the series
 // still exists after that, it proceeds with the following steps: It closes the
 // head chunk if it was not touched in a while. It archives a series if all
@@ -873,7 +1161,7 @@ func (s *memorySeriesStorage) maintainMemorySeries(fp clientmodel.Fingerprint, b
 
 	defer s.seriesOps.WithLabelValues(memoryMaintenance).Inc()
 
-	if s.purgeMemorySeries(fp, series, beforeTime) {
+	if s.purgeMemorySeries(fp, series, s.retentionBeforeTime(series.metric, beforeTime)) {
 		// Series is gone now, we are done.
 		return
 	}
@@ -889,13 +1177,14 @@ func (s *memorySeriesStorage) maintainMemorySeries(fp clientmodel.Fingerprint, b
 	// Archive if all chunks are evicted.
 	if iOldestNotEvicted == -1 {
 		s.fpToSeries.del(fp)
+		s.limiter.remove(series.metric)
 		s.numSeries.Dec()
 		// Make sure we have a head chunk descriptor (a freshly
 		// unarchived series has none).
 		if len(series.chunkDescs) == 0 {
 			cds, err := s.loadChunkDescs(fp, clientmodel.Latest)
 			if err != nil {
-				glog.Errorf(
+				logger.Errorf(
 					"Could not load chunk descriptors prior to archiving metric %v, metric will not be archived: %v",
 					series.metric, err,
 				)
@@ -906,7 +1195,7 @@ func (s *memorySeriesStorage) maintainMemorySeries(fp clientmodel.Fingerprint, b
 		if err := s.persistence.archiveMetric(
 			fp, series.metric, series.firstTime(), series.head().lastTime(),
 		); err != nil {
-			glog.Errorf("Error archiving metric %v: %v", series.metric, err)
+			logger.Errorf("Error archiving metric %v: %v", series.metric, err)
 			return
 		}
 		s.seriesOps.WithLabelValues(archive).Inc()
@@ -917,6 +1206,7 @@ func (s *memorySeriesStorage) maintainMemorySeries(fp clientmodel.Fingerprint, b
 	series.evictChunkDescs(iOldestNotEvicted)
 	if !series.headChunkPersisted && time.Now().Sub(series.head().firstTime().Time()) > headChunkTimeout {
 		series.headChunkPersisted = true
+		series.changed = true
 		// Since we cannot modify the head chunk from now on, we
 		// don't need to bother with cloning anymore.
 		series.headChunkUsedByIterator = false
@@ -935,11 +1225,12 @@ func (s *memorySeriesStorage) purgeMemorySeries(fp clientmodel.Fingerprint, seri
 	}
 	newFirstTime, numDroppedFromPersistence, allDroppedFromPersistence, err := s.persistence.dropChunks(fp, beforeTime)
 	if err != nil {
-		glog.Error("Error dropping persisted chunks: ", err)
+		logger.Error("Error dropping persisted chunks: ", err)
 	}
 	numDroppedFromMemory, allDroppedFromMemory := series.dropChunks(beforeTime)
 	if allDroppedFromPersistence && allDroppedFromMemory {
 		s.fpToSeries.del(fp)
+		s.limiter.remove(series.metric)
 		s.numSeries.Dec()
 		s.seriesOps.WithLabelValues(memoryPurge).Inc()
 		s.persistence.unindexMetric(fp, series.metric)
@@ -948,6 +1239,7 @@ func (s *memorySeriesStorage) purgeMemorySeries(fp clientmodel.Fingerprint, seri
 	if series.chunkDescsOffset != -1 {
 		series.savedFirstTime = newFirstTime
 		series.chunkDescsOffset += numDroppedFromMemory - numDroppedFromPersistence
+		series.changed = true
 		if series.chunkDescsOffset < 0 {
 			panic("dropped more chunks from persistence than from memory")
 		}
@@ -963,9 +1255,17 @@ func (s *memorySeriesStorage) maintainArchivedSeries(fp clientmodel.Fingerprint,
 
 	has, firstTime, lastTime, err := s.persistence.hasArchivedMetric(fp)
 	if err != nil {
-		glog.Error("Error looking up archived time range: ", err)
+		logger.Error("Error looking up archived time range: ", err)
 		return
 	}
+	if has && s.hasMetricRetention() {
+		metric, err := s.persistence.getArchivedMetric(fp)
+		if err != nil {
+			logger.Error("Error looking up archived metric: ", err)
+			return
+		}
+		beforeTime = s.retentionBeforeTime(metric, beforeTime)
+	}
 	if !has || !firstTime.Before(beforeTime) {
 		// Oldest sample not old enough, or metric purged or unarchived in the meantime.
 		return
@@ -975,11 +1275,11 @@ func (s *memorySeriesStorage) maintainArchivedSeries(fp clientmodel.Fingerprint,
 
 	newFirstTime, _, allDropped, err := s.persistence.dropChunks(fp, beforeTime)
 	if err != nil {
-		glog.Error("Error dropping persisted chunks: ", err)
+		logger.Error("Error dropping persisted chunks: ", err)
 	}
 	if allDropped {
 		if err := s.persistence.purgeArchivedMetric(fp); err != nil {
-			glog.Errorf("Error purging archived metric for fingerprint %v: %v", fp, err)
+			logger.Errorf("Error purging archived metric for fingerprint %v: %v", fp, err)
 			return
 		}
 		s.seriesOps.WithLabelValues(archivePurge).Inc()
@@ -1008,7 +1308,10 @@ func (s *memorySeriesStorage) Describe(ch chan<- *prometheus.Desc) {
 	ch <- s.persistQueueLength.Desc()
 	ch <- s.numSeries.Desc()
 	s.seriesOps.Describe(ch)
+	ch <- s.checkpointIntervalGauge.Desc()
+	s.persistenceStateGauge.Describe(ch)
 	ch <- s.ingestedSamplesCount.Desc()
+	s.rejectedSamplesCount.Describe(ch)
 	ch <- s.invalidPreloadRequestsCount.Desc()
 
 	ch <- numMemChunksDesc
@@ -1024,7 +1327,10 @@ func (s *memorySeriesStorage) Collect(ch chan<- prometheus.Metric) {
 	ch <- s.persistQueueLength
 	ch <- s.numSeries
 	s.seriesOps.Collect(ch)
+	ch <- s.checkpointIntervalGauge
+	s.persistenceStateGauge.Collect(ch)
 	ch <- s.ingestedSamplesCount
+	s.rejectedSamplesCount.Collect(ch)
 	ch <- s.invalidPreloadRequestsCount
 
 	count := atomic.LoadInt64(&numMemChunks)
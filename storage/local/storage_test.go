@@ -21,7 +21,7 @@ import (
 	"testing/quick"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
@@ -111,7 +111,7 @@ func TestChunk(t *testing.T) {
 		}
 		s.(*memorySeriesStorage).fpLocker.Unlock(m.fp)
 	}
-	glog.Info("test done, closing")
+	logger.Info("test done, closing")
 }
 
 func TestGetValueAtTime(t *testing.T) {
@@ -437,6 +437,127 @@ func TestEvictAndPurgeSeries(t *testing.T) {
 	}
 }
 
+func TestRetentionTiers(t *testing.T) {
+	samples := make(clientmodel.Samples, 1000)
+	for i := range samples {
+		samples[i] = &clientmodel.Sample{
+			Metric:    clientmodel.Metric{clientmodel.MetricNameLabel: "long"},
+			Timestamp: clientmodel.Timestamp(2 * i),
+			Value:     clientmodel.SampleValue(float64(i) * 0.2),
+		}
+	}
+	s, closer := NewTestStorage(t)
+	defer closer.Close()
+
+	ms := s.(*memorySeriesStorage)
+	ms.retentionTiers = map[string]time.Duration{"long-term": ms.dropAfter + 9*time.Second}
+
+	if err := s.SetMetricRetentionTiers(map[clientmodel.LabelValue]string{"long": "unknown"}); err == nil {
+		t.Fatal("expected error for unknown retention tier")
+	}
+	if err := s.SetMetricRetentionTiers(map[clientmodel.LabelValue]string{"long": "long-term"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.AppendSamples(samples)
+	s.WaitForIndexing()
+	fp := samples[0].Metric.Fingerprint()
+
+	// Samples older than 10000 are dropped at the default retention, but
+	// only those older than 1000 at the longer retention of the tier.
+	ms.maintainMemorySeries(fp, 10000)
+	actual := s.NewIterator(fp).GetBoundaryValues(metric.Interval{
+		OldestInclusive: 0,
+		NewestInclusive: 10000,
+	})
+	if len(actual) != 2 {
+		t.Fatal("expected two results after purging half of series")
+	}
+	if actual[0].Timestamp < 800 || actual[0].Timestamp > 1000 {
+		t.Errorf("1st timestamp out of expected range: %v", actual[0].Timestamp)
+	}
+
+	// Without the tier, the default retention applies again.
+	if err := s.SetMetricRetentionTiers(nil); err != nil {
+		t.Fatal(err)
+	}
+	ms.maintainMemorySeries(fp, 10000)
+	actual = s.NewIterator(fp).GetBoundaryValues(metric.Interval{
+		OldestInclusive: 0,
+		NewestInclusive: 10000,
+	})
+	if len(actual) != 0 {
+		t.Fatal("expected zero results after purging the whole series")
+	}
+}
+
+func TestGetTimeRangeForFingerprint(t *testing.T) {
+	samples := make(clientmodel.Samples, 1000)
+	for i := range samples {
+		samples[i] = &clientmodel.Sample{
+			Timestamp: clientmodel.Timestamp(2*i + 10),
+			Value:     clientmodel.SampleValue(float64(i) * 0.2),
+		}
+	}
+	s, closer := NewTestStorage(t)
+	defer closer.Close()
+
+	s.AppendSamples(samples)
+	s.WaitForIndexing()
+
+	fp := clientmodel.Metric{}.Fingerprint()
+	want := metric.Interval{OldestInclusive: 10, NewestInclusive: 2008}
+	got, ok := s.GetTimeRangeForFingerprint(fp)
+	if !ok {
+		t.Fatal("expected time range for existing series")
+	}
+	if got != want {
+		t.Errorf("want time range %v, got %v", want, got)
+	}
+
+	if _, ok := s.GetTimeRangeForFingerprint(clientmodel.Metric{"foo": "bar"}.Fingerprint()); ok {
+		t.Error("expected no time range for non-existent series")
+	}
+}
+
+func TestGetSeriesInfo(t *testing.T) {
+	samples := make(clientmodel.Samples, 1000)
+	for i := range samples {
+		samples[i] = &clientmodel.Sample{
+			Timestamp: clientmodel.Timestamp(2*i + 10),
+			Value:     clientmodel.SampleValue(float64(i) * 0.2),
+		}
+	}
+	s, closer := NewTestStorage(t)
+	defer closer.Close()
+
+	s.AppendSamples(samples)
+	s.WaitForIndexing()
+
+	fp := clientmodel.Metric{}.Fingerprint()
+	info, ok := s.GetSeriesInfo(fp)
+	if !ok {
+		t.Fatal("expected info for existing series")
+	}
+	if info.Archived {
+		t.Error("series unexpectedly archived")
+	}
+	if info.NumChunks == 0 || info.NumChunks != info.NumMemChunks {
+		t.Errorf("want all of at least one chunk in memory, got %d of %d chunks", info.NumMemChunks, info.NumChunks)
+	}
+	if info.HeadChunk.NewestInclusive != 2008 {
+		t.Errorf("want head chunk to end at 2008, got %v", info.HeadChunk.NewestInclusive)
+	}
+	want := metric.SamplePair{Timestamp: 2008, Value: clientmodel.SampleValue(999 * 0.2)}
+	if info.LastSample == nil || !info.LastSample.Equal(&want) {
+		t.Errorf("want last sample %v, got %v", want, info.LastSample)
+	}
+
+	if _, ok := s.GetSeriesInfo(clientmodel.Metric{"foo": "bar"}.Fingerprint()); ok {
+		t.Error("expected no info for non-existent series")
+	}
+}
+
 func BenchmarkAppend(b *testing.B) {
 	samples := make(clientmodel.Samples, b.N)
 	for i := range samples {
@@ -679,6 +800,38 @@ func verifyStorage(t testing.TB, s Storage, samples clientmodel.Samples, maxAge
 	return result
 }
 
+func TestNextCheckpointInterval(t *testing.T) {
+	s := &memorySeriesStorage{
+		checkpointInterval:         10 * time.Minute,
+		checkpointDirtySeriesLimit: 100,
+		persistence:                &persistence{},
+	}
+	if got := s.nextCheckpointInterval(1000, time.Minute); got != 10*time.Minute {
+		t.Errorf("want configured interval without auto-tuning, got %v", got)
+	}
+
+	s.checkpointAutoTune = true
+	var scenarios = []struct {
+		dirtySeries  int
+		elapsed      time.Duration
+		lastDuration time.Duration
+		want         time.Duration
+	}{
+		{dirtySeries: 0, elapsed: 10 * time.Minute, want: 10 * time.Minute},
+		{dirtySeries: 50, elapsed: 2 * time.Minute, want: 4 * time.Minute},
+		{dirtySeries: 50, elapsed: 2 * time.Minute, lastDuration: 30 * time.Second, want: 5 * time.Minute},
+		{dirtySeries: 100000, elapsed: 2 * time.Minute, want: time.Minute},
+		{dirtySeries: 10, elapsed: 5 * time.Minute, want: 10 * time.Minute},
+		{dirtySeries: 10, elapsed: 5 * time.Minute, lastDuration: 5 * time.Minute, want: 10 * time.Minute},
+	}
+	for i, scenario := range scenarios {
+		s.persistence.lastCheckpointDuration = scenario.lastDuration
+		if got := s.nextCheckpointInterval(scenario.dirtySeries, scenario.elapsed); got != scenario.want {
+			t.Errorf("%d. want %v, got %v", i, scenario.want, got)
+		}
+	}
+}
+
 func TestChunkMaps(t *testing.T) {
 	cm := chunkMaps{}
 
@@ -768,3 +921,45 @@ func TestChunkMaps(t *testing.T) {
 	}
 
 }
+
+func TestUpdatePersistenceState(t *testing.T) {
+	var changes []PersistenceState
+	s := &memorySeriesStorage{
+		persistQueueCap: 100,
+		persistenceStateGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "test_persistence_state"},
+			[]string{stateLabel},
+		),
+		persistenceStateChanged: func(state PersistenceState) {
+			changes = append(changes, state)
+		},
+	}
+	var scenarios = []struct {
+		chunkCount int
+		want       PersistenceState
+	}{
+		{chunkCount: 0, want: PersistenceNormal},
+		{chunkCount: 79, want: PersistenceNormal},
+		{chunkCount: 80, want: PersistenceRushed},
+		{chunkCount: 75, want: PersistenceRushed},
+		{chunkCount: 100, want: PersistenceThrottled},
+		{chunkCount: 90, want: PersistenceRushed},
+		{chunkCount: 100, want: PersistenceThrottled},
+		{chunkCount: 75, want: PersistenceRushed},
+		{chunkCount: 69, want: PersistenceNormal},
+		{chunkCount: 75, want: PersistenceNormal},
+	}
+	for i, scenario := range scenarios {
+		s.updatePersistenceState(scenario.chunkCount)
+		if got := PersistenceState(s.persistenceState); got != scenario.want {
+			t.Errorf("%d. want state %s for %d chunks, got %s", i, scenario.want, scenario.chunkCount, got)
+		}
+	}
+	want := []PersistenceState{
+		PersistenceRushed, PersistenceThrottled, PersistenceRushed,
+		PersistenceThrottled, PersistenceRushed, PersistenceNormal,
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("want state changes %v, got %v", want, changes)
+	}
+}
@@ -46,12 +46,26 @@ func (m MatchType) String() string {
 // LabelMatchers is a slice of LabelMatcher objects.
 type LabelMatchers []*LabelMatcher
 
+// Match returns true if all label matchers match the respective values of the
+// provided label set. A label missing from the label set has the empty value.
+func (ms LabelMatchers) Match(ls clientmodel.LabelSet) bool {
+	for _, m := range ms {
+		if !m.Match(ls[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
 // LabelMatcher models the matching of a label.
 type LabelMatcher struct {
 	Type  MatchType
 	Name  clientmodel.LabelName
 	Value clientmodel.LabelValue
 	re    *regexp.Regexp
+	// If not nil, matches the same values as re without running the
+	// regexp engine.
+	literalRe *literalRegexp
 }
 
 // NewLabelMatcher returns a LabelMatcher object ready to use.
@@ -67,6 +81,7 @@ func NewLabelMatcher(matchType MatchType, name clientmodel.LabelName, value clie
 			return nil, err
 		}
 		m.re = re
+		m.literalRe = parseLiteralRegexp(string(value))
 	}
 	return m, nil
 }
@@ -79,9 +94,9 @@ func (m *LabelMatcher) Match(v clientmodel.LabelValue) bool {
 	case NotEqual:
 		return m.Value != v
 	case RegexMatch:
-		return m.re.MatchString(string(v))
+		return m.matchRegexp(v)
 	case RegexNoMatch:
-		return !m.re.MatchString(string(v))
+		return !m.matchRegexp(v)
 	default:
 		panic("invalid match type")
 	}
@@ -98,3 +113,25 @@ func (m *LabelMatcher) Filter(in clientmodel.LabelValues) clientmodel.LabelValue
 	}
 	return out
 }
+
+// Literals returns the label values matched by a RegexMatch matcher if its
+// regular expression consists of anchored literal alternatives, like
+// "^(api|web|worker)$", so that they can be looked up directly rather than by
+// filtering all values of the label.
+func (m *LabelMatcher) Literals() (clientmodel.LabelValues, bool) {
+	if m.Type != RegexMatch || m.literalRe == nil || !m.literalRe.exact() {
+		return nil, false
+	}
+	lvs := make(clientmodel.LabelValues, 0, len(m.literalRe.literals))
+	for _, l := range m.literalRe.literals {
+		lvs = append(lvs, clientmodel.LabelValue(l))
+	}
+	return lvs, true
+}
+
+func (m *LabelMatcher) matchRegexp(v clientmodel.LabelValue) bool {
+	if m.literalRe != nil {
+		return m.literalRe.matchString(string(v))
+	}
+	return m.re.MatchString(string(v))
+}
@@ -0,0 +1,219 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// The maximum number of literal alternatives a regular expression is
+// decomposed into. Regular expressions with more alternatives are matched by
+// the regexp engine.
+const maxRegexpLiterals = 256
+
+// literalRegexp is a regular expression decomposed into literal alternatives,
+// like "api|web|worker" or "^prod-.*". A string matches if it contains one
+// of the literals at a position allowed by the anchors of the expression.
+type literalRegexp struct {
+	literals []string
+	// Whether the literal must be at the start or the end of the string.
+	anchoredStart, anchoredEnd bool
+	// Whether the part of the string before or after the literal must not
+	// contain newlines, as in "^.*foo", where "." does not match newlines.
+	noNLBefore, noNLAfter bool
+}
+
+// parseLiteralRegexp decomposes the regular expression expr into literal
+// alternatives. It returns nil if that is not possible.
+func parseLiteralRegexp(expr string) *literalRegexp {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	lr := &literalRegexp{}
+	if len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+		lr.anchoredStart = true
+		subs = subs[1:]
+	}
+	if len(subs) > 0 && subs[len(subs)-1].Op == syntax.OpEndText {
+		lr.anchoredEnd = true
+		subs = subs[:len(subs)-1]
+	}
+	// A leading or trailing ".*" only matters next to an anchor, where it
+	// lifts the anchor apart from the newline restriction of ".".
+	if len(subs) > 0 {
+		if op, ok := anyStar(subs[0]); ok {
+			subs = subs[1:]
+			if lr.anchoredStart {
+				lr.anchoredStart = false
+				lr.noNLBefore = op == syntax.OpAnyCharNotNL
+			}
+		}
+	}
+	if len(subs) > 0 {
+		if op, ok := anyStar(subs[len(subs)-1]); ok {
+			subs = subs[:len(subs)-1]
+			if lr.anchoredEnd {
+				lr.anchoredEnd = false
+				lr.noNLAfter = op == syntax.OpAnyCharNotNL
+			}
+		}
+	}
+	if !lr.anchoredStart && !lr.anchoredEnd && (lr.noNLBefore || lr.noNLAfter) {
+		// Not worth it for expressions like "^.*foo.*$".
+		return nil
+	}
+
+	literals := []string{""}
+	for _, sub := range subs {
+		l, ok := regexpLiterals(sub)
+		if !ok {
+			return nil
+		}
+		if literals = concatLiterals(literals, l); literals == nil {
+			return nil
+		}
+	}
+	for _, l := range literals {
+		// The regexp engine matches invalid UTF-8 as the replacement
+		// character, which string comparisons don't.
+		if strings.ContainsRune(l, utf8.RuneError) {
+			return nil
+		}
+	}
+	lr.literals = literals
+	return lr
+}
+
+// anyStar returns whether re is ".*" and which kind of any character it
+// repeats.
+func anyStar(re *syntax.Regexp) (syntax.Op, bool) {
+	if re.Op != syntax.OpStar {
+		return 0, false
+	}
+	switch op := re.Sub[0].Op; op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return op, true
+	}
+	return 0, false
+}
+
+// regexpLiterals returns all strings matched by re if there are not more
+// than maxRegexpLiterals of them.
+func regexpLiterals(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		var literals []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(literals) == maxRegexpLiterals {
+					return nil, false
+				}
+				literals = append(literals, string(r))
+			}
+		}
+		return literals, true
+	case syntax.OpCapture:
+		return regexpLiterals(re.Sub[0])
+	case syntax.OpQuest:
+		l, ok := regexpLiterals(re.Sub[0])
+		if !ok || len(l) == maxRegexpLiterals {
+			return nil, false
+		}
+		return append(l, ""), true
+	case syntax.OpConcat:
+		literals := []string{""}
+		for _, sub := range re.Sub {
+			l, ok := regexpLiterals(sub)
+			if !ok {
+				return nil, false
+			}
+			if literals = concatLiterals(literals, l); literals == nil {
+				return nil, false
+			}
+		}
+		return literals, true
+	case syntax.OpAlternate:
+		var literals []string
+		for _, sub := range re.Sub {
+			l, ok := regexpLiterals(sub)
+			if !ok || len(literals)+len(l) > maxRegexpLiterals {
+				return nil, false
+			}
+			literals = append(literals, l...)
+		}
+		return literals, true
+	}
+	return nil, false
+}
+
+// concatLiterals returns all concatenations of a string from a with a string
+// from b, or nil if there are more than maxRegexpLiterals of them.
+func concatLiterals(a, b []string) []string {
+	if len(a)*len(b) > maxRegexpLiterals {
+		return nil
+	}
+	result := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			result = append(result, x+y)
+		}
+	}
+	return result
+}
+
+// exact returns whether the literals are the complete set of strings matched.
+func (lr *literalRegexp) exact() bool {
+	return lr.anchoredStart && lr.anchoredEnd
+}
+
+// matchString returns whether the regular expression matches s.
+func (lr *literalRegexp) matchString(s string) bool {
+	for _, l := range lr.literals {
+		switch {
+		case lr.exact():
+			if s == l {
+				return true
+			}
+		case lr.anchoredStart:
+			if strings.HasPrefix(s, l) && !(lr.noNLAfter && strings.Contains(s[len(l):], "\n")) {
+				return true
+			}
+		case lr.anchoredEnd:
+			if strings.HasSuffix(s, l) && !(lr.noNLBefore && strings.Contains(s[:len(s)-len(l)], "\n")) {
+				return true
+			}
+		default:
+			if strings.Contains(s, l) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestLiteralRegexp(t *testing.T) {
+	var scenarios = []struct {
+		expr    string
+		literal bool
+	}{
+		{expr: "api|web|worker", literal: true},
+		{expr: "prod-.*", literal: true},
+		{expr: "^prod-", literal: true},
+		{expr: "^prod-.*$", literal: true},
+		{expr: "^(?s:.*)-canary$", literal: true},
+		{expr: ".*-canary$", literal: true},
+		{expr: "^(api|web)$", literal: true},
+		{expr: "^(api|web)-[0-9]$", literal: true},
+		{expr: "^foo(bar)?$", literal: true},
+		{expr: "^$", literal: true},
+		{expr: "", literal: true},
+		{expr: ".*", literal: true},
+		{expr: "(?i)api", literal: false},
+		{expr: "^.*foo.*$", literal: false},
+		{expr: "^api-[0-9]+$", literal: false},
+		{expr: "^a.c$", literal: false},
+		{expr: "(?m)^api$", literal: false},
+	}
+	values := []string{
+		"", "api", "web", "worker", "apiweb", "xapi", "API", "prod-", "prod-1",
+		"prod-\n1", "x-prod-1", "1-canary", "\n-canary", "api-1", "web-9",
+		"api-10", "foo", "foobar", "foobarbar", "abc", "a\nc", "\xffapi",
+	}
+
+	for _, s := range scenarios {
+		lr := parseLiteralRegexp(s.expr)
+		if (lr != nil) != s.literal {
+			t.Errorf("%q: expected decomposition %v, got %v", s.expr, s.literal, lr != nil)
+			continue
+		}
+		if lr == nil {
+			continue
+		}
+		re := regexp.MustCompile(s.expr)
+		for _, v := range values {
+			if want, got := re.MatchString(v), lr.matchString(v); want != got {
+				t.Errorf("%q matching %q: expected %v, got %v", s.expr, v, want, got)
+			}
+		}
+	}
+}
+
+func TestLabelMatcherLiterals(t *testing.T) {
+	var scenarios = []struct {
+		matchType MatchType
+		expr      string
+		literals  clientmodel.LabelValues
+	}{
+		{matchType: RegexMatch, expr: "^(api|web|worker)$", literals: clientmodel.LabelValues{"api", "web", "worker"}},
+		{matchType: RegexMatch, expr: "^(a|b)-[12]$", literals: clientmodel.LabelValues{"a-1", "a-2", "b-1", "b-2"}},
+		{matchType: RegexMatch, expr: "api|web|worker"},
+		{matchType: RegexMatch, expr: "^prod-.*$"},
+		{matchType: RegexNoMatch, expr: "^(api|web)$"},
+	}
+
+	for i, s := range scenarios {
+		m, err := NewLabelMatcher(s.matchType, "job", clientmodel.LabelValue(s.expr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		literals, ok := m.Literals()
+		if ok != (s.literals != nil) {
+			t.Errorf("%d. expected literals %v, got %v", i, s.literals != nil, ok)
+			continue
+		}
+		sort.Sort(literals)
+		if !reflect.DeepEqual(literals, s.literals) {
+			t.Errorf("%d. expected %v, got %v", i, s.literals, literals)
+		}
+	}
+}
@@ -11,12 +11,14 @@ import (
 	"regexp"
 	"time"
 
-	"github.com/golang/glog"
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/utility"
 )
 
+var logger = log.New("storage")
+
 const (
 	putEndpoint     = "/api/put"
 	contentTypeJSON = "application/json"
@@ -28,15 +30,20 @@ var (
 
 // Client allows sending batches of Prometheus samples to OpenTSDB.
 type Client struct {
-	url        string
-	httpClient *http.Client
+	url string
+	// If not empty, the file to read the password of the user of url from
+	// before each request.
+	passwordFile string
+	httpClient   *http.Client
 }
 
-// NewClient creates a new Client.
-func NewClient(url string, timeout time.Duration) *Client {
+// NewClient creates a new Client. If passwordFile is not empty, the password
+// of the user of url is read from it before each request.
+func NewClient(url, passwordFile string, timeout time.Duration) *Client {
 	return &Client{
-		url:        url,
-		httpClient: utility.NewDeadlineClient(timeout),
+		url:          url,
+		passwordFile: passwordFile,
+		httpClient:   utility.NewDeadlineClient(timeout, nil),
 	}
 }
 
@@ -67,7 +74,7 @@ func (c *Client) Store(samples clientmodel.Samples) error {
 	for _, s := range samples {
 		v := float64(s.Value)
 		if math.IsNaN(v) || math.IsInf(v, 0) {
-			glog.Warningf("cannot send value %d to OpenTSDB, skipping sample %#v", v, s)
+			logger.Warnf("cannot send value %f to OpenTSDB, skipping sample %#v", v, s)
 			continue
 		}
 		metric := TagValue(s.Metric[clientmodel.MetricNameLabel])
@@ -79,7 +86,11 @@ func (c *Client) Store(samples clientmodel.Samples) error {
 		})
 	}
 
-	u, err := url.Parse(c.url)
+	rawURL, err := utility.URLWithPasswordFile(c.url, c.passwordFile)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(rawURL)
 	if err != nil {
 		return err
 	}
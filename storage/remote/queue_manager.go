@@ -14,14 +14,18 @@
 package remote
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
-
 	clientmodel "github.com/prometheus/client_golang/model"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/prometheus/log"
 )
 
+var logger = log.New("storage")
+
 const (
 	// The maximum number of concurrent send requests to the TSDB.
 	maxConcurrentSends = 10
@@ -30,6 +34,8 @@ const (
 	// The deadline after which to send queued samples even if the maximum batch
 	// size has not been reached.
 	batchSendDeadline = 5 * time.Second
+	// The interval at which samples buffered in the WAL are sent again.
+	walReplayInterval = 10 * time.Second
 )
 
 // String constants for instrumentation.
@@ -57,21 +63,35 @@ type TSDBQueueManager struct {
 	pendingSamples clientmodel.Samples
 	sendSemaphore  chan bool
 	drained        chan bool
+	wal            *WAL
+	// 1 if the last send succeeded, i.e. the TSDB is likely to accept
+	// samples buffered in the WAL. Accessed atomically.
+	lastSendOK int32
+	// 1 while the WAL is being replayed. Accessed atomically.
+	replaying int32
+	replayWG  sync.WaitGroup
+	stopping  chan struct{}
 
 	samplesCount  *prometheus.CounterVec
 	sendLatency   prometheus.Summary
 	sendErrors    prometheus.Counter
 	queueLength   prometheus.Gauge
 	queueCapacity prometheus.Metric
+	walSize       prometheus.Gauge
 }
 
-// NewTSDBQueueManager builds a new TSDBQueueManager.
-func NewTSDBQueueManager(tsdb TSDBClient, queueCapacity int) *TSDBQueueManager {
+// NewTSDBQueueManager builds a new TSDBQueueManager. If wal is not nil,
+// samples that cannot be sent or don't fit into the queue are buffered in it
+// and sent again once the TSDB accepts samples. Otherwise, they are dropped.
+func NewTSDBQueueManager(tsdb TSDBClient, queueCapacity int, wal *WAL) *TSDBQueueManager {
 	return &TSDBQueueManager{
 		tsdb:          tsdb,
 		queue:         make(chan clientmodel.Samples, queueCapacity),
 		sendSemaphore: make(chan bool, maxConcurrentSends),
 		drained:       make(chan bool),
+		stopping:      make(chan struct{}),
+		wal:           wal,
+		lastSendOK:    1,
 
 		samplesCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -109,11 +129,18 @@ func NewTSDBQueueManager(tsdb TSDBClient, queueCapacity int) *TSDBQueueManager {
 			prometheus.GaugeValue,
 			float64(queueCapacity),
 		),
+		walSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "wal_size_bytes",
+			Help:      "The size of the write-ahead log buffering samples that could not be sent to the remote TSDB.",
+		}),
 	}
 }
 
-// Queue queues a sample batch to be sent to the TSDB. It drops the most
-// recently queued samples on the floor if the queue is full.
+// Queue queues a sample batch to be sent to the TSDB. If the queue is full,
+// it buffers the samples in the WAL or, if that is not possible, drops them
+// on the floor.
 func (t *TSDBQueueManager) Queue(s clientmodel.Samples) {
 	if len(s) == 0 {
 		return
@@ -121,21 +148,33 @@ func (t *TSDBQueueManager) Queue(s clientmodel.Samples) {
 	select {
 	case t.queue <- s:
 	default:
+		if t.buffer(s) {
+			return
+		}
 		t.samplesCount.WithLabelValues(dropped).Add(float64(len(s)))
-		glog.Warningf("TSDB queue full, discarding %d samples", len(s))
+		logger.Warnf("TSDB queue full, discarding %d samples", len(s))
 	}
 }
 
 // Stop stops sending samples to the TSDB and waits for pending sends to
 // complete.
 func (t *TSDBQueueManager) Stop() {
-	glog.Infof("Stopping remote storage...")
+	logger.Infof("Stopping remote storage...")
 	close(t.queue)
 	<-t.drained
+	// Run has returned, so no further replay can start. Interrupt the
+	// running one before closing the WAL.
+	close(t.stopping)
+	t.replayWG.Wait()
 	for i := 0; i < maxConcurrentSends; i++ {
 		t.sendSemaphore <- true
 	}
-	glog.Info("Remote storage stopped.")
+	if t.wal != nil {
+		if err := t.wal.Close(); err != nil {
+			logger.Error("Error closing remote storage WAL: ", err)
+		}
+	}
+	logger.Info("Remote storage stopped.")
 }
 
 // Describe implements prometheus.Collector.
@@ -144,6 +183,9 @@ func (t *TSDBQueueManager) Describe(ch chan<- *prometheus.Desc) {
 	t.sendLatency.Describe(ch)
 	ch <- t.queueLength.Desc()
 	ch <- t.queueCapacity.Desc()
+	if t.wal != nil {
+		ch <- t.walSize.Desc()
+	}
 }
 
 // Collect implements prometheus.Collector.
@@ -153,28 +195,44 @@ func (t *TSDBQueueManager) Collect(ch chan<- prometheus.Metric) {
 	t.queueLength.Set(float64(len(t.queue)))
 	ch <- t.queueLength
 	ch <- t.queueCapacity
+	if t.wal != nil {
+		t.walSize.Set(float64(t.wal.Size()))
+		ch <- t.walSize
+	}
 }
 
 func (t *TSDBQueueManager) sendSamples(s clientmodel.Samples) {
+	// Samples are sent to the TSDB on a best-effort basis. If a sample isn't
+	// sent correctly the first time, it's buffered in the WAL if there is
+	// one, and simply dropped on the floor otherwise.
+	if err := t.store(s); err != nil {
+		t.buffer(s)
+	}
+}
+
+// store sends samples to the TSDB, waiting for a free send slot first.
+func (t *TSDBQueueManager) store(s clientmodel.Samples) error {
 	t.sendSemaphore <- true
 	defer func() {
 		<-t.sendSemaphore
 	}()
 
-	// Samples are sent to the TSDB on a best-effort basis. If a sample isn't
-	// sent correctly the first time, it's simply dropped on the floor.
 	begin := time.Now()
 	err := t.tsdb.Store(s)
 	duration := time.Since(begin) / time.Millisecond
 
 	labelValue := success
 	if err != nil {
-		glog.Warningf("error sending %d samples to TSDB: %s", len(s), err)
+		logger.Warnf("error sending %d samples to TSDB: %s", len(s), err)
 		labelValue = failure
 		t.sendErrors.Inc()
+		atomic.StoreInt32(&t.lastSendOK, 0)
+	} else {
+		atomic.StoreInt32(&t.lastSendOK, 1)
 	}
 	t.samplesCount.WithLabelValues(labelValue).Add(float64(len(s)))
 	t.sendLatency.Observe(float64(duration))
+	return err
 }
 
 // Run continuously sends samples to the TSDB.
@@ -183,15 +241,18 @@ func (t *TSDBQueueManager) Run() {
 		close(t.drained)
 	}()
 
+	replayTicker := time.NewTicker(walReplayInterval)
+	defer replayTicker.Stop()
+
 	// Send batches of at most maxSamplesPerSend samples to the TSDB. If we
 	// have fewer samples than that, flush them out after a deadline anyways.
 	for {
 		select {
 		case s, ok := <-t.queue:
 			if !ok {
-				glog.Infof("Flushing %d samples to OpenTSDB...", len(t.pendingSamples))
+				logger.Infof("Flushing %d samples to OpenTSDB...", len(t.pendingSamples))
 				t.flush()
-				glog.Infof("Done flushing.")
+				logger.Infof("Done flushing.")
 				return
 			}
 
@@ -203,6 +264,8 @@ func (t *TSDBQueueManager) Run() {
 			}
 		case <-time.After(batchSendDeadline):
 			t.flush()
+		case <-replayTicker.C:
+			t.startReplay()
 		}
 	}
 }
@@ -214,3 +277,71 @@ func (t *TSDBQueueManager) flush() {
 	}
 	t.pendingSamples = t.pendingSamples[:0]
 }
+
+// buffer appends samples to the WAL. It returns false if there is no WAL or
+// the samples could not be appended.
+func (t *TSDBQueueManager) buffer(s clientmodel.Samples) bool {
+	if t.wal == nil {
+		return false
+	}
+	if err := t.wal.Append(s); err != nil {
+		logger.Warnf("Error buffering %d samples in the remote storage WAL: %s", len(s), err)
+		return false
+	}
+	return true
+}
+
+// startReplay replays the WAL in the background unless a replay is already
+// running.
+func (t *TSDBQueueManager) startReplay() {
+	if !atomic.CompareAndSwapInt32(&t.replaying, 0, 1) {
+		return
+	}
+	t.replayWG.Add(1)
+	go func() {
+		defer t.replayWG.Done()
+		defer atomic.StoreInt32(&t.replaying, 0)
+		t.replayWAL()
+	}()
+}
+
+// replayWAL sends the samples buffered in the WAL again, unless the last send
+// failed. The samples are sent one batch at a time and only removed from the
+// WAL once sent. Replaying stops at the first failing send, once the WAL is
+// empty, or when the queue manager is stopped.
+func (t *TSDBQueueManager) replayWAL() {
+	if t.wal == nil || atomic.LoadInt32(&t.lastSendOK) == 0 {
+		return
+	}
+	sent := 0
+	defer func() {
+		if sent > 0 {
+			logger.Infof("Sent %d samples buffered in the remote storage WAL.", sent)
+		}
+	}()
+	for {
+		select {
+		case <-t.stopping:
+			return
+		default:
+		}
+		samples, offset, err := t.wal.ReadBatch(maxSamplesPerSend)
+		if err != nil {
+			logger.Error("Error reading remote storage WAL: ", err)
+			return
+		}
+		if len(samples) > 0 {
+			if err := t.store(samples); err != nil {
+				return
+			}
+			sent += len(samples)
+		}
+		if err := t.wal.Commit(offset); err != nil {
+			logger.Error("Error committing sent samples to the remote storage WAL: ", err)
+			return
+		}
+		if t.wal.Size() == 0 {
+			return
+		}
+	}
+}
@@ -63,7 +63,7 @@ func TestSampleDelivery(t *testing.T) {
 
 	c := &TestTSDBClient{}
 	c.expectSamples(samples[:len(samples)/2])
-	m := NewTSDBQueueManager(c, 1)
+	m := NewTSDBQueueManager(c, 1, nil)
 
 	// These should be received by the client.
 	m.Queue(samples[:len(samples)/2])
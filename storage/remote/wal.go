@@ -0,0 +1,236 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// errWALFull is returned when appending to a WAL would exceed its size limit.
+var errWALFull = errors.New("remote storage WAL full")
+
+// WAL is a write-ahead log buffering samples on disk that could not be sent
+// to the remote TSDB, so that they survive outages of the TSDB and restarts.
+// Each appended batch of samples is stored as a length-prefixed gob record.
+// Records are read in batches from the head of the WAL and only removed once
+// committed after they have been sent, so that a crash causes samples to be
+// sent twice rather than to be lost. Committed records are removed from the
+// file when it is closed or has grown large enough. WALs are safe for concurrent use.
+type WAL struct {
+	mtx      sync.Mutex
+	fileName string
+	f        *os.File
+	size     int64
+	// The offset of the first record not committed yet. The records before
+	// it are removed from the file by compaction.
+	head    int64
+	maxSize int64
+}
+
+// OpenWAL opens the WAL in the given file, creating the file if necessary.
+// Appending fails once the records not committed yet would take up more than
+// maxSize bytes. A torn record at the end of the file, as left behind by a
+// crash, is discarded.
+func OpenWAL(fileName string, maxSize int64) (*WAL, error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	size, err := validRecordsSize(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &WAL{fileName: fileName, f: f, size: size, maxSize: maxSize}, nil
+}
+
+// validRecordsSize returns the size of the complete records at the start of
+// the file.
+func validRecordsSize(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		offset int64
+		lenBuf = make([]byte, 4)
+	)
+	for {
+		if _, err := f.ReadAt(lenBuf, offset); err != nil {
+			break
+		}
+		end := offset + 4 + int64(binary.BigEndian.Uint32(lenBuf))
+		if end > fi.Size() {
+			break
+		}
+		offset = end
+	}
+	if offset < fi.Size() {
+		logger.Warn("Discarding torn record at the end of the remote storage WAL.")
+	}
+	return offset, nil
+}
+
+// Append adds a batch of samples to the end of the WAL.
+func (w *WAL) Append(s clientmodel.Samples) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4)) // Room for the length prefix.
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return err
+	}
+	rec := buf.Bytes()
+	binary.BigEndian.PutUint32(rec, uint32(len(rec)-4))
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.size-w.head+int64(len(rec)) > w.maxSize {
+		return errWALFull
+	}
+	if w.size+int64(len(rec)) > w.maxSize {
+		// Make room by removing the committed records.
+		if err := w.compact(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.f.Write(rec); err != nil {
+		// Cut off the partially written record.
+		w.f.Truncate(w.size)
+		w.f.Seek(w.size, os.SEEK_SET)
+		return err
+	}
+	w.size += int64(len(rec))
+	return nil
+}
+
+// Size returns the size in bytes of the records in the WAL that have not been
+// committed yet.
+func (w *WAL) Size() int64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.size - w.head
+}
+
+// ReadBatch returns the samples of the records following the head of the WAL,
+// reading whole records until at least n samples are read or the end of the
+// WAL is reached. The samples stay in the WAL until the returned offset is
+// passed to Commit. A record that cannot be decoded is skipped.
+func (w *WAL) ReadBatch(n int) (clientmodel.Samples, int64, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var (
+		samples clientmodel.Samples
+		offset  = w.head
+		lenBuf  = make([]byte, 4)
+	)
+	for offset < w.size && len(samples) < n {
+		if _, err := w.f.ReadAt(lenBuf, offset); err != nil {
+			return nil, 0, err
+		}
+		rec := make([]byte, binary.BigEndian.Uint32(lenBuf))
+		if _, err := w.f.ReadAt(rec, offset+4); err != nil {
+			return nil, 0, err
+		}
+		offset += 4 + int64(len(rec))
+		var s clientmodel.Samples
+		if err := gob.NewDecoder(bytes.NewReader(rec)).Decode(&s); err != nil {
+			logger.Warn("Skipping corrupted record in the remote storage WAL: ", err)
+			continue
+		}
+		samples = append(samples, s...)
+	}
+	return samples, offset, nil
+}
+
+// Commit removes the records before the given offset, as returned by
+// ReadBatch, from the WAL.
+func (w *WAL) Commit(offset int64) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if offset > w.head {
+		w.head = offset
+	}
+	// Compact once the committed records take up half of the file, so that
+	// a crash does not cause too many samples to be sent twice.
+	if w.head*2 < w.size {
+		return nil
+	}
+	return w.compact()
+}
+
+// compact removes the committed records from the file. The records not
+// committed yet are copied to a new file, which replaces the old one.
+func (w *WAL) compact() error {
+	if w.head == 0 {
+		return nil
+	}
+	if w.head == w.size {
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.f.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		w.head, w.size = 0, 0
+		return nil
+	}
+
+	tmpName := w.fileName + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(tmp, io.NewSectionReader(w.f, w.head, w.size-w.head))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpName, w.fileName)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	w.f.Close()
+	w.f, w.head, w.size = tmp, 0, size
+	return nil
+}
+
+// Close removes the committed records from the WAL file and closes it.
+func (w *WAL) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.compact(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,263 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func testSamples(n int) clientmodel.Samples {
+	samples := make(clientmodel.Samples, 0, n)
+	for i := 0; i < n; i++ {
+		samples = append(samples, &clientmodel.Sample{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "test_metric",
+				"instance":                  "localhost:9090",
+			},
+			Value:     clientmodel.SampleValue(i) / 4,
+			Timestamp: clientmodel.Timestamp(1000 * i),
+		})
+	}
+	return samples
+}
+
+func expectSamples(t *testing.T, got, want clientmodel.Samples) {
+	if len(got) != len(want) {
+		t.Fatalf("expected %d samples, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !want[i].Equal(got[i]) {
+			t.Fatalf("%d. expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote_wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "remote.wal")
+
+	w, err := OpenWAL(fileName, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := testSamples(10)
+	if err := w.Append(samples[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(samples[4:]); err != nil {
+		t.Fatal(err)
+	}
+	size := w.Size()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Buffered samples survive reopening, and a torn record is discarded.
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+	if w, err = OpenWAL(fileName, size+100); err != nil {
+		t.Fatal(err)
+	}
+	if w.Size() != size {
+		t.Fatalf("expected %d bytes after discarding torn record, got %d", size, w.Size())
+	}
+
+	// Batches consist of whole records, and stay in the WAL until committed.
+	got, offset, err := w.ReadBatch(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, got, samples[:4])
+	if got, _, err = w.ReadBatch(1); err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, got, samples[:4])
+	if err := w.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	if got, offset, err = w.ReadBatch(100); err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, got, samples[4:])
+
+	// Committed records are compacted away when making room for new ones.
+	if err := w.Append(samples[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = OpenWAL(fileName, size+100); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got, offset, err = w.ReadBatch(100); err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, got, append(samples[4:], samples[0]))
+
+	if err := w.Append(testSamples(1000)); err != errWALFull {
+		t.Fatalf("expected %q, got %v", errWALFull, err)
+	}
+	if err := w.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	if w.Size() != 0 {
+		t.Fatalf("expected empty WAL after committing, got %d bytes", w.Size())
+	}
+	if got, _, err := w.ReadBatch(100); err != nil || len(got) != 0 {
+		t.Fatalf("expected no samples in emptied WAL, got %v, %v", got, err)
+	}
+}
+
+type failingTSDBClient struct {
+	mtx      sync.Mutex
+	fail     bool
+	received clientmodel.Samples
+}
+
+func (c *failingTSDBClient) Store(s clientmodel.Samples) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.fail {
+		return errors.New("TSDB unavailable")
+	}
+	c.received = append(c.received, s...)
+	return nil
+}
+
+func TestSampleBuffering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote_wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := OpenWAL(filepath.Join(dir, "remote.wal"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	c := &failingTSDBClient{fail: true}
+	m := NewTSDBQueueManager(c, 1, w)
+	samples := testSamples(3)
+
+	m.sendSamples(samples[:2])
+	// The queue is empty, so this is queued rather than buffered.
+	m.Queue(samples[2:])
+	// The queue is full, so this is buffered.
+	m.Queue(samples[:1])
+	if w.Size() == 0 {
+		t.Fatal("expected failed and overflowing samples to be buffered")
+	}
+
+	// Nothing is replayed while the TSDB is failing.
+	m.replayWAL()
+	if w.Size() == 0 {
+		t.Fatal("expected samples to stay buffered after a failed send")
+	}
+
+	c.mtx.Lock()
+	c.fail = false
+	c.mtx.Unlock()
+	m.sendSamples(<-m.queue)
+	m.replayWAL()
+	if w.Size() != 0 {
+		t.Fatalf("expected empty WAL after replay, got %d bytes", w.Size())
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	expectSamples(t, c.received, clientmodel.Samples{samples[2], samples[0], samples[1], samples[0]})
+}
+
+// blockingTSDBClient blocks each Store call until released.
+type blockingTSDBClient struct {
+	started  chan bool
+	release  chan bool
+	received clientmodel.Samples
+}
+
+func (c *blockingTSDBClient) Store(s clientmodel.Samples) error {
+	c.started <- true
+	<-c.release
+	c.received = append(c.received, s...)
+	return nil
+}
+
+func TestStopDuringReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote_wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "remote.wal")
+	w, err := OpenWAL(fileName, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := testSamples(3 * maxSamplesPerSend)
+	for i := 0; i < len(samples); i += maxSamplesPerSend {
+		if err := w.Append(samples[i : i+maxSamplesPerSend]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &blockingTSDBClient{started: make(chan bool, 1), release: make(chan bool)}
+	m := NewTSDBQueueManager(c, 1, w)
+	go m.Run()
+	m.startReplay()
+	<-c.started
+
+	stopped := make(chan bool)
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	<-m.stopping
+	close(c.release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop didn't return during replay")
+	}
+
+	// The batch in flight was sent, the rest stays buffered.
+	expectSamples(t, c.received, samples[:maxSamplesPerSend])
+	if w, err = OpenWAL(fileName, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	got, _, err := w.ReadBatch(len(samples))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, got, samples[maxSamplesPerSend:])
+}
@@ -21,6 +21,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	html_template "html/template"
 	text_template "text/template"
@@ -139,86 +140,136 @@ func NewTemplateExpander(text string, name string, data interface{}, timestamp c
 				sort.Stable(sorter)
 				return v
 			},
-			"humanize": func(v float64) string {
-				if v == 0 {
+			"humanize":           humanize,
+			"humanize1024":       humanize1024,
+			"humanizeDuration":   humanizeDuration,
+			"humanizePercentage": humanizePercentage,
+			"humanizeUnit":       humanizeUnit,
+			"humanizeTimestamp": func(v float64) string {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
 					return fmt.Sprintf("%.4g", v)
 				}
-				if math.Abs(v) >= 1 {
-					prefix := ""
-					for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
-						if math.Abs(v) < 1000 {
-							break
-						}
-						prefix = p
-						v /= 1000
-					}
-					return fmt.Sprintf("%.4g%s", v, prefix)
-				}
-				prefix := ""
-				for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
-					if math.Abs(v) >= 1 {
-						break
-					}
-					prefix = p
-					v *= 1000
-				}
-				return fmt.Sprintf("%.4g%s", v, prefix)
-			},
-			"humanize1024": func(v float64) string {
-				if math.Abs(v) <= 1 {
-					return fmt.Sprintf("%.4g", v)
-				}
-				prefix := ""
-				for _, p := range []string{"ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"} {
-					if math.Abs(v) < 1024 {
-						break
-					}
-					prefix = p
-					v /= 1024
-				}
-				return fmt.Sprintf("%.4g%s", v, prefix)
-			},
-			"humanizeDuration": func(v float64) string {
-				if v == 0 {
-					return fmt.Sprintf("%.4gs", v)
-				}
-				if math.Abs(v) >= 1 {
-					sign := ""
-					if v < 0 {
-						sign = "-"
-						v = -v
-					}
-					seconds := int64(v) % 60
-					minutes := (int64(v) / 60) % 60
-					hours := (int64(v) / 60 / 60) % 24
-					days := (int64(v) / 60 / 60 / 24)
-					// For days to minutes, we display seconds as an integer.
-					if days != 0 {
-						return fmt.Sprintf("%s%dd %dh %dm %ds", sign, days, hours, minutes, seconds)
-					}
-					if hours != 0 {
-						return fmt.Sprintf("%s%dh %dm %ds", sign, hours, minutes, seconds)
-					}
-					if minutes != 0 {
-						return fmt.Sprintf("%s%dm %ds", sign, minutes, seconds)
-					}
-					// For seconds, we display 4 significant digts.
-					return fmt.Sprintf("%s%.4gs", sign, v)
-				}
-				prefix := ""
-				for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
-					if math.Abs(v) >= 1 {
-						break
-					}
-					prefix = p
-					v *= 1000
-				}
-				return fmt.Sprintf("%.4g%ss", v, prefix)
+				sec, frac := math.Modf(v)
+				return time.Unix(int64(sec), int64(frac*1e9)).UTC().String()
 			},
 		},
 	}
 }
 
+// humanize formats v with an SI prefix, e.g. 1.5k for 1500.
+func humanize(v float64) string {
+	if v == 0 {
+		return fmt.Sprintf("%.4g", v)
+	}
+	if math.Abs(v) >= 1 {
+		prefix := ""
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(v) < 1000 {
+				break
+			}
+			prefix = p
+			v /= 1000
+		}
+		return fmt.Sprintf("%.4g%s", v, prefix)
+	}
+	prefix := ""
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix)
+}
+
+// humanize1024 formats v with a binary prefix, e.g. 1.5ki for 1536.
+func humanize1024(v float64) string {
+	if math.Abs(v) <= 1 {
+		return fmt.Sprintf("%.4g", v)
+	}
+	prefix := ""
+	for _, p := range []string{"ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"} {
+		if math.Abs(v) < 1024 {
+			break
+		}
+		prefix = p
+		v /= 1024
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix)
+}
+
+// humanizeDuration formats v seconds as a duration, e.g. 1m 30s for 90.
+func humanizeDuration(v float64) string {
+	if v == 0 {
+		return fmt.Sprintf("%.4gs", v)
+	}
+	if math.Abs(v) >= 1 {
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+		seconds := int64(v) % 60
+		minutes := (int64(v) / 60) % 60
+		hours := (int64(v) / 60 / 60) % 24
+		days := (int64(v) / 60 / 60 / 24)
+		// For days to minutes, we display seconds as an integer.
+		if days != 0 {
+			return fmt.Sprintf("%s%dd %dh %dm %ds", sign, days, hours, minutes, seconds)
+		}
+		if hours != 0 {
+			return fmt.Sprintf("%s%dh %dm %ds", sign, hours, minutes, seconds)
+		}
+		if minutes != 0 {
+			return fmt.Sprintf("%s%dm %ds", sign, minutes, seconds)
+		}
+		// For seconds, we display 4 significant digts.
+		return fmt.Sprintf("%s%.4gs", sign, v)
+	}
+	prefix := ""
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%ss", v, prefix)
+}
+
+// humanizePercentage formats the ratio v as a percentage, e.g. 50% for 0.5.
+func humanizePercentage(v float64) string {
+	return fmt.Sprintf("%.4g%%", v*100)
+}
+
+// humanizeUnit formats v according to the unit of the metric it is a value
+// of, as exposed by the targets in the UNIT metadata of the metric. Durations,
+// sizes, and ratios are formatted like humanizeDuration, humanize1024 with a
+// trailing B, and humanizePercentage. Values of other units are formatted
+// like humanize followed by the unit.
+func humanizeUnit(unit string, v float64) string {
+	switch unit {
+	case "":
+		return humanize(v)
+	case "seconds":
+		return humanizeDuration(v)
+	case "bytes":
+		return humanize1024(v) + "B"
+	case "ratio":
+		return humanizePercentage(v)
+	}
+	return humanize(v) + " " + unit
+}
+
+// Funcs adds the functions in the given map to the functions available to the
+// template, overriding existing functions of the same name.
+func (te templateExpander) Funcs(fm text_template.FuncMap) {
+	for k, v := range fm {
+		te.funcMap[k] = v
+	}
+}
+
 // Expand a template.
 func (te templateExpander) Expand() (result string, resultErr error) {
 	// It'd better to have no alert description than to kill the whole process
@@ -14,6 +14,7 @@
 package templates
 
 import (
+	"math"
 	"testing"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -122,6 +123,23 @@ func TestTemplateExpansion(t *testing.T) {
 			input:  []float64{.1, .0001, .12345, 60.1, 60.5, 1.2345, 12.345},
 			output: "100ms:100us:123.5ms:1m 0s:1m 0s:1.234s:12.35s:",
 		},
+		{
+			// HumanizePercentage.
+			text:   "{{ range . }}{{ humanizePercentage . }}:{{ end }}",
+			input:  []float64{0, 0.1234567, 1, 1.5},
+			output: "0%:12.35%:100%:150%:",
+		},
+		{
+			// HumanizeUnit.
+			text:   "{{ humanizeUnit \"\" 1500 }}:{{ humanizeUnit \"seconds\" 90 }}:{{ humanizeUnit \"bytes\" 1048576 }}:{{ humanizeUnit \"ratio\" 0.5 }}:{{ humanizeUnit \"celsius\" 21.5 }}",
+			output: "1.5k:1m 30s:1MiB:50%:21.5 celsius",
+		},
+		{
+			// HumanizeTimestamp.
+			text:   "{{ range . }}{{ humanizeTimestamp . }}:{{ end }}",
+			input:  []float64{0, 1234567890.5, math.Inf(1)},
+			output: "1970-01-01 00:00:00 +0000 UTC:2009-02-13 23:31:30.5 +0000 UTC:+Inf:",
+		},
 		{
 			// Title.
 			text:   "{{ \"aa bb CC\" | title }}",
@@ -13,30 +13,99 @@
 
 // Rule-Checker allows checking the validity of a Prometheus rule file. It
 // prints an error if the specified rule file is invalid, while it prints a
-// string representation of the parsed rules otherwise.
+// string representation of the parsed rules otherwise. With -lint, it warns
+// about common anti-patterns in the rules, or in a single expression given
+// by -expr, instead. With -test-fire, it asks a running Prometheus server to
+// send a firing test alert of one of its alerting rules to the alert manager.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
-	"github.com/golang/glog"
-
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+)
+
+var logger = log.New("main")
+
+var (
+	ruleFile       = flag.String("rule-file", "", "The path to the rule file to check.")
+	lint           = flag.Bool("lint", false, "Warn about common anti-patterns in the rules. Exits with status 1 if there are any.")
+	expr           = flag.String("expr", "", "An expression to lint instead of a rule file. Implies -lint.")
+	scrapeInterval = flag.Duration("scrape-interval", 0, "The longest scrape interval of the series queried, to warn about rate() ranges shorter than two scrape intervals. 0 disables the check.")
+
+	testFire      = flag.String("test-fire", "", "The name of an alerting rule of the Prometheus server at -server to send a firing test alert of to the alert manager, instead of checking a rule file.")
+	server        = flag.String("server", "http://localhost:9090/", "The URL of the Prometheus server for -test-fire.")
+	adminAPIToken = flag.String("admin-api-token", "", "The administrative API token of the Prometheus server for -test-fire.")
+	testValue     = flag.Float64("value", 1, "The value of the test alert for -test-fire.")
+	testLabels    labelFlags
 )
 
-var ruleFile = flag.String("rule-file", "", "The path to the rule file to check.")
+func init() {
+	flag.Var(&testLabels, "label", "A label of the test alert for -test-fire in the form <name>=<value>. May be repeated.")
+}
+
+// labelFlags collects the values of a repeatable flag.
+type labelFlags []string
+
+func (l *labelFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labelFlags) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
 
 func main() {
 	flag.Parse()
 
+	if *testFire != "" {
+		if err := testFireAlert(); err != nil {
+			logger.Fatalf("Error test-firing alert %s: %s", *testFire, err)
+		}
+		return
+	}
+
+	if *expr != "" {
+		node, err := rules.LoadExprFromString(*expr)
+		if err != nil {
+			logger.Fatalf("Error parsing expression: %s", err)
+		}
+		if printLintWarnings("", ast.Lint(node, lintOptions())) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *ruleFile == "" {
-		glog.Fatal("Must provide a rule file path")
+		logger.Fatal("Must provide a rule file path")
 	}
 
 	rules, err := rules.LoadRulesFromFile(*ruleFile)
 	if err != nil {
-		glog.Fatalf("Error loading rule file %s: %s", *ruleFile, err)
+		logger.Fatalf("Error loading rule file %s: %s", *ruleFile, err)
+	}
+
+	if *lint {
+		found := false
+		for _, rule := range rules {
+			if printLintWarnings(*ruleFile+": "+rule.Name(), ast.Lint(rule.Expr(), lintOptions())) {
+				found = true
+			}
+		}
+		if found {
+			os.Exit(1)
+		}
+		return
 	}
 
 	fmt.Printf("Successfully loaded %d rules:\n\n", len(rules))
@@ -45,3 +114,61 @@ func main() {
 		fmt.Println(rule)
 	}
 }
+
+// lintOptions returns the options of the linter. Without target metadata,
+// counters are only recognized by their name.
+func lintOptions() ast.LintOptions {
+	return ast.LintOptions{ScrapeInterval: *scrapeInterval}
+}
+
+// printLintWarnings prints the given warnings, prefixed with the given
+// source if not empty. It returns whether there were any.
+func printLintWarnings(source string, warnings []ast.LintWarning) bool {
+	for _, w := range warnings {
+		if source != "" {
+			fmt.Printf("%s: ", source)
+		}
+		fmt.Printf("%s: %s\n", w.Expr, w.Message)
+	}
+	return len(warnings) > 0
+}
+
+// testFireAlert asks the server to send a firing test alert of the alerting
+// rule named by -test-fire and prints the notification sent.
+func testFireAlert() error {
+	form := url.Values{
+		"alertname": {*testFire},
+		"label":     testLabels,
+		"value":     {strconv.FormatFloat(*testValue, 'g', -1, 64)},
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(*server, "/")+"/api/v1/admin/alerts/test_fire", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+*adminAPIToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Data   struct {
+			Summary     string            `json:"summary"`
+			Description string            `json:"description"`
+			Labels      map[string]string `json:"labels"`
+		} `json:"data"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	if result.Status != "success" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	fmt.Printf("Sent notification with labels %v:\n\n%s\n\n%s\n", result.Data.Labels, result.Data.Summary, result.Data.Description)
+	return nil
+}
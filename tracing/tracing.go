@@ -0,0 +1,318 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing records trace spans of queries, rule evaluations, and
+// scrapes and sends them to a collector accepting the Zipkin v2 JSON format.
+//
+// Tracing is disabled until a Reporter is installed with SetReporter. While
+// it is disabled, or for traces that are not sampled, StartSpan returns a nil
+// *Span. All methods of Span are no-ops on a nil *Span, so callers never have
+// to check whether tracing is enabled.
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/prometheus/log"
+	"github.com/prometheus/prometheus/utility"
+)
+
+var logger = log.New("tracing")
+
+const (
+	serviceName     = "prometheus"
+	contentTypeJSON = "application/json"
+
+	// The maximum number of spans sent in a single request.
+	maxBatchSize = 100
+	// The interval after which incomplete batches are sent.
+	flushInterval = time.Second
+)
+
+// String constants for instrumentation.
+const (
+	namespace = "prometheus"
+	subsystem = "tracing"
+)
+
+var (
+	mtx      sync.RWMutex
+	reporter *Reporter
+)
+
+// SetReporter installs the reporter finished spans are sent to. A nil
+// reporter disables tracing.
+func SetReporter(r *Reporter) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	reporter = r
+}
+
+// A Span times a single operation of a trace. Spans are not safe for
+// concurrent use, but children may be started from different goroutines.
+type Span struct {
+	reporter              *Reporter
+	traceID, id, parentID uint64
+	name                  string
+	start                 time.Time
+	tags                  map[string]string
+}
+
+// StartSpan starts the root span of a new trace. It returns nil if tracing is
+// disabled or the trace is not sampled.
+func StartSpan(name string) *Span {
+	mtx.RLock()
+	r := reporter
+	mtx.RUnlock()
+	if r == nil || rand.Float64() >= r.sampleRatio {
+		return nil
+	}
+	id := uint64(rand.Int63())
+	return &Span{
+		reporter: r,
+		traceID:  id,
+		id:       id,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+// StartChild starts a span of the same trace with s as its parent.
+func (s *Span) StartChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{
+		reporter: s.reporter,
+		traceID:  s.traceID,
+		id:       uint64(rand.Int63()),
+		parentID: s.id,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+// SetTag annotates the span with a key/value pair.
+func (s *Span) SetTag(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.tags == nil {
+		s.tags = map[string]string{}
+	}
+	s.tags[key] = value
+}
+
+// SetError marks the span as failed with the given error. A nil error is
+// ignored.
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetTag("error", err.Error())
+	}
+}
+
+// Finish ends the span and queues it for sending. The span must not be used
+// afterwards.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	zs := &zipkinSpan{
+		TraceID:       fmt.Sprintf("%016x", s.traceID),
+		ID:            fmt.Sprintf("%016x", s.id),
+		Name:          s.name,
+		Timestamp:     s.start.UnixNano() / int64(time.Microsecond),
+		Duration:      int64(time.Since(s.start) / time.Microsecond),
+		LocalEndpoint: endpoint{ServiceName: serviceName},
+		Tags:          s.tags,
+	}
+	if s.parentID != 0 {
+		zs.ParentID = fmt.Sprintf("%016x", s.parentID)
+	}
+	s.reporter.report(zs)
+}
+
+// zipkinSpan is a span in the Zipkin v2 JSON format. Times are in
+// microseconds.
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint endpoint          `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type endpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type httpPoster interface {
+	Post(url string, bodyType string, body io.Reader) (*http.Response, error)
+}
+
+// Reporter sends finished spans in batches to a collector.
+type Reporter struct {
+	// The URL spans are posted to, e.g. http://localhost:9411/api/v2/spans.
+	collectorURL string
+	// The fraction of traces that are sampled.
+	sampleRatio float64
+	// Buffer of spans that have not yet been sent.
+	pendingSpans chan *zipkinSpan
+	httpClient   httpPoster
+
+	sentSpans    prometheus.Counter
+	failedSpans  prometheus.Counter
+	droppedSpans prometheus.Counter
+
+	mtx     sync.RWMutex // Protects closed.
+	closed  bool
+	stopped chan struct{}
+}
+
+// NewReporter constructs a new Reporter sampling the given fraction of traces.
+// Spans finished while queueCapacity spans are waiting to be sent are dropped.
+func NewReporter(collectorURL string, sampleRatio float64, queueCapacity int, deadline time.Duration) *Reporter {
+	return &Reporter{
+		collectorURL: collectorURL,
+		sampleRatio:  sampleRatio,
+		pendingSpans: make(chan *zipkinSpan, queueCapacity),
+		httpClient:   utility.NewDeadlineClient(deadline, nil),
+
+		sentSpans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sent_spans_total",
+			Help:      "Total number of trace spans sent to the collector.",
+		}),
+		failedSpans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "failed_spans_total",
+			Help:      "Total number of trace spans that could not be sent to the collector.",
+		}),
+		droppedSpans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dropped_spans_total",
+			Help:      "Total number of trace spans dropped because the queue was full or the reporter was stopped.",
+		}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (r *Reporter) report(s *zipkinSpan) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if r.closed {
+		r.droppedSpans.Inc()
+		return
+	}
+	select {
+	case r.pendingSpans <- s:
+	default:
+		r.droppedSpans.Inc()
+	}
+}
+
+// Run sends queued spans continuously until Stop is called.
+func (r *Reporter) Run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*zipkinSpan, 0, maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.send(batch); err != nil {
+			logger.Warn("Error sending trace spans: ", err)
+			r.failedSpans.Add(float64(len(batch)))
+		} else {
+			r.sentSpans.Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s, ok := <-r.pendingSpans:
+			if !ok {
+				flush()
+				close(r.stopped)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (r *Reporter) send(spans []*zipkinSpan) error {
+	buf, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+	resp, err := r.httpClient.Post(r.collectorURL, contentTypeJSON, bytes.NewBuffer(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// Stop sends the remaining queued spans and shuts down the reporter. Spans
+// finished afterwards are dropped.
+func (r *Reporter) Stop() {
+	logger.Info("Stopping trace reporter...")
+	r.mtx.Lock()
+	r.closed = true
+	close(r.pendingSpans)
+	r.mtx.Unlock()
+	<-r.stopped
+	logger.Info("Trace reporter stopped.")
+}
+
+// Describe implements prometheus.Collector.
+func (r *Reporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.sentSpans.Desc()
+	ch <- r.failedSpans.Desc()
+	ch <- r.droppedSpans.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (r *Reporter) Collect(ch chan<- prometheus.Metric) {
+	ch <- r.sentSpans
+	ch <- r.failedSpans
+	ch <- r.droppedSpans
+}
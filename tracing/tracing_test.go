@@ -0,0 +1,101 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDisabledTracing(t *testing.T) {
+	SetReporter(nil)
+	span := StartSpan("query")
+	if span != nil {
+		t.Fatalf("expected no span with tracing disabled, got %v", span)
+	}
+	// None of these may panic.
+	child := span.StartChild("parse")
+	child.SetTag("key", "value")
+	child.SetError(errors.New("failed"))
+	child.Finish()
+	span.Finish()
+
+	SetReporter(NewReporter("", 0, 10, time.Second))
+	defer SetReporter(nil)
+	if span := StartSpan("query"); span != nil {
+		t.Fatalf("expected no span with a sample ratio of 0, got %v", span)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	received := make(chan []zipkinSpan, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != contentTypeJSON {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		var spans []zipkinSpan
+		if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+			t.Error(err)
+		}
+		received <- spans
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	r := NewReporter(server.URL, 1, 10, time.Second)
+	SetReporter(r)
+	defer SetReporter(nil)
+	go r.Run()
+
+	root := StartSpan("query")
+	root.SetTag("query", "up")
+	child := root.StartChild("parse")
+	child.SetError(errors.New("parse error"))
+	child.Finish()
+	root.Finish()
+	r.Stop()
+
+	// Finishing spans after stopping must not panic.
+	StartSpan("late").Finish()
+
+	var spans []zipkinSpan
+	select {
+	case spans = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans received")
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.Name != "parse" || p.Name != "query" {
+		t.Fatalf("unexpected span names %q and %q", c.Name, p.Name)
+	}
+	if c.TraceID != p.TraceID || c.ParentID != p.ID || p.ParentID != "" || len(p.ID) != 16 {
+		t.Errorf("unexpected span IDs: child %+v, parent %+v", c, p)
+	}
+	if c.Tags["error"] != "parse error" || p.Tags["query"] != "up" {
+		t.Errorf("unexpected tags: child %v, parent %v", c.Tags, p.Tags)
+	}
+	if p.LocalEndpoint.ServiceName != serviceName {
+		t.Errorf("expected service name %q, got %q", serviceName, p.LocalEndpoint.ServiceName)
+	}
+	if c.Timestamp < p.Timestamp || c.Timestamp+c.Duration > p.Timestamp+p.Duration+1 {
+		t.Errorf("child span %+v does not fall within parent span %+v", c, p)
+	}
+}
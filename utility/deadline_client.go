@@ -16,14 +16,21 @@ package utility
 import (
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 // NewDeadlineClient returns a new http.Client which will time out long running
-// requests.
-func NewDeadlineClient(timeout time.Duration) *http.Client {
+// requests. If proxyURL is not nil, all requests are sent through that proxy.
+// User information contained in proxyURL is used for proxy authentication.
+func NewDeadlineClient(timeout time.Duration, proxyURL *url.URL) *http.Client {
+	var proxy func(*http.Request) (*url.URL, error)
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
 	return &http.Client{
 		Transport: &http.Transport{
+			Proxy: proxy,
 			// We need to disable keepalive, because we set a deadline on the
 			// underlying connection.
 			DisableKeepAlives: true,
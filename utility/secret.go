@@ -0,0 +1,55 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utility
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// ReadSecretFile returns the contents of a file containing a secret without
+// trailing newlines.
+func ReadSecretFile(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// URLWithPasswordFile returns the URL with the password of its user replaced
+// by the contents of the given password file, which is read on each call so
+// that rotated passwords are picked up. If passwordFile is empty, the URL is
+// returned unchanged.
+func URLWithPasswordFile(rawURL, passwordFile string) (string, error) {
+	if passwordFile == "" {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	password, err := ReadSecretFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %s", err)
+	}
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String(), nil
+}
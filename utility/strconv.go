@@ -20,7 +20,7 @@ import (
 	"time"
 )
 
-var durationRE = regexp.MustCompile("^([0-9]+)([ywdhms]+)$")
+var durationRE = regexp.MustCompile("^([0-9]+)([ywdhms])$")
 
 // DurationToString formats a time.Duration as a string with the assumption that
 // a year always has 365 days and a day always has 24h. (The former doesn't work
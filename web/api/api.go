@@ -15,8 +15,8 @@ package api
 
 import (
 	"net/http"
-
-	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"sync"
 
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/retrieval"
@@ -28,9 +28,21 @@ import (
 // MetricsService manages the /api HTTP endpoint.
 type MetricsService struct {
 	time          utility.Time
+	mtx           sync.RWMutex // Protects Config.
 	Config        *config.Config
 	TargetManager retrieval.TargetManager
 	Storage       local.Storage
+	// Cross-origin requests are allowed from origins matching CORSOrigin.
+	// If nil, they are not allowed.
+	CORSOrigin *regexp.Regexp
+}
+
+// ApplyConfig replaces the configuration the jobs of new targets are looked up
+// in.
+func (msrv *MetricsService) ApplyConfig(conf config.Config) {
+	msrv.mtx.Lock()
+	defer msrv.mtx.Unlock()
+	msrv.Config = &conf
 }
 
 // RegisterHandler registers the handler for the various endpoints below /api.
@@ -40,16 +52,16 @@ func (msrv *MetricsService) RegisterHandler() {
 			Handler: http.HandlerFunc(h),
 		}
 	}
-	http.Handle("/api/query", prometheus.InstrumentHandler(
+	http.Handle("/api/query", httputils.InstrumentHandler(
 		"/api/query", handler(msrv.Query),
 	))
-	http.Handle("/api/query_range", prometheus.InstrumentHandler(
+	http.Handle("/api/query_range", httputils.InstrumentHandler(
 		"/api/query_range", handler(msrv.QueryRange),
 	))
-	http.Handle("/api/metrics", prometheus.InstrumentHandler(
+	http.Handle("/api/metrics", httputils.InstrumentHandler(
 		"/api/metrics", handler(msrv.Metrics),
 	))
-	http.Handle("/api/targets", prometheus.InstrumentHandler(
+	http.Handle("/api/targets", httputils.InstrumentHandler(
 		"/api/targets", handler(msrv.SetTargets),
 	))
 }
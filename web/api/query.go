@@ -22,27 +22,26 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/golang/glog"
-
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/log"
 	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/rules/ast"
 	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/tracing"
 	"github.com/prometheus/prometheus/web/httputils"
 )
 
-// Enables cross-site script calls.
-func setAccessControlHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Origin")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Date")
+var logger = log.New("web")
+
+// Enables cross-site script calls from the allowed origins.
+func (serv *MetricsService) setAccessControlHeaders(w http.ResponseWriter, r *http.Request) {
+	httputils.SetCORS(w, serv.CORSOrigin, r)
 }
 
 // Query handles the /api/query endpoint.
-func (serv MetricsService) Query(w http.ResponseWriter, r *http.Request) {
-	setAccessControlHeaders(w)
+func (serv *MetricsService) Query(w http.ResponseWriter, r *http.Request) {
+	serv.setAccessControlHeaders(w, r)
 
 	params := httputils.GetQueryParams(r)
 	expr := params.Get("expr")
@@ -58,7 +57,11 @@ func (serv MetricsService) Query(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 	}
 
-	exprNode, err := rules.LoadExprFromString(expr)
+	span := tracing.StartSpan("instant query")
+	span.SetTag("query", expr)
+	defer span.Finish()
+
+	exprNode, err := parseExpr(expr, span)
 	if err != nil {
 		fmt.Fprint(w, ast.ErrorToJSON(err))
 		return
@@ -66,14 +69,15 @@ func (serv MetricsService) Query(w http.ResponseWriter, r *http.Request) {
 
 	timestamp := clientmodel.TimestampFromTime(serv.time.Now())
 	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(span)
 	result := ast.EvalToString(exprNode, timestamp, format, serv.Storage, queryStats)
-	glog.V(1).Infof("Instant query: %s\nQuery stats:\n%s\n", expr, queryStats)
+	logger.Debugf("Instant query: %s\nQuery stats:\n%s\n", expr, queryStats)
 	fmt.Fprint(w, result)
 }
 
 // QueryRange handles the /api/query_range endpoint.
-func (serv MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
-	setAccessControlHeaders(w)
+func (serv *MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
+	serv.setAccessControlHeaders(w, r)
 	w.Header().Set("Content-Type", "application/json")
 
 	params := httputils.GetQueryParams(r)
@@ -88,7 +92,11 @@ func (serv MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
 	duration := int64(durationFloat) * nanosPerSecond
 	step := int64(stepFloat) * nanosPerSecond
 
-	exprNode, err := rules.LoadExprFromString(expr)
+	span := tracing.StartSpan("range query")
+	span.SetTag("query", expr)
+	defer span.Finish()
+
+	exprNode, err := parseExpr(expr, span)
 	if err != nil {
 		fmt.Fprint(w, ast.ErrorToJSON(err))
 		return
@@ -121,6 +129,7 @@ func (serv MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
 	end -= end % step
 
 	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(span)
 
 	matrix, err := ast.EvalVectorRange(
 		exprNode.(ast.VectorNode),
@@ -130,6 +139,7 @@ func (serv MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
 		serv.Storage,
 		queryStats)
 	if err != nil {
+		span.SetError(err)
 		fmt.Fprint(w, ast.ErrorToJSON(err))
 		return
 	}
@@ -142,19 +152,28 @@ func (serv MetricsService) QueryRange(w http.ResponseWriter, r *http.Request) {
 	result := ast.TypedValueToJSON(matrix, "matrix")
 	jsonTimer.Stop()
 
-	glog.V(1).Infof("Range query: %s\nQuery stats:\n%s\n", expr, queryStats)
+	logger.Debugf("Range query: %s\nQuery stats:\n%s\n", expr, queryStats)
 	fmt.Fprint(w, result)
 }
 
+// parseExpr parses an expression, tracing it as a child of span.
+func parseExpr(expr string, span *tracing.Span) (ast.Node, error) {
+	parseSpan := span.StartChild("parse")
+	defer parseSpan.Finish()
+	exprNode, err := rules.LoadExprFromString(expr)
+	parseSpan.SetError(err)
+	return exprNode, err
+}
+
 // Metrics handles the /api/metrics endpoint.
-func (serv MetricsService) Metrics(w http.ResponseWriter, r *http.Request) {
-	setAccessControlHeaders(w)
+func (serv *MetricsService) Metrics(w http.ResponseWriter, r *http.Request) {
+	serv.setAccessControlHeaders(w, r)
 
 	metricNames := serv.Storage.GetLabelValuesForLabelName(clientmodel.MetricNameLabel)
 	sort.Sort(metricNames)
 	resultBytes, err := json.Marshal(metricNames)
 	if err != nil {
-		glog.Error("Error marshalling metric names: ", err)
+		logger.Error("Error marshalling metric names: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
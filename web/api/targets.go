@@ -31,7 +31,7 @@ type TargetGroup struct {
 }
 
 // SetTargets handles the /api/targets endpoint.
-func (serv MetricsService) SetTargets(w http.ResponseWriter, r *http.Request) {
+func (serv *MetricsService) SetTargets(w http.ResponseWriter, r *http.Request) {
 	params := httputils.GetQueryParams(r)
 	jobName := params.Get("job")
 
@@ -43,13 +43,16 @@ func (serv MetricsService) SetTargets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	serv.mtx.RLock()
 	job := serv.Config.GetJobByName(jobName)
+	serv.mtx.RUnlock()
 	if job == nil {
 		http.Error(w, "job not found", http.StatusNotFound)
 		return
 	}
 
 	newTargets := []retrieval.Target{}
+	options := serv.TargetManager.TargetOptions(*job)
 
 	for _, targetGroup := range targetGroups {
 		// Do mandatory map type conversion due to Go shortcomings.
@@ -61,7 +64,12 @@ func (serv MetricsService) SetTargets(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, endpoint := range targetGroup.Endpoints {
-			newTarget := retrieval.NewTarget(endpoint, job.ScrapeTimeout(), baseLabels)
+			u, err := job.TargetURL(endpoint)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			newTarget := retrieval.NewTarget(u, options, baseLabels)
 			newTargets = append(newTargets, newTarget)
 		}
 	}
@@ -0,0 +1,1176 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1 implements the stable, versioned JSON HTTP API of Prometheus.
+// Every response is wrapped into an envelope stating whether the request
+// succeeded. Failed requests carry an error type and message.
+package v1
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/extraction"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
+	"github.com/prometheus/prometheus/retrieval"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/rules/manager"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/storage/metric"
+	"github.com/prometheus/prometheus/tracing"
+	"github.com/prometheus/prometheus/utility"
+	"github.com/prometheus/prometheus/web/httputils"
+)
+
+var logger = log.New("web")
+
+type status string
+
+const (
+	statusSuccess status = "success"
+	statusError   status = "error"
+)
+
+type errorType string
+
+const (
+	errorBadData     errorType = "bad_data"
+	errorExec        errorType = "execution"
+	errorTimeout     errorType = "timeout"
+	errorInternal    errorType = "internal"
+	errorUnavailable errorType = "unavailable"
+)
+
+// maxPointsPerSeries is the default limit of the number of points per series
+// a range query may return. This is sufficient for 60s resolution for a week
+// or 1h resolution for a year.
+const maxPointsPerSeries = 11000
+
+type apiError struct {
+	typ errorType
+	err error
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.typ, e.err)
+}
+
+type response struct {
+	Status    status      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType errorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// apiFunc handles an API request. It returns either the data to respond with
+// or an error.
+type apiFunc func(r *http.Request) (interface{}, *apiError)
+
+// API serves the endpoints of the v1 HTTP API.
+type API struct {
+	Storage       local.Storage
+	TargetManager retrieval.TargetManager
+	// Cross-origin requests are allowed from origins matching CORSOrigin.
+	// If nil, they are not allowed.
+	CORSOrigin *regexp.Regexp
+	// The interval at which query subscriptions receive new results unless
+	// they request a different one.
+	EvaluationInterval time.Duration
+	// If not nil, range queries reuse the results of previous queries.
+	QueryCache *QueryCache
+	// Receives the samples pushed to the push endpoint.
+	PushIngester extraction.Ingester
+	// The rule manager whose rules candidate rules are compared against by
+	// the rule preview endpoint. If nil, no rules are loaded.
+	RuleManager manager.RuleManager
+	// The block profile rate set at startup. It is tracked here as the
+	// runtime does not report it.
+	BlockProfileRate int
+	// The header of requests naming the tenant whose series and targets the
+	// query, series, label, rule preview, series inspection, target, and
+	// metadata endpoints are restricted to, i.e. those with the tenant label
+	// set to it. Samples pushed to the push endpoint get the tenant label set
+	// to it. If empty, requests are not restricted.
+	TenantHeader string
+	// If true, requests to the restricted endpoints without a tenant are
+	// rejected.
+	RequireTenant bool
+	// The number of points per series range queries may return at most. If
+	// 0, maxPointsPerSeries applies.
+	MaxPointsPerSeries int
+
+	// Information about the running server served by the status endpoints.
+	// Secrets have to be masked in Config and Flags.
+	mtx       sync.RWMutex // Protects Config, ScrapeInterval, and BlockProfileRate.
+	Config    string
+	Flags     map[string]string
+	BuildInfo map[string]string
+	Birth     time.Time
+	// The longest scrape interval of all jobs, against which the linter
+	// checks the ranges of rate() and similar functions.
+	ScrapeInterval time.Duration
+}
+
+// ApplyConfig updates the configuration served by the status endpoints.
+func (api *API) ApplyConfig(conf config.Config) {
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	api.Config = conf.MaskedString()
+	api.ScrapeInterval = conf.MaxScrapeInterval()
+}
+
+// RegisterHandler registers the handlers for all endpoints of the API.
+func (api *API) RegisterHandler() {
+	handle := func(path string, f apiFunc) {
+		http.Handle(path, httputils.InstrumentHandler(
+			path, httputils.CompressionHandler{Handler: corsHandler(api.CORSOrigin, apiHandler(f))},
+		))
+	}
+	handle("/api/v1/targets", api.targets)
+	handle("/api/v1/targets/metadata", api.targetMetadata)
+	handle("/api/v1/metadata", api.metricMetadata)
+	handle("/api/v1/status/config", api.statusConfig)
+	handle("/api/v1/status/flags", api.statusFlags)
+	handle("/api/v1/status/buildinfo", api.statusBuildInfo)
+	handle("/api/v1/status/runtimeinfo", api.statusRuntimeInfo)
+	handle("/api/v1/lint", api.lint)
+
+	// Without a local storage, as in agent mode, there is nothing to query.
+	if api.Storage == nil {
+		return
+	}
+	handle("/api/v1/query", api.query)
+	handle("/api/v1/query_range", api.queryRange)
+	handle("/api/v1/series", api.series)
+	handle("/api/v1/labels", api.labelNames)
+	handle("/api/v1/label/", api.labelValues)
+	handle("/api/v1/rules/preview", api.previewRules)
+	handle("/api/v1/debug/series", api.inspectSeries)
+
+	// Subscriptions are neither compressed nor instrumented. Both would
+	// buffer the events, and their durations would skew the request latency
+	// summaries.
+	http.Handle("/api/v1/query_stream", corsHandler(api.CORSOrigin, http.HandlerFunc(api.queryStream)))
+}
+
+// RegisterAdminHandler registers the handlers for the administrative
+// endpoints of the API. Requests to them have to authenticate with the given
+// token as a bearer token.
+func (api *API) RegisterAdminHandler(token string) {
+	handle := func(path string, f apiFunc) {
+		http.Handle(path, httputils.InstrumentHandler(
+			path, adminHandler(token, apiHandler(f)),
+		))
+	}
+	handle("/api/v1/admin/scrape/pause", api.pauseJob)
+	handle("/api/v1/admin/scrape/resume", api.resumeJob)
+	handle("/api/v1/admin/scrape/now", api.scrapeNow)
+	handle("/api/v1/admin/log/level", api.setLogLevel)
+	handle("/api/v1/admin/debug/profiling", api.setProfiling)
+	handle("/api/v1/admin/alerts/test_fire", api.testFireAlert)
+}
+
+// adminHandler only passes on POST requests that carry the given bearer
+// token.
+func adminHandler(token string, h http.Handler) http.Handler {
+	return postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// postHandler only passes on POST requests.
+func postHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Add("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// corsHandler sets the CORS headers for origins matching o and answers
+// preflight requests.
+func corsHandler(o *regexp.Regexp, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputils.SetCORS(w, o, r)
+		if r.Method == "OPTIONS" {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func apiHandler(f apiFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := f(r)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		if s, ok := data.(streamer); ok {
+			s.stream(w)
+			return
+		}
+		respond(w, data)
+	})
+}
+
+func respond(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	b, err := json.Marshal(&response{
+		Status: statusSuccess,
+		Data:   data,
+	})
+	if err != nil {
+		logger.Error("Error marshalling API response: ", err)
+		return
+	}
+	w.Write(b)
+}
+
+func respondError(w http.ResponseWriter, apiErr *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var code int
+	switch apiErr.typ {
+	case errorBadData:
+		code = http.StatusBadRequest
+	case errorExec:
+		code = 422
+	case errorTimeout, errorUnavailable:
+		code = http.StatusServiceUnavailable
+	default:
+		code = http.StatusInternalServerError
+	}
+	w.WriteHeader(code)
+
+	b, err := json.Marshal(&response{
+		Status:    statusError,
+		ErrorType: apiErr.typ,
+		Error:     apiErr.err.Error(),
+	})
+	if err != nil {
+		logger.Error("Error marshalling API error response: ", err)
+		return
+	}
+	w.Write(b)
+}
+
+// parseMatchers parses the label matchers of a series selector. An empty
+// selector matches everything.
+func parseMatchers(selector string) (metric.LabelMatchers, *apiError) {
+	if selector == "" {
+		return nil, nil
+	}
+	matchers, err := rules.LoadSelectorFromString(selector)
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	return matchers, nil
+}
+
+// parseTime parses a timestamp given as RFC3339 string or as Unix timestamp
+// in seconds, with optional fractions.
+func parseTime(s string) (clientmodel.Timestamp, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		return clientmodel.TimestampFromUnixNano(int64(t * float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return clientmodel.TimestampFromTime(t), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseTimeParam parses the named timestamp parameter of the request. A
+// missing parameter results in the given default.
+func parseTimeParam(r *http.Request, name string, def clientmodel.Timestamp) (clientmodel.Timestamp, *apiError) {
+	s := r.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		return 0, &apiError{errorBadData, fmt.Errorf("invalid parameter %s: %s", name, err)}
+	}
+	return t, nil
+}
+
+// maxPointsPerSeries returns the number of points per series range queries
+// may return at most.
+func (api *API) maxPointsPerSeries() int {
+	if api.MaxPointsPerSeries > 0 {
+		return api.MaxPointsPerSeries
+	}
+	return maxPointsPerSeries
+}
+
+// minStep returns the smallest step in whole seconds with which a range query
+// over the given duration returns at most maxPoints points per series.
+func minStep(d time.Duration, maxPoints int) time.Duration {
+	step := (d + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	return (step + time.Second - 1) / time.Second * time.Second
+}
+
+// parseDuration parses a duration given as duration string (e.g. "5m") or as
+// number of seconds, with optional fractions.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	if d, err := utility.StringToDuration(s); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+// parseMatchersParams parses the match[] parameters of a request, each of
+// which is a series selector. At least one is required.
+func parseMatchersParams(r *http.Request) ([]metric.LabelMatchers, *apiError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		return nil, &apiError{errorBadData, fmt.Errorf("no match[] parameter provided")}
+	}
+	matcherSets := make([]metric.LabelMatchers, 0, len(selectors))
+	for _, s := range selectors {
+		matchers, err := rules.LoadSelectorFromString(s)
+		if err != nil {
+			return nil, &apiError{errorBadData, err}
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	return matcherSets, nil
+}
+
+// parseLimit parses an optional limit parameter. A missing limit results in
+// -1, meaning no limit.
+func parseLimit(s string) (int, *apiError) {
+	if s == "" {
+		return -1, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 0 {
+		return 0, &apiError{errorBadData, fmt.Errorf("invalid limit %q", s)}
+	}
+	return limit, nil
+}
+
+// samplePair is a value at a point in time. It is encoded as a JSON array of
+// the timestamp in seconds and the value as string.
+type samplePair struct {
+	Timestamp clientmodel.Timestamp
+	Value     string
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p samplePair) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.Timestamp, p.Value})
+}
+
+type vectorSample struct {
+	Metric clientmodel.Metric `json:"metric"`
+	Value  samplePair         `json:"value"`
+}
+
+type matrixSeries struct {
+	Metric clientmodel.Metric `json:"metric"`
+	Values []samplePair       `json:"values"`
+}
+
+type queryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+// queryResult converts the result of evaluating an expression into the data
+// returned by the query endpoints.
+func queryResult(val interface{}, ts clientmodel.Timestamp) *queryData {
+	switch v := val.(type) {
+	case clientmodel.SampleValue:
+		return &queryData{"scalar", samplePair{ts, v.String()}}
+	case string:
+		return &queryData{"string", samplePair{ts, v}}
+	case ast.Vector:
+		res := make([]vectorSample, 0, len(v))
+		for _, s := range v {
+			res = append(res, vectorSample{
+				Metric: s.Metric.Metric,
+				Value:  samplePair{s.Timestamp, s.Value.String()},
+			})
+		}
+		return &queryData{"vector", res}
+	case ast.Matrix:
+		res := make([]matrixSeries, 0, len(v))
+		for _, ss := range v {
+			values := make([]samplePair, 0, len(ss.Values))
+			for _, sp := range ss.Values {
+				values = append(values, samplePair{sp.Timestamp, sp.Value.String()})
+			}
+			res = append(res, matrixSeries{Metric: ss.Metric.Metric, Values: values})
+		}
+		return &queryData{"matrix", res}
+	}
+	panic(fmt.Sprintf("unexpected query result type %T", val))
+}
+
+// evalError wraps an error that occurred while evaluating a query.
+func evalError(err error) *apiError {
+	if ast.IsQueryTimeout(err) {
+		return &apiError{errorTimeout, err}
+	}
+	return &apiError{errorExec, err}
+}
+
+// checkThrottled returns an error if the storage is throttling ingestion to
+// catch up with chunk persistence. Queries are rejected then so that they
+// don't compete with persistence for disk I/O.
+func (api *API) checkThrottled() *apiError {
+	if api.Storage.Stats().PersistenceState == local.PersistenceThrottled {
+		return &apiError{errorUnavailable, fmt.Errorf("storage is throttled, try again later")}
+	}
+	return nil
+}
+
+// query evaluates an expression (query) at a single point in time (time,
+// defaulting to now).
+func (api *API) query(r *http.Request) (interface{}, *apiError) {
+	if apiErr := api.checkThrottled(); apiErr != nil {
+		return nil, apiErr
+	}
+	span := startQuerySpan("instant query", r)
+	defer span.Finish()
+
+	expr, apiErr := api.parseQuery(r, span)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	ts, apiErr := parseTimeParam(r, "time", clientmodel.Now())
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	timeout, apiErr := parseTimeout(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(span)
+	val, err := ast.EvalInstant(expr, ts, timeout, api.Storage, queryStats)
+	if err != nil {
+		span.SetError(err)
+		return nil, evalError(err)
+	}
+	logger.Debugf("Instant query: %s\nQuery stats:\n%s\n", r.FormValue("query"), queryStats)
+	return queryResult(val, ts), nil
+}
+
+// queryRange evaluates an expression (query) of vector type at all steps
+// (step) between a start and an end time (start, end). With format set to csv
+// or ndjson, the result is streamed in that format while it is evaluated
+// instead of being returned as a matrix in the JSON envelope. With format set
+// to heatmap, the expression has to select the buckets of histograms, which
+// are returned de-accumulated as one heatmap per histogram.
+func (api *API) queryRange(r *http.Request) (interface{}, *apiError) {
+	if apiErr := api.checkThrottled(); apiErr != nil {
+		return nil, apiErr
+	}
+	span := startQuerySpan("range query", r)
+	defer func() { span.Finish() }()
+
+	expr, apiErr := api.parseQuery(r, span)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	vector, ok := expr.(ast.VectorNode)
+	if !ok {
+		return nil, &apiError{errorBadData, fmt.Errorf("expression must evaluate to a vector, got %s", expr.Type())}
+	}
+
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter start: %s", err)}
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter end: %s", err)}
+	}
+	if end.Before(start) {
+		return nil, &apiError{errorBadData, fmt.Errorf("end timestamp must not be before start time")}
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter step: %s", err)}
+	}
+	if step <= 0 {
+		return nil, &apiError{errorBadData, fmt.Errorf("zero or negative query resolution step widths are not accepted")}
+	}
+	autoStep := false
+	if s := r.FormValue("auto_step"); s != "" {
+		if autoStep, err = strconv.ParseBool(s); err != nil {
+			return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter auto_step: %s", err)}
+		}
+	}
+	if maxPoints := api.maxPointsPerSeries(); int64(end.Sub(start)/step) > int64(maxPoints) {
+		if !autoStep {
+			return nil, &apiError{errorBadData, fmt.Errorf("exceeded maximum resolution of %d points per timeseries, try decreasing the query resolution (?step=XX) or letting it be chosen (?auto_step=true)", maxPoints)}
+		}
+		coarsened := minStep(end.Sub(start), maxPoints)
+		logger.Debugf("Coarsening step of range query %s from %s to %s", r.FormValue("query"), step, coarsened)
+		step = coarsened
+	}
+	timeout, apiErr := parseTimeout(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	heatmap := false
+	switch format := r.FormValue("format"); format {
+	case "", "json":
+	case formatHeatmap:
+		if vector, err = heatmapNode(vector); err != nil {
+			return nil, &apiError{errorBadData, err}
+		}
+		heatmap = true
+	case formatCSV, formatNDJSON:
+		export := &rangeExport{
+			format:  format,
+			query:   r.FormValue("query"),
+			node:    vector,
+			start:   start,
+			end:     end,
+			step:    step,
+			timeout: timeout,
+			storage: api.Storage,
+			span:    span,
+		}
+		// The span ends with the export.
+		span = nil
+		return export, nil
+	default:
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter format: unknown format %q", format)}
+	}
+
+	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(span)
+	matrix, err := api.evalRange(vector, start, end, step, timeout, queryStats)
+	if err != nil {
+		span.SetError(err)
+		return nil, evalError(err)
+	}
+	sort.Sort(matrix)
+	logger.Debugf("Range query: %s\nQuery stats:\n%s\n", r.FormValue("query"), queryStats)
+	if heatmap {
+		return &queryData{"heatmap", heatmapResult(matrix)}, nil
+	}
+	return queryResult(matrix, end), nil
+}
+
+// startQuerySpan starts the root span of a trace of the query in r.
+func startQuerySpan(name string, r *http.Request) *tracing.Span {
+	span := tracing.StartSpan(name)
+	span.SetTag("query", r.FormValue("query"))
+	return span
+}
+
+// parseQuery parses the expression (query) of r, tracing it as a child of
+// span. The selectors of the expression are restricted to the tenant of the
+// request, if any.
+func (api *API) parseQuery(r *http.Request, span *tracing.Span) (ast.Node, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	parseSpan := span.StartChild("parse")
+	defer parseSpan.Finish()
+	expr, err := rules.LoadExprFromString(r.FormValue("query"))
+	if err != nil {
+		parseSpan.SetError(err)
+		return nil, &apiError{errorBadData, err}
+	}
+	if tenant != nil {
+		ast.RestrictSelectors(expr, tenant)
+	}
+	return expr, nil
+}
+
+// parseTimeout parses the optional timeout parameter of a query. A missing
+// timeout results in 0, selecting the default timeout.
+func parseTimeout(r *http.Request) (time.Duration, *apiError) {
+	s := r.FormValue("timeout")
+	if s == "" {
+		return 0, nil
+	}
+	timeout, err := parseDuration(s)
+	if err != nil || timeout <= 0 {
+		return 0, &apiError{errorBadData, fmt.Errorf("invalid parameter timeout %q", s)}
+	}
+	return timeout, nil
+}
+
+// seriesInRange returns the fingerprints of all series matching any of the
+// given sets of matchers that have samples between start and end.
+func (api *API) seriesInRange(matcherSets []metric.LabelMatchers, start, end clientmodel.Timestamp) map[clientmodel.Fingerprint]struct{} {
+	fps := map[clientmodel.Fingerprint]struct{}{}
+	for _, matchers := range matcherSets {
+		for _, fp := range api.Storage.GetFingerprintsForLabelMatchers(matchers) {
+			if _, ok := fps[fp]; ok {
+				continue
+			}
+			tr, ok := api.Storage.GetTimeRangeForFingerprint(fp)
+			if !ok || tr.NewestInclusive.Before(start) || tr.OldestInclusive.After(end) {
+				continue
+			}
+			fps[fp] = struct{}{}
+		}
+	}
+	return fps
+}
+
+// minTime and maxTime are the defaults of optional start and end parameters.
+const (
+	minTime = clientmodel.Timestamp(math.MinInt64)
+	maxTime = clientmodel.Timestamp(math.MaxInt64)
+)
+
+// series returns the label sets of all series matching any of the match[]
+// selectors that have samples between start and end. Both are optional.
+func (api *API) series(r *http.Request) (interface{}, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	matcherSets, apiErr := parseMatchersParams(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tenant != nil {
+		matcherSets = restrictMatcherSets(matcherSets, tenant)
+	}
+	start, apiErr := parseTimeParam(r, "start", minTime)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	end, apiErr := parseTimeParam(r, "end", maxTime)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	res := []clientmodel.Metric{}
+	for fp := range api.seriesInRange(matcherSets, start, end) {
+		res = append(res, api.Storage.GetMetricForFingerprint(fp).Metric)
+	}
+	sort.Sort(metricsByString(res))
+	return res, nil
+}
+
+// restrictedMetrics returns the metrics of all series matching the optional
+// match[] selectors and time range (start, end) of a request, and whether any
+// restriction was requested at all. Without a match[] selector, all series
+// are considered. Requests of a tenant are always restricted to its series.
+func (api *API) restrictedMetrics(r *http.Request) ([]clientmodel.Metric, bool, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, false, apiErr
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, false, &apiError{errorBadData, err}
+	}
+	if tenant == nil && len(r.Form["match[]"]) == 0 && r.FormValue("start") == "" && r.FormValue("end") == "" {
+		return nil, false, nil
+	}
+
+	matcherSets := []metric.LabelMatchers{allSeries}
+	if len(r.Form["match[]"]) > 0 {
+		if matcherSets, apiErr = parseMatchersParams(r); apiErr != nil {
+			return nil, false, apiErr
+		}
+	}
+	if tenant != nil {
+		matcherSets = restrictMatcherSets(matcherSets, tenant)
+	}
+	start, apiErr := parseTimeParam(r, "start", minTime)
+	if apiErr != nil {
+		return nil, false, apiErr
+	}
+	end, apiErr := parseTimeParam(r, "end", maxTime)
+	if apiErr != nil {
+		return nil, false, apiErr
+	}
+
+	var metrics []clientmodel.Metric
+	for fp := range api.seriesInRange(matcherSets, start, end) {
+		metrics = append(metrics, api.Storage.GetMetricForFingerprint(fp).Metric)
+	}
+	return metrics, true, nil
+}
+
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// allSeries matches every series as every series has a metric name.
+var allSeries = metric.LabelMatchers{
+	mustNewLabelMatcher(metric.RegexMatch, clientmodel.MetricNameLabel, ".+"),
+}
+
+func mustNewLabelMatcher(mt metric.MatchType, name clientmodel.LabelName, val clientmodel.LabelValue) *metric.LabelMatcher {
+	m, err := metric.NewLabelMatcher(mt, name, val)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// labelNames returns all label names, optionally restricted to the series
+// matching the match[] selectors and the time range given by start and end.
+func (api *API) labelNames(r *http.Request) (interface{}, *apiError) {
+	metrics, restricted, apiErr := api.restrictedMetrics(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	var names clientmodel.LabelNames
+	if !restricted {
+		names = api.Storage.GetLabelNames()
+	} else {
+		seen := map[clientmodel.LabelName]struct{}{}
+		for _, m := range metrics {
+			for ln := range m {
+				if _, ok := seen[ln]; !ok {
+					seen[ln] = struct{}{}
+					names = append(names, ln)
+				}
+			}
+		}
+	}
+	if names == nil {
+		names = clientmodel.LabelNames{}
+	}
+	sort.Sort(names)
+	return names, nil
+}
+
+// labelValues handles /api/v1/label/<name>/values. It returns all values of
+// the label, optionally restricted to the series matching the match[]
+// selectors and the time range given by start and end.
+func (api *API) labelValues(r *http.Request) (interface{}, *apiError) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/label/")
+	if !strings.HasSuffix(path, "/values") {
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid path %q", r.URL.Path)}
+	}
+	name := clientmodel.LabelName(strings.TrimSuffix(path, "/values"))
+	if !labelNameRE.MatchString(string(name)) {
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid label name %q", name)}
+	}
+
+	metrics, restricted, apiErr := api.restrictedMetrics(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	var values clientmodel.LabelValues
+	if !restricted {
+		values = api.Storage.GetLabelValuesForLabelName(name)
+	} else {
+		seen := map[clientmodel.LabelValue]struct{}{}
+		for _, m := range metrics {
+			if lv, ok := m[name]; ok {
+				if _, ok := seen[lv]; !ok {
+					seen[lv] = struct{}{}
+					values = append(values, lv)
+				}
+			}
+		}
+	}
+	if values == nil {
+		values = clientmodel.LabelValues{}
+	}
+	sort.Sort(values)
+	return values, nil
+}
+
+type metricsByString []clientmodel.Metric
+
+func (s metricsByString) Len() int           { return len(s) }
+func (s metricsByString) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s metricsByString) Less(i, j int) bool { return s[i].String() < s[j].String() }
+
+// activeTargets returns all targets of the target manager, ordered by job
+// name and URL.
+func (api *API) activeTargets() []retrieval.Target {
+	pools := api.TargetManager.Pools()
+	jobs := make([]string, 0, len(pools))
+	for job := range pools {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+
+	var targets []retrieval.Target
+	for _, job := range jobs {
+		targets = append(targets, pools[job].Targets()...)
+	}
+	return targets
+}
+
+// targetLabels returns the labels attached to all samples scraped from the
+// given target.
+func targetLabels(t retrieval.Target) clientmodel.LabelSet {
+	labels := clientmodel.LabelSet{
+		retrieval.InstanceLabel: clientmodel.LabelValue(t.InstanceIdentifier()),
+	}
+	for ln, lv := range t.BaseLabels() {
+		labels[ln] = lv
+	}
+	return labels
+}
+
+// Label names of the labels a target was discovered with, on top of its base
+// labels.
+const (
+	addressLabel     clientmodel.LabelName = "__address__"
+	schemeLabel      clientmodel.LabelName = "__scheme__"
+	metricsPathLabel clientmodel.LabelName = "__metrics_path__"
+)
+
+// discoveredLabels returns the labels describing a target as it was handed
+// to the target manager: its base labels and the components of its URL.
+func discoveredLabels(t retrieval.Target) clientmodel.LabelSet {
+	labels := clientmodel.LabelSet{}
+	for ln, lv := range t.BaseLabels() {
+		labels[ln] = lv
+	}
+	if u, err := url.Parse(t.URL()); err == nil {
+		labels[addressLabel] = clientmodel.LabelValue(u.Host)
+		labels[schemeLabel] = clientmodel.LabelValue(u.Scheme)
+		labels[metricsPathLabel] = clientmodel.LabelValue(u.Path)
+	}
+	return labels
+}
+
+// targetHealth translates the state of a target into the health reported by
+// the API.
+func targetHealth(state retrieval.TargetState) string {
+	switch state {
+	case retrieval.Alive:
+		return "up"
+	case retrieval.Unreachable:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+type activeTarget struct {
+	DiscoveredLabels   clientmodel.LabelSet `json:"discoveredLabels"`
+	Labels             clientmodel.LabelSet `json:"labels"`
+	ScrapeURL          string               `json:"scrapeUrl"`
+	LastError          string               `json:"lastError"`
+	LastScrape         time.Time            `json:"lastScrape"`
+	LastScrapeDuration float64              `json:"lastScrapeDuration"`
+	Health             string               `json:"health"`
+	Paused             bool                 `json:"paused"`
+}
+
+type droppedTarget struct {
+	DiscoveredLabels clientmodel.LabelSet `json:"discoveredLabels"`
+}
+
+type targetDiscovery struct {
+	ActiveTargets  []*activeTarget  `json:"activeTargets"`
+	DroppedTargets []*droppedTarget `json:"droppedTargets"`
+}
+
+// targets returns the targets currently known to the target manager. The
+// state parameter selects whether active targets, dropped targets, or both
+// (any) are returned. It defaults to any. Requests of a tenant only return its
+// targets.
+func (api *API) targets(r *http.Request) (interface{}, *apiError) {
+	targets, apiErr := api.tenantTargets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	var showActive, showDropped bool
+	switch state := r.FormValue("state"); state {
+	case "", "any":
+		showActive, showDropped = true, true
+	case "active":
+		showActive = true
+	case "dropped":
+		showDropped = true
+	default:
+		return nil, &apiError{errorBadData, fmt.Errorf("invalid target state %q", state)}
+	}
+
+	res := &targetDiscovery{}
+	if showActive {
+		res.ActiveTargets = []*activeTarget{}
+		for _, t := range targets {
+			lastError := ""
+			if err := t.LastError(); err != nil {
+				lastError = err.Error()
+			}
+			res.ActiveTargets = append(res.ActiveTargets, &activeTarget{
+				DiscoveredLabels:   discoveredLabels(t),
+				Labels:             targetLabels(t),
+				ScrapeURL:          t.URL(),
+				LastError:          lastError,
+				LastScrape:         t.LastScrape(),
+				LastScrapeDuration: t.LastScrapeDuration().Seconds(),
+				Health:             targetHealth(t.State()),
+				Paused:             t.Paused(),
+			})
+		}
+	}
+	if showDropped {
+		// Targets are never dropped before scraping so far.
+		res.DroppedTargets = []*droppedTarget{}
+	}
+	return res, nil
+}
+
+type targetMetadata struct {
+	Target clientmodel.LabelSet `json:"target"`
+	Metric string               `json:"metric"`
+	Type   string               `json:"type"`
+	Help   string               `json:"help"`
+	Unit   string               `json:"unit"`
+}
+
+// targetMetadata returns the metric metadata cached per target. The targets
+// can be restricted by a selector on their labels (match_target), the metrics
+// by name (metric). The number of returned entries can be limited (limit).
+// Requests of a tenant only consider its targets.
+func (api *API) targetMetadata(r *http.Request) (interface{}, *apiError) {
+	targets, apiErr := api.tenantTargets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	limit, apiErr := parseLimit(r.FormValue("limit"))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	matchers, apiErr := parseMatchers(r.FormValue("match_target"))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	metricName := r.FormValue("metric")
+
+	res := []targetMetadata{}
+	for _, t := range targets {
+		labels := targetLabels(t)
+		if !matchers.Match(labels) {
+			continue
+		}
+		for _, md := range t.Metadata() {
+			if metricName != "" && md.Metric != metricName {
+				continue
+			}
+			if limit >= 0 && len(res) >= limit {
+				return res, nil
+			}
+			res = append(res, targetMetadata{
+				Target: labels,
+				Metric: md.Metric,
+				Type:   md.Type,
+				Help:   md.Help,
+				Unit:   md.Unit,
+			})
+		}
+	}
+	return res, nil
+}
+
+type metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metricMetadata returns the metadata of the metrics of all targets by metric
+// name. Differing metadata of the same metric across targets is returned as
+// separate entries. The metrics can be restricted by name (metric) and their
+// number limited (limit). Requests of a tenant only consider its targets.
+func (api *API) metricMetadata(r *http.Request) (interface{}, *apiError) {
+	targets, apiErr := api.tenantTargets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	limit, apiErr := parseLimit(r.FormValue("limit"))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	metricName := r.FormValue("metric")
+
+	res := map[string][]metadata{}
+	for _, t := range targets {
+		for _, md := range t.Metadata() {
+			if metricName != "" && md.Metric != metricName {
+				continue
+			}
+			entries, ok := res[md.Metric]
+			if !ok && limit >= 0 && len(res) >= limit {
+				continue
+			}
+			m := metadata{Type: md.Type, Help: md.Help, Unit: md.Unit}
+			if !containsMetadata(entries, m) {
+				res[md.Metric] = append(entries, m)
+			}
+		}
+	}
+	return res, nil
+}
+
+func containsMetadata(entries []metadata, m metadata) bool {
+	for _, e := range entries {
+		if e == m {
+			return true
+		}
+	}
+	return false
+}
+
+// pool returns the target pool of the job named by the job parameter.
+func (api *API) pool(r *http.Request) (*retrieval.TargetPool, *apiError) {
+	job := r.FormValue("job")
+	if job == "" {
+		return nil, &apiError{errorBadData, fmt.Errorf("missing job parameter")}
+	}
+	pool, ok := api.TargetManager.Pools()[job]
+	if !ok {
+		return nil, &apiError{errorBadData, fmt.Errorf("unknown job %q", job)}
+	}
+	return pool, nil
+}
+
+// pauseJob pauses the regular scrapes of all targets of a job.
+func (api *API) pauseJob(r *http.Request) (interface{}, *apiError) {
+	pool, apiErr := api.pool(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	pool.SetPaused(true)
+	return nil, nil
+}
+
+// resumeJob resumes the regular scrapes of all targets of a job.
+func (api *API) resumeJob(r *http.Request) (interface{}, *apiError) {
+	pool, apiErr := api.pool(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	pool.SetPaused(false)
+	return nil, nil
+}
+
+// scrapeNow triggers an immediate scrape of the target of a job with the URL
+// given by the url parameter. Paused targets are scraped as well.
+func (api *API) scrapeNow(r *http.Request) (interface{}, *apiError) {
+	pool, apiErr := api.pool(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	t, ok := pool.Target(r.FormValue("url"))
+	if !ok {
+		return nil, &apiError{errorBadData, fmt.Errorf("unknown target %q", r.FormValue("url"))}
+	}
+	t.ScrapeNow()
+	return nil, nil
+}
+
+// setLogLevel sets the minimum level of logged events (level) of a component
+// (component), or the default level of all components if no component is
+// given. It returns the resulting levels by component.
+func (api *API) setLogLevel(r *http.Request) (interface{}, *apiError) {
+	level, err := log.ParseLevel(r.FormValue("level"))
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	component := r.FormValue("component")
+	log.SetLevel(component, level)
+	if component == "" {
+		logger.Infof("Default log level set to %s.", level)
+	} else {
+		logger.Infof("Log level of component %s set to %s.", component, level)
+	}
+	return log.Levels(), nil
+}
+
+// setProfiling sets the rates of block profiling (block_profile_rate, in
+// nanoseconds spent blocked per recorded event) and mutex profiling
+// (mutex_profile_fraction, one in how many contention events is recorded),
+// as served under /debug/pprof. Omitted rates are left unchanged, and 0
+// disables the respective profile. It returns the resulting rates.
+func (api *API) setProfiling(r *http.Request) (interface{}, *apiError) {
+	parseRate := func(name string) (int, bool, *apiError) {
+		s := r.FormValue(name)
+		if s == "" {
+			return 0, false, nil
+		}
+		rate, err := strconv.Atoi(s)
+		if err != nil || rate < 0 {
+			return 0, false, &apiError{errorBadData, fmt.Errorf("invalid %s %q", name, s)}
+		}
+		return rate, true, nil
+	}
+	blockRate, setBlock, apiErr := parseRate("block_profile_rate")
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	mutexFraction, setMutex, apiErr := parseRate("mutex_profile_fraction")
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	if setBlock {
+		runtime.SetBlockProfileRate(blockRate)
+		api.BlockProfileRate = blockRate
+		logger.Infof("Block profile rate set to %d.", blockRate)
+	}
+	if setMutex {
+		runtime.SetMutexProfileFraction(mutexFraction)
+		logger.Infof("Mutex profile fraction set to %d.", mutexFraction)
+	}
+	return map[string]int{
+		"blockProfileRate":     api.BlockProfileRate,
+		"mutexProfileFraction": runtime.SetMutexProfileFraction(-1),
+	}, nil
+}
@@ -0,0 +1,580 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/retrieval"
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/storage/metric"
+	"github.com/prometheus/prometheus/utility/test"
+)
+
+// testResponse is the envelope of API responses with the data left encoded.
+type testResponse struct {
+	Status    status          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType errorType       `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// serveAPI serves a request to the API function and decodes the envelope of
+// the response.
+func serveAPI(t *testing.T, f apiFunc, method, target string) (int, testResponse) {
+	r, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	apiHandler(f).ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: expected content type application/json, got %q", method, target, ct)
+	}
+	var res testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s %s: error decoding response %q: %s", method, target, w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+// expectData serves a request that has to succeed and decodes its data into
+// data.
+func expectData(t *testing.T, f apiFunc, target string, data interface{}) {
+	code, res := serveAPI(t, f, "GET", target)
+	if code != http.StatusOK || res.Status != statusSuccess {
+		t.Fatalf("GET %s: expected success, got %d %+v", target, code, res)
+	}
+	if res.ErrorType != "" || res.Error != "" {
+		t.Fatalf("GET %s: expected no error in successful response, got %+v", target, res)
+	}
+	if err := json.Unmarshal(res.Data, data); err != nil {
+		t.Fatalf("GET %s: error decoding data %s: %s", target, res.Data, err)
+	}
+}
+
+// expectBadData serves a request that has to be rejected as bad data.
+func expectBadData(t *testing.T, f apiFunc, target string) {
+	code, res := serveAPI(t, f, "GET", target)
+	if code != http.StatusBadRequest || res.Status != statusError || res.ErrorType != errorBadData || res.Error == "" {
+		t.Errorf("GET %s: expected bad data error, got %d %+v", target, code, res)
+	}
+	if res.Data != nil {
+		t.Errorf("GET %s: expected no data in error response, got %s", target, res.Data)
+	}
+}
+
+func query(target string, params url.Values) string {
+	return target + "?" + params.Encode()
+}
+
+// newAPITestStorage returns a storage with the series up{instance="a"} of
+// constant value 1 and the three buckets of the histogram req_bucket, with
+// the cumulative counts 1, 3, and 4, at every 10s from start for a minute.
+func newAPITestStorage(t *testing.T, start clientmodel.Timestamp) (local.Storage, test.Closer) {
+	storage, closer := local.NewTestStorage(t)
+	series := []struct {
+		metric clientmodel.Metric
+		value  clientmodel.SampleValue
+	}{
+		{clientmodel.Metric{clientmodel.MetricNameLabel: "up", "instance": "a"}, 1},
+		{clientmodel.Metric{clientmodel.MetricNameLabel: "req_bucket", "le": "0.1"}, 1},
+		{clientmodel.Metric{clientmodel.MetricNameLabel: "req_bucket", "le": "1"}, 3},
+		{clientmodel.Metric{clientmodel.MetricNameLabel: "req_bucket", "le": "+Inf"}, 4},
+	}
+	for ts := start; !ts.After(start.Add(time.Minute)); ts = ts.Add(10 * time.Second) {
+		for _, s := range series {
+			storage.AppendSamples(clientmodel.Samples{{Metric: s.metric, Value: s.value, Timestamp: ts}})
+		}
+	}
+	storage.WaitForIndexing()
+	return storage, closer
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	code, res := serveAPI(t, func(*http.Request) (interface{}, *apiError) {
+		return map[string]string{"foo": "bar"}, nil
+	}, "GET", "/")
+	if code != http.StatusOK || res.Status != statusSuccess || string(res.Data) != `{"foo":"bar"}` {
+		t.Errorf("Unexpected success response %d %+v", code, res)
+	}
+
+	for typ, wantCode := range map[errorType]int{
+		errorBadData:     http.StatusBadRequest,
+		errorExec:        422,
+		errorTimeout:     http.StatusServiceUnavailable,
+		errorUnavailable: http.StatusServiceUnavailable,
+		errorInternal:    http.StatusInternalServerError,
+	} {
+		code, res := serveAPI(t, func(*http.Request) (interface{}, *apiError) {
+			return nil, &apiError{typ, errors.New("test error")}
+		}, "GET", "/")
+		if code != wantCode {
+			t.Errorf("Error type %s: expected status code %d, got %d", typ, wantCode, code)
+		}
+		if res.Status != statusError || res.ErrorType != typ || res.Error != "test error" || res.Data != nil {
+			t.Errorf("Error type %s: unexpected response %+v", typ, res)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(clientmodel.Now().Unix())
+	storage, closer := newAPITestStorage(t, now.Add(-time.Minute))
+	defer closer.Close()
+	api := &API{Storage: storage, MaxPointsPerSeries: 100}
+
+	start, end := now.Add(-time.Minute).String(), now.String()
+	for _, params := range []url.Values{
+		{"query": {"up{"}},
+		{"query": {"up"}, "time": {"yesterday"}},
+		{"query": {"up"}, "timeout": {"soon"}},
+		{"query": {"up"}, "timeout": {"0"}},
+		{"query": {"up"}, "timeout": {"-1s"}},
+	} {
+		expectBadData(t, api.query, query("/api/v1/query", params))
+	}
+	for _, params := range []url.Values{
+		{"query": {"up{"}, "start": {start}, "end": {end}, "step": {"10s"}},
+		{"query": {"1"}, "start": {start}, "end": {end}, "step": {"10s"}},
+		{"query": {"up"}, "start": {"yesterday"}, "end": {end}, "step": {"10s"}},
+		{"query": {"up"}, "end": {end}, "step": {"10s"}},
+		{"query": {"up"}, "start": {start}, "end": {"today"}, "step": {"10s"}},
+		{"query": {"up"}, "start": {end}, "end": {start}, "step": {"10s"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"often"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"10ms"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"0"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"-10s"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"10s"}, "timeout": {"0s"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"10s"}, "auto_step": {"maybe"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"10s"}, "format": {"xml"}},
+		// More than 100 points per series.
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"0.5"}},
+		{"query": {"up"}, "start": {start}, "end": {end}, "step": {"0.5"}, "auto_step": {"false"}},
+	} {
+		expectBadData(t, api.queryRange, query("/api/v1/query_range", params))
+	}
+
+	// Both RFC3339 and Unix timestamps and both duration strings and
+	// seconds are accepted.
+	var data struct {
+		Result []struct {
+			Values [][]interface{} `json:"values"`
+		} `json:"result"`
+	}
+	expectData(t, api.queryRange, query("/api/v1/query_range", url.Values{
+		"query": {"up"},
+		"start": {now.Add(-time.Minute).Time().UTC().Format(time.RFC3339)},
+		"end":   {end},
+		"step":  {"30"},
+	}), &data)
+	if len(data.Result) != 1 || len(data.Result[0].Values) != 3 {
+		t.Errorf("Expected 3 points of 1 series, got %+v", data.Result)
+	}
+
+	// With auto_step, the step is coarsened to return at most 100 points.
+	expectData(t, api.queryRange, query("/api/v1/query_range", url.Values{
+		"query":     {"up"},
+		"start":     {start},
+		"end":       {end},
+		"step":      {"0.1"},
+		"auto_step": {"true"},
+	}), &data)
+	if len(data.Result) != 1 || len(data.Result[0].Values) == 0 || len(data.Result[0].Values) > 101 {
+		t.Errorf("Expected at most 101 points of coarsened range query, got %+v", data.Result)
+	}
+}
+
+func TestQueryResultEncoding(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(clientmodel.Now().Unix())
+	storage, closer := newAPITestStorage(t, now.Add(-time.Minute))
+	defer closer.Close()
+	api := &API{Storage: storage}
+
+	for _, c := range []struct {
+		target string
+		data   string
+	}{
+		{
+			target: query("/api/v1/query", url.Values{"query": {"1.5"}, "time": {"1000.5"}}),
+			data:   `{"resultType":"scalar","result":[1000.5,"1.5"]}`,
+		},
+		{
+			target: query("/api/v1/query", url.Values{"query": {"up"}, "time": {now.String()}}),
+			data:   `{"resultType":"vector","result":[{"metric":{"__name__":"up","instance":"a"},"value":[` + now.String() + `,"1"]}]}`,
+		},
+		{
+			target: query("/api/v1/query_range", url.Values{
+				"query": {"up"},
+				"start": {now.Add(-20 * time.Second).String()},
+				"end":   {now.String()},
+				"step":  {"10s"},
+			}),
+			data: `{"resultType":"matrix","result":[{"metric":{"__name__":"up","instance":"a"},"values":[` +
+				`[` + now.Add(-20*time.Second).String() + `,"1"],` +
+				`[` + now.Add(-10*time.Second).String() + `,"1"],` +
+				`[` + now.String() + `,"1"]]}]}`,
+		},
+	} {
+		f := api.query
+		if strings.HasPrefix(c.target, "/api/v1/query_range") {
+			f = api.queryRange
+		}
+		code, res := serveAPI(t, f, "GET", c.target)
+		if code != http.StatusOK || string(res.Data) != c.data {
+			t.Errorf("GET %s: expected data %s, got %d %s", c.target, c.data, code, res.Data)
+		}
+	}
+
+	// No expression evaluates to a string yet.
+	b, err := json.Marshal(queryResult("foo", clientmodel.TimestampFromUnix(1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"resultType":"string","result":[1000,"foo"]}`; string(b) != want {
+		t.Errorf("Expected string result %s, got %s", want, b)
+	}
+}
+
+func TestHeatmapQuery(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(clientmodel.Now().Unix())
+	storage, closer := newAPITestStorage(t, now.Add(-time.Minute))
+	defer closer.Close()
+	api := &API{Storage: storage}
+
+	// The points are encoded as arrays, so decode them generically.
+	var data struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric  clientmodel.Metric `json:"metric"`
+			Buckets [][2]string        `json:"buckets"`
+			Values  [][]interface{}    `json:"values"`
+		} `json:"result"`
+	}
+	target := query("/api/v1/query_range", url.Values{
+		"query":  {"req_bucket"},
+		"start":  {now.Add(-10 * time.Second).String()},
+		"end":    {now.String()},
+		"step":   {"10s"},
+		"format": {"heatmap"},
+	})
+	expectData(t, api.queryRange, target, &data)
+	if data.ResultType != "heatmap" || len(data.Result) != 1 {
+		t.Fatalf("Expected one heatmap, got %s %+v", data.ResultType, data.Result)
+	}
+	h := data.Result[0]
+	if _, ok := h.Metric[clientmodel.BucketLabel]; ok {
+		t.Errorf("Expected heatmap without bucket label, got %v", h.Metric)
+	}
+	if want := [][2]string{{"0", "0.1"}, {"0.1", "1"}, {"1", "+Inf"}}; !reflect.DeepEqual(h.Buckets, want) {
+		t.Errorf("Expected buckets %v, got %v", want, h.Buckets)
+	}
+	if len(h.Values) != 2 {
+		t.Fatalf("Expected 2 points, got %v", h.Values)
+	}
+	for _, p := range h.Values {
+		if want := []interface{}{"1", "2", "1"}; !reflect.DeepEqual(p[1], want) {
+			t.Errorf("Expected de-accumulated counts %v, got %v", want, p[1])
+		}
+	}
+
+}
+
+func TestHeatmapResult(t *testing.T) {
+	stream := func(le string, values ...clientmodel.SampleValue) ast.SampleStream {
+		ss := ast.SampleStream{Metric: clientmodel.COWMetric{Metric: clientmodel.Metric{"job": "api"}}}
+		if le != "" {
+			ss.Metric.Metric[clientmodel.BucketLabel] = clientmodel.LabelValue(le)
+		}
+		for i, v := range values {
+			if !math.IsNaN(float64(v)) {
+				ss.Values = append(ss.Values, metric.SamplePair{Timestamp: clientmodel.Timestamp(1000 * i), Value: v})
+			}
+		}
+		return ss
+	}
+	nan := clientmodel.SampleValue(math.NaN())
+	res := heatmapResult(ast.Matrix{
+		stream("+Inf", 1, 2),
+		stream("-1", 3, nan),
+		stream("", 5, 6),
+		stream("0.5", 7, 8),
+	})
+	if len(res) != 1 {
+		t.Fatalf("Expected one heatmap, got %+v", res)
+	}
+	h := res[0]
+	if want := (clientmodel.Metric{"job": "api"}); !h.Metric.Equal(want) {
+		t.Errorf("Expected heatmap of %v, got %v", want, h.Metric)
+	}
+	// A negative lowest bucket has no lower bound.
+	if want := [][2]string{{"-Inf", "-1"}, {"-1", "0.5"}, {"0.5", "+Inf"}}; !reflect.DeepEqual(h.Buckets, want) {
+		t.Errorf("Expected buckets %v, got %v", want, h.Buckets)
+	}
+	want := []heatmapPoint{
+		{Timestamp: 0, Counts: []string{"3", "7", "1"}},
+		{Timestamp: 1000, Counts: []string{"NaN", "8", "2"}},
+	}
+	if !reflect.DeepEqual(h.Values, want) {
+		t.Errorf("Expected points %v, got %v", want, h.Values)
+	}
+}
+
+func TestSeriesAndLabels(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(clientmodel.Now().Unix())
+	storage, closer := newAPITestStorage(t, now.Add(-time.Minute))
+	defer closer.Close()
+	api := &API{Storage: storage}
+
+	var series []clientmodel.Metric
+	expectData(t, api.series, query("/api/v1/series", url.Values{"match[]": {"up", `req_bucket{le="1"}`}}), &series)
+	want := []clientmodel.Metric{
+		{clientmodel.MetricNameLabel: "req_bucket", "le": "1"},
+		{clientmodel.MetricNameLabel: "up", "instance": "a"},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("Expected series %v, got %v", want, series)
+	}
+	expectData(t, api.series, query("/api/v1/series", url.Values{
+		"match[]": {"up"},
+		"end":     {now.Add(-2 * time.Minute).String()},
+	}), &series)
+	if len(series) != 0 {
+		t.Errorf("Expected no series before the first sample, got %v", series)
+	}
+	expectBadData(t, api.series, "/api/v1/series")
+	expectBadData(t, api.series, query("/api/v1/series", url.Values{"match[]": {"up{"}}))
+	expectBadData(t, api.series, query("/api/v1/series", url.Values{"match[]": {"up"}, "start": {"yesterday"}}))
+
+	var names []string
+	expectData(t, api.labelNames, "/api/v1/labels", &names)
+	if want := []string{"__name__", "instance", "le"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected label names %v, got %v", want, names)
+	}
+	expectData(t, api.labelNames, query("/api/v1/labels", url.Values{"match[]": {"up"}}), &names)
+	if want := []string{"__name__", "instance"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected label names %v, got %v", want, names)
+	}
+
+	var values []string
+	expectData(t, api.labelValues, "/api/v1/label/__name__/values", &values)
+	if want := []string{"req_bucket", "up"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Expected label values %v, got %v", want, values)
+	}
+	expectData(t, api.labelValues, query("/api/v1/label/le/values", url.Values{"match[]": {"up"}}), &values)
+	if len(values) != 0 {
+		t.Errorf("Expected no label values, got %v", values)
+	}
+	expectBadData(t, api.labelValues, "/api/v1/label/le")
+	expectBadData(t, api.labelValues, "/api/v1/label/1le/values")
+	expectBadData(t, api.labelValues, query("/api/v1/label/le/values", url.Values{"end": {"today"}}))
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	birth := time.Unix(1000, 0).UTC()
+	api := &API{
+		Config:    "global {}",
+		Flags:     map[string]string{"web.listen-address": ":9090"},
+		BuildInfo: map[string]string{"version": "0.15.0"},
+		Birth:     birth,
+	}
+
+	var config configStatus
+	expectData(t, api.statusConfig, "/api/v1/status/config", &config)
+	if config.Config != "global {}" {
+		t.Errorf("Unexpected configuration %q", config.Config)
+	}
+	var flags, buildInfo map[string]string
+	expectData(t, api.statusFlags, "/api/v1/status/flags", &flags)
+	if !reflect.DeepEqual(flags, api.Flags) {
+		t.Errorf("Expected flags %v, got %v", api.Flags, flags)
+	}
+	expectData(t, api.statusBuildInfo, "/api/v1/status/buildinfo", &buildInfo)
+	if !reflect.DeepEqual(buildInfo, api.BuildInfo) {
+		t.Errorf("Expected build information %v, got %v", api.BuildInfo, buildInfo)
+	}
+
+	// Without a storage, as in agent mode, the storage status is null.
+	var runtimeInfo runtimeStatus
+	expectData(t, api.statusRuntimeInfo, "/api/v1/status/runtimeinfo", &runtimeInfo)
+	if !runtimeInfo.StartTime.Equal(birth) || runtimeInfo.GOMAXPROCS == 0 || runtimeInfo.Storage != nil {
+		t.Errorf("Unexpected runtime information %+v", runtimeInfo)
+	}
+
+	storage, closer := local.NewTestStorage(t)
+	defer closer.Close()
+	api.Storage = storage
+	expectData(t, api.statusRuntimeInfo, "/api/v1/status/runtimeinfo", &runtimeInfo)
+	if runtimeInfo.Storage == nil || runtimeInfo.StorageRetention == "" {
+		t.Errorf("Expected storage status, got %+v", runtimeInfo)
+	}
+}
+
+func TestMetadataEndpoints(t *testing.T) {
+	api := &API{
+		TargetManager: newTenantTargetManager(
+			&fakeTarget{
+				url:    "http://a.example.org/metrics",
+				labels: clientmodel.LabelSet{clientmodel.JobLabel: "test"},
+				metadata: []retrieval.MetricMetadata{
+					{Metric: "a_total", Type: "counter", Help: "A help."},
+					{Metric: "shared", Type: "gauge", Help: "Shared help."},
+				},
+			},
+			&fakeTarget{
+				url:    "http://b.example.org/metrics",
+				labels: clientmodel.LabelSet{clientmodel.JobLabel: "test"},
+				metadata: []retrieval.MetricMetadata{
+					{Metric: "shared", Type: "gauge", Help: "Other help."},
+				},
+			},
+		),
+	}
+
+	for _, c := range []struct {
+		params  url.Values
+		targets []string
+		metrics []string
+	}{
+		{
+			params:  url.Values{},
+			targets: []string{"http://a.example.org/metrics", "http://a.example.org/metrics", "http://b.example.org/metrics"},
+			metrics: []string{"a_total", "shared", "shared"},
+		},
+		{
+			params:  url.Values{"match_target": {`{instance="http://b.example.org/metrics"}`}},
+			targets: []string{"http://b.example.org/metrics"},
+			metrics: []string{"shared"},
+		},
+		{
+			params:  url.Values{"metric": {"shared"}},
+			targets: []string{"http://a.example.org/metrics", "http://b.example.org/metrics"},
+			metrics: []string{"shared", "shared"},
+		},
+		{
+			params:  url.Values{"limit": {"1"}},
+			targets: []string{"http://a.example.org/metrics"},
+			metrics: []string{"a_total"},
+		},
+	} {
+		var md []targetMetadata
+		expectData(t, api.targetMetadata, query("/api/v1/targets/metadata", c.params), &md)
+		if len(md) != len(c.metrics) {
+			t.Fatalf("%v: expected metadata of %v, got %+v", c.params, c.metrics, md)
+		}
+		for i, m := range md {
+			if string(m.Target[retrieval.InstanceLabel]) != c.targets[i] || m.Metric != c.metrics[i] {
+				t.Errorf("%v: expected metadata of %s of %s, got %+v", c.params, c.metrics[i], c.targets[i], m)
+			}
+		}
+	}
+	expectBadData(t, api.targetMetadata, query("/api/v1/targets/metadata", url.Values{"limit": {"-1"}}))
+	expectBadData(t, api.targetMetadata, query("/api/v1/targets/metadata", url.Values{"match_target": {"{"}}))
+
+	// Differing metadata of the same metric is returned as separate entries.
+	var md map[string][]metadata
+	expectData(t, api.metricMetadata, "/api/v1/metadata", &md)
+	want := map[string][]metadata{
+		"a_total": {{Type: "counter", Help: "A help."}},
+		"shared":  {{Type: "gauge", Help: "Shared help."}, {Type: "gauge", Help: "Other help."}},
+	}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("Expected metadata %v, got %v", want, md)
+	}
+	md = nil
+	expectData(t, api.metricMetadata, query("/api/v1/metadata", url.Values{"metric": {"a_total"}}), &md)
+	if len(md) != 1 || len(md["a_total"]) != 1 {
+		t.Errorf("Expected metadata of a_total, got %v", md)
+	}
+	expectBadData(t, api.metricMetadata, query("/api/v1/metadata", url.Values{"limit": {"many"}}))
+}
+
+func TestQueryStream(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(clientmodel.Now().Unix())
+	storage, closer := newAPITestStorage(t, now.Add(-time.Minute))
+	defer closer.Close()
+	api := &API{Storage: storage, EvaluationInterval: time.Minute}
+	server := httptest.NewServer(http.HandlerFunc(api.queryStream))
+	defer server.Close()
+
+	for _, params := range []url.Values{
+		{"query": {"up{"}},
+		{"query": {"up"}, "interval": {"often"}},
+		{"query": {"up"}, "interval": {"10ms"}},
+		{"query": {"up"}, "interval": {"0"}},
+		{"query": {"up"}, "timeout": {"never"}},
+	} {
+		resp, err := http.Get(query(server.URL, params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res testResponse
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%v: error decoding response: %s", params, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || res.ErrorType != errorBadData {
+			t.Errorf("%v: expected bad data error, got %d %+v", params, resp.StatusCode, res)
+		}
+	}
+
+	resp, err := http.Get(query(server.URL, url.Values{"query": {"sum(up)"}, "interval": {"0.01"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected content type text/event-stream, got %q", ct)
+	}
+
+	// Each evaluation is pushed as a result event.
+	events := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		var lines [3]string
+		for j := range lines {
+			if lines[j], err = events.ReadString('\n'); err != nil {
+				t.Fatalf("Error reading event %d: %s", i, err)
+			}
+		}
+		if lines[0] != "event: result\n" || lines[2] != "\n" {
+			t.Fatalf("Unexpected event %d: %q", i, lines)
+		}
+		var data struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data); err != nil {
+			t.Fatalf("Error decoding event %d %q: %s", i, lines[1], err)
+		}
+		if data.ResultType != "vector" || len(data.Result) != 1 || data.Result[0].Value[1] != "1" {
+			t.Errorf("Unexpected result of event %d: %+v", i, data)
+		}
+	}
+}
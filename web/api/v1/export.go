@@ -0,0 +1,158 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/tracing"
+)
+
+// Output formats of range queries besides the default JSON envelope.
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// errorTrailer is the HTTP trailer carrying the error of a streamed response
+// that failed after its status code had already been sent.
+const errorTrailer = "X-Prometheus-Error"
+
+// streamer is returned by an apiFunc whose response is written directly
+// instead of being wrapped into the JSON envelope.
+type streamer interface {
+	stream(w http.ResponseWriter)
+}
+
+// rangeExport streams the result of a range query step by step while it is
+// evaluated, without holding the full matrix in memory. Samples are written
+// in chronological order, one CSV row or JSON line each.
+//
+// Errors occurring before the first sample is written are reported like any
+// other API error. Later errors are reported in the X-Prometheus-Error
+// trailer and, for NDJSON, as a final line holding errorType and error.
+type rangeExport struct {
+	format     string
+	query      string
+	node       ast.VectorNode
+	start, end clientmodel.Timestamp
+	step       time.Duration
+	timeout    time.Duration
+	storage    local.Storage
+	span       *tracing.Span
+
+	started bool
+	csv     *csv.Writer
+	enc     *json.Encoder
+}
+
+func (e *rangeExport) stream(w http.ResponseWriter) {
+	defer e.span.Finish()
+	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(e.span)
+	err := ast.EvalVectorRangeFunc(e.node, e.start, e.end, e.step, e.timeout, e.storage, queryStats, func(_ clientmodel.Timestamp, vector ast.Vector) error {
+		if len(vector) == 0 {
+			return nil
+		}
+		if err := e.begin(w); err != nil {
+			return err
+		}
+		for _, s := range vector {
+			if err := e.write(s); err != nil {
+				return err
+			}
+		}
+		if e.csv != nil {
+			e.csv.Flush()
+			if err := e.csv.Error(); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = e.begin(w)
+		if e.csv != nil {
+			e.csv.Flush()
+		}
+	}
+	if err == nil {
+		logger.Debugf("Range query export: %s\nQuery stats:\n%s\n", e.query, queryStats)
+		return
+	}
+
+	e.span.SetError(err)
+	apiErr := evalError(err)
+	if !e.started {
+		respondError(w, apiErr)
+		return
+	}
+	logger.Warnf("Error exporting range query %s: %s", e.query, err)
+	w.Header().Set(errorTrailer, err.Error())
+	if e.enc != nil {
+		e.enc.Encode(&response{
+			Status:    statusError,
+			ErrorType: apiErr.typ,
+			Error:     err.Error(),
+		})
+	}
+}
+
+// begin sends the response header, and for CSV the header row, unless that
+// has already happened.
+func (e *rangeExport) begin(w http.ResponseWriter) error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+
+	w.Header().Set("Trailer", errorTrailer)
+	switch e.format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		e.csv = csv.NewWriter(w)
+		return e.csv.Write([]string{"metric", "timestamp", "value"})
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		e.enc = json.NewEncoder(w)
+	}
+	return nil
+}
+
+func (e *rangeExport) write(s *ast.Sample) error {
+	if e.csv != nil {
+		return e.csv.Write([]string{
+			s.Metric.Metric.String(),
+			s.Timestamp.String(),
+			s.Value.String(),
+		})
+	}
+	return e.enc.Encode(&vectorSample{
+		Metric: s.Metric.Metric,
+		Value:  samplePair{s.Timestamp, s.Value.String()},
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// formatHeatmap is the format of range queries returning the buckets of
+// histograms as heatmaps.
+const formatHeatmap = "heatmap"
+
+// heatmapSeries holds the buckets of one histogram over time.
+type heatmapSeries struct {
+	// The labels of the histogram without the bucket label.
+	Metric clientmodel.Metric `json:"metric"`
+	// The lower and upper bound of each bucket, ordered by upper bound.
+	Buckets [][2]string `json:"buckets"`
+	// The counts of the buckets at each step, in the order of Buckets.
+	Values []heatmapPoint `json:"values"`
+}
+
+// heatmapPoint holds the counts of the buckets of a histogram at a point in
+// time. It is encoded as a JSON array of the timestamp in seconds and an
+// array of the counts as strings. Buckets without a sample at that time have
+// a count of NaN.
+type heatmapPoint struct {
+	Timestamp clientmodel.Timestamp
+	Counts    []string
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p heatmapPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.Timestamp, p.Counts})
+}
+
+// heatmapNode wraps the expression of a heatmap query in histogram_buckets(),
+// which de-accumulates the buckets at each step and normalizes their bounds.
+func heatmapNode(vector ast.VectorNode) (ast.VectorNode, error) {
+	f, err := ast.GetFunction("histogram_buckets")
+	if err != nil {
+		return nil, err
+	}
+	node, err := ast.NewFunctionCall(f, ast.Nodes{vector})
+	if err != nil {
+		return nil, err
+	}
+	return node.(ast.VectorNode), nil
+}
+
+// heatmapResult groups the series of the buckets returned by a heatmap query
+// into one heatmap per histogram. Series without a bucket label are dropped.
+func heatmapResult(matrix ast.Matrix) []heatmapSeries {
+	type histogram struct {
+		metric  clientmodel.Metric
+		buckets heatmapBuckets
+	}
+	histograms := map[clientmodel.Fingerprint]*histogram{}
+	var fps clientmodel.Fingerprints
+	for _, ss := range matrix {
+		upperBound, err := strconv.ParseFloat(string(ss.Metric.Metric[clientmodel.BucketLabel]), 64)
+		if err != nil {
+			continue
+		}
+		m := make(clientmodel.Metric, len(ss.Metric.Metric))
+		for ln, lv := range ss.Metric.Metric {
+			if ln != clientmodel.BucketLabel {
+				m[ln] = lv
+			}
+		}
+		fp := m.Fingerprint()
+		h, ok := histograms[fp]
+		if !ok {
+			h = &histogram{metric: m}
+			histograms[fp] = h
+			fps = append(fps, fp)
+		}
+		h.buckets = append(h.buckets, heatmapBucket{upperBound, ss.Values})
+	}
+
+	res := make([]heatmapSeries, 0, len(histograms))
+	for _, fp := range fps {
+		h := histograms[fp]
+		sort.Sort(h.buckets)
+
+		series := heatmapSeries{Metric: h.metric}
+		lowerBound := math.Inf(-1)
+		if h.buckets[0].upperBound > 0 {
+			// The natural lower bound assumed by histogram_quantile().
+			lowerBound = 0
+		}
+		// The counts of each bucket by timestamp.
+		counts := make([]map[clientmodel.Timestamp]clientmodel.SampleValue, len(h.buckets))
+		timestamps := map[clientmodel.Timestamp]struct{}{}
+		for i, b := range h.buckets {
+			series.Buckets = append(series.Buckets, [2]string{formatBound(lowerBound), formatBound(b.upperBound)})
+			lowerBound = b.upperBound
+
+			counts[i] = make(map[clientmodel.Timestamp]clientmodel.SampleValue, len(b.values))
+			for _, sp := range b.values {
+				counts[i][sp.Timestamp] = sp.Value
+				timestamps[sp.Timestamp] = struct{}{}
+			}
+		}
+
+		steps := make(timestampsAscending, 0, len(timestamps))
+		for ts := range timestamps {
+			steps = append(steps, ts)
+		}
+		sort.Sort(steps)
+		for _, ts := range steps {
+			p := heatmapPoint{Timestamp: ts, Counts: make([]string, len(h.buckets))}
+			for i := range h.buckets {
+				v, ok := counts[i][ts]
+				if !ok {
+					v = clientmodel.SampleValue(math.NaN())
+				}
+				p.Counts[i] = v.String()
+			}
+			series.Values = append(series.Values, p)
+		}
+		res = append(res, series)
+	}
+	return res
+}
+
+// formatBound formats a bucket bound like the bucket labels normalized by
+// histogram_buckets().
+func formatBound(bound float64) string {
+	switch {
+	case math.IsInf(bound, +1):
+		return "+Inf"
+	case math.IsInf(bound, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+type heatmapBucket struct {
+	upperBound float64
+	values     metric.Values
+}
+
+// heatmapBuckets implements sort.Interface by upper bound.
+type heatmapBuckets []heatmapBucket
+
+func (b heatmapBuckets) Len() int           { return len(b) }
+func (b heatmapBuckets) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b heatmapBuckets) Less(i, j int) bool { return b[i].upperBound < b[j].upperBound }
+
+type timestampsAscending []clientmodel.Timestamp
+
+func (t timestampsAscending) Len() int           { return len(t) }
+func (t timestampsAscending) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timestampsAscending) Less(i, j int) bool { return t[i].Before(t[j]) }
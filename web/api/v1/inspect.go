@@ -0,0 +1,144 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+	"sort"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// seriesInspection describes how a series is stored and where it comes from.
+type seriesInspection struct {
+	Fingerprint string             `json:"fingerprint"`
+	Metric      clientmodel.Metric `json:"metric"`
+	// Archived series have no chunks in memory, so that only Chunks is
+	// set for them.
+	Archived   bool            `json:"archived"`
+	Chunks     int             `json:"chunks"`
+	MemChunks  int             `json:"memChunks"`
+	HeadChunk  *headChunkRange `json:"headChunk,omitempty"`
+	LastSample *samplePair     `json:"lastSample,omitempty"`
+	// The targets whose labels the series carries, i.e. the targets that can
+	// have written it. Series whose target labels were overridden by exposed
+	// labels, as with honor_labels, are not attributed to their targets.
+	WrittenBy []writingTarget `json:"writtenBy"`
+}
+
+type headChunkRange struct {
+	Start clientmodel.Timestamp `json:"start"`
+	End   clientmodel.Timestamp `json:"end"`
+}
+
+type writingTarget struct {
+	ScrapeURL string               `json:"scrapeUrl"`
+	Labels    clientmodel.LabelSet `json:"labels"`
+}
+
+// inspectSeries describes the storage of all series matching any of the
+// match[] selectors and the targets that wrote them, ordered by metric.
+// At most limit series are returned, if given. Requests of a tenant only
+// consider its series and targets.
+func (api *API) inspectSeries(r *http.Request) (interface{}, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	targets, apiErr := api.tenantTargets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	matcherSets, apiErr := parseMatchersParams(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tenant != nil {
+		matcherSets = restrictMatcherSets(matcherSets, tenant)
+	}
+	limit, apiErr := parseLimit(r.FormValue("limit"))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	fps := map[clientmodel.Fingerprint]struct{}{}
+	for _, matchers := range matcherSets {
+		for _, fp := range api.Storage.GetFingerprintsForLabelMatchers(matchers) {
+			fps[fp] = struct{}{}
+		}
+	}
+	res := make(seriesInspections, 0, len(fps))
+	for fp := range fps {
+		info, ok := api.Storage.GetSeriesInfo(fp)
+		if !ok {
+			// The series has been purged in the meantime.
+			continue
+		}
+		s := seriesInspection{
+			Fingerprint: fp.String(),
+			Metric:      api.Storage.GetMetricForFingerprint(fp).Metric,
+			Archived:    info.Archived,
+			Chunks:      info.NumChunks,
+			MemChunks:   info.NumMemChunks,
+			WrittenBy:   []writingTarget{},
+		}
+		if !info.Archived {
+			s.HeadChunk = &headChunkRange{
+				Start: info.HeadChunk.OldestInclusive,
+				End:   info.HeadChunk.NewestInclusive,
+			}
+		}
+		if info.LastSample != nil {
+			s.LastSample = &samplePair{info.LastSample.Timestamp, info.LastSample.Value.String()}
+		}
+		res = append(res, s)
+	}
+	sort.Sort(res)
+	if limit >= 0 && len(res) > limit {
+		res = res[:limit]
+	}
+
+	for _, t := range targets {
+		labels := targetLabels(t)
+		for i := range res {
+			if hasLabels(res[i].Metric, labels) {
+				res[i].WrittenBy = append(res[i].WrittenBy, writingTarget{
+					ScrapeURL: config.MaskURLPassword(t.URL()),
+					Labels:    labels,
+				})
+			}
+		}
+	}
+	return res, nil
+}
+
+// hasLabels returns whether m has all of the given labels.
+func hasLabels(m clientmodel.Metric, labels clientmodel.LabelSet) bool {
+	for ln, lv := range labels {
+		if m[ln] != lv {
+			return false
+		}
+	}
+	return true
+}
+
+type seriesInspections []seriesInspection
+
+func (s seriesInspections) Len() int      { return len(s) }
+func (s seriesInspections) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s seriesInspections) Less(i, j int) bool {
+	return s[i].Metric.String() < s[j].Metric.String()
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+)
+
+type lintWarning struct {
+	// The name of the rule the warning is about, if rules were linted.
+	Rule string `json:"rule,omitempty"`
+	ast.LintWarning
+}
+
+// lint checks the expression given by the query parameter, or the rules of
+// the rule file given by the rules parameter, for common anti-patterns. The
+// types of metrics are taken from the metadata of the active targets.
+func (api *API) lint(r *http.Request) (interface{}, *apiError) {
+	opts := ast.LintOptions{
+		MetricType:     api.metricTypes(),
+		ScrapeInterval: api.scrapeInterval(),
+	}
+
+	warnings := []lintWarning{}
+	switch query, ruleFile := r.FormValue("query"), r.FormValue("rules"); {
+	case query != "" && ruleFile != "":
+		return nil, &apiError{errorBadData, fmt.Errorf("only one of the query and rules parameters may be given")}
+	case query != "":
+		expr, err := rules.LoadExprFromString(query)
+		if err != nil {
+			return nil, &apiError{errorBadData, err}
+		}
+		for _, w := range ast.Lint(expr, opts) {
+			warnings = append(warnings, lintWarning{LintWarning: w})
+		}
+	case ruleFile != "":
+		rs, err := rules.LoadRulesFromString(ruleFile)
+		if err != nil {
+			return nil, &apiError{errorBadData, err}
+		}
+		for _, rule := range rs {
+			for _, w := range ast.Lint(rule.Expr(), opts) {
+				warnings = append(warnings, lintWarning{Rule: rule.Name(), LintWarning: w})
+			}
+		}
+	default:
+		return nil, &apiError{errorBadData, fmt.Errorf("missing query or rules parameter")}
+	}
+	return warnings, nil
+}
+
+// metricTypes returns a lookup of the types of metrics as exposed by the
+// active targets. Metrics exposed with different types, or only untyped, are
+// of unknown type.
+func (api *API) metricTypes() func(clientmodel.LabelValue) string {
+	types := map[clientmodel.LabelValue]string{}
+	for _, t := range api.activeTargets() {
+		for _, md := range t.Metadata() {
+			if md.Type == "untyped" {
+				continue
+			}
+			name := clientmodel.LabelValue(md.Metric)
+			if typ, ok := types[name]; ok && typ != md.Type {
+				types[name] = ""
+				continue
+			}
+			types[name] = md.Type
+		}
+	}
+	return func(name clientmodel.LabelValue) string {
+		return types[name]
+	}
+}
+
+func (api *API) scrapeInterval() time.Duration {
+	api.mtx.RLock()
+	defer api.mtx.RUnlock()
+	return api.ScrapeInterval
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/retrieval"
+	"github.com/prometheus/prometheus/web/httputils"
+)
+
+const pushPathPrefix = "/api/v1/push/"
+
+// maxPushBodySize limits the size of a pushed request body in bytes.
+const maxPushBodySize = 10 << 20
+
+// RegisterPushHandler registers the handler of the push endpoint, which
+// ingests samples in the text exposition format POSTed to
+// /api/v1/push/job/<job>[/instance/<instance>] via the PushIngester.
+func (api *API) RegisterPushHandler() {
+	http.Handle(pushPathPrefix, httputils.InstrumentHandler(
+		pushPathPrefix, postHandler(apiHandler(api.push)),
+	))
+}
+
+// push ingests the samples of the request body with the job and instance
+// labels set as given by the path, and the tenant label set to the tenant of
+// the request, if any. Samples without an explicit timestamp get the time of
+// the request.
+func (api *API) push(r *http.Request) (interface{}, *apiError) {
+	grouping, err := pushGroupingLabels(strings.TrimPrefix(r.URL.Path, pushPathPrefix))
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tenant != nil {
+		grouping[tenant.Name] = tenant.Value
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPushBodySize+1))
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	if len(body) > maxPushBodySize {
+		return nil, &apiError{errorBadData, fmt.Errorf("request body exceeds %d bytes", maxPushBodySize)}
+	}
+	samples, err := retrieval.DecodeTextSamples(bytes.NewReader(body), clientmodel.Now())
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+
+	for _, s := range samples {
+		for name, value := range grouping {
+			if v, ok := s.Metric[name]; ok && v != value {
+				return nil, &apiError{errorBadData, fmt.Errorf("sample %s conflicts with grouping label %s=%q", s.Metric, name, value)}
+			}
+			s.Metric[name] = value
+		}
+	}
+	if err := api.PushIngester.Ingest(samples); err != nil {
+		return nil, &apiError{errorInternal, err}
+	}
+	return nil, nil
+}
+
+// pushGroupingLabels parses the grouping labels of a push path of the form
+// job/<job>[/instance/<instance>].
+func pushGroupingLabels(path string) (clientmodel.LabelSet, error) {
+	parts := strings.Split(path, "/")
+	if (len(parts) != 2 && len(parts) != 4) || parts[0] != "job" || (len(parts) == 4 && parts[2] != "instance") {
+		return nil, fmt.Errorf("invalid push path %q, expected job/<job>[/instance/<instance>]", path)
+	}
+	if parts[1] == "" {
+		return nil, fmt.Errorf("empty job in push path %q", path)
+	}
+	grouping := clientmodel.LabelSet{clientmodel.JobLabel: clientmodel.LabelValue(parts[1])}
+	if len(parts) == 4 {
+		if parts[3] == "" {
+			return nil, fmt.Errorf("empty instance in push path %q", path)
+		}
+		grouping[retrieval.InstanceLabel] = clientmodel.LabelValue(parts[3])
+	}
+	return grouping, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+)
+
+// String constants for instrumentation.
+const (
+	namespace = "prometheus"
+	subsystem = "query_cache"
+)
+
+// QueryCache caches the results of range queries. A query of the same
+// expression and step as a cached one, starting within the cached range and
+// at the same phase of the step, only needs to evaluate the points after the
+// cached range. That is the common case for dashboards refreshing their
+// panels over a moving time range.
+//
+// Only points older than the horizon are cached, as samples ingested later
+// can still change the values of more recent points. QueryCaches are safe for
+// concurrent use.
+type QueryCache struct {
+	mtx     sync.Mutex
+	size    int
+	horizon time.Duration
+	entries map[queryCacheKey]*list.Element
+	lru     *list.List // Of *queryCacheEntry, most recently used first.
+
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+type queryCacheKey struct {
+	expr string
+	step time.Duration
+}
+
+// queryCacheEntry holds the points of a range query result between start and
+// end.
+type queryCacheEntry struct {
+	key        queryCacheKey
+	start, end clientmodel.Timestamp
+	matrix     ast.Matrix
+}
+
+// NewQueryCache returns a QueryCache holding the results of up to size range
+// queries.
+func NewQueryCache(size int, horizon time.Duration) *QueryCache {
+	return &QueryCache{
+		size:    size,
+		horizon: horizon,
+		entries: map[queryCacheKey]*list.Element{},
+		lru:     list.New(),
+
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "hits_total",
+			Help:      "Total number of range queries that reused cached results.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "misses_total",
+			Help:      "Total number of range queries that were evaluated fully.",
+		}),
+	}
+}
+
+// get returns the cached points of a query between start and end and the
+// timestamp from which on the query still has to be evaluated, which is start
+// if nothing is cached.
+func (c *QueryCache) get(key queryCacheKey, start, end clientmodel.Timestamp) (ast.Matrix, clientmodel.Timestamp) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Inc()
+		return ast.Matrix{}, start
+	}
+	e := el.Value.(*queryCacheEntry)
+	if start.Before(e.start) || start.After(e.end) || start.Sub(e.start)%key.step != 0 {
+		c.misses.Inc()
+		return ast.Matrix{}, start
+	}
+	c.hits.Inc()
+	c.lru.MoveToFront(el)
+	if end.After(e.end) {
+		end = e.end
+	}
+	return sliceMatrix(e.matrix, start, end), end.Add(key.step)
+}
+
+// put caches the points of a query result between start and end that are
+// older than the horizon, replacing what was cached for the query before.
+func (c *QueryCache) put(key queryCacheKey, start, end clientmodel.Timestamp, matrix ast.Matrix, now clientmodel.Timestamp) {
+	if limit := now.Add(-c.horizon); end.After(limit) {
+		// Cache up to the last point before the horizon.
+		end = limit.Add(-(limit.Sub(start) % key.step))
+	}
+	if end.Before(start) {
+		return
+	}
+	e := &queryCacheEntry{
+		key:    key,
+		start:  start,
+		end:    end,
+		matrix: sliceMatrix(matrix, start, end),
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Remove(c.lru.Back()).(*queryCacheEntry)
+		delete(c.entries, oldest.key)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *QueryCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits.Desc()
+	ch <- c.misses.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (c *QueryCache) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.hits
+	ch <- c.misses
+}
+
+// sliceMatrix returns the points of m between start and end. The values of
+// the result share their memory with m but have no spare capacity, so that
+// appending to them does not modify m.
+func sliceMatrix(m ast.Matrix, start, end clientmodel.Timestamp) ast.Matrix {
+	sliced := make(ast.Matrix, 0, len(m))
+	for _, ss := range m {
+		i := 0
+		for i < len(ss.Values) && ss.Values[i].Timestamp.Before(start) {
+			i++
+		}
+		j := i
+		for j < len(ss.Values) && !ss.Values[j].Timestamp.After(end) {
+			j++
+		}
+		if i == j {
+			continue
+		}
+		sliced = append(sliced, ast.SampleStream{
+			Metric: ss.Metric,
+			Values: ss.Values[i:j:j],
+		})
+	}
+	return sliced
+}
+
+// mergeMatrices appends the points of the series in tail to the points of the
+// same series in head.
+func mergeMatrices(head, tail ast.Matrix) ast.Matrix {
+	byFP := make(map[clientmodel.Fingerprint]int, len(head))
+	merged := make(ast.Matrix, len(head), len(head)+len(tail))
+	copy(merged, head)
+	for i, ss := range merged {
+		byFP[ss.Metric.Metric.Fingerprint()] = i
+	}
+	for _, ss := range tail {
+		if i, ok := byFP[ss.Metric.Metric.Fingerprint()]; ok {
+			merged[i].Values = append(merged[i].Values, ss.Values...)
+			continue
+		}
+		merged = append(merged, ss)
+	}
+	return merged
+}
+
+// evalRange evaluates a range query of vector, reusing the cached results of
+// previous queries if the API has a query cache.
+func (api *API) evalRange(vector ast.VectorNode, start, end clientmodel.Timestamp, step, timeout time.Duration, queryStats *stats.TimerGroup) (ast.Matrix, error) {
+	if api.QueryCache == nil {
+		return ast.EvalVectorRangeWithTimeout(vector, start, end, step, timeout, api.Storage, queryStats)
+	}
+	key := queryCacheKey{expr: vector.String(), step: step}
+	matrix, next := api.QueryCache.get(key, start, end)
+	if !next.After(end) {
+		tail, err := ast.EvalVectorRangeWithTimeout(vector, next, end, step, timeout, api.Storage, queryStats)
+		if err != nil {
+			return nil, err
+		}
+		matrix = mergeMatrices(matrix, tail)
+	}
+	api.QueryCache.put(key, start, end, matrix, clientmodel.Now())
+	return matrix, nil
+}
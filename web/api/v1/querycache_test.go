@@ -0,0 +1,236 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+const testStep = 10 * time.Second
+
+var testMetric = clientmodel.COWMetric{
+	Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "up"},
+}
+
+// testMatrix returns a matrix of a single series with points every testStep
+// from start to end, inclusive, whose values are offset+i for the i-th point.
+func testMatrix(start, end clientmodel.Timestamp, offset clientmodel.SampleValue) ast.Matrix {
+	var values metric.Values
+	for ts, i := start, 0; !ts.After(end); ts, i = ts.Add(testStep), i+1 {
+		values = append(values, metric.SamplePair{Timestamp: ts, Value: offset + clientmodel.SampleValue(i)})
+	}
+	return ast.Matrix{{Metric: testMetric, Values: values}}
+}
+
+func expectMatrix(t *testing.T, name string, got, want ast.Matrix) {
+	if len(got) != len(want) {
+		t.Fatalf("%s: expected %d series, got %d", name, len(want), len(got))
+	}
+	for i := range want {
+		if len(got[i].Values) != len(want[i].Values) {
+			t.Fatalf("%s: expected %d points of %v, got %v", name, len(want[i].Values), want[i].Metric.Metric, got[i].Values)
+		}
+		for j, v := range want[i].Values {
+			if !v.Equal(&got[i].Values[j]) {
+				t.Errorf("%s: expected point %v of %v, got %v", name, v, want[i].Metric.Metric, got[i].Values[j])
+			}
+		}
+	}
+}
+
+func TestQueryCacheGet(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(10000)
+	start := now.Add(-time.Hour)
+	end := start.Add(10 * testStep)
+	key := queryCacheKey{expr: "up", step: testStep}
+
+	c := NewQueryCache(10, 0)
+	c.put(key, start, end, testMatrix(start, end, 0), now)
+
+	for _, s := range []struct {
+		name       string
+		key        queryCacheKey
+		start, end clientmodel.Timestamp
+		cached     ast.Matrix
+		next       clientmodel.Timestamp
+	}{
+		{
+			name:   "miss of other expression",
+			key:    queryCacheKey{expr: "down", step: testStep},
+			start:  start,
+			end:    end,
+			cached: ast.Matrix{},
+			next:   start,
+		},
+		{
+			name:   "miss of other step",
+			key:    queryCacheKey{expr: "up", step: 2 * testStep},
+			start:  start,
+			end:    end,
+			cached: ast.Matrix{},
+			next:   start,
+		},
+		{
+			name:   "miss of misaligned start",
+			key:    key,
+			start:  start.Add(testStep / 2),
+			end:    end,
+			cached: ast.Matrix{},
+			next:   start.Add(testStep / 2),
+		},
+		{
+			name:   "miss of start before cached range",
+			key:    key,
+			start:  start.Add(-testStep),
+			end:    end,
+			cached: ast.Matrix{},
+			next:   start.Add(-testStep),
+		},
+		{
+			name:   "miss of start after cached range",
+			key:    key,
+			start:  end.Add(testStep),
+			end:    end.Add(2 * testStep),
+			cached: ast.Matrix{},
+			next:   end.Add(testStep),
+		},
+		{
+			name:   "hit within cached range",
+			key:    key,
+			start:  start.Add(2 * testStep),
+			end:    start.Add(5 * testStep),
+			cached: testMatrix(start.Add(2*testStep), start.Add(5*testStep), 2),
+			next:   start.Add(6 * testStep),
+		},
+		{
+			name:   "hit beyond cached range",
+			key:    key,
+			start:  start.Add(4 * testStep),
+			end:    end.Add(5 * testStep),
+			cached: testMatrix(start.Add(4*testStep), end, 4),
+			next:   end.Add(testStep),
+		},
+	} {
+		cached, next := c.get(s.key, s.start, s.end)
+		expectMatrix(t, s.name, cached, s.cached)
+		if next != s.next {
+			t.Errorf("%s: expected evaluation from %v, got %v", s.name, s.next, next)
+		}
+	}
+}
+
+func TestQueryCacheHorizon(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(10000)
+	start := now.Add(-10*testStep - testStep/2)
+	key := queryCacheKey{expr: "up", step: testStep}
+
+	// Only the points older than the horizon of 3 steps are cached, i.e. the
+	// ones up to 3.5 steps before now.
+	c := NewQueryCache(10, 3*testStep)
+	c.put(key, start, now.Add(-testStep/2), testMatrix(start, now, 0), now)
+	cached, next := c.get(key, start, now)
+	expectMatrix(t, "horizon", cached, testMatrix(start, start.Add(7*testStep), 0))
+	if want := start.Add(8 * testStep); next != want {
+		t.Errorf("Expected evaluation from %v, got %v", want, next)
+	}
+
+	// Nothing is cached of results entirely within the horizon.
+	key.expr = "recent"
+	c.put(key, now.Add(-2*testStep), now, testMatrix(now.Add(-2*testStep), now, 0), now)
+	if _, next := c.get(key, now.Add(-2*testStep), now); next != now.Add(-2*testStep) {
+		t.Errorf("Expected no cached points within the horizon, got evaluation from %v", next)
+	}
+}
+
+func TestQueryCacheEviction(t *testing.T) {
+	now := clientmodel.TimestampFromUnix(10000)
+	start := now.Add(-time.Hour)
+	end := start.Add(testStep)
+	keys := []queryCacheKey{
+		{expr: "a", step: testStep},
+		{expr: "b", step: testStep},
+		{expr: "c", step: testStep},
+	}
+
+	c := NewQueryCache(2, 0)
+	c.put(keys[0], start, end, testMatrix(start, end, 0), now)
+	c.put(keys[1], start, end, testMatrix(start, end, 0), now)
+	// Using a makes b the least recently used query, evicted by c.
+	c.get(keys[0], start, end)
+	c.put(keys[2], start, end, testMatrix(start, end, 0), now)
+
+	for i, cached := range []bool{true, false, true} {
+		_, next := c.get(keys[i], start, end)
+		if got := next != start; got != cached {
+			t.Errorf("Query %s: expected cached %t, got %t", keys[i].expr, cached, got)
+		}
+	}
+	if len(c.entries) != 2 || c.lru.Len() != 2 {
+		t.Errorf("Expected 2 cached queries, got %d entries and %d in LRU list", len(c.entries), c.lru.Len())
+	}
+}
+
+func TestEvalRangeMergesCachedResults(t *testing.T) {
+	start := clientmodel.TimestampFromUnix(clientmodel.Now().Unix() - 3600)
+	end := start.Add(10 * testStep)
+	storage, closer := local.NewTestStorage(t)
+	defer closer.Close()
+	for _, v := range testMatrix(start, end, 0)[0].Values {
+		storage.AppendSamples(clientmodel.Samples{{
+			Metric:    testMetric.Metric,
+			Value:     v.Value,
+			Timestamp: v.Timestamp,
+		}})
+	}
+	storage.WaitForIndexing()
+
+	expr, err := rules.LoadExprFromString("up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vector := expr.(ast.VectorNode)
+	evalRange := func(api *API) ast.Matrix {
+		matrix, err := api.evalRange(vector, start, end, testStep, time.Minute, stats.NewTimerGroup())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matrix
+	}
+	api := &API{Storage: storage, QueryCache: NewQueryCache(10, 0)}
+
+	// The cached head is merged with the evaluated tail, so the head's
+	// distinct values show up in the result.
+	key := queryCacheKey{expr: vector.String(), step: testStep}
+	head := start.Add(4 * testStep)
+	api.QueryCache.put(key, start, head, testMatrix(start, head, 100), clientmodel.Now())
+	got := evalRange(api)
+	want := testMatrix(start, end, 0)
+	for i := 0; i <= 4; i++ {
+		want[0].Values[i].Value += 100
+	}
+	expectMatrix(t, "merged", got, want)
+
+	// The whole result is cached now, so the same query returns it again.
+	expectMatrix(t, "cached", evalRange(api), want)
+	expectMatrix(t, "uncached", evalRange(&API{Storage: storage}), testMatrix(start, end, 0))
+}
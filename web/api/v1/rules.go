@@ -0,0 +1,309 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// Statuses of previewed rules compared to the loaded rules of the same name.
+const (
+	ruleAdded     = "added"
+	ruleRemoved   = "removed"
+	ruleModified  = "modified"
+	ruleUnchanged = "unchanged"
+)
+
+// rulePreview is the output of the candidate rules of one name and type
+// compared to that of the loaded rules of the same name and type.
+type rulePreview struct {
+	Name string `json:"name"`
+	// Either "recording" or "alerting".
+	Type string `json:"type"`
+	// Whether the rules were added, removed, modified, or left unchanged
+	// by the candidate rule file.
+	Status string `json:"status"`
+	// The series the candidate rules produce. For alerting rules, these are
+	// the ALERTS series of the alerts they make active, without the
+	// alertstate label.
+	Series []vectorSample `json:"series"`
+	// The differences to the series the loaded rules produce.
+	Added   []vectorSample `json:"added"`
+	Removed []vectorSample `json:"removed"`
+	Changed []seriesChange `json:"changed"`
+	// Errors evaluating the candidate and the loaded rules, respectively.
+	Error        string `json:"error,omitempty"`
+	CurrentError string `json:"currentError,omitempty"`
+}
+
+// seriesChange is a series produced by both the candidate and the loaded
+// rules with different values.
+type seriesChange struct {
+	Metric clientmodel.Metric `json:"metric"`
+	Old    samplePair         `json:"old"`
+	New    samplePair         `json:"new"`
+}
+
+// ruleGroup holds the rules of one name and type in the order of their rule
+// file.
+type ruleGroup struct {
+	name, typ string
+	rules     []rules.Rule
+}
+
+func (g *ruleGroup) String() string {
+	s := ""
+	for _, r := range g.rules {
+		s += r.String()
+	}
+	return s
+}
+
+// groupRules groups rules by name and type, keeping the order in which each
+// group first appears.
+func groupRules(rs []rules.Rule) ([]*ruleGroup, map[string]*ruleGroup) {
+	var groups []*ruleGroup
+	byKey := map[string]*ruleGroup{}
+	for _, r := range rs {
+		typ := "recording"
+		if _, ok := r.(*rules.AlertingRule); ok {
+			typ = "alerting"
+		}
+		key := typ + "/" + r.Name()
+		g, ok := byKey[key]
+		if !ok {
+			g = &ruleGroup{name: r.Name(), typ: typ}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.rules = append(g.rules, r)
+	}
+	return groups, byKey
+}
+
+// previewRules evaluates the rules of the rule file given by the rules
+// parameter at a single point in time (time, defaulting to now) and compares
+// their output to that of the loaded rules. Nothing is recorded, and no state
+// of the loaded rules is changed. The selectors of both the candidate and the
+// loaded rules are restricted to the tenant of the request, if any.
+func (api *API) previewRules(r *http.Request) (interface{}, *apiError) {
+	if apiErr := api.checkThrottled(); apiErr != nil {
+		return nil, apiErr
+	}
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	ruleFile := r.FormValue("rules")
+	if ruleFile == "" {
+		return nil, &apiError{errorBadData, fmt.Errorf("missing rules parameter")}
+	}
+	candidate, err := rules.LoadRulesFromString(ruleFile)
+	if err != nil {
+		return nil, &apiError{errorBadData, err}
+	}
+	ts, apiErr := parseTimeParam(r, "time", clientmodel.Now())
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	var restriction []*metric.LabelMatcher
+	if tenant != nil {
+		restriction = append(restriction, tenant)
+	}
+	for _, rule := range candidate {
+		ast.RestrictSelectors(rule.Expr(), restriction...)
+	}
+	// The loaded rules are evaluated as copies, which neither race with
+	// their evaluation by the rule manager nor pass the restriction on to it.
+	var loaded []rules.Rule
+	if api.RuleManager != nil {
+		for _, rule := range api.RuleManager.Rules() {
+			loaded = append(loaded, rules.RestrictedCopy(rule, restriction...))
+		}
+	}
+
+	candidateGroups, candidateByKey := groupRules(candidate)
+	loadedGroups, loadedByKey := groupRules(loaded)
+
+	res := []rulePreview{}
+	for _, g := range candidateGroups {
+		res = append(res, api.previewRuleGroup(g, loadedByKey[g.typ+"/"+g.name], ts))
+	}
+	for _, g := range loadedGroups {
+		if _, ok := candidateByKey[g.typ+"/"+g.name]; !ok {
+			res = append(res, api.previewRuleGroup(nil, g, ts))
+		}
+	}
+	return res, nil
+}
+
+// previewRuleGroup compares the output of the candidate and the loaded rules
+// of a name and type. Either may be nil if there are no such rules.
+func (api *API) previewRuleGroup(candidate, loaded *ruleGroup, ts clientmodel.Timestamp) rulePreview {
+	var p rulePreview
+	switch {
+	case loaded == nil:
+		p.Name, p.Type, p.Status = candidate.name, candidate.typ, ruleAdded
+	case candidate == nil:
+		p.Name, p.Type, p.Status = loaded.name, loaded.typ, ruleRemoved
+	case candidate.String() != loaded.String():
+		p.Name, p.Type, p.Status = candidate.name, candidate.typ, ruleModified
+	default:
+		p.Name, p.Type, p.Status = candidate.name, candidate.typ, ruleUnchanged
+	}
+
+	newSeries, err := api.previewOutput(candidate, ts)
+	if err != nil {
+		p.Error = err.Error()
+	}
+	oldSeries, err := api.previewOutput(loaded, ts)
+	if err != nil {
+		p.CurrentError = err.Error()
+	}
+
+	p.Series = sortedSamples(newSeries)
+	p.Added, p.Removed, p.Changed = []vectorSample{}, []vectorSample{}, []seriesChange{}
+	for _, s := range p.Series {
+		old, ok := oldSeries[s.Metric.Fingerprint()]
+		switch {
+		case !ok:
+			p.Added = append(p.Added, s)
+		case old.Value.Value != s.Value.Value:
+			p.Changed = append(p.Changed, seriesChange{Metric: s.Metric, Old: old.Value, New: s.Value})
+		}
+	}
+	for _, s := range sortedSamples(oldSeries) {
+		if _, ok := newSeries[s.Metric.Fingerprint()]; !ok {
+			p.Removed = append(p.Removed, s)
+		}
+	}
+	return p
+}
+
+// previewOutput returns the output of the rules of a group by fingerprint. A
+// group failing to evaluate produces no output.
+func (api *API) previewOutput(g *ruleGroup, ts clientmodel.Timestamp) (map[clientmodel.Fingerprint]vectorSample, error) {
+	res := map[clientmodel.Fingerprint]vectorSample{}
+	if g == nil {
+		return res, nil
+	}
+	for _, rule := range g.rules {
+		vector, err := rule.Preview(ts, api.Storage, stats.NewTimerGroup())
+		if err != nil {
+			return map[clientmodel.Fingerprint]vectorSample{}, fmt.Errorf("%s: %s", rule.Name(), err)
+		}
+		for _, s := range vector {
+			res[s.Metric.Metric.Fingerprint()] = vectorSample{
+				Metric: s.Metric.Metric,
+				Value:  samplePair{s.Timestamp, s.Value.String()},
+			}
+		}
+	}
+	return res, nil
+}
+
+// sortedSamples returns the samples ordered by their metrics.
+func sortedSamples(samples map[clientmodel.Fingerprint]vectorSample) []vectorSample {
+	res := make(vectorSamplesByMetric, 0, len(samples))
+	for _, s := range samples {
+		res = append(res, s)
+	}
+	sort.Sort(res)
+	return res
+}
+
+type vectorSamplesByMetric []vectorSample
+
+func (s vectorSamplesByMetric) Len() int      { return len(s) }
+func (s vectorSamplesByMetric) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s vectorSamplesByMetric) Less(i, j int) bool {
+	return s[i].Metric.String() < s[j].Metric.String()
+}
+
+// testFiredAlert is the notification sent for a test alert.
+type testFiredAlert struct {
+	Summary      string                  `json:"summary"`
+	Description  string                  `json:"description"`
+	Labels       clientmodel.LabelSet    `json:"labels"`
+	Value        clientmodel.SampleValue `json:"value"`
+	ActiveSince  time.Time               `json:"activeSince"`
+	GeneratorURL string                  `json:"generatorURL"`
+}
+
+// testFireAlert sends the notification of a firing test alert of the
+// alerting rule named by the alertname parameter to the alert manager and
+// waits for it to be received. The alert has the labels given by the label
+// parameters, each of the form <name>=<value>, and the value of the value
+// parameter, which defaults to 1. The rule's state is not changed. It returns
+// the notification sent.
+func (api *API) testFireAlert(r *http.Request) (interface{}, *apiError) {
+	if api.RuleManager == nil {
+		return nil, &apiError{errorUnavailable, fmt.Errorf("no rule manager")}
+	}
+	name := r.FormValue("alertname")
+	if name == "" {
+		return nil, &apiError{errorBadData, fmt.Errorf("missing alertname parameter")}
+	}
+	var rule *rules.AlertingRule
+	for _, ar := range api.RuleManager.AlertingRules() {
+		if ar.Name() == name {
+			rule = ar
+			break
+		}
+	}
+	if rule == nil {
+		return nil, &apiError{errorBadData, fmt.Errorf("unknown alerting rule %q", name)}
+	}
+
+	labels := clientmodel.LabelSet{}
+	for _, l := range r.Form["label"] {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || !labelNameRE.MatchString(parts[0]) {
+			return nil, &apiError{errorBadData, fmt.Errorf("invalid label %q, must be of the form <name>=<value>", l)}
+		}
+		labels[clientmodel.LabelName(parts[0])] = clientmodel.LabelValue(parts[1])
+	}
+	value := 1.0
+	if s := r.FormValue("value"); s != "" {
+		var err error
+		if value, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, &apiError{errorBadData, fmt.Errorf("invalid parameter value: %s", err)}
+		}
+	}
+
+	req, err := api.RuleManager.TestFire(rule, labels, clientmodel.SampleValue(value))
+	if err != nil {
+		return nil, &apiError{errorUnavailable, fmt.Errorf("error sending notification: %s", err)}
+	}
+	return &testFiredAlert{
+		Summary:      req.Summary,
+		Description:  req.Description,
+		Labels:       req.Labels,
+		Value:        req.Value,
+		ActiveSince:  req.ActiveSince,
+		GeneratorURL: req.GeneratorURL,
+	}, nil
+}
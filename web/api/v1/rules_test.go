@@ -0,0 +1,125 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/manager"
+	"github.com/prometheus/prometheus/storage/local"
+	"github.com/prometheus/prometheus/utility/test"
+)
+
+type fakeRuleManager struct {
+	manager.RuleManager
+	rules []rules.Rule
+}
+
+func (m *fakeRuleManager) Rules() []rules.Rule {
+	return m.rules
+}
+
+// newTenantTestStorage returns a storage with an up series of each of the
+// tenants a and b, with the values 1 and 2, respectively.
+func newTenantTestStorage(t *testing.T, ts clientmodel.Timestamp) (local.Storage, test.Closer) {
+	storage, closer := local.NewTestStorage(t)
+	storage.AppendSamples(clientmodel.Samples{
+		{
+			Metric:    clientmodel.Metric{clientmodel.MetricNameLabel: "up", "tenant": "a"},
+			Value:     1,
+			Timestamp: ts,
+		},
+		{
+			Metric:    clientmodel.Metric{clientmodel.MetricNameLabel: "up", "tenant": "b"},
+			Value:     2,
+			Timestamp: ts,
+		},
+	})
+	storage.WaitForIndexing()
+	return storage, closer
+}
+
+func TestPreviewRulesTenancy(t *testing.T) {
+	ts := clientmodel.Now()
+	storage, closer := newTenantTestStorage(t, ts)
+	defer closer.Close()
+
+	loaded, err := rules.LoadRulesFromString("job:up:sum = sum(up)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedExpr := loaded[0].Expr().String()
+	api := &API{
+		Storage:      storage,
+		RuleManager:  &fakeRuleManager{rules: loaded},
+		TenantHeader: "X-Tenant",
+	}
+
+	previewAs := func(tenant string) []rulePreview {
+		r, err := http.NewRequest("POST", "/api/v1/rules/preview", strings.NewReader(url.Values{
+			"rules": {"job:up:sum = sum(up) * 10\n"},
+			"time":  {ts.String()},
+		}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		res, apiErr := api.previewRules(r)
+		if apiErr != nil {
+			t.Fatalf("Error previewing rules as tenant %q: %v", tenant, apiErr.err)
+		}
+		return res.([]rulePreview)
+	}
+
+	for _, c := range []struct {
+		tenant   string
+		old, new string
+	}{
+		{tenant: "", old: "3", new: "30"},
+		{tenant: "a", old: "1", new: "10"},
+		{tenant: "b", old: "2", new: "20"},
+	} {
+		res := previewAs(c.tenant)
+		if len(res) != 1 || len(res[0].Changed) != 1 {
+			t.Fatalf("Tenant %q: unexpected preview %+v", c.tenant, res)
+		}
+		change := res[0].Changed[0]
+		if change.Old.Value != c.old || change.New.Value != c.new {
+			t.Errorf("Tenant %q: expected change from %s to %s, got %+v", c.tenant, c.old, c.new, change)
+		}
+	}
+
+	if got := loaded[0].Expr().String(); got != loadedExpr {
+		t.Errorf("Loaded rule was changed from %s to %s", loadedExpr, got)
+	}
+
+	api.RequireTenant = true
+	r, err := http.NewRequest("POST", "/api/v1/rules/preview", strings.NewReader("rules=x+%3D+up%0A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, apiErr := api.previewRules(r); apiErr == nil {
+		t.Error("Expected error previewing rules without a tenant")
+	}
+}
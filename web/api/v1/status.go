@@ -0,0 +1,106 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+type configStatus struct {
+	Config string `json:"config"`
+}
+
+// statusConfig returns the currently loaded configuration in the protobuf
+// text format, with secrets masked. As it covers the jobs of all tenants, it
+// is not returned to requests restricted to a tenant.
+func (api *API) statusConfig(r *http.Request) (interface{}, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tenant != nil {
+		return nil, &apiError{errorBadData, fmt.Errorf("configuration not available to tenant %s", tenant.Value)}
+	}
+	api.mtx.RLock()
+	defer api.mtx.RUnlock()
+	return &configStatus{Config: api.Config}, nil
+}
+
+// statusFlags returns the values of all command-line flags.
+func (api *API) statusFlags(r *http.Request) (interface{}, *apiError) {
+	return api.Flags, nil
+}
+
+// statusBuildInfo returns the version information of the running binary.
+func (api *API) statusBuildInfo(r *http.Request) (interface{}, *apiError) {
+	return api.BuildInfo, nil
+}
+
+type storageStatus struct {
+	NumSeries              int        `json:"numSeries"`
+	NumMemChunks           int64      `json:"numMemChunks"`
+	MaxMemChunks           int        `json:"maxMemChunks"`
+	Dirty                  bool       `json:"dirty"`
+	CheckpointInterval     string     `json:"checkpointInterval"`
+	LastCheckpoint         *time.Time `json:"lastCheckpoint"`
+	LastCheckpointDuration float64    `json:"lastCheckpointDuration"`
+}
+
+type runtimeStatus struct {
+	StartTime        time.Time      `json:"startTime"`
+	CWD              string         `json:"CWD"`
+	GoroutineCount   int            `json:"goroutineCount"`
+	GOMAXPROCS       int            `json:"GOMAXPROCS"`
+	StorageRetention string         `json:"storageRetention"`
+	Storage          *storageStatus `json:"storage"`
+}
+
+// statusRuntimeInfo returns information about the running server and the
+// state of its local storage. The last checkpoint is null if no checkpoint
+// was written since the start. Its duration is in seconds. In agent mode, the
+// storage retention is empty and the storage is null.
+func (api *API) statusRuntimeInfo(r *http.Request) (interface{}, *apiError) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		cwd = err.Error()
+	}
+	res := &runtimeStatus{
+		StartTime:      api.Birth,
+		CWD:            cwd,
+		GoroutineCount: runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+	}
+	if api.Storage == nil {
+		return res, nil
+	}
+
+	stats := api.Storage.Stats()
+	res.StorageRetention = stats.RetentionPeriod.String()
+	res.Storage = &storageStatus{
+		NumSeries:              stats.NumSeries,
+		NumMemChunks:           stats.NumMemChunks,
+		MaxMemChunks:           stats.MaxMemChunks,
+		Dirty:                  stats.Dirty,
+		CheckpointInterval:     stats.CheckpointInterval.String(),
+		LastCheckpointDuration: stats.LastCheckpointDuration.Seconds(),
+	}
+	if !stats.LastCheckpoint.IsZero() {
+		res.Storage.LastCheckpoint = &stats.LastCheckpoint
+	}
+	return res, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/tracing"
+)
+
+// queryStream subscribes to an expression (query) evaluated at the current
+// time every interval (interval, defaulting to the evaluation interval). The
+// results are pushed as server-sent events until the client disconnects. Each
+// successful evaluation is sent as a "result" event holding the same data as
+// the query endpoint, each failed one as an "error" event holding errorType
+// and error. Failed evaluations don't end the subscription.
+func (api *API) queryStream(w http.ResponseWriter, r *http.Request) {
+	expr, apiErr := api.parseQuery(r, nil)
+	if apiErr != nil {
+		respondError(w, apiErr)
+		return
+	}
+	interval := api.EvaluationInterval
+	if s := r.FormValue("interval"); s != "" {
+		var err error
+		if interval, err = parseDuration(s); err != nil {
+			respondError(w, &apiError{errorBadData, fmt.Errorf("invalid parameter interval: %s", err)})
+			return
+		}
+	}
+	if interval <= 0 {
+		respondError(w, &apiError{errorBadData, fmt.Errorf("zero or negative subscription intervals are not accepted")})
+		return
+	}
+	timeout, apiErr := parseTimeout(r)
+	if apiErr != nil {
+		respondError(w, apiErr)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, &apiError{errorInternal, fmt.Errorf("streaming is not supported by the connection")})
+		return
+	}
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := api.pushResult(w, expr, timeout); err != nil {
+			logger.Debugf("Ending query subscription %s: %s", r.FormValue("query"), err)
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// pushResult evaluates expr at the current time and writes the result or the
+// evaluation error as an event. Only errors writing the event are returned.
+func (api *API) pushResult(w http.ResponseWriter, expr ast.Node, timeout time.Duration) error {
+	span := tracing.StartSpan("subscribed query")
+	span.SetTag("query", expr.String())
+	defer span.Finish()
+	queryStats := stats.NewTimerGroup()
+	queryStats.SetSpan(span)
+
+	ts := clientmodel.Now()
+	val, err := ast.EvalInstant(expr, ts, timeout, api.Storage, queryStats)
+	span.SetError(err)
+
+	var (
+		event string
+		data  interface{}
+	)
+	if err != nil {
+		apiErr := evalError(err)
+		event = "error"
+		data = &response{
+			Status:    statusError,
+			ErrorType: apiErr.typ,
+			Error:     apiErr.err.Error(),
+		}
+	} else {
+		event = "result"
+		data = queryResult(val, ts)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/retrieval"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// tenantMatcher returns the matcher restricting the series a request may
+// access to the tenant given in its tenant header, or nil if the request is
+// not restricted.
+func (api *API) tenantMatcher(r *http.Request) (*metric.LabelMatcher, *apiError) {
+	if api.TenantHeader == "" {
+		return nil, nil
+	}
+	tenant := r.Header.Get(api.TenantHeader)
+	if tenant == "" {
+		if api.RequireTenant {
+			return nil, &apiError{errorBadData, fmt.Errorf("missing tenant in header %s", api.TenantHeader)}
+		}
+		return nil, nil
+	}
+	return mustNewLabelMatcher(metric.Equal, retrieval.TenantLabel, clientmodel.LabelValue(tenant)), nil
+}
+
+// tenantTargets returns the active targets of the tenant of the request, i.e.
+// those with the tenant label set to it, or all of them if the request is not
+// restricted.
+func (api *API) tenantTargets(r *http.Request) ([]retrieval.Target, *apiError) {
+	tenant, apiErr := api.tenantMatcher(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	targets := api.activeTargets()
+	if tenant == nil {
+		return targets, nil
+	}
+	var res []retrieval.Target
+	for _, t := range targets {
+		if tenant.Match(t.BaseLabels()[tenant.Name]) {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+// restrictMatcherSets adds the given matcher to each of the sets of matchers.
+func restrictMatcherSets(matcherSets []metric.LabelMatchers, m *metric.LabelMatcher) []metric.LabelMatchers {
+	res := make([]metric.LabelMatchers, 0, len(matcherSets))
+	for _, matchers := range matcherSets {
+		restricted := make(metric.LabelMatchers, 0, len(matchers)+1)
+		restricted = append(restricted, matchers...)
+		res = append(res, append(restricted, m))
+	}
+	return res
+}
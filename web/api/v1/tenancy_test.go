@@ -0,0 +1,231 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/extraction"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/retrieval"
+)
+
+type fakeTarget struct {
+	retrieval.Target
+	url      string
+	instance string // Defaults to url.
+	labels   clientmodel.LabelSet
+	metadata []retrieval.MetricMetadata
+}
+
+func (t *fakeTarget) URL() string                                   { return t.url }
+func (t *fakeTarget) BaseLabels() clientmodel.LabelSet              { return t.labels }
+func (t *fakeTarget) Metadata() []retrieval.MetricMetadata          { return t.metadata }
+func (t *fakeTarget) LastError() error                              { return nil }
+func (t *fakeTarget) State() retrieval.TargetState                  { return retrieval.Alive }
+func (t *fakeTarget) LastScrape() time.Time                         { return time.Time{} }
+func (t *fakeTarget) LastScrapeDuration() time.Duration             { return 0 }
+func (t *fakeTarget) Paused() bool                                  { return false }
+func (t *fakeTarget) SetPaused(bool)                                {}
+func (t *fakeTarget) RunScraper(extraction.Ingester, time.Duration) {}
+func (t *fakeTarget) StopScraper()                                  {}
+
+func (t *fakeTarget) InstanceIdentifier() string {
+	if t.instance != "" {
+		return t.instance
+	}
+	return t.url
+}
+
+type fakeTargetManager struct {
+	retrieval.TargetManager
+	pools map[string]*retrieval.TargetPool
+}
+
+func (m *fakeTargetManager) Pools() map[string]*retrieval.TargetPool {
+	return m.pools
+}
+
+// newTenantTargetManager returns a target manager with a job of the given
+// targets.
+func newTenantTargetManager(targets ...retrieval.Target) *fakeTargetManager {
+	pool := retrieval.NewTargetPool(nil, nil, nil, time.Hour)
+	pool.ReplaceTargets(targets)
+	return &fakeTargetManager{pools: map[string]*retrieval.TargetPool{"test": pool}}
+}
+
+func TestTenantTargets(t *testing.T) {
+	api := &API{
+		TargetManager: newTenantTargetManager(
+			&fakeTarget{
+				url:      "http://a.example.org/metrics",
+				labels:   clientmodel.LabelSet{clientmodel.JobLabel: "test", retrieval.TenantLabel: "a"},
+				metadata: []retrieval.MetricMetadata{{Metric: "a_total", Type: "counter"}},
+			},
+			&fakeTarget{
+				url:      "http://b.example.org/metrics",
+				labels:   clientmodel.LabelSet{clientmodel.JobLabel: "test", retrieval.TenantLabel: "b"},
+				metadata: []retrieval.MetricMetadata{{Metric: "b_total", Type: "counter"}},
+			},
+		),
+		TenantHeader: "X-Tenant",
+	}
+
+	request := func(tenant string) *http.Request {
+		r, err := http.NewRequest("GET", "/api/v1/targets", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		return r
+	}
+
+	for _, c := range []struct {
+		tenant  string
+		urls    []string
+		metrics []string
+	}{
+		{
+			tenant:  "",
+			urls:    []string{"http://a.example.org/metrics", "http://b.example.org/metrics"},
+			metrics: []string{"a_total", "b_total"},
+		},
+		{
+			tenant:  "a",
+			urls:    []string{"http://a.example.org/metrics"},
+			metrics: []string{"a_total"},
+		},
+	} {
+		res, apiErr := api.targets(request(c.tenant))
+		if apiErr != nil {
+			t.Fatalf("Tenant %q: unexpected error: %v", c.tenant, apiErr.err)
+		}
+		active := res.(*targetDiscovery).ActiveTargets
+		if len(active) != len(c.urls) {
+			t.Fatalf("Tenant %q: expected %d targets, got %d", c.tenant, len(c.urls), len(active))
+		}
+		for i, at := range active {
+			if at.ScrapeURL != c.urls[i] {
+				t.Errorf("Tenant %q: expected target %s, got %s", c.tenant, c.urls[i], at.ScrapeURL)
+			}
+		}
+
+		res, apiErr = api.targetMetadata(request(c.tenant))
+		if apiErr != nil {
+			t.Fatalf("Tenant %q: unexpected error: %v", c.tenant, apiErr.err)
+		}
+		if md := res.([]targetMetadata); len(md) != len(c.metrics) {
+			t.Errorf("Tenant %q: expected metadata of %v, got %v", c.tenant, c.metrics, md)
+		}
+
+		res, apiErr = api.metricMetadata(request(c.tenant))
+		if apiErr != nil {
+			t.Fatalf("Tenant %q: unexpected error: %v", c.tenant, apiErr.err)
+		}
+		md := res.(map[string][]metadata)
+		if len(md) != len(c.metrics) {
+			t.Errorf("Tenant %q: expected metadata of %v, got %v", c.tenant, c.metrics, md)
+		}
+		for _, m := range c.metrics {
+			if _, ok := md[m]; !ok {
+				t.Errorf("Tenant %q: missing metadata of %s", c.tenant, m)
+			}
+		}
+	}
+
+	api.RequireTenant = true
+	if _, apiErr := api.targets(request("")); apiErr == nil {
+		t.Error("Expected error listing targets without a tenant")
+	}
+}
+
+type fakePushIngester struct {
+	samples clientmodel.Samples
+}
+
+func (i *fakePushIngester) Ingest(s clientmodel.Samples) error {
+	i.samples = append(i.samples, s...)
+	return nil
+}
+
+func TestPushTenancy(t *testing.T) {
+	ingester := &fakePushIngester{}
+	api := &API{
+		PushIngester: ingester,
+		TenantHeader: "X-Tenant",
+	}
+
+	push := func(tenant, body string) *apiError {
+		r, err := http.NewRequest("POST", "/api/v1/push/job/batch", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		_, apiErr := api.push(r)
+		return apiErr
+	}
+
+	if apiErr := push("a", "up 1\n"); apiErr != nil {
+		t.Fatalf("Unexpected error: %v", apiErr.err)
+	}
+	if apiErr := push("", "up 1\n"); apiErr != nil {
+		t.Fatalf("Unexpected error: %v", apiErr.err)
+	}
+	if len(ingester.samples) != 2 {
+		t.Fatalf("Expected 2 ingested samples, got %v", ingester.samples)
+	}
+	if got := ingester.samples[0].Metric[retrieval.TenantLabel]; got != "a" {
+		t.Errorf("Expected pushed sample of tenant a, got tenant %q", got)
+	}
+	if _, ok := ingester.samples[1].Metric[retrieval.TenantLabel]; ok {
+		t.Errorf("Expected pushed sample without tenant, got %v", ingester.samples[1].Metric)
+	}
+
+	apiErr := push("a", "up{tenant=\"b\"} 1\n")
+	if apiErr == nil || apiErr.typ != errorBadData {
+		t.Errorf("Expected bad data error pushing a sample of another tenant, got %v", apiErr)
+	}
+
+	api.RequireTenant = true
+	if apiErr := push("", "up 1\n"); apiErr == nil {
+		t.Error("Expected error pushing without a tenant")
+	}
+	if len(ingester.samples) != 2 {
+		t.Errorf("Expected no further ingested samples, got %v", ingester.samples)
+	}
+}
+
+func TestStatusConfigTenancy(t *testing.T) {
+	api := &API{Config: "global {}", TenantHeader: "X-Tenant"}
+	r, err := http.NewRequest("GET", "/api/v1/status/config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := api.statusConfig(r); apiErr != nil {
+		t.Fatalf("Unexpected error: %v", apiErr.err)
+	}
+	r.Header.Set("X-Tenant", "a")
+	if _, apiErr := api.statusConfig(r); apiErr == nil {
+		t.Error("Expected error getting the configuration as a tenant")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	pb "github.com/prometheus/prometheus/config/generated"
+)
+
+// adminAPIPrefix is the path prefix of the administrative API, which has its
+// own authentication.
+const adminAPIPrefix = "/api/v1/admin/"
+
+// basicAuthHandler passes on requests authenticated as one of the configured
+// users via HTTP basic auth. Requests to the administrative API are passed on
+// unconditionally.
+type basicAuthHandler struct {
+	handler http.Handler
+	users   map[string]*basicAuthUser
+
+	// Protects the verified field of the users.
+	mtx sync.Mutex
+}
+
+// basicAuthUser holds the decoded PBKDF2 key of a user and the parameters it
+// was derived with.
+type basicAuthUser struct {
+	key        []byte
+	salt       string
+	iterations int
+	// The SHA-256 hash of the password the user last authenticated with, so
+	// that the slow key derivation is not repeated for every request.
+	verified *[sha256.Size]byte
+}
+
+func newBasicAuthHandler(h http.Handler, users []*pb.BasicAuthUser) *basicAuthHandler {
+	b := &basicAuthHandler{
+		handler: h,
+		users:   make(map[string]*basicAuthUser, len(users)),
+	}
+	for _, u := range users {
+		// The key has been validated when loading the configuration.
+		key, _ := hex.DecodeString(u.GetPasswordPbkdf2Sha256())
+		b.users[u.GetUsername()] = &basicAuthUser{
+			key:        key,
+			salt:       u.GetSalt(),
+			iterations: int(u.GetPbkdf2Iterations()),
+		}
+	}
+	return b
+}
+
+func (b *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, adminAPIPrefix) || b.authenticated(r) {
+		b.handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Prometheus"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+func (b *basicAuthHandler) authenticated(r *http.Request) bool {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	user, ok := b.users[name]
+	if !ok {
+		return false
+	}
+	hash := sha256.Sum256([]byte(password))
+	b.mtx.Lock()
+	verified := user.verified
+	b.mtx.Unlock()
+	if verified != nil && subtle.ConstantTimeCompare(hash[:], verified[:]) == 1 {
+		return true
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, []byte(user.salt), user.iterations, len(user.key))
+	if err != nil || subtle.ConstantTimeCompare(key, user.key) != 1 {
+		return false
+	}
+	b.mtx.Lock()
+	user.verified = &hash
+	b.mtx.Unlock()
+	return true
+}
+
+// newTLSConfig returns the TLS configuration of the web server. The server
+// certificate is loaded separately by http.Server.ListenAndServeTLS.
+func newTLSConfig(c *pb.TLSServerConfig) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.GetClientCaFile() == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(c.GetClientCaFile())
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificates found in %s", c.GetClientCaFile())
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
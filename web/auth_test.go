@@ -0,0 +1,64 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/prometheus/prometheus/config/generated"
+)
+
+func TestBasicAuthHandler(t *testing.T) {
+	h := newBasicAuthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), []*pb.BasicAuthUser{
+		{
+			Username: proto.String("alice"),
+			// As printed by "openssl kdf -keylen 32 -kdfopt digest:SHA256
+			// -kdfopt pass:secret -kdfopt salt:salt -kdfopt iter:1000 PBKDF2".
+			PasswordPbkdf2Sha256: proto.String("a8df899f3c4f204d967e0ad63c092987c10055ebb017b3d9d28add218d4f7aad"),
+			Salt:                 proto.String("salt"),
+			Pbkdf2Iterations:     proto.Uint32(1000),
+		},
+	})
+
+	for _, c := range []struct {
+		path, user, password string
+		auth                 bool
+		status               int
+	}{
+		{path: "/graph", user: "alice", password: "secret", auth: true, status: http.StatusOK},
+		// Served from the verified password.
+		{path: "/graph", user: "alice", password: "secret", auth: true, status: http.StatusOK},
+		{path: "/graph", user: "alice", password: "wrong", auth: true, status: http.StatusUnauthorized},
+		{path: "/graph", user: "bob", password: "secret", auth: true, status: http.StatusUnauthorized},
+		{path: "/graph", status: http.StatusUnauthorized},
+		{path: adminAPIPrefix + "log/level", status: http.StatusOK},
+	} {
+		r, err := http.NewRequest("GET", c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.auth {
+			r.SetBasicAuth(c.user, c.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s as %s:%s: expected status %d, got %d", c.path, c.user, c.password, c.status, w.Code)
+		}
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/golang/glog"
+	"github.com/prometheus/prometheus/log"
 )
 
+var logger = log.New("web")
+
 // Sub-directories for templates and static content.
 const (
 	TemplateFiles = "templates"
@@ -54,7 +56,7 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	file, err := GetFile(StaticFiles, name)
 	if err != nil {
 		if err != io.EOF {
-			glog.Warning("Could not get file: ", err)
+			logger.Warn("Could not get file: ", err)
 		}
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -20,20 +20,25 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
+
+	text_template "text/template"
 
 	clientmodel "github.com/prometheus/client_golang/model"
+	"github.com/prometheus/prometheus/retrieval"
 	"github.com/prometheus/prometheus/storage/local"
 	"github.com/prometheus/prometheus/templates"
 )
 
 var (
-	consoleTemplatesPath = flag.String("web.console.templates", "consoles", "Path to the console template directory, available at /console.")
+	consoleTemplatesPath = flag.String("web.console.templates", "consoles", "Path to the console template directory, available at /consoles.")
 	consoleLibrariesPath = flag.String("web.console.libraries", "console_libraries", "Path to the console library directory.")
 )
 
 // ConsolesHandler implements http.Handler.
 type ConsolesHandler struct {
-	Storage local.Storage
+	Storage       local.Storage
+	TargetManager retrieval.TargetManager
 }
 
 func (h *ConsolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +47,11 @@ func (h *ConsolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	defer file.Close()
+	if fi, err := file.Stat(); err != nil || fi.IsDir() {
+		http.Error(w, "no console template at "+r.URL.Path, http.StatusNotFound)
+		return
+	}
 	text, err := ioutil.ReadAll(file)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,6 +80,10 @@ func (h *ConsolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	template := templates.NewTemplateExpander(string(text), "__console_"+r.URL.Path, data, clientmodel.Now(), h.Storage)
+	template.Funcs(text_template.FuncMap{
+		"pathPrefix": pathPrefix,
+		"unit":       h.metricUnit,
+	})
 	filenames, err := filepath.Glob(*consoleLibrariesPath + "/*.lib")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -82,3 +96,18 @@ func (h *ConsolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	io.WriteString(w, result)
 }
+
+// metricUnit returns the unit the targets expose for the named metric, or ""
+// if none of them does.
+func (h *ConsolesHandler) metricUnit(metric string) string {
+	for _, pool := range h.TargetManager.Pools() {
+		for _, t := range pool.Targets() {
+			md := t.Metadata()
+			i := sort.Search(len(md), func(i int) bool { return md[i].Metric >= metric })
+			if i < len(md) && md[i].Metric == metric && md[i].Unit != "" {
+				return md[i].Unit
+			}
+		}
+	}
+	return ""
+}
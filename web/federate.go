@@ -0,0 +1,158 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/text"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/rules/ast"
+	"github.com/prometheus/prometheus/stats"
+	"github.com/prometheus/prometheus/storage/local"
+)
+
+// FederationHandler implements http.Handler. It exposes the most recent
+// sample of all series matching the match[] selectors of a request in the
+// text exposition format, to be scraped by another Prometheus server.
+//
+// The external labels are attached to all exposed series that don't have a
+// label of the same name yet. The scraping server should scrape this handler
+// with honor_labels set to true. Otherwise, the exposed job and instance
+// labels are overwritten by the labels of the scraping server's target and
+// kept under names prefixed with "exported_".
+type FederationHandler struct {
+	Storage        local.Storage
+	ExternalLabels clientmodel.LabelSet
+}
+
+func (h *FederationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Form["match[]"]) == 0 {
+		http.Error(w, "no match[] parameter provided", http.StatusBadRequest)
+		return
+	}
+
+	var selectors []*ast.VectorSelector
+	for _, s := range r.Form["match[]"] {
+		matchers, err := rules.LoadSelectorFromString(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		selectors = append(selectors, ast.NewVectorSelector(matchers, 0))
+	}
+
+	timestamp := clientmodel.Now()
+	samples := map[clientmodel.Fingerprint]*ast.Sample{}
+	for _, selector := range selectors {
+		vector, err := ast.EvalVectorInstant(selector, timestamp, h.Storage, stats.NewTimerGroup())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, s := range vector {
+			samples[s.Metric.Metric.Fingerprint()] = s
+		}
+	}
+
+	w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+	for _, family := range h.metricFamilies(samples) {
+		if _, err := text.MetricFamilyToText(w, family); err != nil {
+			logger.Error("Error writing federation response: ", err)
+			return
+		}
+	}
+}
+
+// metricFamilies groups the given samples into untyped metric families, sorted
+// by name, with the external labels attached.
+func (h *FederationHandler) metricFamilies(samples map[clientmodel.Fingerprint]*ast.Sample) []*dto.MetricFamily {
+	byName := map[clientmodel.LabelValue]*dto.MetricFamily{}
+	var names []string
+	for _, s := range samples {
+		name := s.Metric.Metric[clientmodel.MetricNameLabel]
+		family, ok := byName[name]
+		if !ok {
+			family = &dto.MetricFamily{
+				Name: proto.String(string(name)),
+				Type: dto.MetricType_UNTYPED.Enum(),
+			}
+			byName[name] = family
+			names = append(names, string(name))
+		}
+
+		labels := clientmodel.LabelSet{}
+		for ln, lv := range h.ExternalLabels {
+			labels[ln] = lv
+		}
+		for ln, lv := range s.Metric.Metric {
+			if ln != clientmodel.MetricNameLabel {
+				labels[ln] = lv
+			}
+		}
+		labelNames := make(clientmodel.LabelNames, 0, len(labels))
+		for ln := range labels {
+			labelNames = append(labelNames, ln)
+		}
+		sort.Sort(labelNames)
+
+		m := &dto.Metric{
+			Untyped:     &dto.Untyped{Value: proto.Float64(float64(s.Value))},
+			TimestampMs: proto.Int64(s.Timestamp.UnixNano() / 1000000),
+		}
+		for _, ln := range labelNames {
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(string(ln)),
+				Value: proto.String(string(labels[ln])),
+			})
+		}
+		family.Metric = append(family.Metric, m)
+	}
+
+	sort.Strings(names)
+	families := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		family := byName[clientmodel.LabelValue(name)]
+		sort.Sort(metricsByLabels(family.Metric))
+		families = append(families, family)
+	}
+	return families
+}
+
+type metricsByLabels []*dto.Metric
+
+func (s metricsByLabels) Len() int      { return len(s) }
+func (s metricsByLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s metricsByLabels) Less(i, j int) bool {
+	a, b := s[i].Label, s[j].Label
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k].GetName() != b[k].GetName() {
+			return a[k].GetName() < b[k].GetName()
+		}
+		if a[k].GetValue() != b[k].GetValue() {
+			return a[k].GetValue() < b[k].GetValue()
+		}
+	}
+	return len(a) < len(b)
+}
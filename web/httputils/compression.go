@@ -18,16 +18,67 @@ import (
 	"compress/zlib"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 const (
 	acceptEncodingHeader  = "Accept-Encoding"
 	contentEncodingHeader = "Content-Encoding"
+	varyHeader            = "Vary"
 	gzipEncoding          = "gzip"
 	deflateEncoding       = "deflate"
+	identityEncoding      = "identity"
 )
 
+// supportedEncodings are the supported content codings in the order of
+// preference among equally acceptable ones.
+var supportedEncodings = []string{gzipEncoding, deflateEncoding, identityEncoding}
+
+// negotiateEncoding selects the content coding of a response according to
+// the given Accept-Encoding header as described in RFC 7231, section 5.3.4:
+// the supported coding with the highest quality value wins. Codings not
+// mentioned get the quality value of "*", if present. Identity is acceptable
+// unless excluded explicitly or by "*". If no supported coding is acceptable,
+// identity is used nonetheless.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) != "q" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil {
+				v = 0
+			}
+			quality = v
+		}
+		q[coding] = quality
+	}
+
+	best, bestQ := identityEncoding, 0.0
+	for _, coding := range supportedEncodings {
+		quality, ok := q[coding]
+		if !ok {
+			if quality, ok = q["*"]; !ok && coding == identityEncoding {
+				quality = 0.001
+			}
+		}
+		if quality > bestQ {
+			best, bestQ = coding, quality
+		}
+	}
+	return best
+}
+
 // Wrapper around http.Handler which adds suitable response compression based
 // on the client's Accept-Encoding headers.
 type compressedResponseWriter struct {
@@ -40,6 +91,20 @@ func (c *compressedResponseWriter) Write(p []byte) (int, error) {
 	return c.writer.Write(p)
 }
 
+// Flush sends any buffered compressed data to the client if the underlying
+// http.ResponseWriter supports flushing.
+func (c *compressedResponseWriter) Flush() {
+	if zlibWriter, ok := c.writer.(*zlib.Writer); ok {
+		zlibWriter.Flush()
+	}
+	if gzipWriter, ok := c.writer.(*gzip.Writer); ok {
+		gzipWriter.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // Closes the compressedResponseWriter and ensures to flush all data before.
 func (c *compressedResponseWriter) Close() {
 	if zlibWriter, ok := c.writer.(*zlib.Writer); ok {
@@ -55,21 +120,19 @@ func (c *compressedResponseWriter) Close() {
 
 // Constructs a new compressedResponseWriter based on client request headers.
 func newCompressedResponseWriter(writer http.ResponseWriter, req *http.Request) *compressedResponseWriter {
-	encodings := strings.Split(req.Header.Get(acceptEncodingHeader), ",")
-	for _, encoding := range encodings {
-		switch strings.TrimSpace(encoding) {
-		case gzipEncoding:
-			writer.Header().Set(contentEncodingHeader, gzipEncoding)
-			return &compressedResponseWriter{
-				ResponseWriter: writer,
-				writer:         gzip.NewWriter(writer),
-			}
-		case deflateEncoding:
-			writer.Header().Set(contentEncodingHeader, deflateEncoding)
-			return &compressedResponseWriter{
-				ResponseWriter: writer,
-				writer:         zlib.NewWriter(writer),
-			}
+	writer.Header().Add(varyHeader, acceptEncodingHeader)
+	switch negotiateEncoding(req.Header.Get(acceptEncodingHeader)) {
+	case gzipEncoding:
+		writer.Header().Set(contentEncodingHeader, gzipEncoding)
+		return &compressedResponseWriter{
+			ResponseWriter: writer,
+			writer:         gzip.NewWriter(writer),
+		}
+	case deflateEncoding:
+		writer.Header().Set(contentEncodingHeader, deflateEncoding)
+		return &compressedResponseWriter{
+			ResponseWriter: writer,
+			writer:         zlib.NewWriter(writer),
 		}
 	}
 	return &compressedResponseWriter{
@@ -0,0 +1,89 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, s := range []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: identityEncoding},
+		{header: "gzip", want: gzipEncoding},
+		{header: "deflate", want: deflateEncoding},
+		{header: "GZIP", want: gzipEncoding},
+		{header: "deflate, gzip", want: gzipEncoding},
+		{header: "gzip;q=0.5, deflate", want: deflateEncoding},
+		{header: "gzip; q=0.8, deflate; q=0.9", want: deflateEncoding},
+		{header: "gzip;q=0", want: identityEncoding},
+		{header: "gzip;q=invalid, deflate;q=0.1", want: deflateEncoding},
+		{header: "br", want: identityEncoding},
+		{header: "*", want: gzipEncoding},
+		{header: "gzip;q=0, *", want: deflateEncoding},
+		{header: "gzip;q=0, deflate;q=0, *;q=0.5", want: identityEncoding},
+		{header: "identity;q=0.5, gzip;q=0.1", want: identityEncoding},
+		// Nothing is acceptable, so identity is used nonetheless.
+		{header: "identity;q=0", want: identityEncoding},
+		{header: "*;q=0", want: identityEncoding},
+		{header: ", ;q=1, gzip", want: gzipEncoding},
+	} {
+		if got := negotiateEncoding(s.header); got != s.want {
+			t.Errorf("Accept-Encoding %q: expected %s, got %s", s.header, s.want, got)
+		}
+	}
+}
+
+func TestCompressionHandler(t *testing.T) {
+	h := CompressionHandler{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response"))
+	})}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(acceptEncodingHeader, "deflate;q=0.5, gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get(contentEncodingHeader); got != gzipEncoding {
+		t.Fatalf("Expected content encoding %s, got %q", gzipEncoding, got)
+	}
+	if got := w.Header().Get(varyHeader); got != acceptEncodingHeader {
+		t.Errorf("Expected Vary header %s, got %q", acceptEncodingHeader, got)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := ioutil.ReadAll(gz); err != nil || string(body) != "response" {
+		t.Errorf("Expected decompressed body %q, got %q and error %v", "response", body, err)
+	}
+
+	r.Header.Set(acceptEncodingHeader, "br")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get(contentEncodingHeader); got != "" {
+		t.Errorf("Expected no content encoding, got %q", got)
+	}
+	if got := w.Body.String(); got != "response" {
+		t.Errorf("Expected body %q, got %q", "response", got)
+	}
+}
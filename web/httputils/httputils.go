@@ -16,8 +16,35 @@ package httputils
 import (
 	"net/http"
 	"net/url"
+	"regexp"
 )
 
+// corsHeaders are the headers set on responses to allowed cross-origin
+// requests.
+var corsHeaders = map[string]string{
+	"Access-Control-Allow-Headers":  "Accept, Authorization, Content-Type, Origin",
+	"Access-Control-Allow-Methods":  "GET, POST, OPTIONS",
+	"Access-Control-Expose-Headers": "Date",
+}
+
+// SetCORS enables cross-origin requests from origins matching the given
+// regular expression by setting the CORS headers of the response accordingly.
+// Nothing is set for same-origin requests or if the regular expression is nil.
+func SetCORS(w http.ResponseWriter, o *regexp.Regexp, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || o == nil {
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if !o.MatchString(origin) {
+		return
+	}
+	for k, v := range corsHeaders {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
+
 // GetQueryParams calls r.ParseForm and returns r.Form.
 func GetQueryParams(r *http.Request) url.Values {
 	r.ParseForm()
@@ -0,0 +1,151 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/prometheus/log"
+)
+
+var logger = log.New("web")
+
+var slowRequestThreshold = flag.Duration("web.slow-request-threshold", 0, "Log requests to web endpoints that take longer than this, along with their URL. 0 disables logging of slow requests.")
+
+// Constants for instrumentation.
+const (
+	namespace = "prometheus"
+	subsystem = "http"
+
+	handlerLabel = "handler"
+	codeLabel    = "code"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "The total number of HTTP requests by handler and status code.",
+		},
+		[]string{handlerLabel, codeLabel},
+	)
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "The latencies of HTTP requests by handler and status code.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{handlerLabel, codeLabel},
+	)
+	responseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "response_size_bytes",
+			Help:      "The sizes of HTTP response bodies as sent, i.e. after compression, by handler and status code.",
+			Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+		},
+		[]string{handlerLabel, codeLabel},
+	)
+	requestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_in_flight",
+			Help:      "The number of HTTP requests currently being served by handler.",
+		},
+		[]string{handlerLabel},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(responseSize)
+	prometheus.MustRegister(requestsInFlight)
+}
+
+// InstrumentHandler wraps the given handler to count its requests and to
+// track their latencies and response sizes, partitioned by the given handler
+// name and the status code, as well as the number of requests in flight.
+// Requests taking longer than -web.slow-request-threshold are logged.
+func InstrumentHandler(handlerName string, h http.Handler) http.Handler {
+	inFlight := requestsInFlight.WithLabelValues(handlerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		rw := &instrumentedResponseWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rw, r)
+		took := time.Since(start)
+
+		code := strconv.Itoa(rw.code)
+		requestsTotal.WithLabelValues(handlerName, code).Inc()
+		requestDuration.WithLabelValues(handlerName, code).Observe(took.Seconds())
+		responseSize.WithLabelValues(handlerName, code).Observe(float64(rw.size))
+
+		if *slowRequestThreshold > 0 && took > *slowRequestThreshold {
+			logger.Warnf("Slow request to handler %s took %s with status %d: %s %s", handlerName, took, rw.code, r.Method, r.URL)
+		}
+	})
+}
+
+// instrumentedResponseWriter records the status code and the size of the
+// response written through it.
+type instrumentedResponseWriter struct {
+	http.ResponseWriter
+	code int
+	size int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *instrumentedResponseWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.
+func (w *instrumentedResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying http.ResponseWriter
+// supports flushing.
+func (w *instrumentedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify implements http.CloseNotifier. The returned channel never
+// receives if the underlying http.ResponseWriter does not support close
+// notification.
+func (w *instrumentedResponseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
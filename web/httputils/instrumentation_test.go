@@ -0,0 +1,132 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeMetric(t *testing.T, m prometheus.Metric) *dto.Metric {
+	out := &dto.Metric{}
+	if err := m.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// serveInstrumented serves a request by the given handler instrumented under
+// the given name and returns the recorded response.
+func serveInstrumented(t *testing.T, name string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	InstrumentHandler(name, h).ServeHTTP(w, r)
+	return w
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	for _, s := range []struct {
+		name    string
+		handler http.HandlerFunc
+		code    string
+		size    int
+	}{
+		{
+			name:    "default_code",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			code:    "200",
+			size:    5,
+		},
+		{
+			name:    "no_body",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			code:    "200",
+			size:    0,
+		},
+		{
+			name: "explicit_code",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("not "))
+				w.Write([]byte("found"))
+			},
+			code: "404",
+			size: 9,
+		},
+	} {
+		handlerName := "test_instrument_" + s.name
+		w := serveInstrumented(t, handlerName, s.handler)
+		if got := w.Body.Len(); got != s.size {
+			t.Errorf("%s: expected body of %d bytes, got %d", s.name, s.size, got)
+		}
+
+		if got := writeMetric(t, requestsTotal.WithLabelValues(handlerName, s.code)).GetCounter().GetValue(); got != 1 {
+			t.Errorf("%s: expected 1 request with code %s, got %v", s.name, s.code, got)
+		}
+		if got := writeMetric(t, requestDuration.WithLabelValues(handlerName, s.code)).GetHistogram().GetSampleCount(); got != 1 {
+			t.Errorf("%s: expected 1 observed latency with code %s, got %d", s.name, s.code, got)
+		}
+		size := writeMetric(t, responseSize.WithLabelValues(handlerName, s.code)).GetHistogram()
+		if size.GetSampleCount() != 1 || size.GetSampleSum() != float64(s.size) {
+			t.Errorf("%s: expected 1 observed response size of %d bytes, got %d summing to %v", s.name, s.size, size.GetSampleCount(), size.GetSampleSum())
+		}
+	}
+}
+
+func TestInstrumentHandlerInFlight(t *testing.T) {
+	const handlerName = "test_instrument_in_flight"
+	inFlight := requestsInFlight.WithLabelValues(handlerName)
+
+	var during float64
+	serveInstrumented(t, handlerName, func(w http.ResponseWriter, r *http.Request) {
+		during = writeMetric(t, inFlight).GetGauge().GetValue()
+	})
+	if during != 1 {
+		t.Errorf("Expected 1 request in flight while serving, got %v", during)
+	}
+	if got := writeMetric(t, inFlight).GetGauge().GetValue(); got != 0 {
+		t.Errorf("Expected no requests in flight after serving, got %v", got)
+	}
+}
+
+func TestInstrumentHandlerFlush(t *testing.T) {
+	w := serveInstrumented(t, "test_instrument_flush", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected instrumented response writer to implement http.Flusher")
+		}
+		w.Write([]byte("streamed"))
+		flusher.Flush()
+	})
+	if !w.Flushed {
+		t.Error("Expected flush to pass through to the underlying response writer")
+	}
+
+	// Flushing a response writer that doesn't support it is a no-op.
+	rw := &instrumentedResponseWriter{ResponseWriter: nonFlushingWriter{httptest.NewRecorder()}}
+	rw.Flush()
+}
+
+// nonFlushingWriter hides the http.Flusher implementation of its response
+// writer.
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
@@ -18,23 +18,38 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/retrieval"
 	"github.com/prometheus/prometheus/rules/manager"
 )
 
 // PrometheusStatusHandler implements http.Handler.
 type PrometheusStatusHandler struct {
-	mu sync.RWMutex
+	mu sync.RWMutex // Protects Config.
 
-	BuildInfo   map[string]string
-	Config      string
-	Flags       map[string]string
-	RuleManager manager.RuleManager
-	TargetPools map[string]*retrieval.TargetPool
+	BuildInfo     map[string]string
+	Config        string
+	Flags         map[string]string
+	RuleManager   manager.RuleManager
+	TargetManager retrieval.TargetManager
 
 	Birth time.Time
 }
 
+// ApplyConfig updates the displayed configuration.
+func (h *PrometheusStatusHandler) ApplyConfig(conf config.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Config = conf.MaskedString()
+}
+
+// TargetPools returns the current target pools by job name.
+func (h *PrometheusStatusHandler) TargetPools() map[string]*retrieval.TargetPool {
+	return h.TargetManager.Pools()
+}
+
 func (h *PrometheusStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	executeTemplate(w, "status", h)
 }
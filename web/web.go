@@ -20,87 +20,233 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	pprof_runtime "runtime/pprof"
 
-	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 
+	pb "github.com/prometheus/prometheus/config/generated"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/log"
+	"github.com/prometheus/prometheus/storage/local"
 	"github.com/prometheus/prometheus/web/api"
+	"github.com/prometheus/prometheus/web/api/v1"
 	"github.com/prometheus/prometheus/web/blob"
+	"github.com/prometheus/prometheus/web/httputils"
 )
 
+var logger = log.New("web")
+
 // Commandline flags.
 var (
-	listenAddress  = flag.String("web.listen-address", ":9090", "Address to listen on for the web interface, API, and telemetry.")
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	useLocalAssets = flag.Bool("web.use-local-assets", false, "Read assets/templates from file instead of binary.")
-	userAssetsPath = flag.String("web.user-assets", "", "Path to static asset directory, available at /user.")
-	enableQuit     = flag.Bool("web.enable-remote-shutdown", false, "Enable remote service shutdown.")
+	listenAddress   = flag.String("web.listen-address", ":9090", "Address to listen on for the web interface, API, and telemetry.")
+	metricsPath     = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	useLocalAssets  = flag.Bool("web.use-local-assets", false, "Read assets/templates from file instead of binary.")
+	userAssetsPath  = flag.String("web.user-assets", "", "Path to static asset directory, available at /user.")
+	enableQuit      = flag.Bool("web.enable-remote-shutdown", false, "Enable remote service shutdown. Deprecated, use -web.enable-lifecycle instead.")
+	enableLifecycle = flag.Bool("web.enable-lifecycle", false, "Enable shutdown and reload via HTTP requests to /-/quit and /-/reload.")
+	externalURL     = flag.String("web.external-url", "", "The URL under which Prometheus is externally reachable (for example, if Prometheus is served via a reverse proxy). Used for generating relative and absolute links back to Prometheus itself. If the URL has a path portion, it is used to prefix all HTTP endpoints served by Prometheus. If omitted, the URL is derived from the hostname and the listen address.")
+	routePrefix     = flag.String("web.route-prefix", "", "Prefix for the internal routes of web endpoints. Defaults to the path of -web.external-url.")
+	corsOrigin      = flag.String("web.cors.origin", ".*", "Regex for the origins allowed to make cross-origin requests to the API. It is fully anchored. If empty, cross-origin requests are not allowed.")
+	webConfigFile   = flag.String("web.config.file", "", "Path to the web server configuration file setting up TLS and basic auth.")
+	adminAPIToken   = flag.String("web.admin-api-token", "", "Bearer token required by the administrative API endpoints. The endpoints are disabled if empty.")
+	tenantHeader    = flag.String("web.tenant-header", "", "The header of API requests naming the tenant whose series and targets the v1 query, series, label, rule preview, series inspection, target, and metadata endpoints are restricted to, i.e. those with the tenant label set to it. Samples pushed to the push endpoint get the tenant label set to it, and the configuration status endpoint rejects requests naming a tenant. Jobs are assigned to tenants in the configuration. Tenancy is disabled if empty.")
+	requireTenant   = flag.Bool("web.require-tenant", false, "Reject requests to the v1 query, series, label, rule preview, series inspection, target, metadata, and push endpoints without a tenant in -web.tenant-header. The status page and the alerts, federation, console, and legacy API endpoints, which cannot be restricted, are not served.")
+	maxPoints       = flag.Int("web.max-points-per-series", 11000, "The maximum number of points per series v1 range queries may return. Queries exceeding it are rejected unless they set auto_step=true, in which case their step is coarsened to stay within it.")
+	enablePush      = flag.Bool("web.enable-push", false, "Enable ingesting samples in the text exposition format POSTed to /api/v1/push/job/<job>[/instance/<instance>], e.g. by short-lived batch jobs. Pushed series go stale like scraped ones unless pushed again.")
 )
 
-// WebService handles the HTTP endpoints with the exception of /api.
+// WebService handles the HTTP endpoints with the exception of /api. Handlers
+// querying the local storage or the rule manager are nil in agent mode, and
+// their endpoints are not served.
 type WebService struct {
-	StatusHandler   *PrometheusStatusHandler
-	MetricsHandler  *api.MetricsService
-	AlertsHandler   *AlertsHandler
-	ConsolesHandler *ConsolesHandler
+	StatusHandler     *PrometheusStatusHandler
+	MetricsHandler    *api.MetricsService
+	APIv1             *v1.API
+	AlertsHandler     *AlertsHandler
+	ConsolesHandler   *ConsolesHandler
+	FederationHandler *FederationHandler
+
+	QuitDelegate   func()
+	ReloadDelegate func() error
+
+	ready uint32 // 1 if the server is ready to serve queries. Accessed atomically.
+}
 
-	QuitDelegate func()
+// SetReady marks the server as ready or not ready to serve queries, i.e. the
+// storage is loaded, the configuration is applied, and the rule manager is
+// running. The server starts out as not ready.
+func (ws *WebService) SetReady(ready bool) {
+	var v uint32
+	if ready {
+		v = 1
+	}
+	atomic.StoreUint32(&ws.ready, v)
+}
+
+func (ws *WebService) isReady() bool {
+	return atomic.LoadUint32(&ws.ready) == 1
 }
 
 // ServeForever serves the HTTP endpoints and only returns upon errors.
-func (ws WebService) ServeForever() error {
+func (ws *WebService) ServeForever() error {
+	if *requireTenant && *tenantHeader == "" {
+		return fmt.Errorf("-web.require-tenant requires -web.tenant-header")
+	}
+	ws.APIv1.TenantHeader = *tenantHeader
+	ws.APIv1.RequireTenant = *requireTenant
+	if *maxPoints <= 0 {
+		return fmt.Errorf("-web.max-points-per-series must be positive")
+	}
+	ws.APIv1.MaxPointsPerSeries = *maxPoints
+	// Endpoints serving series regardless of the tenant.
+	unrestricted := !*requireTenant
+
 	http.Handle("/favicon.ico", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "", 404)
 	}))
 
-	http.Handle("/", prometheus.InstrumentHandler(
-		"/", ws.StatusHandler,
-	))
-	http.Handle("/alerts", prometheus.InstrumentHandler(
-		"/alerts", ws.AlertsHandler,
-	))
-	http.Handle("/consoles/", prometheus.InstrumentHandler(
-		"/consoles/", http.StripPrefix("/consoles/", ws.ConsolesHandler),
-	))
-	http.Handle("/graph", prometheus.InstrumentHandler(
-		"/graph", http.HandlerFunc(graphHandler),
-	))
-	http.Handle("/heap", prometheus.InstrumentHandler(
+	if unrestricted {
+		http.Handle("/", httputils.InstrumentHandler(
+			"/", ws.StatusHandler,
+		))
+	}
+	if ws.AlertsHandler != nil && unrestricted {
+		http.Handle("/alerts", httputils.InstrumentHandler(
+			"/alerts", ws.AlertsHandler,
+		))
+	}
+	if ws.ConsolesHandler != nil && unrestricted {
+		http.Handle("/consoles/", httputils.InstrumentHandler(
+			"/consoles/", http.StripPrefix("/consoles/", ws.ConsolesHandler),
+		))
+	}
+	if ws.FederationHandler != nil && unrestricted {
+		http.Handle("/federate", httputils.InstrumentHandler(
+			"/federate", httputils.CompressionHandler{Handler: ws.FederationHandler},
+		))
+	}
+	if ws.MetricsHandler != nil && unrestricted {
+		http.Handle("/graph", httputils.InstrumentHandler(
+			"/graph", http.HandlerFunc(graphHandler),
+		))
+	}
+	http.Handle("/heap", httputils.InstrumentHandler(
 		"/heap", http.HandlerFunc(dumpHeap),
 	))
+	http.Handle("/-/healthy", httputils.InstrumentHandler("/-/healthy", http.HandlerFunc(healthyHandler)))
+	http.Handle("/-/ready", httputils.InstrumentHandler("/-/ready", http.HandlerFunc(ws.readyHandler)))
+
+	if *corsOrigin != "" {
+		o, err := regexp.Compile("^(?:" + *corsOrigin + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid CORS origin regex %q: %s", *corsOrigin, err)
+		}
+		if ws.MetricsHandler != nil {
+			ws.MetricsHandler.CORSOrigin = o
+		}
+		ws.APIv1.CORSOrigin = o
+	}
 
-	ws.MetricsHandler.RegisterHandler()
+	if ws.MetricsHandler != nil && unrestricted {
+		ws.MetricsHandler.RegisterHandler()
+	}
+	ws.APIv1.RegisterHandler()
 	http.Handle(*metricsPath, prometheus.Handler())
 	if *useLocalAssets {
-		http.Handle("/static/", prometheus.InstrumentHandler(
+		http.Handle("/static/", httputils.InstrumentHandler(
 			"/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))),
 		))
 	} else {
-		http.Handle("/static/", prometheus.InstrumentHandler(
+		http.Handle("/static/", httputils.InstrumentHandler(
 			"/static/", http.StripPrefix("/static/", new(blob.Handler)),
 		))
 	}
 
 	if *userAssetsPath != "" {
-		http.Handle("/user/", prometheus.InstrumentHandler(
+		http.Handle("/user/", httputils.InstrumentHandler(
 			"/user/", http.StripPrefix("/user/", http.FileServer(http.Dir(*userAssetsPath))),
 		))
 	}
 
-	if *enableQuit {
-		http.Handle("/-/quit", http.HandlerFunc(ws.quitHandler))
+	if *enableQuit || *enableLifecycle {
+		http.Handle("/-/quit", httputils.InstrumentHandler("/-/quit", http.HandlerFunc(ws.quitHandler)))
+	}
+	if *enableLifecycle {
+		http.Handle("/-/reload", httputils.InstrumentHandler("/-/reload", http.HandlerFunc(ws.reloadHandler)))
 	}
 
-	glog.Info("listening on ", *listenAddress)
+	if *adminAPIToken != "" {
+		ws.APIv1.RegisterAdminHandler(*adminAPIToken)
+	}
+	if *enablePush {
+		ws.APIv1.RegisterPushHandler()
+	}
 
-	return http.ListenAndServe(*listenAddress, nil)
+	server := &http.Server{Addr: *listenAddress, Handler: http.DefaultServeMux}
+	if prefix := routePrefixPath(); prefix != "" {
+		mux := http.NewServeMux()
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, http.DefaultServeMux))
+		mux.Handle("/", http.RedirectHandler(prefix+"/", http.StatusFound))
+		server.Handler = mux
+	}
+	var tlsConfig *pb.TLSServerConfig
+	if *webConfigFile != "" {
+		conf, err := config.LoadWebConfigFromFile(*webConfigFile)
+		if err != nil {
+			return fmt.Errorf("error loading web configuration from %s: %s", *webConfigFile, err)
+		}
+		if len(conf.BasicAuthUser) > 0 {
+			server.Handler = newBasicAuthHandler(server.Handler, conf.BasicAuthUser)
+		}
+		if tlsConfig = conf.TlsServerConfig; tlsConfig != nil {
+			if server.TLSConfig, err = newTLSConfig(tlsConfig); err != nil {
+				return fmt.Errorf("error loading TLS configuration: %s", err)
+			}
+		}
+	}
+
+	logger.Info("listening on ", *listenAddress)
+
+	if tlsConfig != nil {
+		return server.ListenAndServeTLS(tlsConfig.GetCertFile(), tlsConfig.GetKeyFile())
+	}
+	return server.ListenAndServe()
+}
+
+// healthyHandler reports that the process is alive.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Prometheus is healthy.\n")
+}
+
+// readyHandler reports whether the server is ready to serve queries. It
+// responds with 503 Service Unavailable otherwise, which includes the storage
+// throttling ingestion to catch up with chunk persistence.
+func (ws *WebService) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !ws.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Prometheus is not ready.\n")
+		return
+	}
+	if ws.APIv1 == nil || ws.APIv1.Storage == nil {
+		fmt.Fprintf(w, "Prometheus is ready.\n")
+		return
+	}
+	state := ws.APIv1.Storage.Stats().PersistenceState
+	if state == local.PersistenceThrottled {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintf(w, "Prometheus is ready. Persistence state: %s.\n", state)
 }
 
-func (ws WebService) quitHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebService) quitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.Header().Add("Allow", "POST")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -112,18 +258,32 @@ func (ws WebService) quitHandler(w http.ResponseWriter, r *http.Request) {
 	ws.QuitDelegate()
 }
 
+func (ws *WebService) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := ws.ReloadDelegate(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Configuration reloaded.")
+}
+
 func getTemplateFile(name string) (string, error) {
 	if *useLocalAssets {
 		file, err := ioutil.ReadFile(fmt.Sprintf("web/templates/%s.html", name))
 		if err != nil {
-			glog.Errorf("Could not read %s template: %s", name, err)
+			logger.Errorf("Could not read %s template: %s", name, err)
 			return "", err
 		}
 		return string(file), nil
 	}
 	file, err := blob.GetFile(blob.TemplateFiles, name+".html")
 	if err != nil {
-		glog.Errorf("Could not read %s template: %s", name, err)
+		logger.Errorf("Could not read %s template: %s", name, err)
 		return "", err
 	}
 	return string(file), nil
@@ -146,17 +306,18 @@ func getTemplate(name string) (t *template.Template, err error) {
 	t.Funcs(template.FuncMap{
 		"since":       time.Since,
 		"getConsoles": getConsoles,
+		"pathPrefix":  pathPrefix,
 	})
 	file, err := getTemplateFile("_base")
 	if err != nil {
-		glog.Error("Could not read base template: ", err)
+		logger.Error("Could not read base template: ", err)
 		return nil, err
 	}
 	t.Parse(file)
 
 	file, err = getTemplateFile(name)
 	if err != nil {
-		glog.Error("Could not read base template: ", err)
+		logger.Error("Could not read base template: ", err)
 		return nil, err
 	}
 	t.Parse(file)
@@ -166,12 +327,12 @@ func getTemplate(name string) (t *template.Template, err error) {
 func executeTemplate(w http.ResponseWriter, name string, data interface{}) {
 	tpl, err := getTemplate(name)
 	if err != nil {
-		glog.Error("Error preparing layout template: ", err)
+		logger.Error("Error preparing layout template: ", err)
 		return
 	}
 	err = tpl.Execute(w, data)
 	if err != nil {
-		glog.Error("Error executing template: ", err)
+		logger.Error("Error executing template: ", err)
 	}
 }
 
@@ -179,7 +340,7 @@ func dumpHeap(w http.ResponseWriter, r *http.Request) {
 	target := fmt.Sprintf("/tmp/%d.heap", time.Now().Unix())
 	f, err := os.Create(target)
 	if err != nil {
-		glog.Error("Could not dump heap: ", err)
+		logger.Error("Could not dump heap: ", err)
 	}
 	fmt.Fprintf(w, "Writing to %s...", target)
 	defer f.Close()
@@ -187,8 +348,17 @@ func dumpHeap(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Done")
 }
 
-// MustBuildServerURL returns the server URL and panics in case an error occurs.
+// MustBuildServerURL returns the URL under which the server is externally
+// reachable, without a trailing slash. It panics in case an error occurs.
 func MustBuildServerURL() string {
+	if *externalURL != "" {
+		u, err := url.Parse(*externalURL)
+		if err != nil {
+			panic(fmt.Errorf("invalid external URL %q: %s", *externalURL, err))
+		}
+		u.Path = strings.TrimRight(u.Path, "/")
+		return u.String()
+	}
 	_, port, err := net.SplitHostPort(*listenAddress)
 	if err != nil {
 		panic(err)
@@ -199,3 +369,22 @@ func MustBuildServerURL() string {
 	}
 	return fmt.Sprintf("http://%s:%s", hostname, port)
 }
+
+// pathPrefix returns the path of the external URL without a trailing slash.
+// Links in the web UI are prefixed with it.
+func pathPrefix() string {
+	u, err := url.Parse(MustBuildServerURL())
+	if err != nil {
+		panic(err)
+	}
+	return u.Path
+}
+
+// routePrefixPath returns the path prefix all endpoints are served under,
+// without a trailing slash.
+func routePrefixPath() string {
+	if *routePrefix == "" {
+		return pathPrefix()
+	}
+	return strings.TrimRight("/"+strings.Trim(*routePrefix, "/"), "/")
+}